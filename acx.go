@@ -0,0 +1,133 @@
+package bs1770wrap
+
+import (
+	"io"
+	"strconv"
+	"text/template"
+)
+
+// LevelProfile is a delivery specification on unweighted
+// levels rather than loudness, as audiobook platforms have.
+type LevelProfile struct {
+	ID            string  // short name, e.g. "acx"
+	Name          string  // human-readable name
+	MinRMS        float64 // dBFS
+	MaxRMS        float64 // dBFS
+	MaxPeak       float64 // sample peak ceiling, dBFS
+	MaxNoiseFloor float64 // dBFS
+	Precision     int     // decimals measurements are judged at
+}
+
+// ACX is the audiobook submission specification of ACX
+// (Audible). It applies to every chapter file on its own.
+var ACX = LevelProfile{
+	ID: "acx", Name: "ACX",
+	MinRMS: ACXMinRMS, MaxRMS: ACXMaxRMS, MaxPeak: ACXMaxPeak, MaxNoiseFloor: ACXMaxNoiseFloor,
+	Precision: 1,
+}
+
+// Check lists the ways in which l violates the profile.
+func (p LevelProfile) Check(l Levels) []Violation {
+	var vs []Violation
+
+	rms := Round(l.RMS, p.Precision)
+	if rms < p.MinRMS || rms > p.MaxRMS {
+		limit := p.MinRMS
+		if rms > p.MaxRMS {
+			limit = p.MaxRMS
+		}
+		vs = append(vs, newViolation("rms", rms, limit,
+			msgRMS,
+			FormatLevel(float32(l.RMS), p.Precision),
+			strconv.FormatFloat(p.MinRMS, 'g', -1, 64),
+			strconv.FormatFloat(p.MaxRMS, 'g', -1, 64)))
+	}
+
+	peak := Round(l.Peak, p.Precision)
+	if peak > p.MaxPeak {
+		vs = append(vs, newViolation("peak", peak, p.MaxPeak,
+			msgSamplePeak,
+			FormatLevel(float32(l.Peak), p.Precision),
+			strconv.FormatFloat(p.MaxPeak, 'g', -1, 64)))
+	}
+
+	floor := Round(l.NoiseFloor, p.Precision)
+	if floor > p.MaxNoiseFloor {
+		vs = append(vs, newViolation("noise_floor", floor, p.MaxNoiseFloor,
+			msgNoiseFloor,
+			FormatLevel(float32(l.NoiseFloor), p.Precision),
+			strconv.FormatFloat(p.MaxNoiseFloor, 'g', -1, 64)))
+	}
+
+	return vs
+}
+
+// Compliant reports whether l satisfies the profile.
+func (p LevelProfile) Compliant(l Levels) bool {
+	return len(p.Check(l)) == 0
+}
+
+// ChapterCompliance is the check of one chapter of an
+// audiobook.
+type ChapterCompliance struct {
+	Chapter
+	Levels     Levels
+	Violations []Violation
+	Err        error // analysis failure, Levels are then zero
+}
+
+// Compliant reports whether the chapter was analyzed and met
+// the profile.
+func (c ChapterCompliance) Compliant() bool {
+	return c.Err == nil && len(c.Violations) == 0
+}
+
+// AudiobookReport is the check of an audiobook against a
+// LevelProfile, as a whole and chapter by chapter.
+type AudiobookReport struct {
+	File       string
+	Profile    LevelProfile
+	Book       Audiobook
+	Violations []Violation // of the file as a whole
+	Chapters   []ChapterCompliance
+	Failed     int // chapters not compliant, including failed ones
+}
+
+// NewAudiobookReport checks an analyzed audiobook against p.
+func NewAudiobookReport(file string, book Audiobook, p LevelProfile) AudiobookReport {
+	r := AudiobookReport{File: file, Profile: p, Book: book, Violations: p.Check(book.Levels)}
+	for _, c := range book.Chapters {
+		cc := ChapterCompliance{Chapter: c.Chapter, Err: c.Err}
+		if c.Err == nil {
+			cc.Levels = c.Levels
+			cc.Violations = p.Check(c.Levels)
+		}
+		if !cc.Compliant() {
+			r.Failed++
+		}
+		r.Chapters = append(r.Chapters, cc)
+	}
+	return r
+}
+
+// CheckAudiobook analyzes file with AnalyzeAudiobook and
+// checks it against p.
+func CheckAudiobook(file string, p LevelProfile, opts Options) (AudiobookReport, error) {
+	book, err := AnalyzeAudiobook(file, opts)
+	if err != nil {
+		return AudiobookReport{}, err
+	}
+	return NewAudiobookReport(file, book, p), nil
+}
+
+// Compliant reports whether the audiobook and all of its
+// chapters met the profile.
+func (r AudiobookReport) Compliant() bool {
+	return len(r.Violations) == 0 && r.Failed == 0
+}
+
+// Render executes tmpl, parsed with ParseReportTemplate, with
+// the audiobook report.
+func (r AudiobookReport) Render(w io.Writer, tmpl *template.Template) error {
+	return tmpl.Execute(w, r)
+}
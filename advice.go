@@ -0,0 +1,96 @@
+package bs1770wrap
+
+import (
+	"fmt"
+
+	"golang.org/x/text/language"
+)
+
+// Severity grades an Advisory.
+type Severity int
+
+// Advisory severities, from least to most pressing.
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Advisory is a human-readable mastering hint derived from
+// measurements. Code identifies the kind of hint and stays
+// stable across releases, so it can be filtered on.
+type Advisory struct {
+	Code     string
+	Severity Severity
+	Message  string // in English
+
+	msg localizable
+}
+
+// Localized returns the message in the given language (see
+// Languages). In report templates: {{.Localized $lang}}.
+func (a Advisory) Localized(tag language.Tag) string {
+	return a.msg.in(tag)
+}
+
+func newAdvisory(code string, sev Severity, key string, args ...interface{}) Advisory {
+	msg := newLocalizable(key, args...)
+	return Advisory{Code: code, Severity: sev, Message: msg.english(), msg: msg}
+}
+
+// Advisory codes.
+const (
+	AdviceNormalizedPeak = "normalized-peak"
+	AdviceClipping       = "clipping"
+	AdviceLowRange       = "low-range"
+	AdviceHighRange      = "high-range"
+	AdviceTooLoud        = "too-loud"
+)
+
+// Thresholds the advice is based on.
+const (
+	AdvicePeakCeiling = -1 // dBTP
+	AdviceMinRange    = 3  // LU
+	AdviceMaxRange    = 20 // LU
+	AdviceMaxLoudness = -9 // LUFS
+)
+
+// Advise derives mastering hints from a measurement, with
+// target being the loudness (LUFS) the track is expected to
+// be normalized to.
+func Advise(ld LoudnessData, target float32) []Advisory {
+	var advice []Advisory
+
+	normalizedPeak := ld.Peak + (target - ld.Integrated)
+	if ld.Peak > 0 {
+		advice = append(advice, newAdvisory(AdviceClipping, SeverityWarning,
+			msgClipping, FormatLevel(ld.Peak, 1)))
+	} else if normalizedPeak > AdvicePeakCeiling {
+		advice = append(advice, newAdvisory(AdviceNormalizedPeak, SeverityWarning,
+			msgNormalizedPeak, AdvicePeakCeiling, FormatLevel(target, 1), FormatLevel(normalizedPeak, 1)))
+	}
+
+	if ld.Range < AdviceMinRange {
+		advice = append(advice, newAdvisory(AdviceLowRange, SeverityInfo,
+			msgLowRange, FormatLevel(ld.Range, 1), AdviceMinRange))
+	} else if ld.Range > AdviceMaxRange {
+		advice = append(advice, newAdvisory(AdviceHighRange, SeverityInfo,
+			msgHighRange, FormatLevel(ld.Range, 1), AdviceMaxRange))
+	}
+
+	if ld.Integrated > AdviceMaxLoudness {
+		advice = append(advice, newAdvisory(AdviceTooLoud, SeverityInfo,
+			msgTooLoud, FormatLevel(ld.Integrated, 1), FormatLevel(ld.Integrated-target, 1)))
+	}
+
+	return advice
+}
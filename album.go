@@ -0,0 +1,180 @@
+package bs1770wrap
+
+import "fmt"
+
+// AlbumTrack is the outcome of analyzing one track of an
+// album.
+type AlbumTrack struct {
+	File     string
+	Loudness LoudnessData
+	Info     AnalysisInfo
+	Err      error // analysis failure, Loudness is then zero
+}
+
+// AlbumLoudness is the result of CalculateAlbumLoudness.
+type AlbumLoudness struct {
+	// Album is the measurement of the album as a whole, by
+	// bs1770gain's album summary. If Combined is set, it was
+	// instead combined from the tracks that could be
+	// analyzed: integrated loudness is their length-weighted
+	// energy mean, which is close to, but not exactly, what
+	// gating the album as a whole gives, and peak, momentary
+	// and short-term maxima and range are the largest of any
+	// track. Length is the total either way.
+	Album    LoudnessData
+	Combined bool
+
+	Tracks   []AlbumTrack // in the order given
+	Excluded int          // failed tracks left out of Album
+}
+
+// Partial reports whether some tracks could not be analyzed
+// and are missing from the album values.
+func (a AlbumLoudness) Partial() bool {
+	return a.Excluded > 0
+}
+
+// Failed returns the tracks that could not be analyzed.
+func (a AlbumLoudness) Failed() []AlbumTrack {
+	var failed []AlbumTrack
+	for _, t := range a.Tracks {
+		if t.Err != nil {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// CalculateAlbumLoudness analyzes the files as the tracks of
+// an album, running bs1770gain once over all of them.
+func CalculateAlbumLoudness(files []string) (AlbumLoudness, error) {
+	return CalculateAlbumLoudnessWithOptions(files, Options{})
+}
+
+// CalculateAlbumLoudnessWithOptions is like
+// CalculateAlbumLoudness, but takes options tuning the
+// analysis. If bs1770gain fails on the album as a whole, or
+// opts ask for anything but a plain bs1770gain analysis, the
+// tracks are analyzed one by one and the album values
+// combined from them. A track that fails is then reported in
+// its AlbumTrack and excluded from the album values rather
+// than failing the album; an error is only returned if no
+// track could be analyzed.
+func CalculateAlbumLoudnessWithOptions(files []string, opts Options) (AlbumLoudness, error) {
+	if wholeAlbum(opts) && len(files) > 0 {
+		a, err := analyzeAlbum(files, opts)
+		if err == nil {
+			return a, nil
+		}
+		logf(opts, LogWarn, "cannot analyze album as a whole, analyzing tracks one by one: %v", err)
+	}
+	return combineTracks(files, opts)
+}
+
+// wholeAlbum reports whether a single bs1770gain run over
+// the album measures it as opts ask for.
+func wholeAlbum(opts Options) bool {
+	if len(opts.Backends) > 0 {
+		if _, ok := opts.Backends[0].(BS1770Gain); !ok {
+			return false
+		}
+	}
+	return !preprocessing(opts) && opts.VerifyWith == nil &&
+		!opts.Inspect && !opts.RepairInput
+}
+
+// analyzeAlbum runs bs1770gain over all files at once.
+func analyzeAlbum(files []string, opts Options) (AlbumLoudness, error) {
+	info := AnalysisInfo{}
+	gd, err := measureLoudness(files, opts, &info)
+	if err != nil {
+		return AlbumLoudness{}, err
+	}
+	if !sameClips(gd.Album.Tracks, files) {
+		return AlbumLoudness{}, errClipCount
+	}
+	if gd.Album.Summary == nil {
+		return AlbumLoudness{}, withKind(ErrNoLoudnessData, fmt.Errorf("Cannot parse loudness information: no album summary in output"))
+	}
+
+	backend := BS1770Gain{}.Name()
+	a := AlbumLoudness{}
+	for i, t := range gd.Album.Tracks {
+		ti := AnalysisInfo{Backend: backend}
+		if g, ok, err := ReadGapless(files[i]); err == nil && ok {
+			ti.Gapless = &g
+			ti.MediaOffset = g.Offset()
+		}
+		length, err := probeLength(files[i], opts, &ti)
+		if err != nil {
+			return AlbumLoudness{}, err
+		}
+		length = excludePadding(length, opts, &ti)
+
+		ld, offset := calibrate(t.loudness(length), backend, opts)
+		ti.Calibration = offset
+		r := t.relative(ld, offset)
+		ti.Relative = &r
+		a.Tracks = append(a.Tracks, AlbumTrack{File: files[i], Loudness: ld, Info: ti})
+		a.Album.Length += length
+	}
+
+	length := a.Album.Length
+	summary := gd.Album.Summary.measurements
+	var offset float32
+	a.Album, offset = calibrate(summary.loudness(length), backend, opts)
+	for i := range a.Tracks {
+		r := a.Tracks[i].Info.Relative.withSummary(summary, a.Album, offset)
+		a.Tracks[i].Info.Relative = &r
+	}
+	return a, nil
+}
+
+// combineTracks analyzes the files one by one, combining the
+// album values from those that succeed.
+func combineTracks(files []string, opts Options) (AlbumLoudness, error) {
+	a := AlbumLoudness{Combined: true}
+	var levels, weights []float64
+	for _, file := range files {
+		ld, info, err := calculateSafely(file, opts)
+		a.Tracks = append(a.Tracks, AlbumTrack{File: file, Loudness: ld, Info: info, Err: err})
+		if err != nil {
+			a.Excluded++
+			continue
+		}
+
+		if len(levels) == 0 {
+			a.Album = ld
+		}
+		levels = append(levels, float64(ld.Integrated))
+		weights = append(weights, float64(ld.Length))
+		a.Album.Peak = max32(a.Album.Peak, ld.Peak)
+		a.Album.Range = max32(a.Album.Range, ld.Range)
+		a.Album.Shortterm = max32(a.Album.Shortterm, ld.Shortterm)
+		a.Album.Momentary = max32(a.Album.Momentary, ld.Momentary)
+		if len(levels) > 1 {
+			a.Album.Length += ld.Length
+		}
+	}
+
+	if len(levels) == 0 {
+		if len(files) == 0 {
+			return a, fmt.Errorf("Cannot analyze album: no tracks given")
+		}
+		return a, fmt.Errorf("Cannot analyze album: all %d tracks failed, first: %v", len(files), a.Tracks[0].Err)
+	}
+	if a.Album.Length == 0 {
+		// no lengths to weigh by
+		a.Album.Integrated = float32(EnergyMean(levels...))
+	} else {
+		a.Album.Integrated = float32(WeightedEnergyMean(levels, weights))
+	}
+	return a, nil
+}
+
+func max32(a, b float32) float32 {
+	if b > a {
+		return b
+	}
+	return a
+}
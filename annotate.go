@@ -0,0 +1,139 @@
+package bs1770wrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Annotation is a human note attached to a stored result,
+// such as a QC decision. Labels are free-form key/value
+// pairs (e.g. "qc": "approved") that can be queried on.
+type Annotation struct {
+	Time     time.Time         `json:"time"`
+	Operator string            `json:"operator,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Note     string            `json:"note,omitempty"`
+}
+
+// Annotator is implemented by stores that can keep
+// annotations alongside results. Annotations are kept in
+// the order they were added.
+type Annotator interface {
+	Annotate(key string, a Annotation) error
+	Annotations(key string) ([]Annotation, error)
+}
+
+// Annotate implements Annotator.
+func (s *MemoryStore) Annotate(key string, a Annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.annotations == nil {
+		s.annotations = make(map[string][]Annotation)
+	}
+	s.annotations[key] = append(s.annotations[key], a)
+	return nil
+}
+
+// Annotations implements Annotator.
+func (s *MemoryStore) Annotations(key string) ([]Annotation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Annotation(nil), s.annotations[key]...), nil
+}
+
+// FindLabeled returns the keys of results carrying an
+// annotation with the given label set to value; an empty
+// value matches any value.
+func (s *MemoryStore) FindLabeled(label, value string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for key, anns := range s.annotations {
+		for _, a := range anns {
+			v, ok := a.Labels[label]
+			if ok && (value == "" || v == value) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// Networked stores keep annotations as a JSON list under a
+// separate name. Adding one is a read-modify-write, so two
+// operators annotating the same result at the same moment
+// may lose one annotation.
+
+func appendAnnotation(buf []byte, a Annotation) ([]byte, error) {
+	var anns []Annotation
+	if len(buf) > 0 {
+		err := json.Unmarshal(buf, &anns)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse annotations: %v", err)
+		}
+	}
+	return json.Marshal(append(anns, a))
+}
+
+func parseAnnotations(buf []byte) ([]Annotation, error) {
+	var anns []Annotation
+	err := json.Unmarshal(buf, &anns)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse annotations: %v", err)
+	}
+	return anns, nil
+}
+
+// Annotate implements Annotator. Annotations don't expire.
+func (s *KVStore) Annotate(key string, a Annotation) error {
+	name := s.key(key) + ":annotations"
+	buf, _, err := s.Client.Get(name)
+	if err != nil {
+		return err
+	}
+	buf, err = appendAnnotation(buf, a)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(name, buf, 0)
+}
+
+// Annotations implements Annotator.
+func (s *KVStore) Annotations(key string) ([]Annotation, error) {
+	buf, ok, err := s.Client.Get(s.key(key) + ":annotations")
+	if err != nil || !ok {
+		return nil, err
+	}
+	return parseAnnotations(buf)
+}
+
+func (s *ObjectStore) annotationsName(key string) string {
+	return strings.TrimSuffix(s.name(key), ".json") + ".annotations.json"
+}
+
+// Annotate implements Annotator.
+func (s *ObjectStore) Annotate(key string, a Annotation) error {
+	name := s.annotationsName(key)
+	buf, _, err := s.Client.GetObject(name)
+	if err != nil {
+		return err
+	}
+	buf, err = appendAnnotation(buf, a)
+	if err != nil {
+		return err
+	}
+	return s.Client.PutObject(name, buf)
+}
+
+// Annotations implements Annotator.
+func (s *ObjectStore) Annotations(key string) ([]Annotation, error) {
+	buf, ok, err := s.Client.GetObject(s.annotationsName(key))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return parseAnnotations(buf)
+}
@@ -0,0 +1,304 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ACX (Audible) audiobook submission requirements, unweighted
+// levels in dBFS. The noise floor is the RMS level of the
+// quietest half second.
+const (
+	ACXMinRMS        = -23.0
+	ACXMaxRMS        = -18.0
+	ACXMaxPeak       = -3.0
+	ACXMaxNoiseFloor = -60.0
+)
+
+// DefaultACXTarget is the RMS level, in dBFS, audiobooks are
+// normalized to when AudiobookOptions.Target is zero, in the
+// middle of the ACX range.
+const DefaultACXTarget = -20.0
+
+// acxLimit is the ceiling, in dBFS, of the limiter applied
+// when the gain would push peaks above ACXMaxPeak, leaving
+// room for inter-sample overs the codec adds
+const acxLimit = -3.5
+
+// noiseWindow is the window the noise floor is measured over
+const noiseWindow = 500 * time.Millisecond
+
+// Levels are unweighted measurements of a signal, in dBFS.
+type Levels struct {
+	RMS        float64
+	Peak       float64 // sample peak
+	NoiseFloor float64 // RMS of the quietest noiseWindow; -Inf for digital silence
+}
+
+// MeasureLevels measures the unweighted levels of file,
+// decoding it with ffmpeg.
+func MeasureLevels(file string, opts Options) (Levels, error) {
+	return measureLevels(file, 0, 0, opts, &AnalysisInfo{})
+}
+
+// measureLevels measures the levels between start and end, or
+// of the whole file if end is zero. The samples are streamed
+// through, never held in memory.
+func measureLevels(file string, start, end time.Duration, opts Options, info *AnalysisInfo) (Levels, error) {
+	rate, channels, err := streamFormat(file, opts, info)
+	if err != nil {
+		return Levels{}, err
+	}
+
+	var stderr bytes.Buffer
+	args := []string{"-nostdin", "-loglevel", "error"}
+	if end > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(start.Seconds(), 'f', -1, 64))
+	}
+	args = append(args, "-i", ffmpegPath(file))
+	if end > 0 {
+		args = append(args, "-t", strconv.FormatFloat((end-start).Seconds(), 'f', -1, 64))
+	}
+	args = append(args, "-map", "0:a:0", "-f", "f32le", "-c:a", "pcm_f32le", "-")
+
+	m := newLevelMeter(int(float64(rate)*noiseWindow.Seconds()) * channels)
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stdout = m
+	cmd.Stderr = &stderr
+
+	t := time.Now()
+	err = run("ffmpeg", cmd, opts, info)
+	info.Timings.Analyze += time.Since(t)
+	if err != nil {
+		return Levels{}, fmt.Errorf("Cannot measure levels: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return m.levels(), nil
+}
+
+// streamFormat probes the sample rate and channel count of
+// the first audio stream of file.
+func streamFormat(file string, opts Options, info *AnalysisInfo) (int, int, error) {
+	var out, stderr bytes.Buffer
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=sample_rate,channels",
+		"-of", "csv=p=0",
+		ffmpegPath(file),
+	)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := run("ffprobe", cmd, opts, info)
+	info.Timings.Probe += time.Since(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Cannot probe audio format: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	fields := strings.Split(strings.TrimSpace(out.String()), ",")
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("Cannot probe audio format: no audio stream")
+	}
+	rate, err1 := strconv.Atoi(fields[0])
+	channels, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || rate <= 0 || channels <= 0 {
+		return 0, 0, fmt.Errorf("Cannot probe audio format: %q", out.String())
+	}
+	return rate, channels, nil
+}
+
+// levelMeter is an io.Writer taking little-endian float
+// samples, interleaved, and keeping their levels.
+type levelMeter struct {
+	window  int // samples per noise window, all channels
+	partial []byte
+
+	sum, peak float64
+	count     uint64
+
+	winSum   float64
+	winCount int
+	windows  int     // complete windows so far
+	floor    float64 // mean square of the quietest window
+}
+
+func newLevelMeter(window int) *levelMeter {
+	return &levelMeter{window: window, floor: math.Inf(1)}
+}
+
+func (m *levelMeter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(m.partial) > 0 {
+		need := 4 - len(m.partial)
+		if len(p) < need {
+			m.partial = append(m.partial, p...)
+			return n, nil
+		}
+		m.partial = append(m.partial, p[:need]...)
+		m.add(math.Float32frombits(binary.LittleEndian.Uint32(m.partial)))
+		m.partial = m.partial[:0]
+		p = p[need:]
+	}
+	for ; len(p) >= 4; p = p[4:] {
+		m.add(math.Float32frombits(binary.LittleEndian.Uint32(p)))
+	}
+	m.partial = append(m.partial, p...)
+	return n, nil
+}
+
+func (m *levelMeter) add(s float32) {
+	x := float64(s)
+	m.sum += x * x
+	m.count++
+	m.peak = math.Max(m.peak, math.Abs(x))
+
+	m.winSum += x * x
+	m.winCount++
+	if m.winCount == m.window {
+		m.floor = math.Min(m.floor, m.winSum/float64(m.window))
+		m.winSum, m.winCount = 0, 0
+		m.windows++
+	}
+}
+
+func (m *levelMeter) levels() Levels {
+	if m.count == 0 {
+		return Levels{RMS: math.Inf(-1), Peak: math.Inf(-1), NoiseFloor: math.Inf(-1)}
+	}
+	// signals shorter than a window are their own floor
+	floor := m.floor
+	if m.windows == 0 {
+		floor = m.winSum / float64(m.winCount)
+	}
+	return Levels{
+		RMS:        10 * math.Log10(m.sum/float64(m.count)),
+		Peak:       LinearToDB(m.peak),
+		NoiseFloor: 10 * math.Log10(floor),
+	}
+}
+
+// AudiobookChapter is the analysis of a chapter of an
+// audiobook.
+type AudiobookChapter struct {
+	ChapterLoudness
+	Levels Levels
+}
+
+// Audiobook is the analysis of an audiobook as a whole and
+// chapter by chapter.
+type Audiobook struct {
+	Loudness LoudnessData
+	Levels   Levels
+	Info     AnalysisInfo
+	Chapters []AudiobookChapter // empty if the file has no chapters
+}
+
+// AnalyzeAudiobook measures the loudness and levels of file
+// as a whole and per chapter. It requires ffmpeg besides the
+// backends. A chapter that fails is reported in its Err.
+func AnalyzeAudiobook(file string, opts Options) (Audiobook, error) {
+	ld, info, chapters, err := CalculateChapterLoudness(file, opts)
+	if err != nil {
+		return Audiobook{}, err
+	}
+	book := Audiobook{Loudness: ld, Info: info}
+	book.Levels, err = measureLevels(file, 0, 0, opts, &book.Info)
+	if err != nil {
+		return Audiobook{}, err
+	}
+
+	for _, c := range chapters {
+		ac := AudiobookChapter{ChapterLoudness: c}
+		if c.Err == nil {
+			ac.Levels, ac.Err = measureLevels(file, c.Start, c.End, opts, &ac.Info)
+		}
+		book.Chapters = append(book.Chapters, ac)
+	}
+	return book, nil
+}
+
+// AudiobookOptions tunes NormalizeAudiobook. Options are used
+// for the analysis and the tools spawned.
+type AudiobookOptions struct {
+	Target float64 // RMS, dBFS; DefaultACXTarget if zero
+
+	// Codec is the encoding of the output; nil means ffmpeg's
+	// default for the extension of the output file.
+	Codec *Codec
+
+	Options Options
+}
+
+// AudiobookResult describes an audiobook normalization.
+type AudiobookResult struct {
+	Before  Audiobook
+	Gain    float64 // dB applied
+	Limited bool    // a limiter held peaks below ACXMaxPeak
+
+	// Warnings list what the gain cannot fix, such as a noise
+	// floor or chapters out of the ACX range.
+	Warnings []string
+}
+
+// NormalizeAudiobook writes a copy of file to outFile with
+// one gain applied throughout, bringing its RMS level to the
+// target and limiting peaks to the ACX maximum if necessary.
+// Chapters and metadata are carried over. outFile must differ
+// from file; ErrReadOnly is returned in read-only mode.
+func NormalizeAudiobook(file, outFile string, aopts AudiobookOptions) (AudiobookResult, error) {
+	opts := aopts.Options
+	if opts.ReadOnly {
+		return AudiobookResult{}, ErrReadOnly
+	}
+	if samePath(file, outFile) {
+		return AudiobookResult{}, fmt.Errorf("Cannot normalize %s onto itself", file)
+	}
+	target := aopts.Target
+	if target == 0 {
+		target = DefaultACXTarget
+	}
+
+	book, err := AnalyzeAudiobook(file, opts)
+	if err != nil {
+		return AudiobookResult{}, err
+	}
+	r := AudiobookResult{Before: book, Gain: target - book.Levels.RMS}
+	if math.IsInf(r.Gain, 0) || math.IsNaN(r.Gain) {
+		return AudiobookResult{}, fmt.Errorf("Cannot normalize %s: it is silent", file)
+	}
+
+	filter := gainFilter(r.Gain)
+	if book.Levels.Peak+r.Gain > ACXMaxPeak {
+		r.Limited = true
+		filter += fmt.Sprintf(",alimiter=limit=%.4f:level=0", DBToLinear(acxLimit))
+	}
+	if floor := book.Levels.NoiseFloor + r.Gain; floor > ACXMaxNoiseFloor {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("noise floor will be %.1f dB, above the ACX maximum of %.0f dB", floor, ACXMaxNoiseFloor))
+	}
+	for _, c := range book.Chapters {
+		if c.Err != nil {
+			continue
+		}
+		if rms := c.Levels.RMS + r.Gain; rms < ACXMinRMS || rms > ACXMaxRMS {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("chapter %q will have an RMS level of %.1f dB, outside the ACX range", c.Title, rms))
+		}
+	}
+
+	codec := Codec{}
+	if aopts.Codec != nil {
+		codec = *aopts.Codec
+	}
+	err = renderFilter(file, outFile, filter, codec, opts, &r.Before.Info)
+	if err != nil {
+		return AudiobookResult{}, err
+	}
+	return r, auditRender(file, outFile, r.Gain, codec, opts)
+}
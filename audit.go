@@ -0,0 +1,148 @@
+package bs1770wrap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	AuditTagWrite  = "tag-write"
+	AuditApplyGain = "apply-gain"
+	AuditReencode  = "re-encode"
+)
+
+// AuditEntry records one modification of a file. Before and
+// After describe the state that was changed (tag values,
+// gain, file hashes), in whatever form suits the operation.
+type AuditEntry struct {
+	Time      time.Time         `json:"time"`
+	Operator  string            `json:"operator"`
+	Operation string            `json:"operation"`
+	File      string            `json:"file"`
+	Before    map[string]string `json:"before,omitempty"`
+	After     map[string]string `json:"after,omitempty"`
+}
+
+// AuditFilter selects audit entries; zero fields match
+// anything. Since and Until are inclusive.
+type AuditFilter struct {
+	File      string
+	Operation string
+	Operator  string
+	Since     time.Time
+	Until     time.Time
+}
+
+// Match reports whether e is selected by f.
+func (f AuditFilter) Match(e AuditEntry) bool {
+	return (f.File == "" || f.File == e.File) &&
+		(f.Operation == "" || f.Operation == e.Operation) &&
+		(f.Operator == "" || f.Operator == e.Operator) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !e.Time.After(f.Until))
+}
+
+// AuditLog is an append-only record of file modifications,
+// for chain-of-custody requirements. Every operation of this
+// package that modifies a file records itself in the
+// Options.Audit log before returning.
+type AuditLog interface {
+	Record(e AuditEntry) error
+	Query(f AuditFilter) ([]AuditEntry, error)
+}
+
+// FileAuditLog is an AuditLog kept as a file of JSON lines,
+// which is only ever appended to. It is safe for concurrent
+// use within a process, and lines are written with a single
+// append so several processes may share a log.
+type FileAuditLog struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Record implements AuditLog.
+func (l *FileAuditLog) Record(e AuditEntry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize audit entry: %v", err)
+	}
+	buf = append(buf, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := openFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot open audit log: %v", err)
+	}
+	_, err = f.Write(buf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write audit log: %v", err)
+	}
+	return nil
+}
+
+// Query implements AuditLog.
+func (l *FileAuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := openFile(l.Path, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		e := AuditEntry{}
+		err := json.Unmarshal(s.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse audit log: %v", err)
+		}
+		if filter.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read audit log: %v", err)
+	}
+	return entries, nil
+}
+
+// audit records a modification in opts.Audit, if set,
+// filling in the time and operator.
+func audit(opts Options, e AuditEntry) error {
+	if opts.Audit == nil {
+		return nil
+	}
+
+	e.Time = time.Now()
+	e.Operator = opts.Operator
+	if e.Operator == "" {
+		if u, err := user.Current(); err == nil {
+			e.Operator = u.Username
+		}
+	}
+
+	err := opts.Audit.Record(e)
+	if err != nil {
+		return fmt.Errorf("Cannot record modification of %s: %v", e.File, err)
+	}
+	return nil
+}
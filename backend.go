@@ -0,0 +1,78 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LoudnessAnalyzer is a backend capable of measuring the
+// loudness of an audio file. Implementations record the
+// tools they spawn and the time spent in info.
+type LoudnessAnalyzer interface {
+	Name() string
+	Analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error)
+}
+
+// BackendAttempt records the outcome of trying one backend.
+type BackendAttempt struct {
+	Backend string
+	Err     error // nil if the backend succeeded
+}
+
+// DefaultBackends is the chain used when Options.Backends is
+// empty: bs1770gain, falling back to ffmpeg where it is not
+// installed.
+var DefaultBackends = []LoudnessAnalyzer{BS1770Gain{}, FFmpeg{}}
+
+// analyzeChain tries each configured backend in turn,
+// returning the first successful result.
+func analyzeChain(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	backends := opts.Backends
+	if len(backends) == 0 {
+		backends = DefaultBackends
+	}
+
+	var msgs []string
+	var errs []error
+	for _, b := range backends {
+		info.ToolVersion = ""
+		ld, err := analyzeSafely(b, file, opts, info)
+		info.Attempts = append(info.Attempts, BackendAttempt{Backend: b.Name(), Err: err})
+		if err == nil {
+			info.Backend = b.Name()
+			ld, info.Calibration = calibrate(ld, b.Name(), opts)
+			if opts.VerifyWith != nil {
+				err = verifyResult(file, ld, opts, info)
+				if err != nil {
+					return LoudnessData{}, err
+				}
+			}
+			return ld, nil
+		}
+		logf(opts, LogWarn, "backend %s failed on %s: %v", b.Name(), file, err)
+		msgs = append(msgs, fmt.Sprintf("%s: %v", b.Name(), err))
+		errs = append(errs, err)
+		if ctx := opts.Context; ctx != nil && ctx.Err() != nil {
+			break // the next backend would not get anywhere either
+		}
+	}
+
+	if len(backends) == 1 {
+		return LoudnessData{}, info.Attempts[0].Err
+	}
+	return LoudnessData{}, &multiError{
+		msg:  fmt.Sprintf("All backends failed: %s", strings.Join(msgs, "; ")),
+		errs: errs,
+	}
+}
+
+// calibrate applies the calibration offset configured for
+// the named backend, returning the adjusted result and the
+// offset applied.
+func calibrate(ld LoudnessData, backend string, opts Options) (LoudnessData, float32) {
+	offset := opts.Calibration[backend]
+	ld.Integrated += offset
+	ld.Shortterm += offset
+	ld.Momentary += offset
+	return ld, offset
+}
@@ -0,0 +1,190 @@
+package bs1770wrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// BlockHistogramStep is the resolution, in LU, at which
+// BlockHistogram keeps block loudness.
+const BlockHistogramStep = 0.01
+
+// BlockHistogram counts the 400 ms gating blocks of a
+// measurement by loudness: Bins[i] is the number of blocks
+// in [i, i+1) * BlockHistogramStep LUFS. Blocks below
+// AbsoluteGate are not kept. Since gating only depends on
+// the distribution of block loudness, the histograms of the
+// tracks of an album add up to that of the album, whose
+// integrated loudness can then be gated exactly (to the
+// histogram's resolution) without decoding any audio.
+type BlockHistogram struct {
+	Bins map[int]uint64 `json:"bins"`
+}
+
+// NewBlockHistogram counts the given block loudness values,
+// such as those of a native.Meter.
+func NewBlockHistogram(blocks []float64) BlockHistogram {
+	h := BlockHistogram{}
+	for _, l := range blocks {
+		h.Add(l)
+	}
+	return h
+}
+
+// Add counts a block of the given loudness.
+func (h *BlockHistogram) Add(lufs float64) {
+	if !(lufs >= AbsoluteGate) {
+		return
+	}
+	if h.Bins == nil {
+		h.Bins = make(map[int]uint64)
+	}
+	h.Bins[int(math.Floor(lufs/BlockHistogramStep))]++
+}
+
+// Merge adds the blocks of other to h.
+func (h *BlockHistogram) Merge(other BlockHistogram) {
+	if h.Bins == nil {
+		h.Bins = make(map[int]uint64, len(other.Bins))
+	}
+	for i, n := range other.Bins {
+		h.Bins[i] += n
+	}
+}
+
+// Blocks returns the number of blocks counted.
+func (h BlockHistogram) Blocks() uint64 {
+	var total uint64
+	for _, n := range h.Bins {
+		total += n
+	}
+	return total
+}
+
+// binLoudness is the loudness a bin stands for, its center.
+func binLoudness(i int) float64 {
+	return (float64(i) + 0.5) * BlockHistogramStep
+}
+
+// Integrated gates the blocks as BS.1770 does and returns
+// the integrated loudness, or -Inf if no block passes.
+func (h BlockHistogram) Integrated() float64 {
+	gated := func(threshold float64) float64 {
+		sum, count := 0.0, uint64(0)
+		for i, n := range h.Bins {
+			if l := binLoudness(i); l >= threshold {
+				sum += float64(n) * LoudnessToEnergy(l)
+				count += n
+			}
+		}
+		if count == 0 {
+			return math.Inf(-1)
+		}
+		return EnergyToLoudness(sum / float64(count))
+	}
+	return gated(gated(AbsoluteGate) + RelativeGate)
+}
+
+// BlockStore is implemented by stores that can keep block
+// histograms alongside results. Cache stores the histogram
+// of every analysis whose backend measured one (see
+// AnalysisInfo.Blocks).
+type BlockStore interface {
+	PutBlocks(key string, h BlockHistogram) error
+	Blocks(key string) (BlockHistogram, bool, error)
+}
+
+// AlbumIntegrated recomputes the integrated loudness of an
+// album from the block histograms store has for the keys of
+// its tracks, so album gain can be updated instantly as
+// tracks are added or removed. It reports false if a track
+// has no histogram, in which case the album has to be
+// analyzed.
+func AlbumIntegrated(store BlockStore, keys []string) (float64, bool, error) {
+	album := BlockHistogram{}
+	for _, key := range keys {
+		h, ok, err := store.Blocks(key)
+		if err != nil {
+			return 0, false, fmt.Errorf("Cannot read block histogram: %v", err)
+		}
+		if !ok {
+			return 0, false, nil
+		}
+		album.Merge(h)
+	}
+	return album.Integrated(), true, nil
+}
+
+// PutBlocks implements BlockStore.
+func (s *MemoryStore) PutBlocks(key string, h BlockHistogram) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blocks == nil {
+		s.blocks = make(map[string]BlockHistogram)
+	}
+	s.blocks[key] = h
+	return nil
+}
+
+// Blocks implements BlockStore.
+func (s *MemoryStore) Blocks(key string) (BlockHistogram, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.blocks[key]
+	return h, ok, nil
+}
+
+// Networked stores keep histograms as JSON under a separate
+// name, like annotations.
+
+func parseBlocks(buf []byte) (BlockHistogram, bool, error) {
+	h := BlockHistogram{}
+	err := json.Unmarshal(buf, &h)
+	if err != nil {
+		return BlockHistogram{}, false, fmt.Errorf("Cannot parse block histogram: %v", err)
+	}
+	return h, true, nil
+}
+
+// PutBlocks implements BlockStore. Histograms expire along
+// with results.
+func (s *KVStore) PutBlocks(key string, h BlockHistogram) error {
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize block histogram: %v", err)
+	}
+	return s.Client.Set(s.key(key)+":blocks", buf, s.TTL)
+}
+
+// Blocks implements BlockStore.
+func (s *KVStore) Blocks(key string) (BlockHistogram, bool, error) {
+	buf, ok, err := s.Client.Get(s.key(key) + ":blocks")
+	if err != nil || !ok {
+		return BlockHistogram{}, false, err
+	}
+	return parseBlocks(buf)
+}
+
+func (s *ObjectStore) blocksName(key string) string {
+	return strings.TrimSuffix(s.name(key), ".json") + ".blocks.json"
+}
+
+// PutBlocks implements BlockStore.
+func (s *ObjectStore) PutBlocks(key string, h BlockHistogram) error {
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize block histogram: %v", err)
+	}
+	return s.Client.PutObject(s.blocksName(key), buf)
+}
+
+// Blocks implements BlockStore.
+func (s *ObjectStore) Blocks(key string) (BlockHistogram, bool, error) {
+	buf, ok, err := s.Client.GetObject(s.blocksName(key))
+	if err != nil || !ok {
+		return BlockHistogram{}, false, err
+	}
+	return parseBlocks(buf)
+}
@@ -0,0 +1,93 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/burillo-se/bs1770wrap/native"
+)
+
+// sineWAV writes seconds of a 1 kHz mono sine of amplitude
+// amp as a 16-bit WAV file, returning its samples.
+func sineWAV(t *testing.T, file string, amp, seconds float64) []byte {
+	t.Helper()
+	const rate = 48000
+	pcm := make([]byte, 2*int(rate*seconds))
+	for i := 0; i < len(pcm)/2; i++ {
+		v := amp * math.Sin(2*math.Pi*1000*float64(i)/rate)
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(v*math.MaxInt16)))
+	}
+	buf := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	buf = binary.LittleEndian.AppendUint32(buf, 16)
+	buf = binary.LittleEndian.AppendUint16(buf, wavPCM)
+	buf = binary.LittleEndian.AppendUint16(buf, 1)
+	buf = binary.LittleEndian.AppendUint32(buf, rate)
+	buf = binary.LittleEndian.AppendUint32(buf, 2*rate)
+	buf = binary.LittleEndian.AppendUint16(buf, 2)
+	buf = binary.LittleEndian.AppendUint16(buf, 16)
+	buf = append(buf, "data"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(pcm)))
+	buf = append(buf, pcm...)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	if err := os.WriteFile(file, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return pcm
+}
+
+func TestAlbumIntegratedFromCachedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	store := NewMemoryStore()
+	c := &Cache{Store: store, Options: Options{Backends: []LoudnessAnalyzer{Native{}}}}
+
+	var keys []string
+	var blocks []float64
+	for i, amp := range []float64{0.5, 0.05, 0.2} {
+		file := filepath.Join(dir, string(rune('a'+i))+".wav")
+		pcm := sineWAV(t, file, amp, 3)
+		if _, err := c.CalculateLoudness(file); err != nil {
+			t.Fatal(err)
+		}
+		key, err := PathMtimeKey{}.Key(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		r, err := native.Analyze(bytes.NewReader(pcm), native.Format{Rate: 48000, Channels: 1, Encoding: native.Int16}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, r.Blocks...)
+	}
+
+	got, ok, err := AlbumIntegrated(store, keys)
+	if err != nil || !ok {
+		t.Fatalf("AlbumIntegrated = %v, %v, %v; want cached blocks", got, ok, err)
+	}
+	if want := gateBlocks(blocks); math.Abs(got-want) > BlockHistogramStep {
+		t.Errorf("album loudness from cached blocks is %.3f LUFS, gating the blocks of the tracks gives %.3f LUFS", got, want)
+	}
+
+	if _, ok, _ := AlbumIntegrated(store, append(keys, "missing")); ok {
+		t.Error("AlbumIntegrated reported a track without blocks as cached")
+	}
+}
+
+// gateBlocks gates block loudness values as BS.1770 does.
+func gateBlocks(blocks []float64) float64 {
+	gated := func(threshold float64) float64 {
+		sum, n := 0.0, 0
+		for _, l := range blocks {
+			if l >= threshold {
+				sum += LoudnessToEnergy(l)
+				n++
+			}
+		}
+		return EnergyToLoudness(sum / float64(n))
+	}
+	return gated(gated(AbsoluteGate) + RelativeGate)
+}
@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"math"
 	"os/exec"
-	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // LoudnessData struct used to return result of
@@ -38,12 +39,18 @@ type LoudnessData struct {
 </bs1770gain>
 `
 
-We ignore the summary part, as well as ignore everything else.
+The album summary follows the tracks, as a <summary> element
+with the same children; everything else is ignored.
+
+Depending on version and flags, the maxima are spelled either
+"momentary" / "shortterm" or "momentary-maximum" /
+"shortterm-maximum"; both spellings are accepted.
 */
 
 type integratedData struct {
 	XMLName xml.Name `xml:"integrated"`
 	Value   float32  `xml:"lufs,attr"`
+	LU      *float32 `xml:"lu,attr"` // gain to the reference, if reported
 }
 
 type rangeData struct {
@@ -54,30 +61,171 @@ type rangeData struct {
 type truePeakData struct {
 	XMLName xml.Name `xml:"true-peak"`
 	Value   float32  `xml:"tpfs,attr"`
+	Factor  *float32 `xml:"factor,attr"` // linear, if reported
+}
+
+// maximum momentary or short-term loudness
+type levelData struct {
+	Value float32  `xml:"lufs,attr"`
+	LU    *float32 `xml:"lu,attr"`
 }
 
-type momentaryMaximumData struct {
-	XMLName xml.Name `xml:"momentary"`
-	Value float32    `xml:"lufs,attr"`
+// Releases differ in what they call the attributes holding
+// the levels: 0.6 and later name them after the unit asked
+// for, so each level is read from the first of several names
+// present. A true peak only given as a factor is converted.
+// The levels relative to the reference, "lu" and "factor",
+// are kept where reported.
+
+func (d *integratedData) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var err error
+	if d.LU, err = optionalAttr(start, "lu"); err != nil {
+		return err
+	}
+	return levelAttr(dec, start, &d.Value, "lufs", "lkfs")
 }
 
-type shorttermMaximumData struct {
-	XMLName xml.Name `xml:"shortterm-maximum"`
-	Value float32    `xml:"lufs,attr"`
+func (d *rangeData) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	return levelAttr(dec, start, &d.Value, "lufs", "lu", "lra")
+}
+
+func (d *truePeakData) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var err error
+	if d.Factor, err = optionalAttr(start, "factor"); err != nil {
+		return err
+	}
+	if hasAttr(start, "tpfs", "dbtp", "dbfs") {
+		return levelAttr(dec, start, &d.Value, "tpfs", "dbtp", "dbfs")
+	}
+	if d.Factor != nil && *d.Factor > 0 {
+		d.Value = float32(LinearToDB(float64(*d.Factor)))
+	}
+	return dec.Skip()
+}
+
+func (d *levelData) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var err error
+	if d.LU, err = optionalAttr(start, "lu"); err != nil {
+		return err
+	}
+	return levelAttr(dec, start, &d.Value, "lufs", "lkfs")
+}
+
+// levelAttr sets v from the first of the named attributes of
+// start present, and skips the rest of the element.
+func levelAttr(dec *xml.Decoder, start xml.StartElement, v *float32, names ...string) error {
+	found := false
+	for _, name := range names {
+		for _, a := range start.Attr {
+			if found || a.Name.Local != name {
+				continue
+			}
+			f, err := strconv.ParseFloat(a.Value, 32)
+			if err != nil {
+				return fmt.Errorf("bad %s value %q", start.Name.Local, a.Value)
+			}
+			*v, found = float32(f), true
+		}
+	}
+	return dec.Skip()
+}
+
+// optionalAttr returns the named attribute of start, or nil
+// if absent.
+func optionalAttr(start xml.StartElement, name string) (*float32, error) {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			f, err := strconv.ParseFloat(a.Value, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s %s value %q", start.Name.Local, name, a.Value)
+			}
+			v := float32(f)
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+// hasAttr reports whether start has any of the attributes.
+func hasAttr(start xml.StartElement, names ...string) bool {
+	for _, a := range start.Attr {
+		for _, name := range names {
+			if a.Name.Local == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// measurements are reported the same way for tracks and the
+// album summary
+type measurements struct {
+	Integrated       integratedData
+	Momentary        *levelData `xml:"momentary"`
+	MomentaryMaximum *levelData `xml:"momentary-maximum"`
+	Shortterm        *levelData `xml:"shortterm"`
+	ShorttermMaximum *levelData `xml:"shortterm-maximum"`
+	Range            rangeData
+	TruePeak         truePeakData
+}
+
+// momentary returns the maximum momentary loudness, under
+// whichever element name it was reported.
+func (m measurements) momentary() float32 {
+	return firstLevel(m.MomentaryMaximum, m.Momentary)
+}
+
+// shortterm returns the maximum short-term loudness, under
+// whichever element name it was reported.
+func (m measurements) shortterm() float32 {
+	return firstLevel(m.ShorttermMaximum, m.Shortterm)
+}
+
+// loudness returns the measurements, with the given length.
+func (m measurements) loudness(length uint64) LoudnessData {
+	return LoudnessData{
+		Integrated: m.Integrated.Value,
+		Range:      m.Range.Value,
+		Peak:       m.TruePeak.Value,
+		Shortterm:  m.shortterm(),
+		Momentary:  m.momentary(),
+		Length:     length,
+	}
 }
 
 type trackData struct {
-	XMLName            xml.Name `xml:"track"`
-	Integrated         integratedData
-	MomentaryMaximum   momentaryMaximumData
-	ShorttermMaximum   shorttermMaximumData
-	Range              rangeData
-	TruePeak           truePeakData
+	XMLName xml.Name `xml:"track"`
+	Number  int      `xml:"number,attr"`
+	File    string   `xml:"file,attr"`
+	measurements
+}
+
+type summaryData struct {
+	XMLName xml.Name `xml:"summary"`
+	measurements
+}
+
+func firstLevel(levels ...*levelData) float32 {
+	if l := firstReported(levels...); l != nil {
+		return l.Value
+	}
+	return 0
+}
+
+func firstReported(levels ...*levelData) *levelData {
+	for _, l := range levels {
+		if l != nil {
+			return l
+		}
+	}
+	return nil
 }
 
 type albumData struct {
-	XMLName xml.Name `xml:"album"`
-	Track   trackData
+	XMLName xml.Name     `xml:"album"`
+	Tracks  []trackData  `xml:"track"`
+	Summary *summaryData `xml:"summary"`
 }
 
 type bs1770gainData struct {
@@ -88,75 +236,245 @@ type bs1770gainData struct {
 // CalculateLoudness will take in a path to an audio file,
 // analyze it with bs1770gain, and return a struct populated
 // with data we're interested in. To avoid bass-heavy music
-// skewing the measurements, we'll be using sox to highpass
-// the file before scanning it for loudness.
+// skewing the measurements, use CalculateLoudnessWithOptions
+// with Options.Highpass set, which has sox highpass the file
+// before scanning it for loudness.
 func CalculateLoudness(file string) (LoudnessData, error) {
-	var out bytes.Buffer
+	ld, _, err := CalculateLoudnessWithOptions(file, Options{})
+	return ld, err
+}
 
-	sampleRegex, err := regexp.Compile(`Length \(seconds\):\s+(?P<len>\d+(\.\d+)?)`)
+// CalculateLoudnessWithOptions is like CalculateLoudness, but
+// takes options tuning the analysis and also reports how the
+// analysis went. The backends in opts.Backends are tried in
+// order until one succeeds.
+func CalculateLoudnessWithOptions(file string, opts Options) (LoudnessData, AnalysisInfo, error) {
+	info := AnalysisInfo{}
+	input := file
+	if ctx := opts.Context; ctx != nil && ctx.Err() != nil {
+		err := contextError(ctx)
+		info.Aborted = AbortReasonOf(err)
+		return LoudnessData{}, info, err
+	}
+	startTrace(file, opts, &info)
+	if opts.OnProgress != nil {
+		// backends may be handed scratch copies, progress is
+		// still that of file
+		onProgress, name := opts.OnProgress, file
+		opts.OnProgress = func(_ string, percent float64) { onProgress(name, percent) }
+		opts.OnProgress(file, 0)
+	}
+	var cleanup func()
+	err := withHooks(opts, &HookEvent{Stage: StageProbe, File: input, Info: &info}, func(*HookEvent) error {
+		if opts.Inspect || opts.RepairInput {
+			inspected, done, err := inspect(file, opts, &info)
+			if err != nil {
+				return err
+			}
+			cleanup = done
+			file = inspected
+		}
+		if g, ok, err := ReadGapless(file); err == nil && ok {
+			info.Gapless = &g
+			info.MediaOffset = g.Offset()
+		}
+		if links, err := oggLinks(file); err == nil && len(links) > 1 {
+			info.Warnings = append(info.Warnings, fmt.Sprintf("chained Ogg file of %d links measured as a whole, see CalculateChainedLoudness", len(links)))
+		}
+		return nil
+	})
+	if cleanup != nil {
+		defer cleanup()
+	}
 	if err != nil {
-		return LoudnessData{}, fmt.Errorf("Cannot compile regex: %v", err)
+		info.Aborted = AbortReasonOf(err)
+		return LoudnessData{}, info, err
 	}
 
-	// write a hi-passed file into temporary dir
-	cmd := exec.Command("sox",
-		file,
-		"-n",
-		"stat",
-	)
-	cmd.Stderr = &out
-
-	err = cmd.Run()
+	var ld LoudnessData
+	err = withHooks(opts, &HookEvent{Stage: StageAnalyze, File: input, Info: &info}, func(e *HookEvent) error {
+		var err error
+		ld, err = analyze(file, opts, &info)
+		if err != nil && opts.RemuxOnError {
+			ld, err = retryRemuxed(file, err, opts, &info)
+		}
+		if err == nil && len(opts.Metrics) > 0 {
+			err = computeMetrics(file, ld, opts, &info)
+		}
+		if err == nil {
+			e.Loudness = &ld
+		}
+		return err
+	})
 	if err != nil {
-		return LoudnessData{}, fmt.Errorf("Error creating temporary file: %v", err)
+		info.Aborted = AbortReasonOf(err)
+		logf(opts, LogError, "cannot analyze %s: %v", file, err)
+	} else {
+		reportProgress(opts, file, 100)
+		logf(opts, LogInfo, "analyzed %s with %s in %v: %s LUFS", file, info.Backend, info.Timings.Total(), FormatLevel(ld.Integrated, DefaultPrecision))
 	}
+	return ld, info, err
+}
 
-	// get length from regex
-	matches := sampleRegex.FindStringSubmatch(out.String())
+// analyze measures file, preprocessing it first if asked to.
+func analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	if preprocessing(opts) {
+		return analyzePreprocessed(file, opts, info)
+	}
+	return analyzeChain(file, opts, info)
+}
+
+// BS1770Gain is the LoudnessAnalyzer that runs bs1770gain,
+// with sox (or ffprobe) measuring the length. The path may
+// also be a directory, in which case bs1770gain analyzes it
+// as an album and opts.TrackNumber or opts.TrackFile select
+// which track's measurements are returned.
+type BS1770Gain struct{}
+
+// Name implements LoudnessAnalyzer.
+func (BS1770Gain) Name() string {
+	return "bs1770gain"
+}
 
-	result := make(map[string]string)
-	for i, name := range matches {
-		result[sampleRegex.SubexpNames()[i]] = name
+// Analyze implements LoudnessAnalyzer.
+func (BS1770Gain) Analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	gd, err := measureLoudness([]string{file}, opts, info)
+	if err != nil {
+		return LoudnessData{}, err
 	}
-	lenstr, ok := result["len"]
-	if !ok {
-		return LoudnessData{}, fmt.Errorf("Cannot get audio length: regex did not match")
+
+	track, err := selectTrack(gd.Album, opts)
+	if err != nil {
+		return LoudnessData{}, err
 	}
 
-	len64, err := strconv.ParseFloat(lenstr, 32)
+	length, err := probeLength(trackPath(file, track), opts, info)
 	if err != nil {
-		return LoudnessData{}, fmt.Errorf("Cannot parse audio length: %v", err)
+		return LoudnessData{}, err
 	}
-	out.Reset()
+	length = excludePadding(length, opts, info)
+
+	ld := track.loudness(length)
+	cal, offset := calibrate(ld, BS1770Gain{}.Name(), opts)
+	r := track.relative(cal, offset)
+	info.Relative = &r
+	return ld, nil
+}
 
-	cmd = exec.Command("bs1770gain",
-		"-itrms",           // integrated, true peak, range, momentary, shortterm
-		"--loglevel=quiet", // remove all non-essential output
-		"--xml",            // get XML output
-		file,            // what file to scan
-	)
+// probeLength finds out how long the file is, in
+// microseconds, and records its format in info.Media. sox
+// reads it from the header, without decoding the file; files
+// sox cannot read, or when sox is missing, are probed with
+// ffprobe instead.
+func probeLength(file string, opts Options, info *AnalysisInfo) (uint64, error) {
+	length, err := soxLength(file, opts, info)
+	if err == nil {
+		return length, nil
+	}
+	logf(opts, LogDebug, "sox cannot tell the length of %s, trying ffprobe: %v", file, err)
+	length, ferr := probeDuration(file, opts, info)
+	if ferr == nil {
+		return length, nil
+	}
+	return 0, &multiError{
+		msg:  fmt.Sprintf("Cannot get audio length: sox: %v; ffprobe: %v", err, ferr),
+		errs: []error{err, ferr},
+	}
+}
 
+// soxLength reads the length of file from its header with
+// sox --info.
+func soxLength(file string, opts Options, info *AnalysisInfo) (uint64, error) {
+	var out bytes.Buffer
+
+	cmd := exec.Command("sox", "--info", toolPath(file))
 	cmd.Stdout = &out
 
-	err = cmd.Run()
+	start := time.Now()
+	err := run("sox", cmd, opts, info)
+	info.Timings.Probe += time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+
+	m, ok := parseSoxInfo(out.String())
+	samples, found := soxSamples(out.String())
+	if !ok || !found {
+		return 0, withKind(ErrParse, fmt.Errorf("Cannot parse audio length: %q", strings.TrimSpace(out.String())))
+	}
+	if info.Media == nil {
+		info.Media = &m
+	}
+	return uint64(math.Round(float64(samples) / float64(m.SampleRate) * 1000000.0)), nil
+}
+
+// measureLoudness runs bs1770gain over files or directories
+// and parses its report. Builds of bs1770gain that don't
+// know --xml, such as those without libxml, are rerun with
+// --csv, and then with neither if need be; their CSV or plain
+// text report is parsed instead.
+func measureLoudness(paths []string, opts Options, info *AnalysisInfo) (bs1770gainData, error) {
+	start := time.Now()
+	out, stderr, err := runBS1770gain(paths, "xml", opts, info)
+	if err != nil && flagUnsupported(stderr, "xml") {
+		out, stderr, err = runBS1770gain(paths, "csv", opts, info)
+		if err != nil && flagUnsupported(stderr, "csv") {
+			out, _, err = runBS1770gain(paths, "", opts, info)
+		}
+	}
+	info.Timings.Analyze += time.Since(start)
 	if err != nil {
-		return LoudnessData{}, fmt.Errorf("Cannot calculate loudness: %v", err)
+		return bs1770gainData{}, fmt.Errorf("Cannot calculate loudness: %w", err)
+	}
+	if opts.KeepRawOutput {
+		info.RawOutput = out
 	}
 
+	start = time.Now()
 	gd := bs1770gainData{}
-	err = xml.Unmarshal([]byte(out.String()), &gd)
+	switch {
+	case looksLikeXML(out):
+		err = xml.Unmarshal(out, &gd)
+	case looksLikeCSV(out):
+		gd, err = parseCSV(out)
+	default:
+		gd, err = parseText(out)
+	}
+	info.Timings.Parse += time.Since(start)
 	if err != nil {
-		return LoudnessData{}, fmt.Errorf("Cannot parse loudness information: %v", err)
+		return bs1770gainData{}, withKind(ErrParse, fmt.Errorf("Cannot parse loudness information: %w", err))
 	}
+	return gd, nil
+}
 
-	microseconds := uint64(math.Round(len64 * 1000000.0))
+// runBS1770gain runs bs1770gain over paths, asking for a
+// report in format ("xml", "csv", or "" for plain text).
+func runBS1770gain(paths []string, format string, opts Options, info *AnalysisInfo) ([]byte, []byte, error) {
+	var out, stderr bytes.Buffer
 
-	return LoudnessData{
-		Integrated: gd.Album.Track.Integrated.Value,
-		Range:      gd.Album.Track.Range.Value,
-		Peak:       gd.Album.Track.TruePeak.Value,
-		Shortterm:  gd.Album.Track.ShorttermMaximum.Value,
-		Momentary:  gd.Album.Track.MomentaryMaximum.Value,
-		Length:     microseconds,
-	}, nil
+	args := []string{"-itrms"} // integrated, true peak, range, momentary, shortterm
+	v, err := bs1770gainVersion(opts, info)
+	if err == nil {
+		info.ToolVersion = v.String()
+	}
+	if err == nil && v.AtLeast(0, 6) {
+		// 0.6 dropped --loglevel; quiet down the progress
+		// display instead, and have levels in LUFS
+		args = append(args, "--suppress-progress", "--unit=ebu")
+	} else {
+		args = append(args, "--loglevel=quiet") // remove all non-essential output
+	}
+	if format != "" {
+		args = append(args, "--"+format) // get XML or CSV output
+	}
+	for _, path := range paths {
+		args = append(args, toolPath(path)) // what files to scan
+	}
+
+	cmd := exec.Command("bs1770gain", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err = run("bs1770gain", cmd, opts, info)
+	return out.Bytes(), stderr.Bytes(), err
 }
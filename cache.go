@@ -0,0 +1,476 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// KeyStrategy derives the key under which results for a
+// file are cached. Different storage backends need different
+// invalidation semantics (e.g. object stores have no mtime),
+// so the strategy is pluggable.
+type KeyStrategy interface {
+	Key(file string) (string, error)
+}
+
+// OptionsKeyStrategy is implemented by key strategies that
+// run tools. Cache calls KeyWithOptions instead of Key, with
+// its Options, so that the tools are found, run and limited
+// as those of the analysis are.
+type OptionsKeyStrategy interface {
+	KeyStrategy
+	KeyWithOptions(file string, opts Options) (string, error)
+}
+
+// KeyFunc adapts an ordinary function to the KeyStrategy
+// interface, which is handy for keying by an external ID
+// (database row, asset UUID and so on).
+type KeyFunc func(file string) (string, error)
+
+// Key calls f(file).
+func (f KeyFunc) Key(file string) (string, error) {
+	return f(file)
+}
+
+// PathMtimeKey keys files by absolute path, size and
+// modification time. It is cheap, but any touch of the file
+// invalidates the entry, and it is meaningless on storage
+// that doesn't preserve mtime.
+type PathMtimeKey struct{}
+
+// Key implements KeyStrategy.
+func (PathMtimeKey) Key(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", fmt.Errorf("Cannot resolve path: %v", err)
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("Cannot stat file: %v", err)
+	}
+	return fmt.Sprintf("path:%s:%d:%d", abs, fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// ContentHashKey keys files by SHA-256 of their full
+// content, so renames and copies share an entry while any
+// change to the bytes (including tags) invalidates it.
+type ContentHashKey struct{}
+
+// Key implements KeyStrategy.
+func (ContentHashKey) Key(file string) (string, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return "", fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("Cannot hash file: %v", err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AudioHashKey keys files by SHA-256 of their decoded audio
+// frames, as produced by sox. Rewriting tags leaves the key
+// unchanged, at the cost of decoding the whole file.
+type AudioHashKey struct{}
+
+// Key implements KeyStrategy, running sox with the default
+// Options.
+func (k AudioHashKey) Key(file string) (string, error) {
+	return k.KeyWithOptions(file, Options{})
+}
+
+// KeyWithOptions implements OptionsKeyStrategy: sox is run
+// with the Tools, Runner, Timeout and Context of opts.
+func (AudioHashKey) KeyWithOptions(file string, opts Options) (string, error) {
+	var stderr bytes.Buffer
+
+	h := sha256.New()
+	cmd := exec.Command("sox",
+		toolPath(file),
+		"-t", "raw", // headerless output, so only samples are hashed
+		"-",
+	)
+	cmd.Stdout = h
+	cmd.Stderr = &stderr
+
+	if err := run("sox", cmd, opts, &AnalysisInfo{}); err != nil {
+		return "", fmt.Errorf("Cannot decode audio: %w", err)
+	}
+	return "audio:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Store is a place to keep loudness data between runs.
+// Get reports whether the key was found.
+type Store interface {
+	Get(key string) (LoudnessData, bool, error)
+	Put(key string, ld LoudnessData) error
+}
+
+// StoredResult is a result as kept by a store that knows
+// which file it was computed for. Method is filled in by
+// stores that keep it (see MethodStore).
+type StoredResult struct {
+	Key      string
+	File     string
+	Loudness LoudnessData
+	Updated  time.Time
+	Method   *Method
+}
+
+// FileStore is implemented by stores that can remember the
+// path a result was computed for, next to the result itself.
+// Cache uses PutFile instead of Put when it is available.
+type FileStore interface {
+	Store
+	PutFile(key, file string, ld LoudnessData) error
+}
+
+// Lister is implemented by stores that can enumerate their
+// results.
+type Lister interface {
+	List() ([]StoredResult, error)
+}
+
+// MemoryStore is a Store backed by a map. It is safe for
+// concurrent use.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	data        map[string]StoredResult
+	annotations map[string][]Annotation
+	blocks      map[string]BlockHistogram
+	methods     map[string]Method
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string]StoredResult)}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(key string) (LoudnessData, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.data[key]
+	return r.Loudness, ok, nil
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(key string, ld LoudnessData) error {
+	return s.PutFile(key, "", ld)
+}
+
+// PutFile implements FileStore.
+func (s *MemoryStore) PutFile(key, file string, ld LoudnessData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = StoredResult{Key: key, File: file, Loudness: ld, Updated: time.Now()}
+	return nil
+}
+
+// List implements Lister. Results are in no particular
+// order.
+func (s *MemoryStore) List() ([]StoredResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := make([]StoredResult, 0, len(s.data))
+	for _, r := range s.data {
+		if m, ok := s.methods[r.Key]; ok {
+			r.Method = &m
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Claimer hands out exclusive, expiring claims on keys. It
+// lets a fleet of workers sharing a Store agree that only one
+// of them analyzes a given file at a time. Claim reports
+// whether the caller obtained the claim, and the token that
+// identifies it as the owner; Release gives up a claim only
+// if it still holds that token, so a worker whose claim lapsed
+// and was taken over cannot release its successor's. A claim
+// that is not released lapses after ttl, so a crashed worker
+// doesn't block the key forever.
+type Claimer interface {
+	Claim(key string, ttl time.Duration) (token string, ok bool, err error)
+	Release(key, token string) error
+}
+
+// MemoryClaimer is a Claimer for workers within one process.
+// It is safe for concurrent use.
+type MemoryClaimer struct {
+	mu     sync.Mutex
+	claims map[string]memoryClaim
+}
+
+type memoryClaim struct {
+	token  string
+	expiry time.Time
+}
+
+// NewMemoryClaimer creates a MemoryClaimer with no claims.
+func NewMemoryClaimer() *MemoryClaimer {
+	return &MemoryClaimer{claims: make(map[string]memoryClaim)}
+}
+
+// Claim implements Claimer.
+func (c *MemoryClaimer) Claim(key string, ttl time.Duration) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if held, ok := c.claims[key]; ok && now.Before(held.expiry) {
+		return "", false, nil
+	}
+	token := claimToken()
+	c.claims[key] = memoryClaim{token: token, expiry: now.Add(ttl)}
+	return token, true, nil
+}
+
+// Release implements Claimer.
+func (c *MemoryClaimer) Release(key, token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.claims[key].token == token {
+		delete(c.claims, key)
+	}
+	return nil
+}
+
+// ClaimRenewer is implemented by Claimers whose claims can
+// be extended. Renew pushes the expiry of the claim holding
+// token to ttl from now, reporting false if it lapsed or is
+// not the caller's any longer. Cache renews its claims while
+// it analyzes, so that long analyses are not taken over.
+type ClaimRenewer interface {
+	Renew(key, token string, ttl time.Duration) (bool, error)
+}
+
+// Renew implements ClaimRenewer.
+func (c *MemoryClaimer) Renew(key, token string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if held, ok := c.claims[key]; !ok || held.token != token || !now.Before(held.expiry) {
+		return false, nil
+	}
+	c.claims[key] = memoryClaim{token: token, expiry: now.Add(ttl)}
+	return true, nil
+}
+
+// Default claim settings used by Cache when its fields are
+// left zero.
+const (
+	DefaultClaimTTL     = 10 * time.Minute
+	DefaultPollInterval = time.Second
+)
+
+// Cache memoizes CalculateLoudness results in a Store. If
+// Keys is nil, PathMtimeKey is used; use ContentHashKey or
+// AudioHashKey to dedup identical audio stored under
+// different paths.
+//
+// Within a process, concurrent calls for the same key share a
+// single analysis. If Claims is set, workers elsewhere are
+// kept from repeating it too: a worker must claim a key before
+// analyzing it. Workers that lose the race poll the Store
+// every PollInterval until the result shows up, or until the
+// claim lapses (ClaimTTL) and they can take it over, or until
+// the Context of Options is done. If Claims is a
+// ClaimRenewer, claims are renewed every third of ClaimTTL
+// while the analysis runs.
+//
+// Files are analyzed with Options, which are not part of
+// the key. Stores that keep methods (see MethodStore) have
+// results re-analyzed once Options measure differently;
+// with other stores, use a separate one per set of options.
+type Cache struct {
+	Store   Store
+	Keys    KeyStrategy
+	Options Options
+
+	Claims       Claimer
+	ClaimTTL     time.Duration
+	PollInterval time.Duration
+
+	flights flightGroup
+}
+
+// CalculateLoudness returns cached loudness data for file,
+// analyzing it only if the store has no entry for its key.
+// Concurrent calls for the same key, such as from the workers
+// of a Scanner, share a single lookup and analysis.
+func (c *Cache) CalculateLoudness(file string) (LoudnessData, error) {
+	keys := c.Keys
+	if keys == nil {
+		keys = PathMtimeKey{}
+	}
+
+	key, err := keySafely(keys, file, c.Options)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot compute cache key: %v", err)
+	}
+	return c.flights.do(key, func() (LoudnessData, error) {
+		return c.calculate(key, file)
+	})
+}
+
+// calculate looks up key, analyzing file if it is missing.
+func (c *Cache) calculate(key, file string) (LoudnessData, error) {
+	ld, ok, err := c.lookup(key)
+	if err != nil || ok {
+		return ld, err
+	}
+	if c.Claims == nil {
+		return c.analyze(key, file)
+	}
+
+	ttl := c.ClaimTTL
+	if ttl <= 0 {
+		ttl = DefaultClaimTTL
+	}
+	poll := c.PollInterval
+	if poll <= 0 {
+		poll = DefaultPollInterval
+	}
+
+	for {
+		token, claimed, err := c.Claims.Claim(key, ttl)
+		if err != nil {
+			return LoudnessData{}, fmt.Errorf("Cannot claim cache key: %v", err)
+		}
+		if claimed {
+			defer c.Claims.Release(key, token)
+			defer c.renew(key, token, ttl)()
+
+			// the previous owner may have finished between our
+			// lookup and the claim
+			ld, ok, err := c.lookup(key)
+			if err != nil || ok {
+				return ld, err
+			}
+			return c.analyze(key, file)
+		}
+
+		var done <-chan struct{}
+		if ctx := c.Options.Context; ctx != nil {
+			done = ctx.Done()
+		}
+		timer := time.NewTimer(poll)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return LoudnessData{}, contextError(c.Options.Context)
+		}
+
+		ld, ok, err := c.lookup(key)
+		if err != nil || ok {
+			return ld, err
+		}
+	}
+}
+
+// renew keeps renewing the claim on key held with token, if
+// Claims can, until the returned function is called, or the
+// claim is lost.
+func (c *Cache) renew(key, token string, ttl time.Duration) func() {
+	cr, ok := c.Claims.(ClaimRenewer)
+	if !ok {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(max(ttl/3, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			held, err := cr.Renew(key, token, ttl)
+			if err != nil {
+				logf(c.Options, LogWarn, "cannot renew claim on %s: %v", key, err)
+				return
+			}
+			if !held {
+				logf(c.Options, LogWarn, "claim on %s lapsed during the analysis", key)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// lookup returns the stored result for key. Results measured
+// with a method that is no longer current (see
+// Method.Current) are treated as missing, so they get
+// analyzed again.
+func (c *Cache) lookup(key string) (LoudnessData, bool, error) {
+	ld, ok, err := c.Store.Get(key)
+	if err != nil {
+		return LoudnessData{}, false, fmt.Errorf("Cannot read cache: %v", err)
+	}
+	if !ok {
+		return ld, false, nil
+	}
+
+	if ms, isMS := c.Store.(MethodStore); isMS {
+		m, found, err := ms.Method(key)
+		if err != nil {
+			return LoudnessData{}, false, fmt.Errorf("Cannot read cache: %v", err)
+		}
+		if found && !m.Current(c.Options) {
+			return LoudnessData{}, false, nil
+		}
+	}
+	return ld, true, nil
+}
+
+func (c *Cache) analyze(key, file string) (LoudnessData, error) {
+	ld, info, err := calculateSafely(file, c.Options)
+	if err != nil {
+		return LoudnessData{}, err
+	}
+
+	if bs, ok := c.Store.(BlockStore); ok && info.Blocks != nil {
+		err = bs.PutBlocks(key, *info.Blocks)
+		if err != nil {
+			return LoudnessData{}, fmt.Errorf("Cannot write cache: %v", err)
+		}
+	}
+	if ms, ok := c.Store.(MethodStore); ok {
+		err = ms.PutMethod(key, MethodOf(c.Options, info))
+		if err != nil {
+			return LoudnessData{}, fmt.Errorf("Cannot write cache: %v", err)
+		}
+	}
+
+	if fs, ok := c.Store.(FileStore); ok {
+		err = fs.PutFile(key, file, ld)
+	} else {
+		err = c.Store.Put(key, ld)
+	}
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot write cache: %v", err)
+	}
+	return ld, nil
+}
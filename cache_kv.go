@@ -0,0 +1,59 @@
+package bs1770wrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// KVClient is the subset of a networked key-value client
+// (Redis, Memcached) that KVStore needs. It is satisfied by a
+// thin wrapper around whichever client library the caller
+// already uses, which keeps this package free of driver deps.
+// Get reports whether the key exists; a ttl of zero means
+// the entry never expires.
+type KVClient interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// KVStore is a Store on top of a shared key-value service,
+// letting several analysis workers share measurement results.
+// Keys are prefixed with Namespace, and entries expire after
+// TTL (zero means never).
+type KVStore struct {
+	Client    KVClient
+	Namespace string
+	TTL       time.Duration
+}
+
+func (s *KVStore) key(key string) string {
+	if s.Namespace == "" {
+		return key
+	}
+	return s.Namespace + ":" + key
+}
+
+// Get implements Store.
+func (s *KVStore) Get(key string) (LoudnessData, bool, error) {
+	buf, ok, err := s.Client.Get(s.key(key))
+	if err != nil || !ok {
+		return LoudnessData{}, false, err
+	}
+
+	ld := LoudnessData{}
+	err = json.Unmarshal(buf, &ld)
+	if err != nil {
+		return LoudnessData{}, false, fmt.Errorf("Cannot parse cached entry: %v", err)
+	}
+	return ld, true, nil
+}
+
+// Put implements Store.
+func (s *KVStore) Put(key string, ld LoudnessData) error {
+	buf, err := json.Marshal(ld)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize cache entry: %v", err)
+	}
+	return s.Client.Set(s.key(key), buf, s.TTL)
+}
@@ -0,0 +1,94 @@
+package bs1770wrap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LockClient is the subset of a distributed lock service that
+// DistributedClaimer needs: Redis (SET NX PX, and a
+// compare-and-delete script), etcd (a transaction on a
+// leased key) and the like. As with KVClient, callers wrap
+// their client library of choice.
+type LockClient interface {
+	// SetIfAbsent sets key to value, expiring after ttl,
+	// unless it exists; it reports whether it was set.
+	SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error)
+
+	// DeleteIf deletes key if it still holds value.
+	DeleteIf(key string, value []byte) error
+}
+
+// LockExtender is implemented by LockClients that can extend
+// a lock, such as with a compare-and-expire script on Redis
+// or a lease keep-alive on etcd. DistributedClaimer needs it
+// to renew claims.
+type LockExtender interface {
+	// ExtendIf has key expire after ttl from now if it still
+	// holds value, reporting whether it does.
+	ExtendIf(key string, value []byte, ttl time.Duration) (bool, error)
+}
+
+// DistributedClaimer is a Claimer shared by workers on any
+// number of hosts, so that a fleet using one networked Store
+// analyzes and stores each file only once. Claims are named
+// Namespace + ":claim:" + key, and hold the claim's token.
+type DistributedClaimer struct {
+	Client    LockClient
+	Namespace string
+}
+
+func (c *DistributedClaimer) name(key string) string {
+	if c.Namespace == "" {
+		return "claim:" + key
+	}
+	return c.Namespace + ":claim:" + key
+}
+
+// claimToken returns a token unique to a claim, naming the
+// host and process that took it for whoever inspects a lock.
+func claimToken() string {
+	host, _ := os.Hostname()
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(buf))
+}
+
+// Claim implements Claimer.
+func (c *DistributedClaimer) Claim(key string, ttl time.Duration) (string, bool, error) {
+	token := claimToken()
+	ok, err := c.Client.SetIfAbsent(c.name(key), []byte(token), ttl)
+	if err != nil {
+		return "", false, fmt.Errorf("Cannot take distributed lock: %v", err)
+	}
+	if !ok {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+// Release implements Claimer.
+func (c *DistributedClaimer) Release(key, token string) error {
+	err := c.Client.DeleteIf(c.name(key), []byte(token))
+	if err != nil {
+		return fmt.Errorf("Cannot release distributed lock: %v", err)
+	}
+	return nil
+}
+
+// Renew implements ClaimRenewer; it fails unless Client is a
+// LockExtender.
+func (c *DistributedClaimer) Renew(key, token string, ttl time.Duration) (bool, error) {
+	le, ok := c.Client.(LockExtender)
+	if !ok {
+		return false, fmt.Errorf("Cannot renew distributed lock: %T cannot extend locks", c.Client)
+	}
+	held, err := le.ExtendIf(c.name(key), []byte(token), ttl)
+	if err != nil {
+		return false, fmt.Errorf("Cannot renew distributed lock: %v", err)
+	}
+	return held, nil
+}
@@ -0,0 +1,124 @@
+package bs1770wrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ObjectClient is the subset of an S3-compatible client that
+// ObjectStore needs. Get reports whether the object exists.
+// As with KVClient, callers wrap their SDK of choice.
+type ObjectClient interface {
+	GetObject(name string) ([]byte, bool, error)
+	PutObject(name string, data []byte) error
+}
+
+// ObjectStore is a Store that keeps one JSON sidecar per
+// result in an object bucket, so stateless workers can reuse
+// earlier analyses without a database. Pair it with
+// ContentHashKey, as object stores generally don't keep
+// meaningful mtimes. Objects are named
+// Prefix + "<scheme>/<digest>.json".
+type ObjectStore struct {
+	Client ObjectClient
+	Prefix string
+}
+
+func (s *ObjectStore) name(key string) string {
+	return s.Prefix + strings.Replace(key, ":", "/", 1) + ".json"
+}
+
+// Get implements Store.
+func (s *ObjectStore) Get(key string) (LoudnessData, bool, error) {
+	buf, ok, err := s.Client.GetObject(s.name(key))
+	if err != nil || !ok {
+		return LoudnessData{}, false, err
+	}
+
+	ld := LoudnessData{}
+	err = json.Unmarshal(buf, &ld)
+	if err != nil {
+		return LoudnessData{}, false, fmt.Errorf("Cannot parse result sidecar: %v", err)
+	}
+	return ld, true, nil
+}
+
+// Put implements Store.
+func (s *ObjectStore) Put(key string, ld LoudnessData) error {
+	buf, err := json.Marshal(ld)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize result sidecar: %v", err)
+	}
+	return s.Client.PutObject(s.name(key), buf)
+}
+
+// DirClient is an ObjectClient on a local directory, for an
+// ObjectStore kept on disk: objects are files under Dir,
+// written atomically. Names that would land outside Dir are
+// refused, so keys with paths in them (PathMtimeKey) are best
+// avoided; ContentHashKey suits it.
+type DirClient struct {
+	Dir string
+}
+
+// NewDirStore returns an ObjectStore keeping its sidecars
+// under dir.
+func NewDirStore(dir string) *ObjectStore {
+	return &ObjectStore{Client: DirClient{Dir: dir}}
+}
+
+func (c DirClient) path(name string) (string, error) {
+	rel := filepath.FromSlash(name)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("Cannot use object name %q: it is not under %s", name, c.Dir)
+	}
+	return filepath.Join(c.Dir, rel), nil
+}
+
+// GetObject implements ObjectClient.
+func (c DirClient) GetObject(name string) ([]byte, bool, error) {
+	path, err := c.path(name)
+	if err != nil {
+		return nil, false, err
+	}
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot read %s: %v", path, err)
+	}
+	return buf, true, nil
+}
+
+// PutObject implements ObjectClient.
+func (c DirClient) PutObject(name string, data []byte) error {
+	path, err := c.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("Cannot create directory: %v", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("Cannot write %s: %v", path, err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Cannot write %s: %v", path, err)
+	}
+	return nil
+}
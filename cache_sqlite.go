@@ -0,0 +1,249 @@
+package bs1770wrap
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// sqliteMigrations upgrade the schema of a SQLiteStore one
+// version at a time; migration i brings the database to
+// version i+1. Released migrations must never change, new
+// ones are appended.
+var sqliteMigrations = []string{
+	`CREATE TABLE results (
+		key        TEXT PRIMARY KEY,
+		file       TEXT NOT NULL DEFAULT '',
+		integrated REAL NOT NULL,
+		peak       REAL NOT NULL,
+		range      REAL NOT NULL,
+		shortterm  REAL NOT NULL,
+		momentary  REAL NOT NULL,
+		length     INTEGER NOT NULL,
+		updated    INTEGER NOT NULL
+	)`,
+	`CREATE INDEX results_file ON results (file)`,
+	`CREATE TABLE annotations (
+		key      TEXT NOT NULL,
+		time     INTEGER NOT NULL,
+		operator TEXT NOT NULL DEFAULT '',
+		labels   TEXT NOT NULL DEFAULT '{}',
+		note     TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX annotations_key ON annotations (key)`,
+	`CREATE TABLE blocks (
+		key       TEXT PRIMARY KEY,
+		histogram TEXT NOT NULL
+	)`,
+	`CREATE TABLE methods (
+		key    TEXT PRIMARY KEY,
+		method TEXT NOT NULL
+	)`,
+}
+
+// SQLiteSchemaVersion is the schema version this package
+// creates and upgrades SQLite databases to.
+var SQLiteSchemaVersion = len(sqliteMigrations)
+
+// SQLiteStore is a Store in a SQLite database, for
+// single-host installations that want results to survive
+// restarts. The database is opened by the caller, with
+// whichever SQLite driver they use, which keeps this package
+// free of driver deps.
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+// NewSQLiteStore creates a SQLiteStore on db, creating the
+// schema in an empty database and upgrading that of one
+// created by an earlier version of this package. The schema
+// version is kept in PRAGMA user_version, and every upgrade
+// step is applied in a transaction of its own.
+func NewSQLiteStore(db *sql.DB) (*SQLiteStore, error) {
+	s := &SQLiteStore{DB: db}
+	err := s.migrate()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteStore) migrate() error {
+	var version int
+	err := s.DB.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("Cannot read schema version: %v", err)
+	}
+	if version > SQLiteSchemaVersion {
+		return fmt.Errorf("Cannot use database: schema version %d is newer than supported (%d)", version, SQLiteSchemaVersion)
+	}
+
+	for ; version < SQLiteSchemaVersion; version++ {
+		tx, err := s.DB.Begin()
+		if err != nil {
+			return fmt.Errorf("Cannot upgrade schema: %v", err)
+		}
+		_, err = tx.Exec(sqliteMigrations[version])
+		if err == nil {
+			// PRAGMA takes no placeholders
+			_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1))
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot upgrade schema to version %d: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *SQLiteStore) Get(key string) (LoudnessData, bool, error) {
+	ld := LoudnessData{}
+	err := s.DB.QueryRow(
+		`SELECT integrated, peak, range, shortterm, momentary, length FROM results WHERE key = ?`, key,
+	).Scan(&ld.Integrated, &ld.Peak, &ld.Range, &ld.Shortterm, &ld.Momentary, &ld.Length)
+	if err == sql.ErrNoRows {
+		return LoudnessData{}, false, nil
+	}
+	if err != nil {
+		return LoudnessData{}, false, fmt.Errorf("Cannot query results: %v", err)
+	}
+	return ld, true, nil
+}
+
+// Put implements Store.
+func (s *SQLiteStore) Put(key string, ld LoudnessData) error {
+	return s.PutFile(key, "", ld)
+}
+
+// PutFile implements FileStore.
+func (s *SQLiteStore) PutFile(key, file string, ld LoudnessData) error {
+	_, err := s.DB.Exec(
+		`INSERT OR REPLACE INTO results
+		(key, file, integrated, peak, range, shortterm, momentary, length, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, file, ld.Integrated, ld.Peak, ld.Range, ld.Shortterm, ld.Momentary, int64(ld.Length),
+		time.Now().UnixNano(),
+	)
+	if err != nil {
+		return fmt.Errorf("Cannot store result: %v", err)
+	}
+	return nil
+}
+
+// List implements Lister. Results are ordered by key.
+func (s *SQLiteStore) List() ([]StoredResult, error) {
+	rows, err := s.DB.Query(
+		`SELECT r.key, r.file, r.integrated, r.peak, r.range, r.shortterm, r.momentary, r.length, r.updated,
+		COALESCE(m.method, '')
+		FROM results r LEFT JOIN methods m ON m.key = r.key ORDER BY r.key`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot query results: %v", err)
+	}
+	defer rows.Close()
+
+	var results []StoredResult
+	for rows.Next() {
+		r := StoredResult{}
+		ld := &r.Loudness
+		var updated int64
+		var method string
+		err := rows.Scan(&r.Key, &r.File, &ld.Integrated, &ld.Peak, &ld.Range, &ld.Shortterm, &ld.Momentary, &ld.Length, &updated, &method)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read results: %v", err)
+		}
+		r.Updated = time.Unix(0, updated)
+		if method != "" {
+			m, _, err := parseMethod([]byte(method))
+			if err != nil {
+				return nil, err
+			}
+			r.Method = &m
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read results: %v", err)
+	}
+	return results, nil
+}
+
+// Annotate implements Annotator.
+func (s *SQLiteStore) Annotate(key string, a Annotation) error {
+	labels, err := json.Marshal(a.Labels)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize annotation: %v", err)
+	}
+	_, err = s.DB.Exec(
+		`INSERT INTO annotations (key, time, operator, labels, note) VALUES (?, ?, ?, ?, ?)`,
+		key, a.Time.UnixNano(), a.Operator, string(labels), a.Note,
+	)
+	if err != nil {
+		return fmt.Errorf("Cannot store annotation: %v", err)
+	}
+	return nil
+}
+
+// Annotations implements Annotator.
+func (s *SQLiteStore) Annotations(key string) ([]Annotation, error) {
+	rows, err := s.DB.Query(
+		`SELECT time, operator, labels, note FROM annotations WHERE key = ? ORDER BY rowid`, key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot query annotations: %v", err)
+	}
+	defer rows.Close()
+
+	var anns []Annotation
+	for rows.Next() {
+		a := Annotation{}
+		var t int64
+		var labels string
+		err := rows.Scan(&t, &a.Operator, &labels, &a.Note)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read annotations: %v", err)
+		}
+		err = json.Unmarshal([]byte(labels), &a.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse annotations: %v", err)
+		}
+		a.Time = time.Unix(0, t)
+		anns = append(anns, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read annotations: %v", err)
+	}
+	return anns, nil
+}
+
+// PutBlocks implements BlockStore.
+func (s *SQLiteStore) PutBlocks(key string, h BlockHistogram) error {
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize block histogram: %v", err)
+	}
+	_, err = s.DB.Exec(`INSERT OR REPLACE INTO blocks (key, histogram) VALUES (?, ?)`, key, string(buf))
+	if err != nil {
+		return fmt.Errorf("Cannot store block histogram: %v", err)
+	}
+	return nil
+}
+
+// Blocks implements BlockStore.
+func (s *SQLiteStore) Blocks(key string) (BlockHistogram, bool, error) {
+	var buf string
+	err := s.DB.QueryRow(`SELECT histogram FROM blocks WHERE key = ?`, key).Scan(&buf)
+	if err == sql.ErrNoRows {
+		return BlockHistogram{}, false, nil
+	}
+	if err != nil {
+		return BlockHistogram{}, false, fmt.Errorf("Cannot query block histogram: %v", err)
+	}
+	return parseBlocks([]byte(buf))
+}
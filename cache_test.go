@@ -0,0 +1,190 @@
+package bs1770wrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowBackend takes a while over each analysis, counting them.
+type slowBackend struct {
+	delay time.Duration
+	runs  *int32
+}
+
+func (slowBackend) Name() string {
+	return "slow"
+}
+
+func (b slowBackend) Analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	atomic.AddInt32(b.runs, 1)
+	time.Sleep(b.delay)
+	return LoudnessData{Integrated: -20}, nil
+}
+
+func TestCacheClaimWaitCancelled(t *testing.T) {
+	files := scanFiles(t, "a.wav")
+	claims := NewMemoryClaimer()
+	claims.Claim("key", time.Hour) // another worker's
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	c := &Cache{
+		Store:        NewMemoryStore(),
+		Keys:         KeyFunc(func(string) (string, error) { return "key", nil }),
+		Options:      Options{Backends: []LoudnessAnalyzer{fakeBackend{}}, Context: ctx},
+		Claims:       claims,
+		PollInterval: time.Hour,
+	}
+	start := time.Now()
+	_, err := c.CalculateLoudness(files[0])
+	if AbortReasonOf(err) != AbortCancelled {
+		t.Errorf("waiting for a claim ended with %v, want it cancelled", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("the wait took %v past the cancellation", time.Since(start))
+	}
+}
+
+func TestCacheRenewsClaim(t *testing.T) {
+	files := scanFiles(t, "a.wav")
+	store, claims := NewMemoryStore(), NewMemoryClaimer()
+	var runs int32
+	newCache := func() *Cache {
+		// separate caches, as on two hosts, share no flights
+		return &Cache{
+			Store:        store,
+			Keys:         KeyFunc(func(string) (string, error) { return "key", nil }),
+			Options:      Options{Backends: []LoudnessAnalyzer{slowBackend{delay: 500 * time.Millisecond, runs: &runs}}},
+			Claims:       claims,
+			ClaimTTL:     100 * time.Millisecond,
+			PollInterval: 10 * time.Millisecond,
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(c *Cache) {
+			defer wg.Done()
+			ld, err := c.CalculateLoudness(files[0])
+			if err != nil || ld.Integrated != -20 {
+				t.Errorf("CalculateLoudness = %+v, %v", ld, err)
+			}
+		}(newCache())
+		time.Sleep(50 * time.Millisecond)
+	}
+	wg.Wait()
+	if runs != 1 {
+		t.Errorf("analyzed %d times, want once: the claim lapsed during the analysis", runs)
+	}
+}
+
+func TestMemoryClaimerTakeover(t *testing.T) {
+	claims := NewMemoryClaimer()
+	old, ok, _ := claims.Claim("key", time.Millisecond)
+	if !ok {
+		t.Fatal("cannot claim a free key")
+	}
+	time.Sleep(5 * time.Millisecond)
+	token, ok, _ := claims.Claim("key", time.Hour)
+	if !ok {
+		t.Fatal("cannot take over a lapsed claim")
+	}
+
+	if held, _ := claims.Renew("key", old, time.Hour); held {
+		t.Error("the lapsed holder renewed its successor's claim")
+	}
+	claims.Release("key", old)
+	if _, ok, _ := claims.Claim("key", time.Hour); ok {
+		t.Error("the lapsed holder released its successor's claim")
+	}
+	if held, _ := claims.Renew("key", token, time.Hour); !held {
+		t.Error("the successor cannot renew its claim")
+	}
+	claims.Release("key", token)
+	if _, ok, _ := claims.Claim("key", time.Hour); !ok {
+		t.Error("the successor cannot release its claim")
+	}
+}
+
+// memoryKV is a KVClient in memory.
+type memoryKV map[string][]byte
+
+func (kv memoryKV) Get(key string) ([]byte, bool, error) {
+	v, ok := kv[key]
+	return v, ok, nil
+}
+
+func (kv memoryKV) Set(key string, value []byte, ttl time.Duration) error {
+	kv[key] = value
+	return nil
+}
+
+func TestStoresKeepSilence(t *testing.T) {
+	silence := LoudnessData{
+		Integrated: float32(-inf),
+		Peak:       float32(-inf),
+		Range:      0,
+		Shortterm:  float32(-inf),
+		Momentary:  float32(-inf),
+		Length:     1000000,
+	}
+	for _, c := range []struct {
+		name  string
+		store Store
+	}{
+		{"KVStore", &KVStore{Client: memoryKV{}}},
+		{"ObjectStore", NewDirStore(t.TempDir())},
+	} {
+		if err := c.store.Put("key", silence); err != nil {
+			t.Errorf("%s: cannot store silence: %v", c.name, err)
+			continue
+		}
+		got, ok, err := c.store.Get("key")
+		if err != nil || !ok || got != silence {
+			t.Errorf("%s: silence reads back as %+v, %v, %v", c.name, got, ok, err)
+		}
+	}
+
+	m := Method{Backend: "native", Calibration: float32(inf)}
+	if m.ID() == (Method{Backend: "native"}).ID() {
+		t.Error("a method that cannot be encoded has the ID of another")
+	}
+}
+
+func TestCacheAudioHashKeyUsesOptions(t *testing.T) {
+	files := scanFiles(t, "a.wav")
+	var soxRuns int32
+	c := &Cache{
+		Store: NewMemoryStore(),
+		Keys:  AudioHashKey{},
+		Options: Options{
+			Backends: []LoudnessAnalyzer{fakeBackend{}},
+			Runner: RunnerFunc(func(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+				if cmd != "sox" {
+					return nil, nil, fmt.Errorf("unexpected %s", cmd)
+				}
+				atomic.AddInt32(&soxRuns, 1)
+				return []byte("samples"), nil, nil
+			}),
+		},
+	}
+	for i := 0; i < 2; i++ {
+		if ld, err := c.CalculateLoudness(files[0]); err != nil || ld.Integrated != -20 {
+			t.Fatalf("CalculateLoudness = %+v, %v", ld, err)
+		}
+	}
+	if soxRuns != 2 {
+		t.Errorf("sox ran %d times through the Runner of the cache, want 2", soxRuns)
+	}
+	results, err := c.Store.(Lister).List()
+	if err != nil || len(results) != 1 || !strings.HasPrefix(results[0].Key, "audio:") {
+		t.Errorf("the store holds %+v, %v, want a single result keyed by its audio", results, err)
+	}
+}
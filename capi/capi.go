@@ -0,0 +1,157 @@
+// Command capi exports the package to C, for applications
+// that cannot link Go but would rather not run a service
+// either. Build it with
+//
+//	go build -buildmode=c-shared -o libbs1770wrap.so ./capi
+//
+// which also writes libbs1770wrap.h. Options and results are
+// passed as JSON strings, which suits ctypes and friends:
+//
+//	lib = ctypes.CDLL("./libbs1770wrap.so")
+//	lib.bs1770_analyze.restype = ctypes.c_void_p
+//	p = lib.bs1770_analyze(b"track.flac", b'{"backends": ["ffmpeg"]}')
+//	result = json.loads(ctypes.string_at(p))
+//	lib.bs1770_free(p)
+//
+// Every returned string is the caller's to free with
+// bs1770_free. Measurements have the JSON form of the server
+// package (see server.Loudness), levels that are -Inf, as for
+// silence, being null. Failures are returned as
+// {"error": message}.
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/burillo-se/bs1770wrap"
+	"github.com/burillo-se/bs1770wrap/server"
+)
+
+// options are the Options that can be set from C. Timeout is
+// in seconds.
+type options struct {
+	Backends    []string `json:"backends"`
+	Highpass    float64  `json:"highpass"`
+	Effects     []string `json:"effects"`
+	FilterGraph string   `json:"filtergraph"`
+	Timeout     float64  `json:"timeout"`
+	TempDir     string   `json:"tempdir"`
+	Tools       *struct {
+		Dir        string `json:"dir"`
+		Sox        string `json:"sox"`
+		BS1770Gain string `json:"bs1770gain"`
+		FFmpeg     string `json:"ffmpeg"`
+		FFprobe    string `json:"ffprobe"`
+	} `json:"tools"`
+
+	// for bs1770_normalize: "loudness" (the default) or
+	// "peak", the target in LUFS or dBTP, and the true peak
+	// limit in dBTP, if any
+	Mode      string   `json:"mode"`
+	Target    float64  `json:"target"`
+	PeakLimit *float64 `json:"peak_limit"`
+}
+
+var backends = map[string]bs1770wrap.LoudnessAnalyzer{
+	bs1770wrap.BS1770Gain{}.Name(): bs1770wrap.BS1770Gain{},
+	bs1770wrap.FFmpeg{}.Name():     bs1770wrap.FFmpeg{},
+	bs1770wrap.Native{}.Name():     bs1770wrap.Native{},
+}
+
+// parseOptions parses the JSON options given from C, which may
+// be NULL or empty.
+func parseOptions(s *C.char) (options, bs1770wrap.Options, error) {
+	var o options
+	if s != nil && C.GoString(s) != "" {
+		if err := json.Unmarshal([]byte(C.GoString(s)), &o); err != nil {
+			return options{}, bs1770wrap.Options{}, fmt.Errorf("Cannot parse options: %v", err)
+		}
+	}
+
+	opts := bs1770wrap.Options{
+		Highpass:    o.Highpass,
+		Effects:     o.Effects,
+		FilterGraph: o.FilterGraph,
+		Timeout:     time.Duration(o.Timeout * float64(time.Second)),
+		TempDir:     o.TempDir,
+	}
+	for _, name := range o.Backends {
+		b, ok := backends[name]
+		if !ok {
+			return options{}, bs1770wrap.Options{}, fmt.Errorf("Unknown backend %q", name)
+		}
+		opts.Backends = append(opts.Backends, b)
+	}
+	if t := o.Tools; t != nil {
+		opts.Tools = &bs1770wrap.Tools{Dir: t.Dir, Sox: t.Sox, BS1770Gain: t.BS1770Gain, FFmpeg: t.FFmpeg, FFprobe: t.FFprobe}
+	}
+	return o, opts, nil
+}
+
+// reply encodes v, or err if set, as a C string.
+func reply(v interface{}, err error) *C.char {
+	if err != nil {
+		v = map[string]string{"error": err.Error()}
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		buf, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return C.CString(string(buf))
+}
+
+// bs1770_analyze measures file, returning its loudness.
+//
+//export bs1770_analyze
+func bs1770_analyze(file, opts *C.char) *C.char {
+	_, o, err := parseOptions(opts)
+	if err != nil {
+		return reply(nil, err)
+	}
+	ld, info, err := bs1770wrap.CalculateLoudnessWithOptions(C.GoString(file), o)
+	return reply(server.NewLoudness(ld, info), err)
+}
+
+// bs1770_normalize writes a normalized copy of file to out,
+// returning the loudness measured before and the gain
+// applied: {"before": ..., "gain": dB, "limited": bool}.
+//
+//export bs1770_normalize
+func bs1770_normalize(file, out, opts *C.char) *C.char {
+	o, bo, err := parseOptions(opts)
+	if err != nil {
+		return reply(nil, err)
+	}
+	nopts := bs1770wrap.NormalizeOptions{Target: o.Target, PeakLimit: o.PeakLimit, Options: bo}
+	switch o.Mode {
+	case "", "loudness":
+	case "peak":
+		nopts.Mode = bs1770wrap.NormalizePeak
+	default:
+		return reply(nil, fmt.Errorf("Unknown mode %q", o.Mode))
+	}
+
+	r, err := bs1770wrap.Normalize(C.GoString(file), C.GoString(out), nopts)
+	return reply(map[string]interface{}{
+		"before":  server.NewLoudness(r.Before, r.Info),
+		"gain":    r.Gain,
+		"limited": r.Limited,
+	}, err)
+}
+
+// bs1770_free frees a string returned by the functions above.
+//
+//export bs1770_free
+func bs1770_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+func main() {}
@@ -0,0 +1,350 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Radio automation systems read levels and cue points from
+// the AES46 cart chunk of broadcast WAV files rather than from
+// ReplayGain tags. The chunk is a fixed 2048 bytes of fields
+// followed by free tag text:
+//
+//	Version [4], Title, Artist, CutID, ClientID, Category,
+//	Classification, OutCue [64 each], StartDate [10],
+//	StartTime [8], EndDate [10], EndTime [8], ProducerAppID,
+//	ProducerAppVersion, UserDef [64 each], LevelReference
+//	[int32], PostTimer [8 of usage fourcc, uint32 samples],
+//	Reserved [276], URL [1024], TagText
+//
+// All integers are little-endian, text is NUL padded.
+
+// Timer usages defined by AES46; the last letter tells the
+// start ('s') from the end ('e') of the span.
+const (
+	CartAudioStart = "AUDs"
+	CartAudioEnd   = "AUDe"
+	CartIntroStart = "INTs"
+	CartIntroEnd   = "INTe"
+	CartSegueStart = "SEGs"
+	CartSegueEnd   = "SEGe"
+)
+
+// CartVersion is the version of AES46 written when
+// Cart.Version is empty.
+const CartVersion = "0101"
+
+const (
+	cartFixedSize = 2048
+	cartTimers    = 8
+)
+
+// CartTimer is a cue point, in samples from the start of the
+// audio data.
+type CartTimer struct {
+	Usage string // fourcc, see CartAudioStart and so on
+	Value uint32
+}
+
+// Cart holds the fields of an AES46 cart chunk. Text longer
+// than its field is truncated when written.
+type Cart struct {
+	Version            string
+	Title              string
+	Artist             string
+	CutID              string
+	ClientID           string
+	Category           string
+	Classification     string
+	OutCue             string
+	StartDate          string // yyyy-mm-dd
+	StartTime          string // hh:mm:ss
+	EndDate            string
+	EndTime            string
+	ProducerAppID      string
+	ProducerAppVersion string
+	UserDef            string
+	LevelReference     int32       // sample value of the 0 dB reference level, see CartLevel
+	Timers             []CartTimer // at most 8
+	URL                string
+	TagText            string
+}
+
+// Timer returns the value of the timer with the given usage.
+func (c Cart) Timer(usage string) (uint32, bool) {
+	for _, t := range c.Timers {
+		if t.Usage == usage {
+			return t.Value, true
+		}
+	}
+	return 0, false
+}
+
+// SetTimer sets the timer with the given usage, adding it if
+// there is none yet.
+func (c *Cart) SetTimer(usage string, value uint32) {
+	for i, t := range c.Timers {
+		if t.Usage == usage {
+			c.Timers[i].Value = value
+			return
+		}
+	}
+	c.Timers = append(c.Timers, CartTimer{Usage: usage, Value: value})
+}
+
+// CartLevel returns the level reference for a measurement:
+// the sample value, on a 16-bit scale, of the integrated
+// loudness, which automation systems match levels by.
+func CartLevel(ld LoudnessData) int32 {
+	if math.IsInf(float64(ld.Integrated), -1) || math.IsNaN(float64(ld.Integrated)) {
+		return 0
+	}
+	return int32(math.Round(32768 * DBToLinear(float64(ld.Integrated))))
+}
+
+// textFields lists the fixed-size text fields in chunk order.
+func (c *Cart) textFields() []struct {
+	s    *string
+	size int
+} {
+	return []struct {
+		s    *string
+		size int
+	}{
+		{&c.Version, 4}, {&c.Title, 64}, {&c.Artist, 64}, {&c.CutID, 64},
+		{&c.ClientID, 64}, {&c.Category, 64}, {&c.Classification, 64},
+		{&c.OutCue, 64}, {&c.StartDate, 10}, {&c.StartTime, 8},
+		{&c.EndDate, 10}, {&c.EndTime, 8}, {&c.ProducerAppID, 64},
+		{&c.ProducerAppVersion, 64}, {&c.UserDef, 64},
+	}
+}
+
+// encode returns the chunk payload, padded to an even size.
+func (c Cart) encode() ([]byte, error) {
+	if len(c.Timers) > cartTimers {
+		return nil, fmt.Errorf("Cannot write cart chunk: %d timers, at most %d fit", len(c.Timers), cartTimers)
+	}
+	if c.Version == "" {
+		c.Version = CartVersion
+	}
+
+	buf := make([]byte, 0, cartFixedSize+len(c.TagText)+1)
+	for _, f := range c.textFields() {
+		buf = append(buf, fixedText(*f.s, f.size)...)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(c.LevelReference))
+	for i := 0; i < cartTimers; i++ {
+		if i < len(c.Timers) {
+			buf = append(buf, fixedText(c.Timers[i].Usage, 4)...)
+			buf = binary.LittleEndian.AppendUint32(buf, c.Timers[i].Value)
+		} else {
+			buf = append(buf, make([]byte, 8)...)
+		}
+	}
+	buf = append(buf, make([]byte, 276)...)
+	buf = append(buf, fixedText(c.URL, 1024)...)
+	buf = append(buf, c.TagText...)
+	if len(buf)%2 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf, nil
+}
+
+func fixedText(s string, size int) []byte {
+	buf := make([]byte, size)
+	copy(buf, s)
+	return buf
+}
+
+// parseCart parses a cart chunk payload.
+func parseCart(buf []byte) (Cart, error) {
+	if len(buf) < cartFixedSize {
+		return Cart{}, fmt.Errorf("Cannot parse cart chunk: %d bytes, need %d", len(buf), cartFixedSize)
+	}
+
+	c := Cart{}
+	off := 0
+	for _, f := range c.textFields() {
+		*f.s = string(bytes.TrimRight(buf[off:off+f.size], "\x00"))
+		off += f.size
+	}
+	c.LevelReference = int32(binary.LittleEndian.Uint32(buf[off:]))
+	off += 4
+	for i := 0; i < cartTimers; i++ {
+		usage := bytes.TrimRight(buf[off:off+4], "\x00")
+		if len(usage) > 0 {
+			c.Timers = append(c.Timers, CartTimer{Usage: string(usage), Value: binary.LittleEndian.Uint32(buf[off+4:])})
+		}
+		off += 8
+	}
+	off += 276
+	c.URL = string(bytes.TrimRight(buf[off:off+1024], "\x00"))
+	c.TagText = string(bytes.TrimRight(buf[cartFixedSize:], "\x00"))
+	return c, nil
+}
+
+// ReadCart reads the cart chunk of a WAV file. It reports
+// false if the file has none.
+func ReadCart(file string) (Cart, bool, error) {
+	buf, cf, ok, err := findChunk(file, "cart")
+	if err == nil && cf.magic != "RIFF" {
+		err = fmt.Errorf("Not a RIFF WAVE file")
+	}
+	if err != nil || !ok {
+		return Cart{}, false, err
+	}
+	cart, err := parseCart(buf)
+	return cart, err == nil, err
+}
+
+// WriteCart replaces the cart chunk of a WAV file, adding one
+// ahead of the audio data if there is none. The rest of the
+// file is copied unchanged.
+func WriteCart(file string, cart Cart, opts Options) error {
+	payload, err := cart.encode()
+	if err != nil {
+		return err
+	}
+	before, _, err := ReadCart(file)
+	if err != nil {
+		return err
+	}
+
+	write := func(dst string) error {
+		return rewriteChunk(file, dst, newChunk("cart", payload), "data")
+	}
+	verify := func(path string) error {
+		got, ok, err := ReadCart(path)
+		if err != nil {
+			return err
+		}
+		buf, _ := got.encode()
+		if !ok || !bytes.Equal(buf, payload) {
+			return fmt.Errorf("cart chunk does not read back as written")
+		}
+		return nil
+	}
+	err = modifyFile(file, opts, write, verify)
+	if err != nil {
+		return err
+	}
+
+	return audit(opts, AuditEntry{
+		Operation: AuditTagWrite,
+		File:      file,
+		Before:    cartAudit(before),
+		After:     cartAudit(cart),
+	})
+}
+
+// cartAudit describes the analysis-derived fields of a cart
+// chunk for the audit log.
+func cartAudit(c Cart) map[string]string {
+	m := map[string]string{"LevelReference": strconv.Itoa(int(c.LevelReference))}
+	for _, t := range c.Timers {
+		m[t.Usage] = strconv.FormatUint(uint64(t.Value), 10)
+	}
+	return m
+}
+
+// Thresholds of DetectCues.
+const (
+	CueSilence = -60 // LUFS, momentary loudness below which there is no audio
+	CueSegue   = 15  // LU, how far short-term loudness drops below integrated loudness at the segue
+)
+
+// Cues are positions in a file, in media time, found by
+// DetectCues.
+type Cues struct {
+	AudioStart time.Duration // first audible momentary window
+	AudioEnd   time.Duration // end of the last audible momentary window
+	Segue      time.Duration // where the outro fades more than CueSegue below the integrated loudness
+}
+
+// DetectCues finds the audio start and end, and the segue
+// point, of file from its loudness series, given its
+// integrated loudness. It requires ffmpeg.
+func DetectCues(file string, integrated float32, opts Options) (Cues, error) {
+	series, err := mediaSeries(file, opts)
+	if err != nil {
+		return Cues{}, err
+	}
+
+	// At is the end of the windows, which are 400 ms long for
+	// momentary loudness
+	cues := Cues{}
+	first := true
+	for _, s := range series {
+		if s.Momentary < CueSilence {
+			continue
+		}
+		if first {
+			cues.AudioStart = s.At - 400*time.Millisecond
+			if cues.AudioStart < 0 {
+				cues.AudioStart = 0
+			}
+			first = false
+		}
+		cues.AudioEnd = s.At
+	}
+	cues.Segue = cues.AudioEnd
+	for i := len(series) - 1; i >= 0; i-- {
+		if series[i].Shortterm >= integrated-CueSegue {
+			cues.Segue = series[i].At
+			break
+		}
+	}
+	return cues, nil
+}
+
+// Timers returns the cues as cart timers, at the given sample
+// rate.
+func (c Cues) Timers(rate int) []CartTimer {
+	samples := func(d time.Duration) uint32 {
+		return uint32(d * time.Duration(rate) / time.Second)
+	}
+	return []CartTimer{
+		{Usage: CartAudioStart, Value: samples(c.AudioStart)},
+		{Usage: CartAudioEnd, Value: samples(c.AudioEnd)},
+		{Usage: CartSegueStart, Value: samples(c.Segue)},
+	}
+}
+
+// TagCart sets the level reference of the cart chunk of a WAV
+// file from ld and, if given, its audio and segue timers from
+// cues, keeping its other fields. A file without a cart chunk
+// gets one.
+func TagCart(file string, ld LoudnessData, cues *Cues, opts Options) error {
+	cart, _, err := ReadCart(file)
+	if err != nil {
+		return err
+	}
+	cart.LevelReference = CartLevel(ld)
+	if cues != nil {
+		rate, err := wavRate(file)
+		if err != nil {
+			return err
+		}
+		for _, t := range cues.Timers(rate) {
+			cart.SetTimer(t.Usage, t.Value)
+		}
+	}
+	return WriteCart(file, cart, opts)
+}
+
+// wavRate reads the sample rate of a WAV file from its fmt
+// chunk.
+func wavRate(file string) (int, error) {
+	buf, _, ok, err := findChunk(file, "fmt ")
+	if err != nil {
+		return 0, err
+	}
+	if !ok || len(buf) < 8 {
+		return 0, fmt.Errorf("Not a RIFF WAVE file: no fmt chunk")
+	}
+	return int(binary.LittleEndian.Uint32(buf[4:])), nil
+}
@@ -0,0 +1,154 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chapter is a chapter of a file, as ffprobe reports it for
+// Matroska, MP4 and other containers with chapters.
+type Chapter struct {
+	// UID is the Matroska ChapterUID; for other containers it
+	// is whatever ID ffprobe assigns, usually the index.
+	UID   uint64
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// ChapterLoudness is the measurement of a chapter.
+type ChapterLoudness struct {
+	Chapter
+	Loudness LoudnessData
+	Info     AnalysisInfo
+	Err      error // analysis failure, Loudness is then zero
+}
+
+// chapter as printed by ffprobe -of json
+type ffprobeChapter struct {
+	ID        json.Number       `json:"id"`
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Chapters lists the chapters of file, in order, using
+// ffprobe. A file without chapters has none, which is not an
+// error.
+func Chapters(file string, opts Options) ([]Chapter, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_chapters",
+		"-of", "json",
+		ffmpegPath(file),
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := run("ffprobe", cmd, opts, &AnalysisInfo{})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read chapters: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out struct {
+		Chapters []ffprobeChapter `json:"chapters"`
+	}
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse chapters: %v", err)
+	}
+
+	chapters := make([]Chapter, 0, len(out.Chapters))
+	for _, c := range out.Chapters {
+		// ffprobe prints 64-bit UIDs signed
+		id, err1 := strconv.ParseInt(c.ID.String(), 10, 64)
+		start, err2 := strconv.ParseFloat(c.StartTime, 64)
+		end, err3 := strconv.ParseFloat(c.EndTime, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("Cannot parse chapter %s", c.ID)
+		}
+		title := c.Tags["title"]
+		if title == "" {
+			title = c.Tags["TITLE"]
+		}
+		chapters = append(chapters, Chapter{
+			UID:   uint64(id),
+			Title: title,
+			Start: time.Duration(start * float64(time.Second)),
+			End:   time.Duration(end * float64(time.Second)),
+		})
+	}
+	return chapters, nil
+}
+
+// CalculateChapterLoudness measures file as a whole and each
+// of its chapters on its own. It requires ffmpeg, which cuts
+// the chapters out, besides the backends. A chapter that
+// fails is reported in its ChapterLoudness; an error is only
+// returned if the file as a whole cannot be analyzed.
+func CalculateChapterLoudness(file string, opts Options) (LoudnessData, AnalysisInfo, []ChapterLoudness, error) {
+	ld, info, err := calculateSafely(file, opts)
+	if err != nil {
+		return LoudnessData{}, info, nil, err
+	}
+	chapters, err := Chapters(file, opts)
+	if err != nil {
+		return LoudnessData{}, info, nil, err
+	}
+	if len(chapters) == 0 {
+		return ld, info, nil, nil
+	}
+
+	dir, err := os.MkdirTemp(opts.TempDir, "bs1770wrap")
+	if err != nil {
+		return LoudnessData{}, info, nil, fmt.Errorf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	results := make([]ChapterLoudness, len(chapters))
+	for i, c := range chapters {
+		r := ChapterLoudness{Chapter: c}
+		part := filepath.Join(dir, fmt.Sprintf("chapter%d.wav", i))
+		r.Err = extractSpan(file, part, c.Start, c.End, opts)
+		if r.Err == nil {
+			r.Loudness, r.Info, r.Err = calculateSafely(part, opts)
+		}
+		results[i] = r
+		os.Remove(part)
+	}
+	return ld, info, results, nil
+}
+
+// extractSpan decodes the audio of file between start and
+// end into the WAV file out, as float samples so nothing is
+// clipped.
+func extractSpan(file, out string, start, end time.Duration, opts Options) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("ffmpeg",
+		"-nostdin",
+		"-loglevel", "error",
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', -1, 64),
+		"-i", ffmpegPath(file),
+		"-t", strconv.FormatFloat((end-start).Seconds(), 'f', -1, 64),
+		"-map", "0:a:0",
+		"-c:a", "pcm_f32le",
+		ffmpegPath(out),
+	)
+	cmd.Stderr = &stderr
+
+	err := run("ffmpeg", cmd, opts, &AnalysisInfo{})
+	if err != nil {
+		return fmt.Errorf("Cannot extract %v-%v: %w: %s", start, end, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
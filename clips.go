@@ -0,0 +1,138 @@
+package bs1770wrap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// ClipBatchSize is how many clips CalculateClips hands to
+// one bs1770gain invocation.
+const ClipBatchSize = 100
+
+// CalculateClips is a low-latency path for many very short
+// files, such as jingles and sound effects, where spawning
+// tools dominates the time spent. Clips are measured by
+// bs1770gain in batches of ClipBatchSize, WAV lengths are
+// read from the file header instead of being probed, and
+// preprocessing, inspection and backend options do not
+// apply. If a batch fails, its clips are measured one by
+// one, so a bad clip only fails itself. Results are in the
+// order of files; the returned AnalysisInfo covers all of
+// them.
+func CalculateClips(files []string, opts Options) ([]AlbumTrack, AnalysisInfo) {
+	info := AnalysisInfo{Backend: BS1770Gain{}.Name()}
+	results := make([]AlbumTrack, len(files))
+	for start := 0; start < len(files); start += ClipBatchSize {
+		end := start + ClipBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		batch := files[start:end]
+
+		gd, err := measureLoudness(batch, opts, &info)
+		if err == nil && !sameClips(gd.Album.Tracks, batch) {
+			err = errClipCount
+		}
+		if err != nil {
+			logf(opts, LogWarn, "clip batch failed, measuring clips one by one: %v", err)
+			for i, file := range batch {
+				results[start+i] = measureClip(file, opts, &info)
+			}
+			continue
+		}
+		for i, t := range gd.Album.Tracks {
+			results[start+i] = clipResult(batch[i], t, opts, &info)
+		}
+	}
+	return results, info
+}
+
+var errClipCount = errors.New("bs1770gain skipped or reordered clips")
+
+// sameClips reports whether the tracks are the clips of the
+// batch, in order.
+func sameClips(tracks []trackData, batch []string) bool {
+	if len(tracks) != len(batch) {
+		return false
+	}
+	for i, t := range tracks {
+		if !sameFileName(t.File, batch[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func measureClip(file string, opts Options, info *AnalysisInfo) AlbumTrack {
+	gd, err := measureLoudness([]string{file}, opts, info)
+	if err == nil && len(gd.Album.Tracks) == 0 {
+		err = errClipCount
+	}
+	if err != nil {
+		return AlbumTrack{File: file, Err: err}
+	}
+	return clipResult(file, gd.Album.Tracks[0], opts, info)
+}
+
+func clipResult(file string, t trackData, opts Options, info *AnalysisInfo) AlbumTrack {
+	length, ok := wavLength(file)
+	if !ok {
+		var err error
+		length, err = probeLength(file, opts, info)
+		if err != nil {
+			return AlbumTrack{File: file, Err: err}
+		}
+	}
+	return AlbumTrack{
+		File:     file,
+		Loudness: t.loudness(length),
+		Info:     AnalysisInfo{Backend: info.Backend},
+	}
+}
+
+// wavLength reads the length of a RIFF WAVE file, in
+// microseconds, from its fmt and data chunks.
+func wavLength(file string) (uint64, bool) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(f, hdr); err != nil || string(hdr[:4]) != "RIFF" || string(hdr[8:]) != "WAVE" {
+		return 0, false
+	}
+
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return 0, false
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:])
+		switch string(chunk[:4]) {
+		case "fmt ":
+			fmtData := make([]byte, 12)
+			if size < 12 {
+				return 0, false
+			}
+			if _, err := io.ReadFull(f, fmtData); err != nil {
+				return 0, false
+			}
+			byteRate = binary.LittleEndian.Uint32(fmtData[8:])
+			size -= 12
+		case "data":
+			if byteRate == 0 {
+				return 0, false
+			}
+			return uint64(size) * 1000000 / uint64(byteRate), true
+		}
+		// chunks are padded to an even size
+		if _, err := f.Seek(int64(size+size&1), io.SeekCurrent); err != nil {
+			return 0, false
+		}
+	}
+}
@@ -0,0 +1,397 @@
+// Command bs1770wrap measures the loudness of audio files
+// from the command line, for shell scripts and users of
+// other languages:
+//
+//	bs1770wrap [flags] file or directory...
+//
+// Directories are scanned recursively, leaving out the files
+// that are no audio (cover art, cue sheets, logs...). Results
+// are printed as a table, or with -format as JSON or CSV,
+// with the gain towards -target. -tag writes ReplayGain tags,
+// and -album measures albums as well, grouped with -group.
+// -progress=json writes the JSON progress protocol to stderr
+// as files are analyzed.
+//
+// An interrupt aborts the files not analyzed yet, which are
+// reported with the reason "cancelled". The exit status is 1
+// if any file failed, 2 on bad usage.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/burillo-se/bs1770wrap"
+)
+
+var backends = map[string]bs1770wrap.LoudnessAnalyzer{
+	bs1770wrap.BS1770Gain{}.Name(): bs1770wrap.BS1770Gain{},
+	bs1770wrap.FFmpeg{}.Name():     bs1770wrap.FFmpeg{},
+	bs1770wrap.Native{}.Name():     bs1770wrap.Native{},
+}
+
+var groupers = map[string]bs1770wrap.AlbumGrouper{
+	"dir":  bs1770wrap.DirectoryAlbums{},
+	"tag":  bs1770wrap.TagAlbums{},
+	"work": bs1770wrap.WorkAlbums{},
+}
+
+// level is a loudness or peak value, null when -Inf, as in
+// the JSON progress protocol.
+type level float32
+
+func (l level) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(l), 0) || math.IsNaN(float64(l)) {
+		return []byte("null"), nil
+	}
+	return []byte(bs1770wrap.FormatLevel(float32(l), bs1770wrap.DefaultPrecision)), nil
+}
+
+func (l level) String() string {
+	return bs1770wrap.FormatLevel(float32(l), bs1770wrap.DefaultPrecision)
+}
+
+// loudness is the printed form of a measurement. Length is
+// in seconds.
+type loudness struct {
+	Integrated level   `json:"integrated"`
+	Peak       level   `json:"peak"`
+	Range      level   `json:"range"`
+	Shortterm  level   `json:"shortterm"`
+	Momentary  level   `json:"momentary"`
+	Length     float64 `json:"length"`
+	Gain       level   `json:"gain"` // towards the target, in dB
+}
+
+func newLoudness(ld bs1770wrap.LoudnessData, target float64) *loudness {
+	return &loudness{
+		Integrated: level(ld.Integrated),
+		Peak:       level(ld.Peak),
+		Range:      level(ld.Range),
+		Shortterm:  level(ld.Shortterm),
+		Momentary:  level(ld.Momentary),
+		Length:     float64(ld.Length) / 1e6,
+		Gain:       level(target - float64(ld.Integrated)),
+	}
+}
+
+// result is the outcome for one file.
+type result struct {
+	File     string    `json:"file"`
+	Loudness *loudness `json:"loudness,omitempty"`
+	Album    *loudness `json:"album,omitempty"`
+	Backend  string    `json:"backend,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// why the analysis was aborted, if it was: "cancelled"
+	// (interrupted), "timeout" or "missing-tool"
+	Reason bs1770wrap.AbortReason `json:"reason,omitempty"`
+}
+
+type config struct {
+	format   string
+	target   float64
+	tag      bool
+	album    bool
+	group    string
+	workers  int
+	progress string
+	opts     bs1770wrap.Options
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bs1770wrap", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: bs1770wrap [flags] file or directory...")
+		fs.PrintDefaults()
+	}
+
+	c := config{}
+	var backendList string
+	var timeout time.Duration
+	fs.StringVar(&c.format, "format", "text", "output `format`: text, json or csv")
+	fs.Float64Var(&c.target, "target", bs1770wrap.ReferenceReplayGain2, "target loudness, in `LUFS`, gains are computed towards")
+	fs.BoolVar(&c.tag, "tag", false, "write ReplayGain tags")
+	fs.BoolVar(&c.album, "album", false, "measure albums too, and tag album gain with -tag")
+	fs.StringVar(&c.group, "group", "dir", "how -album groups files: dir, tag or work")
+	fs.IntVar(&c.workers, "workers", 0, "files analyzed at once (default the number of CPUs)")
+	fs.StringVar(&c.progress, "progress", "", "write progress to stderr: json")
+	fs.StringVar(&backendList, "backends", "", "comma-separated `backends` to try in order (bs1770gain, ffmpeg, native)")
+	fs.DurationVar(&timeout, "timeout", 0, "give up on tools running longer than this")
+	fs.BoolVar(&c.opts.ReadOnly, "read-only", false, "never modify files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c.opts.Timeout = timeout
+	if backendList != "" {
+		for _, name := range strings.Split(backendList, ",") {
+			b, ok := backends[strings.TrimSpace(name)]
+			if !ok {
+				fmt.Fprintf(stderr, "bs1770wrap: unknown backend %q\n", name)
+				return 2
+			}
+			c.opts.Backends = append(c.opts.Backends, b)
+		}
+	}
+	switch c.format {
+	case "text", "json", "csv":
+	default:
+		fmt.Fprintf(stderr, "bs1770wrap: unknown format %q\n", c.format)
+		return 2
+	}
+	if _, ok := groupers[c.group]; !ok {
+		fmt.Fprintf(stderr, "bs1770wrap: unknown grouping %q\n", c.group)
+		return 2
+	}
+	switch c.progress {
+	case "":
+	case "json":
+		pw := bs1770wrap.NewProgressWriter(stderr)
+		c.opts.OnProgress = pw.Progress
+		c.opts.Hooks = append(c.opts.Hooks, bs1770wrap.HookFunc(func(e *bs1770wrap.HookEvent) error {
+			if e.Stage == bs1770wrap.StageAnalyze && e.Post {
+				ld := bs1770wrap.LoudnessData{}
+				if e.Loudness != nil {
+					ld = *e.Loudness
+				}
+				pw.Result(e.File, ld, *e.Info, e.Err)
+			}
+			return nil
+		}))
+	default:
+		fmt.Fprintf(stderr, "bs1770wrap: unknown progress format %q\n", c.progress)
+		return 2
+	}
+
+	// an interrupt aborts the files left, which are reported
+	// as cancelled
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+	go func() {
+		if _, ok := <-interrupts; ok {
+			cancel(bs1770wrap.ErrCancelled)
+		}
+	}()
+	c.opts.Context = ctx
+
+	files, err := listFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "bs1770wrap: %v\n", err)
+		return 1
+	}
+
+	var results []result
+	if c.album {
+		results, err = scanAlbums(files, c)
+	} else {
+		results = scanTracks(files, c)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "bs1770wrap: %v\n", err)
+		return 1
+	}
+
+	if err := write(stdout, results, c.format); err != nil {
+		fmt.Fprintf(stderr, "bs1770wrap: %v\n", err)
+		return 1
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return 1
+		}
+	}
+	return 0
+}
+
+// listFiles returns the files to analyze: those named, and
+// the audio files under the directories named, in order.
+func listFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read %s: %v", arg, err)
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		var found []string
+		err = filepath.Walk(arg, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				if _, skip := bs1770wrap.ClassifySkip(p); !skip {
+					found = append(found, p)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Cannot list %s: %v", arg, err)
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+func scanTracks(files []string, c config) []result {
+	s := &bs1770wrap.Scanner{Options: c.opts, NoSkip: true}
+	scanned := s.Scan(files, c.workers)
+
+	results := make([]result, 0, len(files))
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+		sr := scanned[file]
+		r := result{File: file, Backend: sr.Info.Backend}
+		if sr.Err != nil {
+			r.Error = sr.Err.Error()
+			r.Reason = sr.Aborted
+		} else {
+			r.Loudness = newLoudness(sr.Loudness, c.target)
+			if c.tag {
+				err := bs1770wrap.WriteReplayGainTags(file, bs1770wrap.NewReplayGain(sr.Loudness, c.target), c.opts)
+				if err != nil {
+					r.Error = fmt.Sprintf("Cannot tag: %v", err)
+				}
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func scanAlbums(files []string, c config) ([]result, error) {
+	s := &bs1770wrap.Scanner{Options: c.opts, NoSkip: true}
+	albums, err := s.ScanAlbums(files, groupers[c.group], c.workers)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []result
+	for _, a := range albums {
+		if a.Err != nil {
+			for _, file := range a.Files {
+				results = append(results, result{File: file, Error: a.Err.Error(), Reason: a.Aborted})
+			}
+			continue
+		}
+		var tagErr error
+		if c.tag {
+			tagErr = bs1770wrap.WriteAlbumReplayGain(a.Loudness, c.target, c.opts)
+		}
+		album := newLoudness(a.Loudness.Album, c.target)
+		for _, t := range a.Loudness.Tracks {
+			r := result{File: t.File, Album: album, Backend: t.Info.Backend}
+			switch {
+			case t.Err != nil:
+				r.Error = t.Err.Error()
+				r.Reason = bs1770wrap.AbortReasonOf(t.Err)
+			case tagErr != nil:
+				r.Error = tagErr.Error()
+				fallthrough
+			default:
+				r.Loudness = newLoudness(t.Loudness, c.target)
+			}
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func write(w io.Writer, results []result, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "csv":
+		return writeCSV(w, results)
+	}
+	return writeText(w, results)
+}
+
+func writeCSV(w io.Writer, results []result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"file", "integrated", "peak", "range", "shortterm", "momentary", "length", "gain",
+		"album integrated", "album peak", "album gain", "error", "reason"})
+	for _, r := range results {
+		row := []string{r.File, "", "", "", "", "", "", "", "", "", "", r.Error, string(r.Reason)}
+		if l := r.Loudness; l != nil {
+			copy(row[1:], []string{l.Integrated.String(), l.Peak.String(), l.Range.String(),
+				l.Shortterm.String(), l.Momentary.String(), strconv.FormatFloat(l.Length, 'f', 3, 64), l.Gain.String()})
+		}
+		if a := r.Album; a != nil {
+			copy(row[8:], []string{a.Integrated.String(), a.Peak.String(), a.Gain.String()})
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeText(w io.Writer, results []result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	album := false
+	for _, r := range results {
+		album = album || r.Album != nil
+	}
+	header := "INTEGRATED\tPEAK\tRANGE\tGAIN\t"
+	if album {
+		header += "ALBUM\tALBUM GAIN\t"
+	}
+	fmt.Fprintln(tw, header+" FILE")
+	for _, r := range results {
+		line := "-\t-\t-\t-\t"
+		if l := r.Loudness; l != nil {
+			line = fmt.Sprintf("%v\t%v\t%v\t%v\t", l.Integrated, l.Peak, l.Range, l.Gain)
+		}
+		if album {
+			if a := r.Album; a != nil {
+				line += fmt.Sprintf("%v\t%v\t", a.Integrated, a.Gain)
+			} else {
+				line += "-\t-\t"
+			}
+		}
+		line += " " + r.File
+		if r.Error != "" {
+			line += ": " + r.Error
+		}
+		fmt.Fprintln(tw, line)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,92 @@
+package bs1770wrap
+
+import (
+	"strconv"
+
+	"golang.org/x/text/language"
+)
+
+// Profile is a delivery specification loudness results can
+// be checked against.
+type Profile struct {
+	ID        string  // short name, e.g. "ebu-r128"
+	Name      string  // human-readable name
+	Target    float64 // integrated loudness, LUFS
+	Tolerance float64 // allowed deviation from Target, LU
+	MaxPeak   float64 // true peak ceiling, dBTP
+	Precision int     // decimals measurements are judged at
+}
+
+// Common delivery specifications.
+var (
+	EBUR128 = Profile{
+		ID: "ebu-r128", Name: "EBU R 128",
+		Target: ReferenceEBUR128, Tolerance: 0.5, MaxPeak: -1, Precision: 1,
+	}
+	ATSCA85 = Profile{
+		ID: "atsc-a85", Name: "ATSC A/85",
+		Target: ReferenceATSCA85, Tolerance: 2, MaxPeak: -2, Precision: 1,
+	}
+	Streaming = Profile{
+		ID: "streaming", Name: "Music streaming",
+		Target: ReferenceStreaming, Tolerance: 1, MaxPeak: -1, Precision: 1,
+	}
+)
+
+// Profiles lists the known profiles by ID. Callers may add
+// their own.
+var Profiles = map[string]Profile{
+	EBUR128.ID:   EBUR128,
+	ATSCA85.ID:   ATSCA85,
+	Streaming.ID: Streaming,
+}
+
+// Violation is one way in which a result fails a profile.
+type Violation struct {
+	Field    string
+	Measured float64
+	Limit    float64
+	Message  string // in English
+
+	msg localizable
+}
+
+// Localized returns the message in the given language (see
+// Languages).
+func (v Violation) Localized(tag language.Tag) string {
+	return v.msg.in(tag)
+}
+
+func newViolation(field string, measured, limit float64, key string, args ...interface{}) Violation {
+	msg := newLocalizable(key, args...)
+	return Violation{Field: field, Measured: measured, Limit: limit, Message: msg.english(), msg: msg}
+}
+
+// Check lists the ways in which ld violates the profile.
+func (p Profile) Check(ld LoudnessData) []Violation {
+	var vs []Violation
+
+	integrated := float64(ld.Integrated)
+	if !WithinTolerance(integrated, p.Target, p.Tolerance, p.Precision) {
+		vs = append(vs, newViolation("integrated", Round(integrated, p.Precision), p.Target,
+			msgIntegrated,
+			FormatLevel(ld.Integrated, p.Precision),
+			FormatLevel(float32(p.Target), p.Precision),
+			strconv.FormatFloat(p.Tolerance, 'g', -1, 64)))
+	}
+
+	peak := Round(float64(ld.Peak), p.Precision)
+	if peak > p.MaxPeak {
+		vs = append(vs, newViolation("peak", peak, p.MaxPeak,
+			msgPeak,
+			FormatLevel(ld.Peak, p.Precision),
+			strconv.FormatFloat(p.MaxPeak, 'g', -1, 64)))
+	}
+
+	return vs
+}
+
+// Compliant reports whether ld satisfies the profile.
+func (p Profile) Compliant(ld LoudnessData) bool {
+	return len(p.Check(ld)) == 0
+}
@@ -0,0 +1,144 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* CSV format, as printed with --csv:
+
+`
+track,file,integrated,momentary maximum,shortterm maximum,range,true peak
+1,01 - Powerful Blues Rock.wav,-14.14,-9.55,-11.32,4.52,0.05
+2,...
+ALBUM,,-14.53,...
+`
+
+Columns are found by their header, whatever their order and
+however they are spelled across releases ("short-term",
+"true-peak", with units such as "integrated (LUFS)"), and
+values may carry a unit too. Some builds separate fields with
+semicolons or tabs. The row whose track is ALBUM, or that has
+neither track nor file, is the album summary.
+*/
+
+var csvValueRegex = regexp.MustCompile(`^\s*(-?(?:inf|\d+(?:\.\d+)?))`)
+
+// looksLikeCSV reports whether a report is CSV, going by
+// its header.
+func looksLikeCSV(out []byte) bool {
+	line, _, _ := bytes.Cut(bytes.TrimSpace(out), []byte("\n"))
+	header := strings.ToLower(string(line))
+	return strings.Contains(header, "integrated") && strings.ContainsAny(header, ",;\t")
+}
+
+// csvColumn maps a header to the measurement it holds, or
+// "" for columns that are of no interest.
+func csvColumn(header string) string {
+	h := strings.ToLower(strings.TrimSpace(header))
+	h = strings.NewReplacer("-", "", "_", "", " ", "").Replace(h)
+	switch {
+	case strings.HasPrefix(h, "track"), h == "number", h == "#":
+		return "track"
+	case strings.HasPrefix(h, "file"), strings.HasPrefix(h, "path"), strings.HasPrefix(h, "name"):
+		return "file"
+	case strings.HasPrefix(h, "integrated"):
+		return "integrated"
+	case strings.HasPrefix(h, "momentary"):
+		return "momentary"
+	case strings.HasPrefix(h, "shortterm"):
+		return "shortterm"
+	case strings.HasPrefix(h, "range"), strings.HasPrefix(h, "lra"):
+		return "range"
+	case strings.HasPrefix(h, "truepeak"), strings.HasPrefix(h, "peak"):
+		return "true peak"
+	}
+	return ""
+}
+
+// parseCSV parses the CSV report into the same structure the
+// XML report is unmarshalled into.
+func parseCSV(out []byte) (bs1770gainData, error) {
+	out = bytes.TrimSpace(out)
+	line, _, _ := bytes.Cut(out, []byte("\n"))
+	r := csv.NewReader(bytes.NewReader(out))
+	r.FieldsPerRecord = -1
+	for _, sep := range []rune{';', '\t'} {
+		if !bytes.ContainsRune(line, ',') && bytes.ContainsRune(line, sep) {
+			r.Comma = sep
+		}
+	}
+	// trimming would take the tabs of empty fields with it
+	r.TrimLeadingSpace = r.Comma != '\t'
+	rows, err := r.ReadAll()
+	if err != nil {
+		return bs1770gainData{}, err
+	}
+	if len(rows) == 0 {
+		return bs1770gainData{}, withKind(ErrNoLoudnessData, fmt.Errorf("no tracks in output"))
+	}
+
+	columns := make([]string, len(rows[0]))
+	for i, h := range rows[0] {
+		columns[i] = csvColumn(h)
+	}
+
+	gd := bs1770gainData{}
+	for _, row := range rows[1:] {
+		var track, file string
+		var m measurements
+		for i, field := range row {
+			if i >= len(columns) {
+				break
+			}
+			switch columns[i] {
+			case "":
+				continue
+			case "track":
+				track = strings.TrimSpace(field)
+				continue
+			case "file":
+				file = strings.TrimSpace(field)
+				continue
+			}
+			v := csvValueRegex.FindStringSubmatch(field)
+			if v == nil {
+				continue // empty, or not measured
+			}
+			f, err := strconv.ParseFloat(v[1], 32)
+			if err != nil {
+				return bs1770gainData{}, fmt.Errorf("bad %s value %q", columns[i], field)
+			}
+			switch columns[i] {
+			case "integrated":
+				m.Integrated.Value = float32(f)
+			case "momentary":
+				m.MomentaryMaximum = &levelData{Value: float32(f)}
+			case "shortterm":
+				m.ShorttermMaximum = &levelData{Value: float32(f)}
+			case "range":
+				m.Range.Value = float32(f)
+			case "true peak":
+				m.TruePeak.Value = float32(f)
+			}
+		}
+
+		if strings.EqualFold(track, "album") || track == "" && file == "" {
+			gd.Album.Summary = &summaryData{measurements: m}
+			continue
+		}
+		n, err := strconv.Atoi(strings.SplitN(track, "/", 2)[0])
+		if err != nil {
+			n = len(gd.Album.Tracks) + 1
+		}
+		gd.Album.Tracks = append(gd.Album.Tracks, trackData{Number: n, File: file, measurements: m})
+	}
+	if len(gd.Album.Tracks) == 0 {
+		return bs1770gainData{}, withKind(ErrNoLoudnessData, fmt.Errorf("no tracks in output"))
+	}
+	return gd, nil
+}
@@ -0,0 +1,87 @@
+package bs1770wrap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// csvAlbum is the --csv report of the album of textAlbum:
+// file names with separators or quotes are quoted, their
+// quotes doubled.
+const csvAlbum = `track,file,integrated,momentary maximum,shortterm maximum,range,true peak
+1,01 - Powerful Blues Rock.wav,-14.14,-9.55,-11.32,4.52,0.05
+2,"02 - Björk – ""Jóga"" (夜).flac",-15.20,-10.01,-12.40,6.10,-0.30
+3,"03 - C:\ ""silence"": x.mp3",-inf,-inf,-inf,0.00,-inf
+ALBUM,,-14.53,-9.55,-11.32,5.34,0.05
+`
+
+func TestParseCSV(t *testing.T) {
+	album := &LoudnessData{Integrated: -14.53, Momentary: -9.55, Shortterm: -11.32, Range: 5.34, Peak: 0.05}
+	for _, c := range []struct {
+		name   string
+		out    string
+		tracks []textTrack
+		album  *LoudnessData
+	}{
+		{"album", csvAlbum, textAlbumTracks, album},
+		{"CRLF", strings.ReplaceAll(csvAlbum, "\n", "\r\n"), textAlbumTracks, album},
+		{"semicolons and units", `Track;File;Integrated (LUFS);Momentary Maximum (LUFS);Short-term Maximum (LUFS);Range (LU);True-Peak (dBTP)
+1/3;01 - Powerful Blues Rock.wav;-14.14 LUFS;-9.55 LUFS;-11.32 LUFS;4.52 LU;0.05 dBTP
+2/3;"02 - Björk – ""Jóga"" (夜).flac";-15.20 LUFS;-10.01 LUFS;-12.40 LUFS;6.10 LU;-0.30 dBTP
+3/3;"03 - C:\ ""silence"": x.mp3";-inf LUFS;-inf LUFS;-inf LUFS;0.00 LU;-inf dBTP
+;;-14.53 LUFS;-9.55 LUFS;-11.32 LUFS;5.34 LU;0.05 dBTP
+`, textAlbumTracks, album},
+		// columns in another order, some of no interest, with a
+		// comma in a name and no album row
+		{"tabs", "path\tsample peak\ttrue_peak\tlra\tintegrated\tnumber\n" +
+			"a, b.flac\t-0.50\t-0.30\t6.10\t-15.20\t7\n" +
+			"日本語.ogg\t\t\t\t-23.00\t8\n",
+			[]textTrack{
+				{7, "a, b.flac", LoudnessData{Integrated: -15.2, Range: 6.1, Peak: -0.3}},
+				{8, "日本語.ogg", LoudnessData{Integrated: -23}},
+			}, nil},
+		// tracks numbered in order where the number is not one
+		{"unnumbered", "file,integrated\na.flac,-20\nb.flac,-21\n", []textTrack{
+			{1, "a.flac", LoudnessData{Integrated: -20}},
+			{2, "b.flac", LoudnessData{Integrated: -21}},
+		}, nil},
+	} {
+		if !looksLikeCSV([]byte(c.out)) {
+			t.Errorf("%s: not taken for CSV", c.name)
+		}
+		gd, err := parseCSV([]byte(c.out))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(gd.Album.Tracks) != len(c.tracks) {
+			t.Errorf("%s: %d tracks, want %d", c.name, len(gd.Album.Tracks), len(c.tracks))
+			continue
+		}
+		for i, want := range c.tracks {
+			got := gd.Album.Tracks[i]
+			if got.Number != want.number || got.File != want.file || got.loudness(0) != want.ld {
+				t.Errorf("%s: track %d is %d %q measuring %+v, want %d %q measuring %+v", c.name, i, got.Number, got.File, got.loudness(0), want.number, want.file, want.ld)
+			}
+		}
+		switch {
+		case (gd.Album.Summary != nil) != (c.album != nil):
+			t.Errorf("%s: album summary %v, want %v", c.name, gd.Album.Summary, c.album)
+		case c.album != nil && gd.Album.Summary.loudness(0) != *c.album:
+			t.Errorf("%s: album measures %+v, want %+v", c.name, gd.Album.Summary.loudness(0), *c.album)
+		}
+	}
+
+	if looksLikeCSV([]byte(textAlbum)) {
+		t.Error("the plain text report is taken for CSV")
+	}
+	for _, out := range []string{"", "track,file,integrated\n", "track,file,integrated\nALBUM,,-14.53\n"} {
+		if _, err := parseCSV([]byte(out)); !errors.Is(err, ErrNoLoudnessData) {
+			t.Errorf("parseCSV(%q) = %v, want ErrNoLoudnessData", out, err)
+		}
+	}
+	if _, err := parseCSV([]byte("track,file,integrated\n1,\"a.flac,-14\n")); err == nil || errors.Is(err, ErrNoLoudnessData) {
+		t.Errorf("an unterminated quote parses, with %v", err)
+	}
+}
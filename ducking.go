@@ -0,0 +1,178 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Defaults of DuckingOptions.
+const (
+	DefaultDuckSeparation = 10.0                   // LU
+	DefaultSpeechGate     = 10.0                   // LU
+	DefaultDuckTolerance  = 500 * time.Millisecond // ducking attack and release
+)
+
+// seriesHop is the spacing of the ebur128 frame log
+const seriesHop = 100 * time.Millisecond
+
+// DuckingOptions tune CheckDucking. Zero fields take the
+// defaults above. Options are used for the analyses and the
+// tools spawned.
+type DuckingOptions struct {
+	// Separation is how far, in LU, the momentary loudness of
+	// the bed must stay under that of the voice while it
+	// speaks.
+	Separation float64
+
+	// SpeechGate is how far, in LU, the momentary loudness of
+	// the voice may drop below its integrated loudness and
+	// still count as speech.
+	SpeechGate float64
+
+	// Tolerance is how long the separation may fall short
+	// before it counts, leaving the ducker time to react.
+	Tolerance time.Duration
+
+	Options Options
+}
+
+// DuckSpan is a span of speech over which the bed is not
+// ducked enough.
+type DuckSpan struct {
+	Segment
+	Separation float64 // smallest over the span, LU
+}
+
+// DuckingReport is the analysis of a voice-over.
+type DuckingReport struct {
+	Voice, Bed, Mix LoudnessData
+
+	// Separation is the loudness of the voice over that of
+	// the bed while the voice speaks, LU; zero if it never
+	// does.
+	Separation float64
+	Speech     time.Duration // total time the voice speaks
+
+	Insufficient []DuckSpan
+	Ducked       bool // no span is insufficient
+}
+
+// CheckDucking measures a voice track and the music bed under
+// it, separately and mixed as they would be, and reports
+// whether the bed is ducked far enough under the speech. The
+// two are expected to start together. It requires ffmpeg,
+// which mixes them and measures the momentary loudness,
+// besides the backends.
+func CheckDucking(voice, bed string, dopts DuckingOptions) (DuckingReport, error) {
+	opts := dopts.Options
+	separation := floatOr(dopts.Separation, DefaultDuckSeparation)
+	gate := floatOr(dopts.SpeechGate, DefaultSpeechGate)
+	tolerance := durationOr(dopts.Tolerance, DefaultDuckTolerance)
+
+	r := DuckingReport{}
+	var err error
+	r.Voice, _, err = calculateSafely(voice, opts)
+	if err != nil {
+		return DuckingReport{}, fmt.Errorf("Cannot analyze voice: %w", err)
+	}
+	r.Bed, _, err = calculateSafely(bed, opts)
+	if err != nil {
+		return DuckingReport{}, fmt.Errorf("Cannot analyze bed: %w", err)
+	}
+	r.Mix, err = mixLoudness(voice, bed, opts)
+	if err != nil {
+		return DuckingReport{}, err
+	}
+
+	voiceSeries, err := mediaSeries(voice, opts)
+	if err != nil {
+		return DuckingReport{}, err
+	}
+	bedSeries, err := mediaSeries(bed, opts)
+	if err != nil {
+		return DuckingReport{}, err
+	}
+	bedAt := make(map[int64]float32, len(bedSeries))
+	for _, s := range bedSeries {
+		bedAt[int64((s.At+seriesHop/2)/seriesHop)] = s.Momentary
+	}
+
+	var voiceLevels, bedLevels []float64
+	var span *DuckSpan
+	closeSpan := func() {
+		if span != nil && span.End-span.Start >= tolerance {
+			r.Insufficient = append(r.Insufficient, *span)
+		}
+		span = nil
+	}
+	speechLevel := float32(float64(r.Voice.Integrated) - gate)
+	for _, s := range voiceSeries {
+		if s.Momentary < speechLevel {
+			closeSpan()
+			continue
+		}
+		b, ok := bedAt[int64((s.At+seriesHop/2)/seriesHop)]
+		if !ok {
+			b = float32(math.Inf(-1))
+		}
+		r.Speech += seriesHop
+		voiceLevels = append(voiceLevels, float64(s.Momentary))
+		bedLevels = append(bedLevels, float64(b))
+
+		sep := float64(s.Momentary - b)
+		if sep >= separation {
+			closeSpan()
+			continue
+		}
+		if span == nil {
+			span = &DuckSpan{Segment: Segment{Start: s.At - seriesHop}, Separation: sep}
+		}
+		span.End = s.At
+		span.Separation = math.Min(span.Separation, sep)
+	}
+	closeSpan()
+
+	if len(voiceLevels) > 0 {
+		r.Separation = EnergyMean(voiceLevels...) - EnergyMean(bedLevels...)
+	}
+	r.Ducked = len(r.Insufficient) == 0
+	return r, nil
+}
+
+// mixLoudness measures voice and bed mixed at unity gain.
+func mixLoudness(voice, bed string, opts Options) (LoudnessData, error) {
+	dir, err := os.MkdirTemp(opts.TempDir, "bs1770wrap")
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	mix := filepath.Join(dir, "mix.wav")
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg",
+		"-nostdin",
+		"-loglevel", "error",
+		"-i", ffmpegPath(voice),
+		"-i", ffmpegPath(bed),
+		"-filter_complex", "[0:a:0][1:a:0]amix=inputs=2:duration=longest:normalize=0",
+		"-c:a", "pcm_f32le",
+		ffmpegPath(mix),
+	)
+	cmd.Stderr = &stderr
+
+	err = run("ffmpeg", cmd, opts, &AnalysisInfo{})
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot mix voice and bed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	ld, _, err := calculateSafely(mix, opts)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot analyze mix: %w", err)
+	}
+	return ld, nil
+}
@@ -0,0 +1,198 @@
+package bs1770wrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"strings"
+)
+
+// Kinds of failure callers may want to tell apart, matched
+// with errors.Is against the errors the package returns.
+var (
+	// ErrBinaryNotFound is a tool that could not be started
+	// because it is not installed.
+	ErrBinaryNotFound = errors.New("tool not found")
+
+	// ErrDecodeFailed is a tool that ran but failed, usually
+	// because the file is corrupt or in a format it cannot
+	// read.
+	ErrDecodeFailed = errors.New("tool failed")
+
+	// ErrTimeout is a tool killed for running longer than
+	// Options.Timeout.
+	ErrTimeout = errors.New("tool timed out")
+
+	// ErrParse is tool output that cannot be made sense of.
+	ErrParse = errors.New("cannot parse tool output")
+
+	// ErrNoLoudnessData is tool output that parses but lacks
+	// the measurements asked for, such as a track that is not
+	// in an album analysis.
+	ErrNoLoudnessData = errors.New("no loudness data")
+
+	// ErrCancelled and ErrShutdown are causes to cancel
+	// Options.Context with, telling an operator cancelling
+	// an analysis from the service it runs in shutting down.
+	ErrCancelled = errors.New("analysis cancelled")
+	ErrShutdown  = errors.New("shutting down")
+)
+
+// AbortReason is why an analysis was aborted, as opposed to
+// failing on a file, for reports to tell apart.
+type AbortReason string
+
+// Reasons to abort, as AbortReasonOf tells them.
+const (
+	AbortTimeout     AbortReason = "timeout"      // Options.Timeout, or a context deadline
+	AbortShutdown    AbortReason = "shutdown"     // cancelled with ErrShutdown
+	AbortCancelled   AbortReason = "cancelled"    // cancelled otherwise
+	AbortMissingTool AbortReason = "missing-tool" // no backend had its tools
+)
+
+// AbortReasonOf returns why err aborted an analysis, or ""
+// if it did not: the analysis failed on the file, or err is
+// nil. A cancellation anywhere in err is the reason; tools
+// timing out or missing are only when every backend tried
+// failed that way.
+func AbortReasonOf(err error) AbortReason {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrShutdown):
+		return AbortShutdown
+	case errors.Is(err, context.DeadlineExceeded):
+		return AbortTimeout
+	case errors.Is(err, ErrCancelled), errors.Is(err, context.Canceled):
+		return AbortCancelled
+	case allAre(err, ErrTimeout):
+		return AbortTimeout
+	case allAre(err, ErrBinaryNotFound):
+		return AbortMissingTool
+	}
+	return ""
+}
+
+// allAre reports whether err matches target, and so do all
+// the attempts it joins, if any.
+func allAre(err, target error) bool {
+	var me *multiError
+	if !errors.As(err, &me) {
+		return errors.Is(err, target)
+	}
+	for _, e := range me.errs {
+		if !allAre(e, target) {
+			return false
+		}
+	}
+	return len(me.errs) > 0
+}
+
+// contextError is the error of an analysis aborted by ctx,
+// matching its cause.
+func contextError(ctx context.Context) error {
+	return fmt.Errorf("Aborted: %w", context.Cause(ctx))
+}
+
+// stderrTail is how much of what a tool prints to stderr is
+// kept in a ToolError
+const stderrTail = 4096
+
+// ToolError is the failure of a spawned tool. It matches
+// ErrBinaryNotFound if the tool is missing and ErrDecodeFailed
+// if it exited with an error.
+type ToolError struct {
+	Tool   string
+	Err    error  // from os/exec, or the memory limit
+	Stderr string // the end of what the tool printed to stderr
+}
+
+func (e *ToolError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// Is implements errors.Is.
+func (e *ToolError) Is(target error) bool {
+	switch target {
+	case ErrBinaryNotFound:
+		return errors.Is(e.Err, exec.ErrNotFound) || errors.Is(e.Err, fs.ErrNotExist)
+	case ErrDecodeFailed:
+		var exit *exec.ExitError
+		return errors.As(e.Err, &exit)
+	}
+	return false
+}
+
+// ToolStderr returns what the tool behind err printed to
+// stderr, if err comes from a tool.
+func ToolStderr(err error) string {
+	var te *ToolError
+	if errors.As(err, &te) {
+		return te.Stderr
+	}
+	return ""
+}
+
+// kindError marks err as being of one of the kinds above
+// without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Is implements errors.Is.
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// multiError joins the errors of several attempts, matching
+// whatever any of them matches.
+type multiError struct {
+	msg  string
+	errs []error
+}
+
+func (e *multiError) Error() string {
+	return e.msg
+}
+
+func (e *multiError) Unwrap() []error {
+	return e.errs
+}
+
+// tailBuffer is an io.Writer keeping the last max bytes
+// written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return strings.TrimSpace(string(b.buf))
+}
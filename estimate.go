@@ -0,0 +1,127 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Defaults of EstimateOptions.
+const (
+	DefaultEstimateSegments      = 8
+	DefaultEstimateSegmentLength = 10 * time.Second
+)
+
+// EstimateOptions tunes EstimateLoudnessURL. Options are
+// used for the tools spawned, and their Calibration applies.
+type EstimateOptions struct {
+	// Remote has the credentials to fetch with. ffmpeg makes
+	// its range requests to a proxy on the loopback interface,
+	// which fetches them with Remote, so Runners must run it
+	// on the same host.
+	Remote *Remote
+
+	// Segments is how many portions of the file are measured,
+	// of SegmentLength each; zero means DefaultEstimateSegments
+	// and DefaultEstimateSegmentLength.
+	Segments      int
+	SegmentLength time.Duration
+
+	Options Options
+}
+
+// EstimateLoudnessURL estimates the loudness of the audio at
+// url from portions spread evenly across it, which ffmpeg
+// reads with HTTP range requests, instead of downloading a
+// file of maybe gigabytes. The portions are measured as one
+// signal: integrated loudness and range are approximations,
+// and the maxima and peak lower bounds. Length is that of the
+// whole file. Files no longer than the portions together are
+// measured whole. The server must support range requests.
+func EstimateLoudnessURL(url string, eo EstimateOptions) (LoudnessData, AnalysisInfo, error) {
+	opts := eo.Options
+	info := AnalysisInfo{}
+	remote := eo.Remote
+	if remote == nil {
+		remote = &Remote{}
+	}
+	segments, length := eo.Segments, eo.SegmentLength
+	if segments <= 0 {
+		segments = DefaultEstimateSegments
+	}
+	if length <= 0 {
+		length = DefaultEstimateSegmentLength
+	}
+
+	// this also has a refused URL refreshed
+	resp, err := remote.get(opts.Context, url, http.Header{"Range": {"bytes=0-0"}})
+	if err != nil {
+		return LoudnessData{}, info, fmt.Errorf("Cannot estimate loudness: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return LoudnessData{}, info, fmt.Errorf("Cannot estimate loudness: %s does not support range requests", redactURL(url))
+	}
+	// ffmpeg reads through a proxy, the credentials and a
+	// pre-signed URL kept off its command line
+	local, stop, err := remote.proxy(opts.Context, url)
+	if err != nil {
+		return LoudnessData{}, info, fmt.Errorf("Cannot estimate loudness: %v", err)
+	}
+	defer stop()
+	input := []string{local}
+
+	total, err := probeInput(input, opts, &info)
+	if err != nil {
+		return LoudnessData{}, info, err
+	}
+
+	args := []string{"-nostats", "-loglevel", "info"}
+	if time.Duration(total)*time.Microsecond <= time.Duration(segments)*length {
+		args = append(args, input...)
+		args = append(args, "-vn", "-af", "ebur128=framelog=info:peak=true")
+	} else {
+		// each portion an input of its own, ffmpeg seeking to
+		// it, and concatenated to measure
+		span := time.Duration(total)*time.Microsecond - length
+		graph := ""
+		for i := 0; i < segments; i++ {
+			at := span / 2
+			if segments > 1 {
+				at = span * time.Duration(i) / time.Duration(segments-1)
+			}
+			args = append(args, "-ss", seconds(at), "-t", seconds(length))
+			args = append(args, input...)
+			graph += fmt.Sprintf("[%d:a:0]", i)
+		}
+		graph += fmt.Sprintf("concat=n=%d:v=0:a=1,ebur128=framelog=info:peak=true", segments)
+		args = append(args, "-filter_complex", graph)
+		info.Warnings = append(info.Warnings, fmt.Sprintf("estimated from %d portions of %v", segments, length))
+	}
+	args = append(args, "-f", "null", "-")
+
+	out, err := runFilterLog(exec.Command("ffmpeg", args...), nil, opts, &info)
+	if err != nil {
+		return LoudnessData{}, info, fmt.Errorf("Cannot estimate loudness: %w", err)
+	}
+	if opts.KeepRawOutput {
+		info.RawOutput = []byte(out)
+	}
+	start := time.Now()
+	ld, err := parseEBUR128(out)
+	info.Timings.Parse += time.Since(start)
+	if err != nil {
+		return LoudnessData{}, info, err
+	}
+	ld.Length = total
+	info.Backend = FFmpeg{}.Name()
+	ld, info.Calibration = calibrate(ld, info.Backend, opts)
+	logf(opts, LogInfo, "estimated %s in %v: %s LUFS", redactURL(url), info.Timings.Total(), FormatLevel(ld.Integrated, DefaultPrecision))
+	return ld, info, nil
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,75 @@
+package bs1770wrap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// The exporters below write stored results in formats other
+// tools read, for workflows that mix tools. They are the
+// counterparts of the importers, which read them back.
+
+// ExportLoudgain writes results as the tab separated table
+// loudgain prints with -O, with gains relative to reference
+// (usually ReferenceReplayGain2). Clipping is flagged for
+// new peaks above 0 dBTP; no clipping prevention is applied.
+func ExportLoudgain(w io.Writer, results []StoredResult, reference float64) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = '\t'
+	rows := [][]string{{
+		"File", "Loudness", "Range", "True_Peak", "True_Peak_dBTP", "Reference",
+		"Will_clip", "Clip_prevent", "Gain", "New_Peak", "New_Peak_dBTP",
+	}}
+	for _, r := range results {
+		ld := r.Loudness
+		gain := reference - float64(ld.Integrated)
+		newPeak := float64(ld.Peak) + gain
+		clip := "N"
+		if newPeak > 0 {
+			clip = "Y"
+		}
+		rows = append(rows, []string{
+			r.File,
+			fmt.Sprintf("%.2f LUFS", ld.Integrated),
+			fmt.Sprintf("%.2f LU", ld.Range),
+			fmt.Sprintf("%.6f", DBToLinear(float64(ld.Peak))),
+			fmt.Sprintf("%.2f dBTP", ld.Peak),
+			fmt.Sprintf("%.2f LUFS", reference),
+			clip,
+			"N",
+			fmt.Sprintf("%.2f dB", gain),
+			fmt.Sprintf("%.6f", DBToLinear(newPeak)),
+			fmt.Sprintf("%.2f dBTP", newPeak),
+		})
+	}
+	return writeTable(cw, rows)
+}
+
+// ExportFoobar2000 writes results as a semicolon separated
+// table of ReplayGain track values, headed by the foobar2000
+// title formatting fields (%path%, %replaygain_track_gain%
+// and %replaygain_track_peak%), for tools that tag files from
+// such tables. Gains are relative to ReferenceReplayGain2,
+// as foobar2000's own scanner has them.
+func ExportFoobar2000(w io.Writer, results []StoredResult) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+	rows := [][]string{{"%path%", "%replaygain_track_gain%", "%replaygain_track_peak%"}}
+	for _, r := range results {
+		rows = append(rows, []string{
+			r.File,
+			fmt.Sprintf("%.2f dB", ReferenceReplayGain2-float64(r.Loudness.Integrated)),
+			fmt.Sprintf("%.6f", DBToLinear(float64(r.Loudness.Peak))),
+		})
+	}
+	return writeTable(cw, rows)
+}
+
+func writeTable(cw *csv.Writer, rows [][]string) error {
+	err := cw.WriteAll(rows)
+	if err != nil {
+		return fmt.Errorf("Cannot write table: %v", err)
+	}
+	return nil
+}
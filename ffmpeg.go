@@ -0,0 +1,184 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The ebur128 filter ends its log with a summary:
+//
+//	[Parsed_ebur128_0 @ 0x...] Summary:
+//
+//	  Integrated loudness:
+//	    I:         -23.0 LUFS
+//	    Threshold: -33.5 LUFS
+//
+//	  Loudness range:
+//	    LRA:         6.7 LU
+//	    ...
+//
+//	  True peak:
+//	    Peak:       -1.2 dBFS
+//
+// Maximum momentary and short-term loudness are not in it,
+// they are taken from the frame log preceding it.
+var (
+	summaryIntegrated = regexp.MustCompile(`I:\s*(\S+)\s+LUFS`)
+	summaryRange      = regexp.MustCompile(`LRA:\s*(\S+)\s+LU`)
+	summaryPeak       = regexp.MustCompile(`Peak:\s*(\S+)\s+dBFS`)
+)
+
+// FFmpeg is the LoudnessAnalyzer that runs ffmpeg's ebur128
+// filter, with ffprobe measuring the length. ffmpeg is
+// available nearly everywhere bs1770gain is not. Results of
+// the two agree to within rounding on most material, but are
+// recorded as different methods all the same.
+type FFmpeg struct{}
+
+// Name implements LoudnessAnalyzer.
+func (FFmpeg) Name() string {
+	return "ffmpeg"
+}
+
+// Analyze implements LoudnessAnalyzer.
+func (FFmpeg) Analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	// the length is needed first to tell progress
+	length, err := probeDuration(file, opts, info)
+	if err != nil {
+		return LoudnessData{}, err
+	}
+	var progress io.Writer
+	if opts.OnProgress != nil {
+		progress = &ebur128Progress{progress: newProgress(file, float64(length), opts)}
+	}
+
+	out, err := runEBUR128(file, "ebur128=framelog=info:peak=true", progress, opts, info)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot calculate loudness: %w", err)
+	}
+	if opts.KeepRawOutput {
+		info.RawOutput = []byte(out)
+	}
+
+	start := time.Now()
+	ld, err := parseEBUR128(out)
+	info.Timings.Parse += time.Since(start)
+	if err != nil {
+		return LoudnessData{}, err
+	}
+	ld.Length = length
+	return ld, nil
+}
+
+// parseEBUR128 parses the log of the ebur128 filter run with
+// peak=true. The length is taken from the frame log, so it is
+// only accurate to 100 ms; a probed length is better.
+func parseEBUR128(out string) (LoudnessData, error) {
+	series, err := parseSeries(out)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot parse loudness information: %w", err)
+	}
+	ld := LoudnessData{
+		Momentary: float32(math.Inf(-1)),
+		Shortterm: float32(math.Inf(-1)),
+		Length:    uint64(series[len(series)-1].At / time.Microsecond),
+	}
+	for _, s := range series {
+		ld.Momentary = max32(ld.Momentary, s.Momentary)
+		ld.Shortterm = max32(ld.Shortterm, s.Shortterm)
+	}
+
+	i := strings.LastIndex(out, "Summary:")
+	if i < 0 {
+		return LoudnessData{}, withKind(ErrNoLoudnessData, fmt.Errorf("Cannot parse loudness information: no summary in output"))
+	}
+	summary := out[i:]
+	for _, f := range []struct {
+		re *regexp.Regexp
+		v  *float32
+	}{
+		{summaryIntegrated, &ld.Integrated},
+		{summaryRange, &ld.Range},
+		{summaryPeak, &ld.Peak},
+	} {
+		m := f.re.FindStringSubmatch(summary)
+		if m == nil {
+			return LoudnessData{}, withKind(ErrNoLoudnessData, fmt.Errorf("Cannot parse loudness information: summary lacks %s", f.re))
+		}
+		*f.v, err = parseLevel(m[1])
+		if err != nil {
+			return LoudnessData{}, withKind(ErrParse, fmt.Errorf("Cannot parse loudness information: %v", err))
+		}
+	}
+	return ld, nil
+}
+
+// probeDuration uses ffprobe to find out how long the file
+// is, in microseconds, according to its container, and
+// records the format of its first audio stream in info.Media.
+func probeDuration(file string, opts Options, info *AnalysisInfo) (uint64, error) {
+	return probeInput([]string{ffmpegPath(file)}, opts, info)
+}
+
+// probeInput is probeDuration for an input given as ffprobe
+// arguments, such as protocol options and a URL.
+func probeInput(input []string, opts Options, info *AnalysisInfo) (uint64, error) {
+	var out, stderr bytes.Buffer
+
+	args := []string{
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "format=duration:stream=codec_name,sample_rate,channels,bits_per_sample,bits_per_raw_sample",
+		"-of", "json",
+	}
+	cmd := exec.Command("ffprobe", append(args, input...)...)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := run("ffprobe", cmd, opts, info)
+	info.Timings.Probe += time.Since(start)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot get audio length: %w: %s", err, lastLine(stderr.String()))
+	}
+
+	// ffprobe prints numbers as strings, and N/A where unknown
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			Codec      string `json:"codec_name"`
+			SampleRate string `json:"sample_rate"`
+			Channels   int    `json:"channels"`
+			Bits       int    `json:"bits_per_sample"`
+			RawBits    string `json:"bits_per_raw_sample"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return 0, withKind(ErrParse, fmt.Errorf("Cannot parse audio length: %v", err))
+	}
+	if len(probe.Streams) > 0 && info.Media == nil {
+		st := probe.Streams[0]
+		m := MediaInfo{Channels: st.Channels, BitDepth: st.Bits, Encoding: st.Codec}
+		m.SampleRate, _ = strconv.Atoi(st.SampleRate)
+		if bits, err := strconv.Atoi(st.RawBits); err == nil && bits > 0 {
+			m.BitDepth = bits // what FLAC and ALAC keep, rather than decode to
+		}
+		info.Media = &m
+	}
+
+	secs, err := strconv.ParseFloat(strings.TrimSpace(probe.Format.Duration), 64)
+	if err != nil {
+		return 0, withKind(ErrParse, fmt.Errorf("Cannot parse audio length: %v", err))
+	}
+	return uint64(math.Round(secs * 1000000.0)), nil
+}
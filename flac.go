@@ -0,0 +1,119 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// A FLAC file is "fLaC" and metadata blocks, then the audio
+// frames. A block header is a byte holding a last-block flag
+// and the block type, and the 24-bit size of the payload. Tags
+// are in the VORBIS_COMMENT block, which has no framing bit.
+
+const (
+	flacLast          = 0x80
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+)
+
+type flacBlock struct {
+	typ  byte
+	data []byte
+}
+
+// readFLACBlocks reads the metadata blocks of a FLAC file and
+// where its frames start.
+func readFLACBlocks(file string) ([]flacBlock, int64, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(f, hdr); err != nil || string(hdr) != "fLaC" {
+		return nil, 0, fmt.Errorf("Not a FLAC file")
+	}
+	pos := int64(4)
+	var blocks []flacBlock
+	for {
+		if _, err := io.ReadFull(f, hdr); err != nil {
+			return nil, 0, fmt.Errorf("Cannot parse FLAC metadata: truncated")
+		}
+		b := flacBlock{typ: hdr[0] &^ flacLast, data: make([]byte, int(hdr[1])<<16|int(hdr[2])<<8|int(hdr[3]))}
+		if _, err := io.ReadFull(f, b.data); err != nil {
+			return nil, 0, fmt.Errorf("Cannot parse FLAC metadata: truncated")
+		}
+		if len(blocks) == 0 && b.typ != flacStreamInfo {
+			return nil, 0, fmt.Errorf("Cannot parse FLAC metadata: no STREAMINFO")
+		}
+		blocks = append(blocks, b)
+		pos += 4 + int64(len(b.data))
+		if hdr[0]&flacLast != 0 {
+			return blocks, pos, nil
+		}
+	}
+}
+
+// flacComment returns the Vorbis comment of the blocks and the
+// index of its block, or an empty comment and -1.
+func flacComment(blocks []flacBlock) (vorbisComment, int, error) {
+	for i, b := range blocks {
+		if b.typ == flacVorbisComment {
+			vc, _, err := parseVorbisComment(b.data)
+			return vc, i, err
+		}
+	}
+	return vorbisComment{vendor: "bs1770wrap"}, -1, nil
+}
+
+// readFLACTags reads the tags of a FLAC file.
+func readFLACTags(file string) (map[string]string, error) {
+	blocks, _, err := readFLACBlocks(file)
+	if err != nil {
+		return nil, err
+	}
+	vc, _, err := flacComment(blocks)
+	if err != nil {
+		return nil, err
+	}
+	return vc.tags(), nil
+}
+
+// writeFLACTags writes src with its VORBIS_COMMENT block,
+// which is added after STREAMINFO if missing, updated to dst.
+func writeFLACTags(src, dst string, tags map[string]string) error {
+	blocks, end, err := readFLACBlocks(src)
+	if err != nil {
+		return err
+	}
+	vc, i, err := flacComment(blocks)
+	if err != nil {
+		return err
+	}
+	for k, v := range tags {
+		vc.set(k, v)
+	}
+	b := flacBlock{typ: flacVorbisComment, data: vc.encode()}
+	if len(b.data) >= 1<<24 {
+		return fmt.Errorf("Cannot tag FLAC file: comment too large")
+	}
+	if i < 0 {
+		blocks = append(blocks[:1], append([]flacBlock{b}, blocks[1:]...)...)
+	} else {
+		blocks[i] = b
+	}
+
+	buf := []byte("fLaC")
+	for i, b := range blocks {
+		typ := b.typ
+		if i == len(blocks)-1 {
+			typ |= flacLast
+		}
+		n := len(b.data)
+		buf = append(buf, typ, byte(n>>16), byte(n>>8), byte(n))
+		buf = append(buf, b.data...)
+	}
+	return replaceRange(src, dst, 0, end, buf)
+}
@@ -0,0 +1,52 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"sync"
+)
+
+// flightGroup coalesces concurrent analyses of the same key:
+// while one is running, callers asking for the key again wait
+// for it and share its result instead of starting their own.
+// The zero value is ready to use.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	ld   LoudnessData
+	err  error
+}
+
+// do runs f for key, unless a call for key is already running,
+// in which case it waits for that one and returns its result.
+// A panic in f, such as in a caller's Store, is returned to
+// all of them as a *PanicError.
+func (g *flightGroup) do(key string, f func() (LoudnessData, error)) (LoudnessData, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if fl, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		<-fl.done
+		return fl.ld, fl.err
+	}
+	fl := &flight{done: make(chan struct{}), err: fmt.Errorf("Cannot analyze %s: analysis aborted", key)}
+	g.flights[key] = fl
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(fl.done)
+	}()
+	func() {
+		defer recoverPanic(&fl.err)
+		fl.ld, fl.err = f()
+	}()
+	return fl.ld, fl.err
+}
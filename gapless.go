@@ -0,0 +1,381 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lossy encoders prepend priming samples (encoder delay) and
+// append padding to fill the last frame. Decoders that don't
+// know about it, sox among them, output those samples too, so
+// everything measured is shifted against the original by the
+// delay, and the length is off by delay plus padding.
+
+// mp3DecoderDelay is the delay added by the MP3 decoder
+// itself, on top of what the LAME header reports
+const mp3DecoderDelay = 529
+
+// Gapless describes the priming and padding of a lossy file.
+type Gapless struct {
+	Source     string // where the values came from: "LAME" or "iTunSMPB"
+	SampleRate int
+	Delay      int    // samples to drop at the start
+	Padding    int    // samples to drop at the end
+	Samples    uint64 // length of the original, if recorded (0 otherwise)
+}
+
+// Offset returns the delay as a duration.
+func (g Gapless) Offset() time.Duration {
+	if g.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(g.Delay) * time.Second / time.Duration(g.SampleRate)
+}
+
+// ReadGapless reads the gapless information of an MP3 (LAME
+// header) or MP4/M4A (iTunSMPB tag) file. It reports false
+// if the file is of another type or has no such information.
+func ReadGapless(file string) (Gapless, bool, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return Gapless{}, false, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 12)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return Gapless{}, false, fmt.Errorf("Cannot read file: %v", err)
+	}
+
+	if len(head) >= 8 && string(head[4:8]) == "ftyp" {
+		return readITunSMPB(f)
+	}
+	return readLAME(f)
+}
+
+// readLAME finds the LAME extension of the Xing/Info header
+// in the first frame of an MP3 file.
+func readLAME(r io.ReadSeeker) (Gapless, bool, error) {
+	hdr := mp3FirstFrame(r)
+	if hdr == nil {
+		return Gapless{}, false, nil
+	}
+
+	version := (hdr[1] >> 3) & 3 // 3: MPEG1, 2: MPEG2, 0: MPEG2.5
+	rates := map[byte][3]int{
+		3: {44100, 48000, 32000},
+		2: {22050, 24000, 16000},
+		0: {11025, 12000, 8000},
+	}
+	rateIdx := (hdr[2] >> 2) & 3
+	r3, ok := rates[version]
+	if !ok || rateIdx == 3 {
+		return Gapless{}, false, nil
+	}
+	mono := hdr[3]>>6 == 3
+
+	sideInfo := 17
+	switch {
+	case version == 3 && !mono:
+		sideInfo = 32
+	case version != 3 && mono:
+		sideInfo = 9
+	}
+
+	if len(hdr) < 4+sideInfo+8 {
+		return Gapless{}, false, nil
+	}
+	x := hdr[4+sideInfo:]
+	if string(x[:4]) != "Xing" && string(x[:4]) != "Info" {
+		return Gapless{}, false, nil
+	}
+	flags := binary.BigEndian.Uint32(x[4:8])
+	off := 8
+	for _, f := range []struct {
+		bit  uint32
+		size int
+	}{{1, 4}, {2, 4}, {4, 100}, {8, 4}} { // frames, bytes, TOC, quality
+		if flags&f.bit != 0 {
+			off += f.size
+		}
+	}
+
+	// the LAME extension: 9 bytes encoder version, then after
+	// 12 more bytes, 12 bits each of delay and padding
+	lame := x[off:]
+	if len(lame) < 24 || !(bytes.HasPrefix(lame, []byte("LAME")) || bytes.HasPrefix(lame, []byte("Lavc"))) {
+		return Gapless{}, false, nil
+	}
+	dp := lame[21:24]
+	delay := int(dp[0])<<4 | int(dp[1])>>4
+	padding := int(dp[1]&0x0f)<<8 | int(dp[2])
+	if padding < mp3DecoderDelay {
+		padding = mp3DecoderDelay
+	}
+
+	return Gapless{
+		Source:     "LAME",
+		SampleRate: r3[rateIdx],
+		Delay:      delay + mp3DecoderDelay,
+		Padding:    padding - mp3DecoderDelay,
+	}, true, nil
+}
+
+// mp3FirstFrame returns the start of the first MPEG audio
+// frame of r, or nil if none is found.
+func mp3FirstFrame(r io.ReadSeeker) []byte {
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil
+	}
+
+	// skip any ID3v2 tag (size is syncsafe, footer optional)
+	start := int64(0)
+	if string(buf[:3]) == "ID3" {
+		size := int64(buf[6])<<21 | int64(buf[7])<<14 | int64(buf[8])<<7 | int64(buf[9])
+		start = 10 + size
+		if buf[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil
+	}
+
+	// the first frame holds the header, so it is small
+	frame := make([]byte, 4096)
+	n, _ := io.ReadFull(r, frame)
+	frame = frame[:n]
+
+	i := 0
+	for ; i+4 <= len(frame); i++ {
+		if frame[i] == 0xff && frame[i+1]&0xe0 == 0xe0 {
+			break
+		}
+	}
+	if i+4 > len(frame) {
+		return nil
+	}
+	return frame[i:]
+}
+
+// mp4Box is the header of an ISO base media box.
+type mp4Box struct {
+	typ        string
+	pos        int64 // of the header
+	start, end int64 // payload
+}
+
+// mp4Children lists the boxes within [start, end).
+func mp4Children(r io.ReadSeeker, start, end int64) []mp4Box {
+	var boxes []mp4Box
+	hdr := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			break
+		}
+		if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		payload := pos + 8
+		switch size {
+		case 0: // extends to the end
+			size = end - pos
+		case 1: // 64-bit size follows
+			if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+				return boxes
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			payload += 8
+		}
+		if size < payload-pos || pos+size > end {
+			break
+		}
+		boxes = append(boxes, mp4Box{typ: string(hdr[4:8]), pos: pos, start: payload, end: pos + size})
+		pos += size
+	}
+	return boxes
+}
+
+// mp4Find descends the path of box types from [start, end),
+// returning all matches.
+func mp4Find(r io.ReadSeeker, start, end int64, path ...string) []mp4Box {
+	var found []mp4Box
+	for _, b := range mp4Children(r, start, end) {
+		if b.typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			found = append(found, b)
+			continue
+		}
+		if b.typ == "meta" {
+			b.start += 4 // meta is a full box
+		}
+		found = append(found, mp4Find(r, b.start, b.end, path[1:]...)...)
+	}
+	return found
+}
+
+func readBox(r io.ReadSeeker, b mp4Box) []byte {
+	if b.end-b.start > 1<<16 {
+		return nil
+	}
+	buf := make([]byte, b.end-b.start)
+	if _, err := r.Seek(b.start, io.SeekStart); err != nil {
+		return nil
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil
+	}
+	return buf
+}
+
+// readITunSMPB reads the iTunes gapless tag of an MP4 file,
+// moov/udta/meta/ilst/----, whose value looks like
+// " 00000000 00000840 000001CA 00000000000E1A36 ...": delay,
+// padding and original length, in hex.
+func readITunSMPB(r io.ReadSeeker) (Gapless, bool, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Gapless{}, false, fmt.Errorf("Cannot read file: %v", err)
+	}
+
+	g := Gapless{Source: "iTunSMPB"}
+	for _, mdhd := range mp4Find(r, 0, end, "moov", "trak", "mdia", "mdhd") {
+		buf := readBox(r, mdhd)
+		switch {
+		case len(buf) >= 16 && buf[0] == 0:
+			g.SampleRate = int(binary.BigEndian.Uint32(buf[12:16]))
+		case len(buf) >= 24 && buf[0] == 1:
+			g.SampleRate = int(binary.BigEndian.Uint32(buf[20:24]))
+		}
+		if g.SampleRate != 0 {
+			break
+		}
+	}
+
+	for _, item := range mp4Find(r, 0, end, "moov", "udta", "meta", "ilst", "----") {
+		var name, value []byte
+		for _, b := range mp4Children(r, item.start, item.end) {
+			buf := readBox(r, b)
+			switch {
+			case b.typ == "name" && len(buf) >= 4:
+				name = buf[4:] // version and flags
+			case b.typ == "data" && len(buf) >= 8:
+				value = buf[8:] // type and locale
+			}
+		}
+		if string(name) != "iTunSMPB" {
+			continue
+		}
+
+		fields := strings.Fields(string(value))
+		if len(fields) < 4 {
+			return Gapless{}, false, fmt.Errorf("Cannot parse iTunSMPB %q", value)
+		}
+		delay, err1 := strconv.ParseUint(fields[1], 16, 32)
+		padding, err2 := strconv.ParseUint(fields[2], 16, 32)
+		samples, err3 := strconv.ParseUint(fields[3], 16, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return Gapless{}, false, fmt.Errorf("Cannot parse iTunSMPB %q", value)
+		}
+		g.Delay = int(delay)
+		g.Padding = int(padding)
+		g.Samples = samples
+		return g, true, nil
+	}
+	return Gapless{}, false, nil
+}
+
+// encodeArgs returns the ffmpeg arguments encoding in into
+// out with the codec (ffmpeg's default for the extension of
+// out if the encoder is empty), through the audio filter if
+// given, keeping its metadata and chapters. Whatever gapless
+// information the input has is used by ffmpeg to skip its
+// delay and padding while decoding; the output then gets
+// fresh gapless metadata for its own delay and padding, so a
+// re-encoded album still plays gaplessly: a LAME header in MP3
+// files, an edit list in MP4 files. Ogg formats carry it
+// intrinsically.
+func encodeArgs(in, out string, codec Codec, filter string) []string {
+	args := []string{
+		"-nostdin",
+		"-loglevel", "error",
+		"-i", ffmpegPath(in),
+		"-vn",
+		"-map_metadata", "0",
+		"-map_chapters", "0",
+	}
+	if filter != "" {
+		args = append(args, "-af", filter)
+	}
+	if codec.Encoder != "" {
+		args = append(args, "-c:a", codec.Encoder)
+	}
+	if codec.Bitrate != "" {
+		args = append(args, "-b:a", codec.Bitrate)
+	}
+	switch strings.ToLower(filepath.Ext(out)) {
+	case ".mp3":
+		args = append(args, "-write_xing", "1")
+	case ".m4a", ".mp4", ".m4b", ".aac":
+		args = append(args, "-use_editlist", "1")
+	}
+	return append(args, ffmpegPath(out))
+}
+
+// excludePadding corrects a length sox probed on a lossy file
+// that still has its delay and padding.
+func excludePadding(length uint64, opts Options, info *AnalysisInfo) uint64 {
+	g := info.Gapless
+	if g == nil || g.SampleRate == 0 || opts.KeepPadding || info.GaplessTrimmed {
+		return length
+	}
+	if g.Samples > 0 {
+		return g.Samples * 1000000 / uint64(g.SampleRate)
+	}
+	extra := uint64(g.Delay+g.Padding) * 1000000 / uint64(g.SampleRate)
+	if extra >= length {
+		return length
+	}
+	return length - extra
+}
+
+// LoudnessSample is a point of a loudness time series. At is
+// in media time: the position in the original audio, with
+// encoder delay accounted for, so it lines up with what
+// editors and players show.
+type LoudnessSample struct {
+	At        time.Duration
+	Momentary float32 // lufs
+	Shortterm float32 // lufs
+}
+
+// alignSeries shifts a series measured on the decoded signal
+// into media time, dropping the samples that fall into the
+// encoder delay.
+func alignSeries(series []LoudnessSample, offset time.Duration) []LoudnessSample {
+	if offset == 0 {
+		return series
+	}
+	aligned := make([]LoudnessSample, 0, len(series))
+	for _, s := range series {
+		s.At -= offset
+		if s.At >= 0 {
+			aligned = append(aligned, s)
+		}
+	}
+	return aligned
+}
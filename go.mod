@@ -0,0 +1,15 @@
+module github.com/burillo-se/bs1770wrap
+
+go 1.22
+
+require (
+	golang.org/x/net v0.28.0
+	golang.org/x/text v0.22.0
+	google.golang.org/grpc v1.67.3
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	golang.org/x/sys v0.28.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
+)
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package bs1770wrap
+
+import "os/exec"
+
+// process groups are not used on this platform
+func startGroup(cmd *exec.Cmd) {}
+
+func joinGroup(cmd *exec.Cmd) func() { return func() {} }
+
+// killGroup kills cmd, but not what it may have spawned.
+func killGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,103 @@
+//go:build unix || windows
+
+package bs1770wrap
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGroupHelper is not a test, but the tool of
+// TestKillGroup: run with BS1770WRAP_GROUP_HELPER set to a
+// file, it starts a copy of itself that sleeps, holding its
+// stderr, writes the copy's pid to the file, and sleeps as
+// well, or exits if BS1770WRAP_GROUP_HELPER_EXIT is set.
+func TestGroupHelper(t *testing.T) {
+	pidFile := os.Getenv("BS1770WRAP_GROUP_HELPER")
+	if pidFile == "" {
+		t.Skip("only run by TestKillGroup")
+	}
+	if pidFile != "child" {
+		child := exec.Command(os.Args[0], "-test.run=^TestGroupHelper$")
+		child.Env = append(os.Environ(), "BS1770WRAP_GROUP_HELPER=child")
+		child.Stderr = os.Stderr
+		if err := child.Start(); err != nil {
+			os.Exit(1)
+		}
+		os.WriteFile(pidFile+".tmp", []byte(strconv.Itoa(child.Process.Pid)), 0644)
+		os.Rename(pidFile+".tmp", pidFile)
+		if os.Getenv("BS1770WRAP_GROUP_HELPER_EXIT") != "" {
+			os.Exit(0)
+		}
+	}
+	time.Sleep(30 * time.Second)
+	os.Exit(0)
+}
+
+func TestKillGroup(t *testing.T) {
+	exe, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, how := range []string{"timeout", "context"} {
+		t.Run(how, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pid")
+			cmd := exec.Command(exe, "-test.run=^TestGroupHelper$")
+			cmd.Env = append(os.Environ(), "BS1770WRAP_GROUP_HELPER="+pidFile)
+
+			opts := Options{}
+			if how == "timeout" {
+				opts.Timeout = 2 * time.Second
+			} else {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				opts.Context = ctx
+				go func() {
+					waitFile(pidFile)
+					cancel()
+				}()
+			}
+			start := time.Now()
+			err := run(exe, cmd, opts, &AnalysisInfo{})
+			if err == nil {
+				t.Fatal("the tool was not killed")
+			}
+			if time.Since(start) > 20*time.Second {
+				t.Fatalf("the tool was killed after %v", time.Since(start))
+			}
+
+			if !waitFile(pidFile) {
+				t.Fatal("the tool started no child")
+			}
+			buf, _ := os.ReadFile(pidFile)
+			pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for alive(pid) {
+				if time.Now().After(deadline) {
+					t.Fatalf("the child %d of the tool outlived it", pid)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// waitFile waits a while for file to exist.
+func waitFile(file string) bool {
+	for i := 0; i < 1000; i++ {
+		if _, err := os.Stat(file); err == nil {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package bs1770wrap
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startGroup has cmd started in a process group of its own,
+// so that killGroup reaches whatever it spawns as well.
+func startGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// joinGroup is for platforms that group processes once they
+// are started; the group was set up by startGroup.
+func joinGroup(cmd *exec.Cmd) func() { return func() {} }
+
+// killGroup kills the process group of cmd.
+func killGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
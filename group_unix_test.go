@@ -0,0 +1,26 @@
+//go:build unix
+
+package bs1770wrap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// alive reports whether process pid is running. Zombies are
+// not: reaping the orphans killed is up to whichever process
+// adopted them.
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true // no procfs to tell zombies by
+	}
+	// "pid (comm) state ..."
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
@@ -0,0 +1,170 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Windows has no process groups to kill; each tool is put in
+// a job object instead, which whatever it spawns joins too.
+// The job kills its processes once closed, so nothing the
+// tool started outlives it, nor the package's process. A tool
+// that may have to be killed is started suspended and only
+// resumed once in its job, so that it spawns nothing outside.
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procThread32First            = kernel32.NewProc("Thread32First")
+	procThread32Next             = kernel32.NewProc("Thread32Next")
+	procOpenThread               = kernel32.NewProc("OpenThread")
+	procResumeThread             = kernel32.NewProc("ResumeThread")
+)
+
+const (
+	jobObjectExtendedLimitInformation = 9
+	jobObjectLimitKillOnJobClose      = 0x2000
+
+	processSetQuota  = 0x0100
+	processTerminate = 0x0001
+
+	createSuspended     = 0x00000004
+	threadSuspendResume = 0x0002
+)
+
+// JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+type jobLimits struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+
+	IoCounters            [6]uint64
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// THREADENTRY32
+type threadEntry struct {
+	Size           uint32
+	Usage          uint32
+	ThreadID       uint32
+	OwnerProcessID uint32
+	BasePri        int32
+	DeltaPri       int32
+	Flags          uint32
+}
+
+// the job of each running tool
+var jobs sync.Map // *exec.Cmd -> syscall.Handle
+
+// startGroup has cmd started suspended, for joinGroup to put
+// in its job before it runs.
+func startGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createSuspended
+}
+
+// joinGroup puts the started cmd in a job of its own, and
+// returns a function closing the job once cmd has exited,
+// which kills what is left of it. If startGroup had cmd
+// started suspended, it is resumed once in the job, or if it
+// cannot be, killed; otherwise processes spawned before cmd
+// joined get away.
+func joinGroup(cmd *exec.Cmd) func() {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.CreationFlags&createSuspended != 0 {
+		// in its job or not, the tool must run
+		defer func() {
+			if err := resumeProcess(uint32(cmd.Process.Pid)); err != nil {
+				cmd.Process.Kill()
+			}
+		}()
+	}
+
+	h, _, _ := procCreateJobObjectW.Call(0, 0)
+	if h == 0 {
+		return func() {}
+	}
+	job := syscall.Handle(h)
+
+	limits := jobLimits{LimitFlags: jobObjectLimitKillOnJobClose}
+	ok, _, _ := procSetInformationJobObject.Call(uintptr(job), jobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&limits)), unsafe.Sizeof(limits))
+	if ok != 0 {
+		var process syscall.Handle
+		process, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(cmd.Process.Pid))
+		if err == nil {
+			ok, _, _ = procAssignProcessToJobObject.Call(uintptr(job), uintptr(process))
+			syscall.CloseHandle(process)
+		}
+		if err != nil {
+			ok = 0
+		}
+	}
+	if ok == 0 {
+		syscall.CloseHandle(job)
+		return func() {}
+	}
+
+	jobs.Store(cmd, job)
+	return func() {
+		jobs.Delete(cmd)
+		syscall.CloseHandle(job)
+	}
+}
+
+// killGroup kills cmd and whatever it spawned.
+func killGroup(cmd *exec.Cmd) {
+	if job, ok := jobs.Load(cmd); ok {
+		procTerminateJobObject.Call(uintptr(job.(syscall.Handle)), 1)
+		return
+	}
+	cmd.Process.Kill()
+}
+
+// resumeProcess resumes the threads of the process pid,
+// started suspended with its main thread only.
+func resumeProcess(pid uint32) error {
+	snap, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(snap)
+
+	resumed := false
+	t := threadEntry{Size: uint32(unsafe.Sizeof(threadEntry{}))}
+	ok, _, err := procThread32First.Call(uintptr(snap), uintptr(unsafe.Pointer(&t)))
+	for ; ok != 0; ok, _, err = procThread32Next.Call(uintptr(snap), uintptr(unsafe.Pointer(&t))) {
+		if t.OwnerProcessID != pid {
+			continue
+		}
+		h, _, err := procOpenThread.Call(threadSuspendResume, 0, uintptr(t.ThreadID))
+		if h == 0 {
+			return err
+		}
+		n, _, err := procResumeThread.Call(h)
+		syscall.CloseHandle(syscall.Handle(h))
+		if int32(n) == -1 {
+			return err
+		}
+		resumed = true
+	}
+	if !resumed {
+		return fmt.Errorf("no thread of process %d to resume: %v", pid, err)
+	}
+	return nil
+}
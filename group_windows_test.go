@@ -0,0 +1,15 @@
+package bs1770wrap
+
+import "syscall"
+
+// alive reports whether process pid is running.
+func alive(pid int) bool {
+	const stillActive = 259
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	return syscall.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
@@ -0,0 +1,292 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Album is a group of files to be measured as an album, in
+// playing order.
+type Album struct {
+	Key   string // identifies the album among the groups
+	Files []string
+}
+
+// AlbumGrouper sorts the files of a library into albums, for
+// album gain. Implementations may group by whatever the
+// library is organized by; DirectoryAlbums and TagAlbums
+// cover the usual cases, WorkAlbums classical music.
+type AlbumGrouper interface {
+	GroupAlbums(files []string, opts Options) ([]Album, error)
+}
+
+// disc subdirectories, which belong to the album above them
+var discDirRegex = regexp.MustCompile(`(?i)^(cd|dis[ck])\s*[-_]?\s*\d+$`)
+
+// albumDir returns the directory an album of file is in,
+// treating disc subdirectories ("CD1", "Disc 2") as part of
+// the album above them.
+func albumDir(file string) string {
+	dir := filepath.Dir(file)
+	if discDirRegex.MatchString(filepath.Base(dir)) {
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+// DirectoryAlbums groups files by the directory they are in,
+// disc subdirectories included, ordered by path.
+type DirectoryAlbums struct{}
+
+// GroupAlbums implements AlbumGrouper.
+func (DirectoryAlbums) GroupAlbums(files []string, opts Options) ([]Album, error) {
+	return groupBy(files, func(file string) (string, albumOrder, error) {
+		return albumDir(file), albumOrder{}, nil
+	})
+}
+
+// TagAlbums groups files by their album artist and album tags,
+// read with ffprobe, and orders them by disc and track number.
+// Files with an album but no album artist are grouped by album
+// within their directory, so that albums of the same name by
+// different artists stay apart; files without an album tag are
+// grouped like DirectoryAlbums does.
+type TagAlbums struct{}
+
+// GroupAlbums implements AlbumGrouper.
+func (TagAlbums) GroupAlbums(files []string, opts Options) ([]Album, error) {
+	return groupBy(files, func(file string) (string, albumOrder, error) {
+		tags, err := probeTags(file, opts)
+		if err != nil {
+			return "", albumOrder{}, err
+		}
+		return tagAlbumKey(file, tags), tagOrder(tags), nil
+	})
+}
+
+// tagAlbumKey is the key TagAlbums groups file by.
+func tagAlbumKey(file string, tags map[string]string) string {
+	switch album, artist := tags["album"], tags["album_artist"]; {
+	case album != "" && artist != "":
+		return "tag:" + artist + "\x00" + album
+	case album != "":
+		return "tag:" + albumDir(file) + "\x00" + album
+	}
+	return albumDir(file)
+}
+
+// tagOrder orders files by disc and track number.
+func tagOrder(tags map[string]string) albumOrder {
+	return albumOrder{disc: tagNumber(tags["disc"]), track: tagNumber(tags["track"])}
+}
+
+// WorkAlbums is TagAlbums for classical music: files with a
+// work tag are grouped by work within their album, and
+// ordered by movement number, so that each work of a disc
+// gets a gain of its own rather than sharing one with the
+// others. The work is read from "work", as Vorbis comments
+// and MP4 have it, or failing that "grouping", the ID3 content
+// group (TIT1) players use for it. Files without one are
+// grouped like TagAlbums does.
+type WorkAlbums struct{}
+
+// GroupAlbums implements AlbumGrouper.
+func (WorkAlbums) GroupAlbums(files []string, opts Options) ([]Album, error) {
+	return groupBy(files, func(file string) (string, albumOrder, error) {
+		tags, err := probeTags(file, opts)
+		if err != nil {
+			return "", albumOrder{}, err
+		}
+		work := tags["work"]
+		if work == "" {
+			work = tags["grouping"]
+		}
+		if work == "" {
+			return tagAlbumKey(file, tags), tagOrder(tags), nil
+		}
+
+		order := tagOrder(tags)
+		if n := tagNumber(tags["movement"]); n > 0 {
+			order = albumOrder{track: n}
+		}
+		return "work:" + tagAlbumKey(file, tags) + "\x00" + work, order, nil
+	})
+}
+
+// albumOrder is where a file goes within its album.
+type albumOrder struct {
+	disc, track int
+}
+
+// groupBy groups files by the key f assigns them, in the
+// order f assigns, then by path. Albums are ordered by key.
+func groupBy(files []string, f func(string) (string, albumOrder, error)) ([]Album, error) {
+	type entry struct {
+		file  string
+		order albumOrder
+	}
+	groups := make(map[string][]entry)
+	for _, file := range files {
+		key, order, err := f(file)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot group %s into an album: %w", file, err)
+		}
+		groups[key] = append(groups[key], entry{file, order})
+	}
+
+	albums := make([]Album, 0, len(groups))
+	for key, entries := range groups {
+		sort.SliceStable(entries, func(i, j int) bool {
+			a, b := entries[i], entries[j]
+			if a.order.disc != b.order.disc {
+				return a.order.disc < b.order.disc
+			}
+			if a.order.track != b.order.track {
+				return a.order.track < b.order.track
+			}
+			return a.file < b.file
+		})
+		album := Album{Key: key}
+		for _, e := range entries {
+			album.Files = append(album.Files, e.file)
+		}
+		albums = append(albums, album)
+	}
+	sort.Slice(albums, func(i, j int) bool { return albums[i].Key < albums[j].Key })
+	return albums, nil
+}
+
+// tagNumber parses a track or disc number such as "3" or
+// "3/12", returning 0 if there is none.
+func tagNumber(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(strings.SplitN(s, "/", 2)[0]))
+	return n
+}
+
+// probeTags reads the container and first audio stream tags
+// of file with ffprobe, which names the common ones the same
+// whatever the format. Keys are lowercased.
+func probeTags(file string, opts Options) (map[string]string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "format_tags:stream_tags",
+		"-of", "json",
+		ffmpegPath(file),
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := run("ffprobe", cmd, opts, &AnalysisInfo{})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read tags: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("Cannot parse tags: %v", err)
+	}
+
+	// Ogg files keep their tags on the stream
+	tags := make(map[string]string)
+	for _, s := range out.Streams {
+		for k, v := range s.Tags {
+			tags[strings.ToLower(k)] = v
+		}
+	}
+	for k, v := range out.Format.Tags {
+		tags[strings.ToLower(k)] = v
+	}
+	if v, ok := tags["albumartist"]; ok && tags["album_artist"] == "" {
+		tags["album_artist"] = v
+	}
+	if v, ok := tags["tracknumber"]; ok && tags["track"] == "" {
+		tags["track"] = v
+	}
+	if v, ok := tags["discnumber"]; ok && tags["disc"] == "" {
+		tags["disc"] = v
+	}
+	for _, k := range []string{"movementnumber", "mvin", "movement_number"} {
+		if v, ok := tags[k]; ok && tags["movement"] == "" {
+			tags["movement"] = v
+		}
+	}
+	return tags, nil
+}
+
+// ScannedAlbum is the outcome of analyzing one album of
+// ScanAlbums.
+type ScannedAlbum struct {
+	Album
+	Loudness AlbumLoudness
+	Err      error
+	Aborted  AbortReason // see ScanResult
+}
+
+// ScanAlbums groups files into albums with grouper, TagAlbums
+// if nil, and analyzes the albums on a pool of workers
+// goroutines, runtime.NumCPU() if workers is not positive, as
+// CalculateAlbumLoudnessWithOptions does. The albums are
+// returned in the order the grouper gives them. The Cache is
+// not used, album values depending on every track. Files
+// that are no audio are left out of the albums, as Scan skips
+// them, unless NoSkip is set.
+func (s *Scanner) ScanAlbums(files []string, grouper AlbumGrouper, workers int) ([]ScannedAlbum, error) {
+	if grouper == nil {
+		grouper = TagAlbums{}
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	if !s.NoSkip {
+		audio := make([]string, 0, len(files))
+		for _, file := range files {
+			if _, skip := ClassifySkip(file); !skip {
+				audio = append(audio, file)
+			}
+		}
+		files = audio
+	}
+	albums, err := grouper.GroupAlbums(files, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ScannedAlbum, len(albums))
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				a, err := CalculateAlbumLoudnessWithOptions(albums[i].Files, s.Options)
+				results[i] = ScannedAlbum{Album: albums[i], Loudness: a, Err: err, Aborted: AbortReasonOf(err)}
+			}
+		}()
+	}
+	for i := range albums {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results, nil
+}
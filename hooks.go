@@ -0,0 +1,177 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Stage is a stage of the pipeline hooks run around.
+type Stage string
+
+// Stages hooks are run around.
+const (
+	StageProbe     Stage = "probe"     // looking at the input ahead of analysis
+	StageAnalyze   Stage = "analyze"   // measuring the loudness
+	StageTag       Stage = "tag"       // writing tags, see WriteTags
+	StageNormalize Stage = "normalize" // writing a normalized copy, see Normalize
+)
+
+// HookEvent is what a hook is called with, before a stage
+// and after it. Hooks may change what the stage works with:
+// the tags to write before StageTag, the output file before
+// StageNormalize, and the loudness after StageAnalyze.
+type HookEvent struct {
+	Stage Stage
+	Post  bool // after the stage rather than before
+	File  string
+
+	Info     *AnalysisInfo
+	Loudness *LoudnessData     // the measurement, once there is one
+	Tags     map[string]string // StageTag: the tags to write
+	Output   string            // StageNormalize: the file to write
+	Gain     float64           // StageNormalize: dB applied, after
+
+	Err error // after: what the stage failed with, if it did
+}
+
+// Hook is called around the stages of the pipeline, for site
+// specific logic such as extra validation. Hooks are run in
+// the order of Options.Hooks. An error before a stage stops
+// it, and an error after one is returned in its place.
+type Hook interface {
+	Run(e *HookEvent) error
+}
+
+// HookFunc adapts a function to a Hook.
+type HookFunc func(e *HookEvent) error
+
+// Run implements Hook.
+func (f HookFunc) Run(e *HookEvent) error {
+	return f(e)
+}
+
+// CommandHook runs an external command as a hook. The event
+// is written to its stdin as a JSON object with the stage,
+// "when" ("pre" or "post"), file, loudness (as the progress
+// protocol has it), tags, output, gain and error, and is in
+// BS1770WRAP_STAGE, BS1770WRAP_WHEN and BS1770WRAP_FILE too.
+// The command may print a JSON object with tags, output or
+// loudness to change them when a hook may. A non-zero exit
+// status fails the hook, with what it printed to stderr.
+type CommandHook struct {
+	Command string
+	Args    []string
+
+	// Stages the command runs around; all of them if empty.
+	Stages []Stage
+
+	// Options run the command like the tools; see Runner.
+	Options Options
+}
+
+type hookJSON struct {
+	Stage    Stage             `json:"stage"`
+	When     string            `json:"when"`
+	File     string            `json:"file"`
+	Loudness *ProgressResult   `json:"loudness,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Output   string            `json:"output,omitempty"`
+	Gain     *float64          `json:"gain,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Run implements Hook.
+func (h CommandHook) Run(e *HookEvent) error {
+	if len(h.Stages) > 0 {
+		found := false
+		for _, s := range h.Stages {
+			found = found || s == e.Stage
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	in := hookJSON{Stage: e.Stage, When: "pre", File: e.File, Tags: e.Tags, Output: e.Output}
+	if e.Post {
+		in.When = "post"
+	}
+	if e.Loudness != nil {
+		backend := ""
+		if e.Info != nil {
+			backend = e.Info.Backend
+		}
+		in.Loudness = progressResult(*e.Loudness, backend)
+	}
+	if e.Stage == StageNormalize && e.Post {
+		in.Gain = &e.Gain
+	}
+	if e.Err != nil {
+		in.Error = e.Err.Error()
+	}
+	buf, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize hook event: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(h.Command, h.Args...)
+	cmd.Stdin = bytes.NewReader(buf)
+	cmd.Stdout = &stdout
+	cmd.Env = append(os.Environ(),
+		"BS1770WRAP_STAGE="+string(e.Stage),
+		"BS1770WRAP_WHEN="+in.When,
+		"BS1770WRAP_FILE="+e.File,
+	)
+	info := e.Info
+	if info == nil {
+		info = &AnalysisInfo{}
+	}
+	err = run(h.Command, cmd, h.Options, info)
+	if err != nil {
+		return fmt.Errorf("Hook %s failed: %w: %s", h.Command, err, strings.TrimSpace(ToolStderr(err)))
+	}
+
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return nil
+	}
+	var out hookJSON
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return fmt.Errorf("Cannot parse output of hook %s: %v", h.Command, err)
+	}
+	switch {
+	case e.Stage == StageTag && !e.Post && out.Tags != nil:
+		e.Tags = out.Tags
+	case e.Stage == StageNormalize && !e.Post && out.Output != "":
+		e.Output = out.Output
+	case e.Stage == StageAnalyze && e.Post && out.Loudness != nil && e.Loudness != nil:
+		*e.Loudness = out.Loudness.loudness()
+	}
+	return nil
+}
+
+// withHooks runs stage between the hooks of opts, before and
+// after it, and returns the error of the stage or hooks.
+func withHooks(opts Options, e *HookEvent, stage func(e *HookEvent) error) error {
+	if len(opts.Hooks) == 0 {
+		return stage(e)
+	}
+	for _, h := range opts.Hooks {
+		if err := h.Run(e); err != nil {
+			return fmt.Errorf("Stopped before %s by hook: %w", e.Stage, err)
+		}
+	}
+	e.Err = stage(e)
+	e.Post = true
+	for _, h := range opts.Hooks {
+		if err := h.Run(e); err != nil {
+			return fmt.Errorf("Rejected after %s by hook: %w", e.Stage, err)
+		}
+	}
+	return e.Err
+}
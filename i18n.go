@@ -0,0 +1,107 @@
+package bs1770wrap
+
+import (
+	"fmt"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// Languages are those compliance and advisory messages are
+// translated into. Messages for any other language fall back
+// to English.
+var Languages = []language.Tag{
+	language.English,
+	language.German,
+	language.French,
+	language.Japanese,
+}
+
+// Message keys are the English format strings.
+const (
+	msgClipping       = "true peak of %s dBTP indicates inter-sample clipping; consider limiting"
+	msgNormalizedPeak = "true peak exceeds %d dBTP after normalization to %s LUFS (%s dBTP); consider limiting"
+	msgLowRange       = "LRA of %s LU is below %d LU, which suggests over-compression"
+	msgHighRange      = "LRA of %s LU is above %d LU; quiet passages may get lost in noisy environments"
+	msgTooLoud        = "integrated loudness of %s LUFS will be turned down %s dB for playback; the extra loudness buys nothing"
+	msgIntegrated     = "integrated loudness %s LUFS is outside %s ±%s LU"
+	msgPeak           = "true peak %s dBTP exceeds %s dBTP"
+	msgRMS            = "RMS level %s dBFS is outside %s to %s dBFS"
+	msgSamplePeak     = "peak %s dBFS exceeds %s dBFS"
+	msgNoiseFloor     = "noise floor %s dBFS exceeds %s dBFS"
+)
+
+var translations = map[language.Tag]map[string]string{
+	language.German: {
+		msgClipping:       "True Peak von %s dBTP deutet auf Intersample-Übersteuerung hin; Limiter empfohlen",
+		msgNormalizedPeak: "True Peak überschreitet %d dBTP nach Normalisierung auf %s LUFS (%s dBTP); Limiter empfohlen",
+		msgLowRange:       "LRA von %s LU liegt unter %d LU, was auf Überkompression hindeutet",
+		msgHighRange:      "LRA von %s LU liegt über %d LU; leise Passagen können in lauter Umgebung untergehen",
+		msgTooLoud:        "Integrierte Lautheit von %s LUFS wird bei der Wiedergabe um %s dB abgesenkt; die zusätzliche Lautheit bringt nichts",
+		msgIntegrated:     "Integrierte Lautheit %s LUFS liegt außerhalb von %s ±%s LU",
+		msgPeak:           "True Peak %s dBTP überschreitet %s dBTP",
+		msgRMS:            "RMS-Pegel %s dBFS liegt außerhalb von %s bis %s dBFS",
+		msgSamplePeak:     "Spitzenpegel %s dBFS überschreitet %s dBFS",
+		msgNoiseFloor:     "Grundrauschen %s dBFS überschreitet %s dBFS",
+	},
+	language.French: {
+		msgClipping:       "un true peak de %s dBTP indique un écrêtage inter-échantillons ; envisagez un limiteur",
+		msgNormalizedPeak: "le true peak dépasse %d dBTP après normalisation à %s LUFS (%s dBTP) ; envisagez un limiteur",
+		msgLowRange:       "une LRA de %s LU, inférieure à %d LU, suggère une compression excessive",
+		msgHighRange:      "une LRA de %s LU dépasse %d LU ; les passages calmes risquent de se perdre dans un environnement bruyant",
+		msgTooLoud:        "la sonie intégrée de %s LUFS sera réduite de %s dB à la lecture ; ce surplus de sonie n'apporte rien",
+		msgIntegrated:     "la sonie intégrée de %s LUFS est hors de %s ±%s LU",
+		msgPeak:           "le true peak de %s dBTP dépasse %s dBTP",
+		msgRMS:            "le niveau RMS de %s dBFS est hors de %s à %s dBFS",
+		msgSamplePeak:     "la crête de %s dBFS dépasse %s dBFS",
+		msgNoiseFloor:     "le bruit de fond de %s dBFS dépasse %s dBFS",
+	},
+	language.Japanese: {
+		msgClipping:       "トゥルーピーク %s dBTP はインターサンプルクリッピングを示しています。リミッターの使用を検討してください",
+		msgNormalizedPeak: "ノーマライズ後のトゥルーピークが %d dBTP を超えます（目標 %s LUFS、%s dBTP）。リミッターの使用を検討してください",
+		msgLowRange:       "LRA %s LU は %d LU 未満で、過剰なコンプレッションが疑われます",
+		msgHighRange:      "LRA %s LU は %d LU を超えています。静かな部分は騒がしい環境で聞こえにくくなる可能性があります",
+		msgTooLoud:        "統合ラウドネス %s LUFS は再生時に %s dB 下げられます。余分なラウドネスは効果がありません",
+		msgIntegrated:     "統合ラウドネス %s LUFS は %s ±%s LU の範囲外です",
+		msgPeak:           "トゥルーピーク %s dBTP が %s dBTP を超えています",
+		msgRMS:            "RMS レベル %s dBFS は %s～%s dBFS の範囲外です",
+		msgSamplePeak:     "ピーク %s dBFS が %s dBFS を超えています",
+		msgNoiseFloor:     "ノイズフロア %s dBFS が %s dBFS を超えています",
+	},
+}
+
+var messages = newCatalog()
+
+func newCatalog() catalog.Catalog {
+	b := catalog.NewBuilder(catalog.Fallback(language.English))
+	for tag, msgs := range translations {
+		for key, msg := range msgs {
+			// keys and messages are constants, this cannot fail
+			b.SetString(tag, key, msg)
+		}
+	}
+	return b
+}
+
+// localizable is a message that can be rendered in any of
+// the Languages.
+type localizable struct {
+	key  string
+	args []interface{}
+}
+
+func newLocalizable(key string, args ...interface{}) localizable {
+	return localizable{key: key, args: args}
+}
+
+func (l localizable) english() string {
+	return fmt.Sprintf(l.key, l.args...)
+}
+
+func (l localizable) in(tag language.Tag) string {
+	if l.key == "" {
+		return ""
+	}
+	return message.NewPrinter(tag, message.Catalog(messages)).Sprintf(l.key, l.args...)
+}
@@ -0,0 +1,289 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3v2 tags are a 10 byte header ("ID3", version, revision,
+// flags, syncsafe size) followed by frames of a 10 byte
+// header (id, size, flags) and payload, then padding, zeros.
+// Frame sizes are syncsafe in v2.4, plain in v2.3. A v2.4 tag
+// may end in a footer, a copy of the header starting "3DI",
+// and then has no padding. Only user-defined text (TXXX)
+// frames are interpreted; others are kept as they are.
+
+// id3Padding is the padding of tags written anew, or grown out
+// of theirs, so that they can be rewritten in place later.
+const id3Padding = 1024
+
+// id3 header flags
+const (
+	id3Unsync   = 0x80
+	id3Extended = 0x40
+	id3Footer   = 0x10
+)
+
+// id3Tag is an ID3v2.3 or v2.4 tag.
+type id3Tag struct {
+	version byte
+	flags   byte
+	size    int // as read, header and footer included
+	frames  []id3Frame
+}
+
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// newID3Tag returns an empty ID3v2.4 tag.
+func newID3Tag() id3Tag {
+	return id3Tag{version: 4}
+}
+
+// parseID3 parses an ID3v2 tag. Unsynchronised tags and
+// extended headers are rare enough not to be supported.
+func parseID3(buf []byte) (id3Tag, error) {
+	if len(buf) < 10 || string(buf[:3]) != "ID3" {
+		return id3Tag{}, fmt.Errorf("Cannot parse ID3 tag: no header")
+	}
+	t := id3Tag{version: buf[3], flags: buf[5]}
+	if t.version != 3 && t.version != 4 {
+		return id3Tag{}, fmt.Errorf("Cannot parse ID3 tag: version 2.%d is not supported", t.version)
+	}
+	if t.flags&(id3Unsync|id3Extended) != 0 {
+		return id3Tag{}, fmt.Errorf("Cannot parse ID3 tag: unsynchronisation and extended headers are not supported")
+	}
+	end := 10 + unsyncsafe(buf[6:10])
+	t.size = id3Size(buf)
+	if t.size > len(buf) {
+		return id3Tag{}, fmt.Errorf("Cannot parse ID3 tag: truncated")
+	}
+
+	for pos := 10; pos+10 <= end && buf[pos] != 0; { // padding is zeros
+		f := id3Frame{id: string(buf[pos : pos+4])}
+		size := int(binary.BigEndian.Uint32(buf[pos+4:]))
+		if t.version == 4 {
+			size = unsyncsafe(buf[pos+4 : pos+8])
+		}
+		copy(f.flags[:], buf[pos+8:pos+10])
+		pos += 10
+		if pos+size > end {
+			return id3Tag{}, fmt.Errorf("Cannot parse ID3 tag: truncated %s frame", f.id)
+		}
+		f.data = buf[pos : pos+size]
+		t.frames = append(t.frames, f)
+		pos += size
+	}
+	return t, nil
+}
+
+// encode returns the tag with its flags. It keeps the size it
+// was read with if the frames fit, padding them, or else is
+// padded with id3Padding; with a footer, it is not padded.
+func (t id3Tag) encode() []byte {
+	var frames []byte
+	for _, f := range t.frames {
+		frames = append(frames, f.id...)
+		if t.version == 4 {
+			frames = append(frames, syncsafe(len(f.data))...)
+		} else {
+			frames = binary.BigEndian.AppendUint32(frames, uint32(len(f.data)))
+		}
+		frames = append(frames, f.flags[:]...)
+		frames = append(frames, f.data...)
+	}
+
+	footer := t.version == 4 && t.flags&id3Footer != 0
+	size := len(frames)
+	if !footer {
+		if pad := t.size - 10 - size; pad >= 0 {
+			size += pad
+		} else {
+			size += id3Padding
+		}
+	}
+
+	hdr := append([]byte{t.version, 0, t.flags}, syncsafe(size)...)
+	buf := append([]byte("ID3"), hdr...)
+	buf = append(buf, frames...)
+	buf = append(buf, make([]byte, size-len(frames))...)
+	if footer {
+		buf = append(append(buf, "3DI"...), hdr...)
+	}
+	return buf
+}
+
+// userText returns the values of the TXXX frames by
+// description.
+func (t id3Tag) userText() map[string]string {
+	m := make(map[string]string)
+	for _, f := range t.frames {
+		// compression and encryption are flagged in the second
+		// byte, in either version
+		if f.id != "TXXX" || len(f.data) < 1 || f.flags[1] != 0 {
+			continue
+		}
+		s := id3Strings(f.data[0], f.data[1:])
+		if len(s) >= 2 {
+			m[s[0]] = s[1]
+		}
+	}
+	return m
+}
+
+// setUserText replaces the TXXX frames with the description,
+// matched case-insensitively, by one with the value. An empty
+// value removes them.
+func (t *id3Tag) setUserText(desc, value string) {
+	var frames []id3Frame
+	for _, f := range t.frames {
+		if f.id == "TXXX" && len(f.data) > 0 {
+			if s := id3Strings(f.data[0], f.data[1:]); len(s) > 0 && strings.EqualFold(s[0], desc) {
+				continue
+			}
+		}
+		frames = append(frames, f)
+	}
+	if value != "" {
+		frames = append(frames, id3Frame{id: "TXXX", data: t.encodeStrings(desc, value)})
+	}
+	t.frames = frames
+}
+
+// encodeStrings encodes NUL-terminated strings, as UTF-8 in
+// v2.4 and as UTF-16 in v2.3, which knows no UTF-8.
+func (t id3Tag) encodeStrings(s ...string) []byte {
+	if t.version == 4 {
+		buf := []byte{3}
+		for _, v := range s {
+			buf = append(append(buf, v...), 0)
+		}
+		return buf
+	}
+	buf := []byte{1}
+	for _, v := range s {
+		buf = append(buf, 0xff, 0xfe) // little-endian BOM
+		for _, u := range utf16.Encode([]rune(v)) {
+			buf = binary.LittleEndian.AppendUint16(buf, u)
+		}
+		buf = append(buf, 0, 0)
+	}
+	return buf
+}
+
+// id3Strings decodes the NUL-separated strings of a text
+// frame in the given encoding: ISO-8859-1, UTF-16 with BOM,
+// UTF-16BE or UTF-8.
+func id3Strings(enc byte, buf []byte) []string {
+	var s []string
+	switch enc {
+	case 0, 3:
+		for _, b := range bytes.Split(bytes.TrimRight(buf, "\x00"), []byte{0}) {
+			if enc == 3 {
+				s = append(s, string(b))
+				continue
+			}
+			r := make([]rune, len(b))
+			for i, c := range b {
+				r[i] = rune(c)
+			}
+			s = append(s, string(r))
+		}
+	case 1, 2:
+		var u []uint16
+		var order binary.ByteOrder = binary.BigEndian
+		for i := 0; i+1 < len(buf); i += 2 {
+			c := order.Uint16(buf[i:])
+			switch {
+			case enc == 1 && len(u) == 0 && (c == 0xfeff || c == 0xfffe):
+				if c == 0xfffe {
+					order = binary.LittleEndian
+				}
+			case c == 0:
+				s = append(s, string(utf16.Decode(u)))
+				u = u[:0]
+				order = binary.BigEndian
+			default:
+				u = append(u, c)
+			}
+		}
+		if len(u) > 0 {
+			s = append(s, string(utf16.Decode(u)))
+		}
+	}
+	return s
+}
+
+// id3Size returns the size, footer included, of the ID3v2 tag
+// whose header is hdr, or zero if hdr is not one.
+func id3Size(hdr []byte) int {
+	if len(hdr) < 10 || string(hdr[:3]) != "ID3" {
+		return 0
+	}
+	n := 10 + unsyncsafe(hdr[6:10])
+	if hdr[3] == 4 && hdr[5]&id3Footer != 0 {
+		n += 10
+	}
+	return n
+}
+
+// readID3File reads the ID3v2 tag an MP3 file starts with and
+// returns it with its size, or an empty tag and zero if there
+// is none.
+func readID3File(file string) (id3Tag, int64, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return id3Tag{}, 0, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	hdr := make([]byte, 10)
+	if _, err := io.ReadFull(f, hdr); err != nil || id3Size(hdr) == 0 {
+		return newID3Tag(), 0, nil
+	}
+	buf := make([]byte, id3Size(hdr))
+	copy(buf, hdr)
+	if _, err := io.ReadFull(f, buf[10:]); err != nil {
+		return id3Tag{}, 0, fmt.Errorf("Cannot parse ID3 tag: truncated")
+	}
+	t, err := parseID3(buf)
+	return t, int64(len(buf)), err
+}
+
+// readID3Tags reads the tags of an MP3 file.
+func readID3Tags(file string) (map[string]string, error) {
+	t, _, err := readID3File(file)
+	if err != nil {
+		return nil, err
+	}
+	return t.userText(), nil
+}
+
+// writeID3Tags writes src with its ID3v2 tag, which is added
+// if missing, updated to dst.
+func writeID3Tags(src, dst string, tags map[string]string) error {
+	t, size, err := readID3File(src)
+	if err != nil {
+		return err
+	}
+	for k, v := range tags {
+		t.setUserText(k, v)
+	}
+	return replaceRange(src, dst, 0, size, t.encode())
+}
+
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+func unsyncsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
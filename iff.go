@@ -0,0 +1,264 @@
+package bs1770wrap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// WAV (RIFF), AIFF (IFF) and CAF files are sequences of
+// chunks: a four character type, a size and a payload. RIFF
+// is little-endian, AIFF big-endian, and both pad payloads to
+// an even size within a top-level chunk of at most 4 GiB; CAF
+// has 64-bit sizes, no padding and no top-level chunk. Their
+// metadata is rewritten by copying the chunks over, replacing
+// or adding the metadata chunk.
+
+// chunk is a chunk of a file, or, if data is set, one to be
+// written.
+type chunk struct {
+	id    string
+	start int64 // payload
+	size  int64
+	data  []byte
+}
+
+// chunkFile is the layout of a chunked file.
+type chunkFile struct {
+	magic    string // "RIFF", "FORM" or "caff"
+	formType string // "WAVE", "AIFF" or "AIFC"; empty for CAF
+	chunks   []chunk
+}
+
+func (cf chunkFile) order() interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+} {
+	if cf.magic == "RIFF" {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func (cf chunkFile) padded() bool {
+	return cf.magic != "caff"
+}
+
+// readChunks lists the chunks of a WAV, AIFF or CAF file.
+// RF64 files, whose sizes don't fit the header, are not
+// supported.
+func readChunks(r io.ReadSeeker) (chunkFile, error) {
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return chunkFile{}, fmt.Errorf("Unknown file format")
+	}
+	cf := chunkFile{magic: string(hdr[:4])}
+	pos := int64(12)
+	switch {
+	case cf.magic == "RIFF" && string(hdr[8:]) == "WAVE",
+		cf.magic == "FORM" && (string(hdr[8:]) == "AIFF" || string(hdr[8:]) == "AIFC"):
+		cf.formType = string(hdr[8:])
+	case cf.magic == "caff":
+		pos = 8 // version and flags
+	default:
+		return chunkFile{}, fmt.Errorf("Unknown file format")
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return chunkFile{}, fmt.Errorf("Cannot read file: %v", err)
+	}
+	headerSize := int64(8)
+	if !cf.padded() {
+		headerSize = 12
+	}
+	for pos+headerSize <= end {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return chunkFile{}, fmt.Errorf("Cannot read file: %v", err)
+		}
+		if _, err := io.ReadFull(r, hdr[:headerSize]); err != nil {
+			return chunkFile{}, fmt.Errorf("Cannot read file: %v", err)
+		}
+		c := chunk{id: string(hdr[:4]), start: pos + headerSize}
+		if cf.padded() {
+			c.size = int64(cf.order().Uint32(hdr[4:8]))
+		} else {
+			c.size = int64(binary.BigEndian.Uint64(hdr[4:12]))
+			if c.size == -1 && c.id == "data" { // extends to the end
+				c.size = end - c.start
+			}
+		}
+		if c.size < 0 || c.start+c.size > end {
+			return chunkFile{}, fmt.Errorf("Truncated %q chunk", c.id)
+		}
+		cf.chunks = append(cf.chunks, c)
+		pos = c.start + c.size
+		if cf.padded() {
+			pos += c.size & 1
+		}
+	}
+	return cf, nil
+}
+
+// find returns the first chunk of the given type. Types are
+// compared case-insensitively, as AIFF writers disagree on
+// "ID3 " and "id3 ".
+func (cf chunkFile) find(id string) (chunk, bool) {
+	for _, c := range cf.chunks {
+		if strings.EqualFold(c.id, id) {
+			return c, true
+		}
+	}
+	return chunk{}, false
+}
+
+// replace puts c in place of the chunks of its type, where
+// the first of them was or right before the first chunk of
+// type before, whichever comes first, or else at the end.
+func (cf *chunkFile) replace(c chunk, before string) {
+	var chunks []chunk
+	placed := false
+	for _, old := range cf.chunks {
+		if !placed && (strings.EqualFold(old.id, c.id) || old.id == before) {
+			chunks = append(chunks, c)
+			placed = true
+		}
+		if !strings.EqualFold(old.id, c.id) {
+			chunks = append(chunks, old)
+		}
+	}
+	if !placed {
+		chunks = append(chunks, c)
+	}
+	cf.chunks = chunks
+}
+
+// write writes the file, taking the payloads of existing
+// chunks from in.
+func (cf chunkFile) write(w io.Writer, in io.ReadSeeker) error {
+	order := cf.order()
+	var hdr []byte
+	if cf.padded() {
+		size := int64(4) // form type
+		for _, c := range cf.chunks {
+			size += 8 + c.size + c.size&1
+		}
+		if size > math.MaxUint32 {
+			return fmt.Errorf("file would exceed 4 GiB")
+		}
+		hdr = append([]byte(cf.magic), order.AppendUint32(nil, uint32(size))...)
+		hdr = append(hdr, cf.formType...)
+	} else {
+		hdr = append([]byte(cf.magic), 0, 1, 0, 0) // version 1, no flags
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	for _, c := range cf.chunks {
+		hdr = []byte(c.id)
+		if cf.padded() {
+			hdr = order.AppendUint32(hdr, uint32(c.size))
+		} else {
+			hdr = order.AppendUint64(hdr, uint64(c.size))
+		}
+		if _, err := w.Write(hdr); err != nil {
+			return err
+		}
+
+		if c.data != nil {
+			if _, err := w.Write(c.data); err != nil {
+				return err
+			}
+		} else {
+			if _, err := in.Seek(c.start, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, in, c.size); err != nil {
+				return err
+			}
+		}
+		if cf.padded() && c.size&1 != 0 {
+			if _, err := w.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newChunk returns a chunk to be written with the payload.
+func newChunk(id string, data []byte) chunk {
+	return chunk{id: id, size: int64(len(data)), data: data}
+}
+
+// readChunk returns the payload of c, which must be small
+// enough to hold in memory.
+func readChunk(r io.ReadSeeker, c chunk) ([]byte, error) {
+	if c.size > 1<<24 {
+		return nil, fmt.Errorf("Cannot read %q chunk: too large", c.id)
+	}
+	buf := make([]byte, c.size)
+	if _, err := r.Seek(c.start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("Cannot read file: %v", err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("Cannot read file: %v", err)
+	}
+	return buf, nil
+}
+
+// findChunk reads the payload of the first chunk of the
+// given type in file, reporting false if there is none.
+func findChunk(file, id string) ([]byte, chunkFile, bool, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, chunkFile{}, false, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	cf, err := readChunks(f)
+	if err != nil {
+		return nil, chunkFile{}, false, err
+	}
+	c, ok := cf.find(id)
+	if !ok {
+		return nil, cf, false, nil
+	}
+	buf, err := readChunk(f, c)
+	return buf, cf, err == nil, err
+}
+
+// rewriteChunk copies the chunked file src to dst, with c
+// replacing its chunk of the same type (see chunkFile.replace).
+func rewriteChunk(src, dst string, c chunk, before string) error {
+	openFiles.acquire(nil)
+	defer openFiles.release()
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer in.Close()
+
+	cf, err := readChunks(in)
+	if err != nil {
+		return err
+	}
+	cf.replace(c, before)
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot create file: %v", err)
+	}
+	err = cf.write(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write file: %v", err)
+	}
+	return nil
+}
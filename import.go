@@ -0,0 +1,238 @@
+package bs1770wrap
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The importers below read measurements made by other tools
+// into StoredResults, so users migrating to this package don't
+// lose them; Import then puts them into a Store. Tools don't
+// report everything this package measures: what is missing
+// (often the length, and the momentary and short-term
+// maxima) is left zero.
+
+// ImportBS1770gain reads a bs1770gain report, XML or plain
+// text, as saved from its standard output.
+func ImportBS1770gain(r io.Reader) ([]StoredResult, error) {
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read report: %v", err)
+	}
+
+	gd := bs1770gainData{}
+	if looksLikeXML(out) {
+		err = xml.Unmarshal(out, &gd)
+	} else {
+		gd, err = parseText(out)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse report: %v", err)
+	}
+
+	var results []StoredResult
+	for _, t := range gd.Album.Tracks {
+		results = append(results, StoredResult{File: t.File, Loudness: t.loudness(0)})
+	}
+	return results, nil
+}
+
+// ImportLoudgain reads the tab separated table loudgain
+// prints with -O. The album row is skipped.
+func ImportLoudgain(r io.Reader) ([]StoredResult, error) {
+	rows, cols, err := readTable(r, '\t')
+	if err != nil {
+		return nil, err
+	}
+	file, ok1 := cols["file"]
+	loudness, ok2 := cols["loudness"]
+	lra, ok3 := cols["range"]
+	peak, ok4 := cols["true_peak_dbtp"]
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, fmt.Errorf("Cannot parse loudgain table: missing columns")
+	}
+
+	var results []StoredResult
+	for _, row := range rows {
+		if row[file] == "Album" {
+			continue
+		}
+		ld := LoudnessData{}
+		var errs [3]error
+		ld.Integrated, errs[0] = parseUnit(row[loudness])
+		ld.Range, errs[1] = parseUnit(row[lra])
+		ld.Peak, errs[2] = parseUnit(row[peak])
+		for _, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("Cannot parse loudgain table: %v", err)
+			}
+		}
+		results = append(results, StoredResult{File: row[file], Loudness: ld})
+	}
+	return results, nil
+}
+
+var r128gainRegex = regexp.MustCompile(`File '(.+)': loudness = (\S+) LUFS, sample peak = (\S+) dBFS`)
+
+// ImportR128gain reads the per-file lines r128gain logs,
+// such as
+//
+//	File 'a.flac': loudness = -14.4 LUFS, sample peak = -1.0 dBFS
+//
+// r128gain measures the sample peak, not the true peak, so
+// the peaks imported may be somewhat low.
+func ImportR128gain(r io.Reader) ([]StoredResult, error) {
+	var results []StoredResult
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		m := r128gainRegex.FindStringSubmatch(s.Text())
+		if m == nil {
+			continue
+		}
+		integrated, err1 := strconv.ParseFloat(m[2], 32)
+		peak, err2 := strconv.ParseFloat(m[3], 32)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("Cannot parse r128gain log: %q", s.Text())
+		}
+		results = append(results, StoredResult{
+			File:     m[1],
+			Loudness: LoudnessData{Integrated: float32(integrated), Peak: float32(peak)},
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read r128gain log: %v", err)
+	}
+	return results, nil
+}
+
+// ImportFoobar2000 reads ReplayGain values exported from
+// foobar2000 as a table (e.g. copied from a playlist view, or
+// written by Text Tools), comma, semicolon or tab separated.
+// The header names the columns after the title formatting
+// fields: %path%, %replaygain_track_gain% and
+// %replaygain_track_peak%, with or without the percent
+// signs. foobar2000 scans at ReferenceReplayGain2, so the
+// loudness is the reference minus the gain; its peaks are
+// sample peaks.
+func ImportFoobar2000(r io.Reader) ([]StoredResult, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(4096)
+	sep := ','
+	if line, _, _ := strings.Cut(string(header), "\n"); strings.Contains(line, "\t") {
+		sep = '\t'
+	} else if strings.Contains(line, ";") {
+		sep = ';'
+	}
+
+	rows, cols, err := readTable(br, sep)
+	if err != nil {
+		return nil, err
+	}
+	path, ok1 := cols["path"]
+	gain, ok2 := cols["replaygain_track_gain"]
+	peak, ok3 := cols["replaygain_track_peak"]
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("Cannot parse foobar2000 export: missing columns")
+	}
+
+	var results []StoredResult
+	for _, row := range rows {
+		if row[gain] == "" || row[gain] == "?" {
+			continue // not scanned
+		}
+		g, err1 := parseUnit(row[gain])
+		p, err2 := strconv.ParseFloat(strings.TrimSpace(row[peak]), 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("Cannot parse foobar2000 export: bad values for %s", row[path])
+		}
+		results = append(results, StoredResult{
+			File: row[path],
+			Loudness: LoudnessData{
+				Integrated: float32(ReferenceReplayGain2) - g,
+				Peak:       float32(LinearToDB(p)),
+			},
+		})
+	}
+	return results, nil
+}
+
+// readTable reads a table with a header row, returning the
+// rows and the column index by lowercased name.
+func readTable(r io.Reader, sep rune) ([][]string, map[string]int, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = sep
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot parse table: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("Cannot parse table: no header")
+	}
+
+	cols := make(map[string]int)
+	for i, name := range records[0] {
+		cols[strings.ToLower(strings.Trim(strings.TrimSpace(name), "%"))] = i
+	}
+	var rows [][]string
+	for _, rec := range records[1:] {
+		if len(rec) == len(records[0]) {
+			rows = append(rows, rec)
+		}
+	}
+	return rows, cols, nil
+}
+
+// parseUnit parses a value followed by a unit, such as
+// "-13.95 LUFS" or "-4.05 dB".
+func parseUnit(s string) (float32, error) {
+	v, _, _ := strings.Cut(strings.TrimSpace(s), " ")
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	return float32(f), nil
+}
+
+// Import puts imported results into store, under the keys
+// keys derives (PathMtimeKey if nil), so that Cache finds
+// them. Relative file names are taken relative to dir. Files
+// that cannot be keyed, usually because they no longer
+// exist, are skipped and returned.
+func Import(store Store, results []StoredResult, keys KeyStrategy, dir string) ([]string, error) {
+	if keys == nil {
+		keys = PathMtimeKey{}
+	}
+
+	var skipped []string
+	for _, r := range results {
+		file := r.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		key, err := keySafely(keys, file, Options{})
+		if err != nil {
+			skipped = append(skipped, file)
+			continue
+		}
+
+		if fs, ok := store.(FileStore); ok {
+			err = fs.PutFile(key, file, r.Loudness)
+		} else {
+			err = store.Put(key, r.Loudness)
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("Cannot store imported result: %v", err)
+		}
+	}
+	return skipped, nil
+}
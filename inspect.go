@@ -0,0 +1,207 @@
+package bs1770wrap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Issue codes for structural problems of input files.
+const (
+	// IssueVBRHeaderless is a variable bitrate MP3 without a
+	// Xing/Info or VBRI header, whose length tools can only
+	// estimate from the first frames.
+	IssueVBRHeaderless = "vbr-headerless"
+
+	// IssueSampleRateChange is a stream whose sample rate
+	// changes partway, which sox and some analyzers don't
+	// handle.
+	IssueSampleRateChange = "sample-rate-change"
+
+	// IssueDecodeErrors is a file the decoder reported
+	// errors for, such as corrupt frames or headers.
+	IssueDecodeErrors = "decode-errors"
+)
+
+// Issue is a structural problem found in an input file, which
+// may make its measurement or length unreliable.
+type Issue struct {
+	Code     string // one of the Issue constants
+	Message  string
+	Repaired bool // worked around by Options.RepairInput
+}
+
+// Inspect checks file for structural problems, decoding it
+// with ffprobe. It does not apply to directories.
+func Inspect(file string, opts Options) ([]Issue, error) {
+	info := AnalysisInfo{}
+	issues, _, err := inspectStream(file, opts, &info)
+	return issues, err
+}
+
+// inspectStream is Inspect, also returning the sample rate
+// the stream starts with.
+func inspectStream(file string, opts Options, info *AnalysisInfo) ([]Issue, int, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name:frame=sample_rate,pkt_size",
+		"-of", "csv",
+		ffmpegPath(file),
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := run("ffprobe", cmd, opts, info)
+	info.Timings.Probe += time.Since(start)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Cannot inspect file: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var codec string
+	var rate, minSize, maxSize int
+	var issues []Issue
+	rates := make(map[int]bool)
+	s := bufio.NewScanner(&stdout)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ",")
+		switch {
+		case fields[0] == "stream" && len(fields) >= 2:
+			codec = fields[1]
+		case fields[0] == "frame" && len(fields) >= 3:
+			r, err1 := strconv.Atoi(fields[1])
+			size, err2 := strconv.Atoi(fields[2])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			if rate == 0 {
+				rate, minSize, maxSize = r, size, size
+			}
+			rates[r] = true
+			if r == rate && size < minSize {
+				minSize = size
+			}
+			if r == rate && size > maxSize {
+				maxSize = size
+			}
+		}
+	}
+
+	// constant bitrate MP3 frames only differ by the padding
+	// byte
+	if codec == "mp3" && maxSize-minSize > 1 && !hasVBRHeader(file) {
+		issues = append(issues, Issue{
+			Code:    IssueVBRHeaderless,
+			Message: "variable bitrate MP3 without a VBR header, length may be misreported",
+		})
+	}
+	if len(rates) > 1 {
+		issues = append(issues, Issue{
+			Code:    IssueSampleRateChange,
+			Message: fmt.Sprintf("sample rate changes within the stream (%d rates)", len(rates)),
+		})
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		if i := strings.IndexByte(msg, '\n'); i >= 0 {
+			msg = msg[:i]
+		}
+		issues = append(issues, Issue{
+			Code:    IssueDecodeErrors,
+			Message: "decoder reported errors: " + msg,
+		})
+	}
+	return issues, rate, nil
+}
+
+// hasVBRHeader reports whether the first frame of an MP3 file
+// is a Xing/Info or VBRI header.
+func hasVBRHeader(file string) bool {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	frame := mp3FirstFrame(f)
+	if len(frame) > 64 {
+		frame = frame[:64]
+	}
+	return bytes.Contains(frame, []byte("Xing")) ||
+		bytes.Contains(frame, []byte("Info")) ||
+		bytes.Contains(frame, []byte("VBRI"))
+}
+
+// inspect runs Inspect ahead of an analysis, recording the
+// issues in info, and with opts.RepairInput works around the
+// ones it can in a scratch copy: a remux has ffmpeg write the
+// missing VBR header, a sample rate change takes decoding to
+// a single rate. It returns the file to analyze and a
+// function removing any scratch copy.
+func inspect(file string, opts Options, info *AnalysisInfo) (string, func(), error) {
+	noop := func() {}
+	if fi, err := os.Stat(file); err == nil && fi.IsDir() {
+		return file, noop, nil
+	}
+
+	issues, rate, err := inspectStream(file, opts, info)
+	if err != nil {
+		return "", nil, err
+	}
+	info.Issues = append(info.Issues, issues...)
+	if !opts.RepairInput {
+		return file, noop, nil
+	}
+
+	var headerless, rateChange bool
+	for _, i := range issues {
+		headerless = headerless || i.Code == IssueVBRHeaderless
+		rateChange = rateChange || i.Code == IssueSampleRateChange
+	}
+	if !headerless && !rateChange {
+		return file, noop, nil
+	}
+
+	dir, err := os.MkdirTemp(opts.TempDir, "bs1770wrap")
+	if err != nil {
+		return "", nil, fmt.Errorf("Error creating temporary directory: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	args := []string{"-nostdin", "-loglevel", "error", "-i", ffmpegPath(file), "-map", "0:a:0"}
+	out := filepath.Join(dir, "repaired.mp3")
+	if rateChange {
+		out = filepath.Join(dir, "repaired.wav")
+		args = append(args, "-ar", strconv.Itoa(rate), "-c:a", "pcm_f32le")
+	} else {
+		args = append(args, "-c:a", "copy", "-write_xing", "1")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", append(args, ffmpegPath(out))...)
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err = run("ffmpeg", cmd, opts, info)
+	info.Timings.Preprocess += time.Since(start)
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("Cannot repair file: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	for i := range info.Issues {
+		switch info.Issues[i].Code {
+		case IssueVBRHeaderless, IssueSampleRateChange:
+			info.Issues[i].Repaired = true
+		}
+	}
+	return out, cleanup, nil
+}
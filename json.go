@@ -0,0 +1,64 @@
+package bs1770wrap
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// JSONLevel is a level, in LUFS, LU or dB, as JSON has it:
+// a number, or null where it is not finite, such as the -Inf
+// loudness of silence, which JSON has no number for. null
+// reads back as -Inf.
+type JSONLevel float32
+
+// MarshalJSON implements json.Marshaler.
+func (l JSONLevel) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(l), 0) || math.IsNaN(float64(l)) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float32(l))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *JSONLevel) UnmarshalJSON(buf []byte) error {
+	if string(buf) == "null" {
+		*l = JSONLevel(math.Inf(-1))
+		return nil
+	}
+	return json.Unmarshal(buf, (*float32)(l))
+}
+
+// loudnessJSON is LoudnessData as it is kept in stores and
+// sidecars, under the names of its fields.
+type loudnessJSON struct {
+	Integrated JSONLevel
+	Peak       JSONLevel
+	Range      JSONLevel
+	Shortterm  JSONLevel
+	Momentary  JSONLevel
+	Length     uint64
+}
+
+// MarshalJSON implements json.Marshaler, with levels that are
+// not finite, as of silence, as null, so that they can be
+// stored.
+func (ld LoudnessData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(loudnessJSON{
+		JSONLevel(ld.Integrated), JSONLevel(ld.Peak), JSONLevel(ld.Range),
+		JSONLevel(ld.Shortterm), JSONLevel(ld.Momentary), ld.Length,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler; null levels
+// read back as -Inf.
+func (ld *LoudnessData) UnmarshalJSON(buf []byte) error {
+	var l loudnessJSON
+	if err := json.Unmarshal(buf, &l); err != nil {
+		return err
+	}
+	*ld = LoudnessData{
+		float32(l.Integrated), float32(l.Peak), float32(l.Range),
+		float32(l.Shortterm), float32(l.Momentary), l.Length,
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package bs1770wrap
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// A service embedding this package may run several batches
+// at once, each spawning tools and opening files; together
+// they can exhaust the per-process limits (ulimit -n, -u)
+// even if every batch is bounded. The limits below apply to
+// everything the package does in the process, whoever calls
+// it.
+
+// limiter is a counting semaphore whose size can be changed
+// at any time; a size of zero means no limit.
+type limiter struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int
+	used  int
+}
+
+func newLimiter() *limiter {
+	l := &limiter{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits for a slot, unless ctx, if not nil, is done
+// first.
+func (l *limiter) acquire(ctx context.Context) error {
+	if ctx != nil {
+		stop := context.AfterFunc(ctx, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.cond.Broadcast()
+		})
+		defer stop()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.limit > 0 && l.used >= l.limit {
+		if ctx != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.cond.Wait()
+	}
+	l.used++
+	return nil
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used--
+	l.cond.Signal()
+}
+
+func (l *limiter) setLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = n
+	l.cond.Broadcast()
+}
+
+var (
+	processes = newLimiter()
+	openFiles = newLimiter()
+)
+
+// SetMaxProcesses caps the number of tools the package runs
+// at the same time, process-wide; further ones wait for a
+// running one to exit. Zero, the default, means no limit.
+// Each tool also uses a few file descriptors for its pipes,
+// which SetMaxOpenFiles doesn't count.
+func SetMaxProcesses(n int) {
+	processes.setLimit(n)
+}
+
+// SetMaxOpenFiles caps the number of files the package has
+// open at the same time, process-wide; further opens wait
+// for an open file to be closed. Zero, the default, means no
+// limit.
+func SetMaxOpenFiles(n int) {
+	openFiles.setLimit(n)
+}
+
+// limitedFile is an open file counted against
+// SetMaxOpenFiles until it is closed.
+type limitedFile struct {
+	*os.File
+	once sync.Once
+}
+
+// Close closes the file and releases its slot.
+func (f *limitedFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(openFiles.release)
+	return err
+}
+
+// openFile is os.OpenFile, waiting for a slot first.
+// Operations on two files at once, such as copying one into
+// the other, take a single slot instead, with openFiles
+// directly: taking one per file, with every slot held by an
+// operation waiting for its second, none would go on.
+func openFile(name string, flag int, perm os.FileMode) (*limitedFile, error) {
+	openFiles.acquire(nil)
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		openFiles.release()
+		return nil, err
+	}
+	return &limitedFile{File: f}, nil
+}
@@ -0,0 +1,65 @@
+package bs1770wrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyRangeWithOneOpenFile(t *testing.T) {
+	SetMaxOpenFiles(1)
+	defer SetMaxOpenFiles(0)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- copyRange(src, filepath.Join(dir, "dst"), 2, 5)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("copyRange deadlocked with SetMaxOpenFiles(1)")
+	}
+	buf, err := os.ReadFile(filepath.Join(dir, "dst"))
+	if err != nil || string(buf) != "234" {
+		t.Fatalf("copied %q, %v; want \"234\"", buf, err)
+	}
+}
+
+func TestLimiterAcquireCancelled(t *testing.T) {
+	l := newLimiter()
+	l.setLimit(1)
+	if err := l.acquire(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- l.acquire(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("acquire returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire ignored the cancelled context")
+	}
+
+	l.release()
+	if err := l.acquire(ctx); err != nil {
+		t.Fatalf("acquire of a free slot with a done context: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// LogLevel is the verbosity of Options.Logger.
+type LogLevel int
+
+// Log levels, from least to most verbose. At LogTrace, the
+// input and output of every tool is also saved if
+// Options.TraceDir is set.
+const (
+	LogError LogLevel = iota // analyses that failed
+	LogWarn                  // problems worked around
+	LogInfo                  // outcome of each analysis
+	LogDebug                 // every tool spawned
+	LogTrace                 // everything
+)
+
+var logLevelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// Logger receives the log messages of the orchestration
+// layer: which tools run, what fails and what is retried.
+type Logger interface {
+	Log(level LogLevel, msg string)
+}
+
+// LogFunc adapts an ordinary function to the Logger
+// interface, e.g. to forward messages to log/slog.
+type LogFunc func(level LogLevel, msg string)
+
+// Log calls f(level, msg).
+func (f LogFunc) Log(level LogLevel, msg string) {
+	f(level, msg)
+}
+
+// WriterLogger returns a Logger writing one line per message
+// to w, prefixed with the level.
+func WriterLogger(w io.Writer) Logger {
+	return LogFunc(func(level LogLevel, msg string) {
+		fmt.Fprintf(w, "bs1770wrap: %s: %s\n", level, msg)
+	})
+}
+
+// logf logs a message, if opts ask for the level.
+func logf(opts Options, level LogLevel, format string, args ...interface{}) {
+	if opts.Logger == nil || level > opts.LogLevel {
+		return
+	}
+	opts.Logger.Log(level, fmt.Sprintf(format, args...))
+}
+
+// startTrace creates the directory the tool IO of an
+// analysis of file is saved in, if opts ask for it.
+func startTrace(file string, opts Options, info *AnalysisInfo) {
+	if opts.TraceDir == "" || opts.LogLevel < LogTrace {
+		return
+	}
+	dir, err := os.MkdirTemp(opts.TraceDir, filepath.Base(file)+"-")
+	if err != nil {
+		logf(opts, LogWarn, "cannot create trace directory: %v", err)
+		return
+	}
+	info.TraceDir = dir
+}
+
+// traceCmd saves the command line of cmd into the trace
+// directory, and has its output copied there too. The
+// returned function closes the copies once cmd is done.
+func traceCmd(name string, cmd *exec.Cmd, opts Options, info *AnalysisInfo) func() {
+	if info.TraceDir == "" {
+		return func() {}
+	}
+
+	prefix := filepath.Join(info.TraceDir, fmt.Sprintf("%02d-%s", len(info.Tools)+1, name))
+	args := strings.Join(redactArgs(cmd.Args), "\n") + "\n"
+	if err := os.WriteFile(prefix+".args", []byte(args), 0644); err != nil {
+		logf(opts, LogWarn, "cannot write trace: %v", err)
+	}
+
+	var files []*os.File
+	tee := func(w io.Writer, suffix string) io.Writer {
+		f, err := os.Create(prefix + suffix)
+		if err != nil {
+			logf(opts, LogWarn, "cannot write trace: %v", err)
+			return w
+		}
+		files = append(files, f)
+		if w == nil {
+			return f
+		}
+		return io.MultiWriter(w, f)
+	}
+	cmd.Stdout = tee(cmd.Stdout, ".stdout")
+	cmd.Stderr = tee(cmd.Stderr, ".stderr")
+
+	return func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+}
+
+// redactArgs returns a command line for logs and traces, with
+// the query and credentials of URLs, and HTTP headers, left
+// out, as they may be secrets.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		switch u, err := neturl.Parse(arg); {
+		case i > 0 && args[i-1] == "-headers":
+			redacted[i] = "[redacted]"
+		case err == nil && u.Host != "" && (u.User != nil || u.RawQuery != ""):
+			redacted[i] = redactURL(arg)
+		default:
+			redacted[i] = arg
+		}
+	}
+	return redacted
+}
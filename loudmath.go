@@ -0,0 +1,133 @@
+package bs1770wrap
+
+import "math"
+
+// Constants from ITU-R BS.1770-4 and EBU Tech 3342.
+const (
+	// LoudnessOffset is the -0.691 dB term in the loudness of
+	// a block, which makes a 997 Hz full scale sine read as
+	// -3.01 LUFS after K-weighting.
+	LoudnessOffset = -0.691
+
+	AbsoluteGate        = -70.0 // LUFS, blocks below are ignored
+	RelativeGate        = -10.0 // LU, relative to absolute-gated loudness (integrated)
+	RangeRelativeGate   = -20.0 // LU, relative to absolute-gated loudness (range)
+	RangeLowPercentile  = 10.0  // percentiles of the short-term
+	RangeHighPercentile = 95.0  // distribution spanned by the range
+)
+
+// Common reference levels, in LUFS.
+const (
+	ReferenceEBUR128     = -23.0 // EBU R 128 broadcast
+	ReferenceATSCA85     = -24.0 // ATSC A/85 broadcast (LKFS)
+	ReferenceReplayGain2 = -18.0 // ReplayGain 2.0
+	ReferenceStreaming   = -14.0 // most music streaming services
+)
+
+// DBToLinear converts a level in dB to a linear amplitude
+// factor.
+func DBToLinear(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// LinearToDB converts a linear amplitude factor to dB.
+// Zero maps to -Inf.
+func LinearToDB(factor float64) float64 {
+	return 20 * math.Log10(factor)
+}
+
+// LoudnessToEnergy converts a loudness in LUFS to the
+// (K-weighted, channel-summed) mean square energy it stands
+// for. Loudness values must be averaged as energies, never
+// directly.
+func LoudnessToEnergy(lufs float64) float64 {
+	return math.Pow(10, (lufs-LoudnessOffset)/10)
+}
+
+// EnergyToLoudness converts a mean square energy back into
+// LUFS. Zero maps to -Inf.
+func EnergyToLoudness(energy float64) float64 {
+	return LoudnessOffset + 10*math.Log10(energy)
+}
+
+// EnergyMean averages loudness values in the energy domain,
+// e.g. to combine equal-length blocks. It returns -Inf for
+// no values.
+func EnergyMean(lufs ...float64) float64 {
+	if len(lufs) == 0 {
+		return math.Inf(-1)
+	}
+	sum := 0.0
+	for _, l := range lufs {
+		sum += LoudnessToEnergy(l)
+	}
+	return EnergyToLoudness(sum / float64(len(lufs)))
+}
+
+// WeightedEnergyMean is EnergyMean with a weight per value,
+// such as durations when combining tracks of differing
+// length. Weights must not all be zero.
+func WeightedEnergyMean(lufs, weights []float64) float64 {
+	sum, total := 0.0, 0.0
+	for i, l := range lufs {
+		sum += weights[i] * LoudnessToEnergy(l)
+		total += weights[i]
+	}
+	if total == 0 {
+		return math.Inf(-1)
+	}
+	return EnergyToLoudness(sum / total)
+}
+
+// Conversions between measurement systems. LKFS (ATSC A/85)
+// and LUFS (EBU R 128) are the same unit under two names, and
+// LU differences are dB differences. Loudness and RMS levels,
+// on the other hand, only agree for narrowband signals near
+// 1 kHz, where K-weighting is flat; for program material the
+// highpass and high shelf of K-weighting make them differ by
+// a few dB either way, depending on the spectrum. The RMS
+// conversions below are approximations for meters and rules
+// of thumb, never substitutes for a measurement.
+
+// AES17Offset is the dB an AES17 RMS meter adds, so that a
+// full scale sine reads 0 dBFS RMS rather than -3.01.
+const AES17Offset = 3.0103
+
+// LKFSToLUFS converts LKFS to LUFS, which it is equal to.
+func LKFSToLUFS(lkfs float64) float64 {
+	return lkfs
+}
+
+// LUFSToLKFS converts LUFS to LKFS, which it is equal to.
+func LUFSToLKFS(lufs float64) float64 {
+	return lufs
+}
+
+// LUFSToLU returns lufs relative to the reference, in LU, as
+// loudness meters in relative mode display it.
+func LUFSToLU(lufs, reference float64) float64 {
+	return lufs - reference
+}
+
+// LUFSToRMS approximates the unweighted RMS level, in dBFS, of
+// each channel of a signal with the given loudness, assuming
+// all channels carry the same level and the energy is near
+// 1 kHz. With aes17 set it is given as an AES17 meter shows
+// it. A 997 Hz sine on one channel at -20 dBFS RMS measures
+// -20 LUFS; on both channels of a stereo file, -16.99 LUFS.
+func LUFSToRMS(lufs float64, channels int, aes17 bool) float64 {
+	rms := lufs - 10*math.Log10(float64(channels))
+	if aes17 {
+		rms += AES17Offset
+	}
+	return rms
+}
+
+// RMSToLUFS is the inverse of LUFSToRMS, with the same
+// caveats.
+func RMSToLUFS(rms float64, channels int, aes17 bool) float64 {
+	if aes17 {
+		rms -= AES17Offset
+	}
+	return rms + 10*math.Log10(float64(channels))
+}
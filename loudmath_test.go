@@ -0,0 +1,168 @@
+package bs1770wrap
+
+import (
+	"math"
+	"testing"
+)
+
+var (
+	inf = math.Inf(1)
+	nan = math.NaN()
+)
+
+// near reports whether got is want to 1e-4, infinities and
+// NaN only matching themselves.
+func near(got, want float64) bool {
+	switch {
+	case math.IsNaN(want):
+		return math.IsNaN(got)
+	case math.IsInf(want, 0):
+		return got == want
+	}
+	return math.Abs(got-want) < 1e-4
+}
+
+func TestLevelConversions(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		f       func(float64) float64
+		in, out float64
+	}{
+		{"DBToLinear", DBToLinear, 0, 1},
+		{"DBToLinear", DBToLinear, 20, 10},
+		{"DBToLinear", DBToLinear, -6.0206, 0.5},
+		{"DBToLinear", DBToLinear, -inf, 0},
+		{"DBToLinear", DBToLinear, inf, inf},
+		{"DBToLinear", DBToLinear, nan, nan},
+
+		{"LinearToDB", LinearToDB, 1, 0},
+		{"LinearToDB", LinearToDB, 10, 20},
+		{"LinearToDB", LinearToDB, 0.5, -6.0206},
+		{"LinearToDB", LinearToDB, 0, -inf},
+		{"LinearToDB", LinearToDB, inf, inf},
+		{"LinearToDB", LinearToDB, -1, nan},
+		{"LinearToDB", LinearToDB, nan, nan},
+
+		{"LoudnessToEnergy", LoudnessToEnergy, LoudnessOffset, 1},
+		{"LoudnessToEnergy", LoudnessToEnergy, LoudnessOffset + 10, 10},
+		{"LoudnessToEnergy", LoudnessToEnergy, -inf, 0},
+		{"LoudnessToEnergy", LoudnessToEnergy, inf, inf},
+		{"LoudnessToEnergy", LoudnessToEnergy, nan, nan},
+
+		{"EnergyToLoudness", EnergyToLoudness, 1, LoudnessOffset},
+		{"EnergyToLoudness", EnergyToLoudness, 0.1, LoudnessOffset - 10},
+		{"EnergyToLoudness", EnergyToLoudness, 0, -inf},
+		{"EnergyToLoudness", EnergyToLoudness, inf, inf},
+		{"EnergyToLoudness", EnergyToLoudness, -1, nan},
+		{"EnergyToLoudness", EnergyToLoudness, nan, nan},
+
+		{"LKFSToLUFS", LKFSToLUFS, -24, -24},
+		{"LKFSToLUFS", LKFSToLUFS, -inf, -inf},
+		{"LKFSToLUFS", LKFSToLUFS, nan, nan},
+		{"LUFSToLKFS", LUFSToLKFS, -23, -23},
+		{"LUFSToLKFS", LUFSToLKFS, inf, inf},
+		{"LUFSToLKFS", LUFSToLKFS, nan, nan},
+	} {
+		if got := c.f(c.in); !near(got, c.out) {
+			t.Errorf("%s(%v) = %v, want %v", c.name, c.in, got, c.out)
+		}
+	}
+}
+
+func TestLevelRoundTrips(t *testing.T) {
+	for _, v := range []float64{-70, -23, -0.691, 0, 3.5, -inf, inf} {
+		if got := EnergyToLoudness(LoudnessToEnergy(v)); !near(got, v) {
+			t.Errorf("EnergyToLoudness(LoudnessToEnergy(%v)) = %v", v, got)
+		}
+		if got := LinearToDB(DBToLinear(v)); !near(got, v) {
+			t.Errorf("LinearToDB(DBToLinear(%v)) = %v", v, got)
+		}
+		for _, channels := range []int{1, 2, 6} {
+			for _, aes17 := range []bool{false, true} {
+				if got := RMSToLUFS(LUFSToRMS(v, channels, aes17), channels, aes17); !near(got, v) {
+					t.Errorf("RMSToLUFS(LUFSToRMS(%v, %d, %v)) = %v", v, channels, aes17, got)
+				}
+			}
+		}
+	}
+}
+
+func TestEnergyMean(t *testing.T) {
+	for _, c := range []struct {
+		lufs []float64
+		want float64
+	}{
+		{nil, -inf},
+		{[]float64{-23}, -23},
+		{[]float64{-20, -20}, -20},
+		{[]float64{-20, -inf}, -20 - 10*math.Log10(2)}, // half the energy
+		{[]float64{-10, -20}, -12.5964},
+		{[]float64{-inf, -inf}, -inf},
+		{[]float64{-20, inf}, inf},
+		{[]float64{-20, nan}, nan},
+	} {
+		if got := EnergyMean(c.lufs...); !near(got, c.want) {
+			t.Errorf("EnergyMean(%v) = %v, want %v", c.lufs, got, c.want)
+		}
+	}
+}
+
+func TestWeightedEnergyMean(t *testing.T) {
+	for _, c := range []struct {
+		lufs, weights []float64
+		want          float64
+	}{
+		{nil, nil, -inf},
+		{[]float64{-20, -30}, []float64{0, 0}, -inf},
+		{[]float64{-20, -30}, []float64{1, 0}, -20},
+		{[]float64{-20, -inf}, []float64{1, 1}, -20 - 10*math.Log10(2)},
+		{[]float64{-20, -inf}, []float64{1, 3}, -20 - 10*math.Log10(4)},
+		{[]float64{-10, -20}, []float64{2, 2}, EnergyMean(-10, -20)},
+		{[]float64{-20, inf}, []float64{1, 1}, inf},
+		{[]float64{-20, nan}, []float64{1, 1}, nan},
+	} {
+		if got := WeightedEnergyMean(c.lufs, c.weights); !near(got, c.want) {
+			t.Errorf("WeightedEnergyMean(%v, %v) = %v, want %v", c.lufs, c.weights, got, c.want)
+		}
+	}
+}
+
+func TestRelativeAndRMSLevels(t *testing.T) {
+	for _, c := range []struct {
+		lufs, reference, want float64
+	}{
+		{-23, ReferenceEBUR128, 0},
+		{-18, ReferenceEBUR128, 5},
+		{-30, ReferenceEBUR128, -7},
+		{-inf, ReferenceEBUR128, -inf},
+		{nan, ReferenceEBUR128, nan},
+	} {
+		if got := LUFSToLU(c.lufs, c.reference); !near(got, c.want) {
+			t.Errorf("LUFSToLU(%v, %v) = %v, want %v", c.lufs, c.reference, got, c.want)
+		}
+	}
+
+	// from the examples of the LUFSToRMS doc on
+	for _, c := range []struct {
+		lufs     float64
+		channels int
+		aes17    bool
+		rms      float64
+	}{
+		{-20, 1, false, -20},
+		{-16.9897, 2, false, -20},
+		{-20, 1, true, -16.9897},
+		{-20, 2, true, -20},
+		{-20, 6, false, -27.7815},
+		{-inf, 2, true, -inf},
+		{inf, 2, false, inf},
+		{nan, 1, false, nan},
+	} {
+		if got := LUFSToRMS(c.lufs, c.channels, c.aes17); !near(got, c.rms) {
+			t.Errorf("LUFSToRMS(%v, %d, %v) = %v, want %v", c.lufs, c.channels, c.aes17, got, c.rms)
+		}
+		if got := RMSToLUFS(c.rms, c.channels, c.aes17); !near(got, c.lufs) {
+			t.Errorf("RMSToLUFS(%v, %d, %v) = %v, want %v", c.rms, c.channels, c.aes17, got, c.lufs)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package bs1770wrap
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo describes the audio of an analyzed file, as the
+// tool probing its length reports it.
+type MediaInfo struct {
+	SampleRate int // Hz
+	Channels   int
+
+	// BitDepth is the precision of the samples, in bits; for
+	// lossy formats it is what they decode to, or zero.
+	BitDepth int
+
+	// Encoding is the sample encoding or codec, as the tool
+	// names it: "16-bit Signed Integer PCM" from sox, "flac"
+	// from ffprobe.
+	Encoding string
+}
+
+/* sox --info describes a file from its header:
+
+`
+Input File     : 'x.wav'
+Channels       : 2
+Sample Rate    : 44100
+Precision      : 16-bit
+Duration       : 00:00:10.00 = 441000 samples ~ 750 CDDA sectors
+File Size      : 1.76M
+Bit Rate       : 1.41M
+Sample Encoding: 16-bit Signed Integer PCM
+`
+
+as does sox -V3 on stderr, followed by its output file.
+*/
+
+var (
+	soxChannelsRegex  = regexp.MustCompile(`(?m)^Channels\s*:\s*(\d+)`)
+	soxRateRegex      = regexp.MustCompile(`(?m)^Sample Rate\s*:\s*(\d+)`)
+	soxPrecisionRegex = regexp.MustCompile(`(?m)^Precision\s*:\s*(\d+)-bit`)
+	soxEncodingRegex  = regexp.MustCompile(`(?m)^Sample Encoding\s*:\s*(.*?)\s*$`)
+	soxDurationRegex  = regexp.MustCompile(`(?m)^Duration\s*:.*?=\s*(\d+)\s+samples`)
+)
+
+// parseSoxInfo parses the description of the input file sox
+// prints.
+func parseSoxInfo(out string) (MediaInfo, bool) {
+	i := strings.Index(out, "Input File")
+	if i < 0 {
+		return MediaInfo{}, false
+	}
+	out = out[i:]
+	if i := strings.Index(out, "Output File"); i >= 0 {
+		out = out[:i]
+	}
+
+	number := func(re *regexp.Regexp) int {
+		if m := re.FindStringSubmatch(out); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			return n
+		}
+		return 0
+	}
+	m := MediaInfo{
+		SampleRate: number(soxRateRegex),
+		Channels:   number(soxChannelsRegex),
+		BitDepth:   number(soxPrecisionRegex),
+	}
+	if e := soxEncodingRegex.FindStringSubmatch(out); e != nil {
+		m.Encoding = e[1]
+	}
+	return m, m.SampleRate > 0 && m.Channels > 0
+}
+
+// soxSamples returns the length in samples, per channel, of
+// the file sox described.
+func soxSamples(out string) (uint64, bool) {
+	m := soxDurationRegex.FindStringSubmatch(out)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	return n, err == nil
+}
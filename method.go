@@ -0,0 +1,251 @@
+package bs1770wrap
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AnalyzerVersion is the version of the way the package
+// measures and reads the tools' reports. It goes up with
+// changes that move results, so that cached results get
+// analyzed again.
+const AnalyzerVersion = 1
+
+// Method describes how a result was measured, as far as it
+// affects the numbers: which analyzer, backend and tool
+// version, and calibration, and what preprocessing. Results
+// measured with different methods are not comparable, e.g. a
+// highpassed integrated loudness reads lower than the plain
+// one.
+type Method struct {
+	Analyzer     int      `json:"analyzer,omitempty"` // AnalyzerVersion
+	Backend      string   `json:"backend"`
+	ToolVersion  string   `json:"tool_version,omitempty"`
+	Calibration  float32  `json:"calibration,omitempty"`
+	Highpass     float64  `json:"highpass,omitempty"`
+	Effects      []string `json:"effects,omitempty"`
+	FilterGraph  string   `json:"filtergraph,omitempty"`
+	RoundTrip    string   `json:"roundtrip,omitempty"`     // encoder and bitrate
+	DialogueGate string   `json:"dialogue_gate,omitempty"` // detector and its settings
+	KeepPadding  bool     `json:"keep_padding,omitempty"`
+}
+
+// MethodOf returns the method of an analysis run with opts.
+func MethodOf(opts Options, info AnalysisInfo) Method {
+	m := Method{
+		Analyzer:    AnalyzerVersion,
+		Backend:     info.Backend,
+		ToolVersion: info.ToolVersion,
+		Calibration: info.Calibration,
+		Highpass:    opts.Highpass,
+		Effects:     opts.Effects,
+		FilterGraph: opts.FilterGraph,
+		KeepPadding: opts.KeepPadding,
+	}
+	if opts.RoundTrip != nil {
+		m.RoundTrip = strings.TrimSpace(opts.RoundTrip.Encoder + " " + opts.RoundTrip.Bitrate)
+	}
+	if opts.DialogueGate != nil {
+		m.DialogueGate = fmt.Sprintf("%#v", opts.DialogueGate)
+	}
+	return m
+}
+
+// ID returns a short fingerprint of the method; equal
+// methods have equal IDs.
+func (m Method) ID() string {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		// only a Calibration or Highpass that is not finite
+		// makes it fail
+		buf = fmt.Appendf(nil, "%#v", m)
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Current reports whether a result measured with m would be
+// measured the same way with opts: by this AnalyzerVersion,
+// with its backend in the chain opts configure, the same
+// version of its tool and the same calibration, and with
+// the preprocessing unchanged. Results stored before the
+// versions were kept are taken as current on that count.
+func (m Method) Current(opts Options) bool {
+	backends := opts.Backends
+	if len(backends) == 0 {
+		backends = DefaultBackends
+	}
+	inChain := false
+	for _, b := range backends {
+		inChain = inChain || b.Name() == m.Backend
+	}
+	if !inChain {
+		return false
+	}
+
+	want := MethodOf(opts, AnalysisInfo{Backend: m.Backend, Calibration: opts.Calibration[m.Backend]})
+	if m.Analyzer == 0 {
+		want.Analyzer = 0
+	}
+	want.ToolVersion = m.ToolVersion
+	if m.ToolVersion != "" && m.Backend == (BS1770Gain{}).Name() {
+		v, err := BS1770GainVersionOf(opts)
+		if err != nil {
+			return false
+		}
+		want.ToolVersion = v.String()
+	}
+	return want.ID() == m.ID()
+}
+
+// Stale returns the results in store whose method is not
+// current for opts, which need to be analyzed again to be
+// comparable with new results. A Cache with opts does that
+// by itself when a stale result is looked up; Stale lets the
+// work be scheduled ahead of time. Results of unknown method
+// are not considered stale.
+func Stale(store Lister, opts Options) ([]StoredResult, error) {
+	results, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list results: %v", err)
+	}
+	var stale []StoredResult
+	for _, r := range results {
+		if r.Method != nil && !r.Method.Current(opts) {
+			stale = append(stale, r)
+		}
+	}
+	return stale, nil
+}
+
+// MethodStore is implemented by stores that can keep the
+// method of a result alongside it. Cache stores the method
+// of every analysis it makes, and the stores' List fills in
+// StoredResult.Method.
+type MethodStore interface {
+	PutMethod(key string, m Method) error
+	Method(key string) (Method, bool, error)
+}
+
+// ErrMixedMethods is returned when results measured with
+// different methods are to be compared or aggregated.
+var ErrMixedMethods = errors.New("results were measured with different methods")
+
+// SameMethod checks that all results were measured with the
+// same method. Results of unknown method (measured before
+// methods were stored, or imported) only match each other.
+func SameMethod(results []StoredResult) error {
+	for i := 1; i < len(results); i++ {
+		if methodID(results[i]) != methodID(results[0]) {
+			return fmt.Errorf("%w: %s and %s differ", ErrMixedMethods, results[0].File, results[i].File)
+		}
+	}
+	return nil
+}
+
+func methodID(r StoredResult) string {
+	if r.Method == nil {
+		return ""
+	}
+	return r.Method.ID()
+}
+
+// PutMethod implements MethodStore.
+func (s *MemoryStore) PutMethod(key string, m Method) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.methods == nil {
+		s.methods = make(map[string]Method)
+	}
+	s.methods[key] = m
+	return nil
+}
+
+// Method implements MethodStore.
+func (s *MemoryStore) Method(key string) (Method, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.methods[key]
+	return m, ok, nil
+}
+
+func parseMethod(buf []byte) (Method, bool, error) {
+	m := Method{}
+	err := json.Unmarshal(buf, &m)
+	if err != nil {
+		return Method{}, false, fmt.Errorf("Cannot parse method: %v", err)
+	}
+	return m, true, nil
+}
+
+// PutMethod implements MethodStore. Methods expire along
+// with results.
+func (s *KVStore) PutMethod(key string, m Method) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize method: %v", err)
+	}
+	return s.Client.Set(s.key(key)+":method", buf, s.TTL)
+}
+
+// Method implements MethodStore.
+func (s *KVStore) Method(key string) (Method, bool, error) {
+	buf, ok, err := s.Client.Get(s.key(key) + ":method")
+	if err != nil || !ok {
+		return Method{}, false, err
+	}
+	return parseMethod(buf)
+}
+
+func (s *ObjectStore) methodName(key string) string {
+	return strings.TrimSuffix(s.name(key), ".json") + ".method.json"
+}
+
+// PutMethod implements MethodStore.
+func (s *ObjectStore) PutMethod(key string, m Method) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize method: %v", err)
+	}
+	return s.Client.PutObject(s.methodName(key), buf)
+}
+
+// Method implements MethodStore.
+func (s *ObjectStore) Method(key string) (Method, bool, error) {
+	buf, ok, err := s.Client.GetObject(s.methodName(key))
+	if err != nil || !ok {
+		return Method{}, false, err
+	}
+	return parseMethod(buf)
+}
+
+// PutMethod implements MethodStore.
+func (s *SQLiteStore) PutMethod(key string, m Method) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Cannot serialize method: %v", err)
+	}
+	_, err = s.DB.Exec(`INSERT OR REPLACE INTO methods (key, method) VALUES (?, ?)`, key, string(buf))
+	if err != nil {
+		return fmt.Errorf("Cannot store method: %v", err)
+	}
+	return nil
+}
+
+// Method implements MethodStore.
+func (s *SQLiteStore) Method(key string) (Method, bool, error) {
+	var buf string
+	err := s.DB.QueryRow(`SELECT method FROM methods WHERE key = ?`, key).Scan(&buf)
+	if err == sql.ErrNoRows {
+		return Method{}, false, nil
+	}
+	if err != nil {
+		return Method{}, false, fmt.Errorf("Cannot query method: %v", err)
+	}
+	return parseMethod([]byte(buf))
+}
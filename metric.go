@@ -0,0 +1,62 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metric computes measurements of its own from the loudness
+// of a file, for metrics the package does not know about.
+// Metrics plugged in through Options.Metrics are run once the
+// file has been analyzed, and the values they return end up
+// in AnalysisInfo.Metrics, keyed by the metric's name and the
+// value's name joined with a dot ("crest.max").
+type Metric interface {
+	Name() string
+	Compute(in MetricInput) (map[string]float64, error)
+}
+
+// MetricInput is what a Metric computes from. Series is the
+// momentary and short-term loudness every 100 ms, in media
+// time, as measured by ffmpeg on the file without any
+// preprocessing.
+type MetricInput struct {
+	File     string
+	Loudness LoudnessData
+	Series   []LoudnessSample
+}
+
+// Blocks returns the momentary loudness values of the series,
+// which are the overlapping 400 ms gating blocks of BS.1770,
+// as taken by NewBlockHistogram.
+func (in MetricInput) Blocks() []float64 {
+	blocks := make([]float64, len(in.Series))
+	for i, s := range in.Series {
+		blocks[i] = float64(s.Momentary)
+	}
+	return blocks
+}
+
+// computeMetrics runs the metrics of opts on file, measuring
+// its loudness series once for all of them.
+func computeMetrics(file string, ld LoudnessData, opts Options, info *AnalysisInfo) error {
+	series, err := loudnessSeries(file, opts, info)
+	if err != nil {
+		return err
+	}
+	in := MetricInput{File: file, Loudness: ld, Series: alignSeries(series, ffmpegOffset(info))}
+
+	start := time.Now()
+	defer func() { info.Timings.Analyze += time.Since(start) }()
+	info.Metrics = make(map[string]float64)
+	for _, m := range opts.Metrics {
+		values, err := m.Compute(in)
+		if err != nil {
+			return fmt.Errorf("Cannot compute metric %s: %w", m.Name(), err)
+		}
+		for k, v := range values {
+			info.Metrics[m.Name()+"."+k] = v
+		}
+	}
+	return nil
+}
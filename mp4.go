@@ -0,0 +1,303 @@
+package bs1770wrap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// MP4 files are boxes: a 32-bit big-endian size, covering the
+// header, and a type; a size of 1 means a 64-bit size follows,
+// 0 that the box runs to the end of the file. Tags live in
+// moov/udta/meta/ilst, meta usually having a version and
+// flags before its children. iTunes freeform items ("----")
+// hold a mean box (the namespace), a name box and a data box
+// (type, locale, value); ReplayGain readers look for them in
+// the com.apple.iTunes namespace. Chunk offsets in stco and
+// co64 boxes are absolute, so when moov grows whatever comes
+// after it moves and they must be shifted.
+
+const mp4Namespace = "com.apple.iTunes"
+
+// the largest moov box read into memory
+const maxMoovSize = 64 << 20
+
+// mp4Containers are the boxes parsed into their children on the
+// way to the tags and the chunk offsets.
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "meta": true, "ilst": true, "----": true,
+}
+
+// mp4Node is a box in memory: the payload of a leaf, or the
+// children of a container and, for a full box meta, its
+// version and flags.
+type mp4Node struct {
+	typ      string
+	data     []byte
+	children []*mp4Node
+	leaf     bool
+}
+
+// parseMP4Nodes parses the boxes in buf. Containers that do
+// not parse, such as old QuickTime udta boxes, are kept as
+// leaves.
+func parseMP4Nodes(buf []byte) ([]*mp4Node, error) {
+	var nodes []*mp4Node
+	for len(buf) > 0 {
+		if len(buf) < 8 {
+			return nil, fmt.Errorf("truncated box")
+		}
+		size, hdr := uint64(binary.BigEndian.Uint32(buf)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(buf))
+		case 1:
+			if len(buf) < 16 {
+				return nil, fmt.Errorf("truncated box")
+			}
+			size, hdr = binary.BigEndian.Uint64(buf[8:]), 16
+		}
+		if size < hdr || size > uint64(len(buf)) {
+			return nil, fmt.Errorf("truncated %s box", buf[4:8])
+		}
+
+		b := &mp4Node{typ: string(buf[4:8]), data: buf[hdr:size], leaf: true}
+		if mp4Containers[b.typ] {
+			payload, prefix := b.data, []byte(nil)
+			if b.typ == "meta" && len(payload) >= 4 && !(len(payload) >= 8 && string(payload[4:8]) == "hdlr") {
+				payload, prefix = payload[4:], payload[:4]
+			}
+			if children, err := parseMP4Nodes(payload); err == nil {
+				b.data, b.children, b.leaf = prefix, children, false
+			}
+		}
+		nodes = append(nodes, b)
+		buf = buf[size:]
+	}
+	return nodes, nil
+}
+
+func (b *mp4Node) encode() []byte {
+	payload := b.data
+	if !b.leaf {
+		payload = append([]byte{}, b.data...)
+		for _, c := range b.children {
+			payload = append(payload, c.encode()...)
+		}
+	}
+	var buf []byte
+	if size := 8 + len(payload); uint64(size) <= math.MaxUint32 {
+		buf = binary.BigEndian.AppendUint32(nil, uint32(size))
+		buf = append(buf, b.typ...)
+	} else {
+		buf = binary.BigEndian.AppendUint32(nil, 1)
+		buf = append(buf, b.typ...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(16+len(payload)))
+	}
+	return append(buf, payload...)
+}
+
+// child returns the first child of type typ, or nil.
+func (b *mp4Node) child(typ string) *mp4Node {
+	for _, c := range b.children {
+		if c.typ == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+// container returns the child container of type typ, adding
+// it if missing.
+func (b *mp4Node) container(typ string) (*mp4Node, error) {
+	c := b.child(typ)
+	if c == nil {
+		c = &mp4Node{typ: typ}
+		if typ == "meta" {
+			c.data = make([]byte, 4)
+			hdlr := append(make([]byte, 8), "mdirappl"...)
+			c.children = []*mp4Node{{typ: "hdlr", data: append(hdlr, make([]byte, 9)...), leaf: true}}
+		}
+		b.children = append(b.children, c)
+	}
+	if c.leaf {
+		return nil, fmt.Errorf("Cannot parse %s box", typ)
+	}
+	return c, nil
+}
+
+// readMP4Moov reads the moov box of an MP4 file.
+func readMP4Moov(file string) (*mp4Node, mp4Box, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, mp4Box{}, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, mp4Box{}, fmt.Errorf("Cannot read file: %v", err)
+	}
+	for _, b := range mp4Children(f, 0, end) {
+		if b.typ != "moov" {
+			continue
+		}
+		if b.end-b.pos > maxMoovSize {
+			return nil, mp4Box{}, fmt.Errorf("Cannot parse MP4 file: moov box too large")
+		}
+		buf := make([]byte, b.end-b.pos)
+		if _, err := f.ReadAt(buf, b.pos); err != nil {
+			return nil, mp4Box{}, fmt.Errorf("Cannot read file: %v", err)
+		}
+		nodes, err := parseMP4Nodes(buf)
+		if err != nil || len(nodes) != 1 || nodes[0].leaf {
+			return nil, mp4Box{}, fmt.Errorf("Cannot parse MP4 file: bad moov box")
+		}
+		return nodes[0], b, nil
+	}
+	return nil, mp4Box{}, fmt.Errorf("Cannot parse MP4 file: no moov box")
+}
+
+// mp4ItemList returns the item list of moov, or nil.
+func mp4ItemList(moov *mp4Node) *mp4Node {
+	for _, typ := range []string{"udta", "meta", "ilst"} {
+		if moov = moov.child(typ); moov == nil || moov.leaf {
+			return nil
+		}
+	}
+	return moov
+}
+
+// freeform returns the name and value of an iTunes freeform
+// item in mp4Namespace.
+func freeform(item *mp4Node) (string, string, bool) {
+	if item.typ != "----" || item.leaf {
+		return "", "", false
+	}
+	mean, name, data := item.child("mean"), item.child("name"), item.child("data")
+	if mean == nil || name == nil || data == nil ||
+		len(mean.data) < 4 || len(name.data) < 4 || len(data.data) < 8 ||
+		string(mean.data[4:]) != mp4Namespace {
+		return "", "", false
+	}
+	return string(name.data[4:]), string(data.data[8:]), true
+}
+
+// readMP4Tags reads the tags of an MP4 file.
+func readMP4Tags(file string) (map[string]string, error) {
+	moov, _, err := readMP4Moov(file)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	if ilst := mp4ItemList(moov); ilst != nil {
+		for _, item := range ilst.children {
+			if k, v, ok := freeform(item); ok && !hasTag(tags, k) {
+				tags[k] = v
+			}
+		}
+	}
+	return tags, nil
+}
+
+// writeMP4Tags writes src with its freeform items updated to
+// dst, adding the boxes holding them if missing.
+func writeMP4Tags(src, dst string, tags map[string]string) error {
+	moov, box, err := readMP4Moov(src)
+	if err != nil {
+		return err
+	}
+	if moov.child("mvex") != nil {
+		return fmt.Errorf("Cannot tag fragmented MP4 files")
+	}
+
+	ilst := moov
+	for _, typ := range []string{"udta", "meta", "ilst"} {
+		ilst, err = ilst.container(typ)
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range tags {
+		var items []*mp4Node
+		for _, item := range ilst.children {
+			if name, _, ok := freeform(item); !ok || !strings.EqualFold(name, k) {
+				items = append(items, item)
+			}
+		}
+		if v != "" {
+			items = append(items, &mp4Node{typ: "----", children: []*mp4Node{
+				{typ: "mean", data: append(make([]byte, 4), mp4Namespace...), leaf: true},
+				{typ: "name", data: append(make([]byte, 4), k...), leaf: true},
+				{typ: "data", data: append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, v...), leaf: true},
+			}})
+		}
+		ilst.children = items
+	}
+
+	// offsets into boxes after moov move by the change of its
+	// size, which shifting them does not change
+	delta := int64(len(moov.encode())) - (box.end - box.pos)
+	if delta != 0 {
+		err = shiftChunkOffsets(moov, box.end, delta)
+		if err != nil {
+			return err
+		}
+	}
+	return replaceRange(src, dst, box.pos, box.end, moov.encode())
+}
+
+// shiftChunkOffsets adds delta to the chunk offsets of all
+// tracks that point at or after from.
+func shiftChunkOffsets(moov *mp4Node, from, delta int64) error {
+	for _, trak := range moov.children {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl := trak
+		for _, typ := range []string{"mdia", "minf", "stbl"} {
+			if stbl = stbl.child(typ); stbl == nil || stbl.leaf {
+				return fmt.Errorf("Cannot parse MP4 file: track without sample table")
+			}
+		}
+		for _, c := range stbl.children {
+			if c.typ != "stco" && c.typ != "co64" {
+				continue
+			}
+			width := 4
+			if c.typ == "co64" {
+				width = 8
+			}
+			if len(c.data) < 8 {
+				return fmt.Errorf("Cannot parse MP4 file: truncated %s box", c.typ)
+			}
+			n := int(binary.BigEndian.Uint32(c.data[4:]))
+			if len(c.data) < 8+n*width {
+				return fmt.Errorf("Cannot parse MP4 file: truncated %s box", c.typ)
+			}
+			// the payload may share memory with the file read,
+			// change a copy
+			c.data = append([]byte{}, c.data...)
+			for i := 0; i < n; i++ {
+				p := c.data[8+i*width:]
+				if width == 4 {
+					off := int64(binary.BigEndian.Uint32(p))
+					if off < from {
+						continue
+					}
+					if off+delta > math.MaxUint32 {
+						return fmt.Errorf("Cannot tag MP4 file: chunk offsets overflow")
+					}
+					binary.BigEndian.PutUint32(p, uint32(off+delta))
+				} else if off := int64(binary.BigEndian.Uint64(p)); off >= from {
+					binary.BigEndian.PutUint64(p, uint64(off+delta))
+				}
+			}
+		}
+	}
+	return nil
+}
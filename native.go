@@ -0,0 +1,162 @@
+package bs1770wrap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/burillo-se/bs1770wrap/native"
+)
+
+// Native is the LoudnessAnalyzer that measures PCM WAV files
+// in process, with the native package, needing no external
+// tools at all. Other formats fail, so it is best followed by
+// other backends in a chain, or preceded by preprocessing
+// that decodes to WAV.
+type Native struct {
+	DSP native.DSP // nil means native.InProcess
+}
+
+// Name implements LoudnessAnalyzer.
+func (Native) Name() string {
+	return "native"
+}
+
+// Analyze implements LoudnessAnalyzer.
+func (n Native) Analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	start := time.Now()
+	cf, err := readChunks(f)
+	if err == nil && cf.magic != "RIFF" {
+		err = fmt.Errorf("Not a RIFF WAVE file")
+	}
+	var format native.Format
+	var data chunk
+	if err == nil {
+		format, data, err = wavFormat(f, cf)
+	}
+	info.Timings.Probe += time.Since(start)
+	if err != nil {
+		return LoudnessData{}, withKind(ErrDecodeFailed, fmt.Errorf("Cannot analyze natively: %v", err))
+	}
+	if info.Media == nil {
+		info.Media = nativeMedia(format)
+	}
+
+	if _, err := f.Seek(data.start, io.SeekStart); err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot read file: %v", err)
+	}
+	start = time.Now()
+	var pcm io.Reader = io.LimitReader(f, data.size)
+	if opts.OnProgress != nil {
+		pcm = &progressReader{Reader: pcm, progress: newProgress(file, float64(data.size), opts)}
+	}
+	r, err := native.Analyze(pcm, format, n.DSP)
+	info.Timings.Analyze += time.Since(start)
+	if err != nil {
+		return LoudnessData{}, withKind(ErrDecodeFailed, fmt.Errorf("Cannot calculate loudness: %v", err))
+	}
+	blocks := NewBlockHistogram(r.Blocks)
+	info.Blocks = &blocks
+	return nativeLoudness(r), nil
+}
+
+// CalculateLoudnessPCM measures a stream of interleaved PCM
+// samples in process, for callers that decode audio
+// themselves.
+func CalculateLoudnessPCM(r io.Reader, format native.Format) (LoudnessData, error) {
+	res, err := native.Analyze(r, format, nil)
+	if err != nil {
+		return LoudnessData{}, err
+	}
+	return nativeLoudness(res), nil
+}
+
+// nativeLoudness converts a native result.
+func nativeLoudness(r native.Result) LoudnessData {
+	return LoudnessData{
+		Integrated: float32(r.Integrated),
+		Peak:       float32(r.TruePeak),
+		Range:      float32(r.Range),
+		Shortterm:  float32(r.Shortterm),
+		Momentary:  float32(r.Momentary),
+		Length:     uint64(r.Length() / time.Microsecond),
+	}
+}
+
+// nativeMedia describes a PCM format.
+func nativeMedia(f native.Format) *MediaInfo {
+	m := &MediaInfo{SampleRate: int(f.Rate), Channels: f.Channels, Encoding: "Signed Integer PCM"}
+	switch f.Encoding {
+	case native.Int16:
+		m.BitDepth = 16
+	case native.Int24:
+		m.BitDepth = 24
+	case native.Int32:
+		m.BitDepth = 32
+	case native.Float32:
+		m.BitDepth, m.Encoding = 32, "Floating Point PCM"
+	case native.Float64:
+		m.BitDepth, m.Encoding = 64, "Floating Point PCM"
+	}
+	return m
+}
+
+// WAV format tags
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xfffe
+)
+
+// wavFormat reads the sample format of a WAV file from its
+// fmt chunk, and finds its data chunk.
+func wavFormat(r io.ReadSeeker, cf chunkFile) (native.Format, chunk, error) {
+	fc, ok := cf.find("fmt ")
+	if !ok {
+		return native.Format{}, chunk{}, fmt.Errorf("no fmt chunk")
+	}
+	data, ok := cf.find("data")
+	if !ok {
+		return native.Format{}, chunk{}, fmt.Errorf("no data chunk")
+	}
+	buf, err := readChunk(r, fc)
+	if err != nil {
+		return native.Format{}, chunk{}, err
+	}
+	if len(buf) < 16 {
+		return native.Format{}, chunk{}, fmt.Errorf("fmt chunk too short")
+	}
+
+	tag := binary.LittleEndian.Uint16(buf)
+	if tag == wavExtensible && len(buf) >= 26 {
+		tag = binary.LittleEndian.Uint16(buf[24:]) // start of the subformat GUID
+	}
+	f := native.Format{
+		Channels: int(binary.LittleEndian.Uint16(buf[2:])),
+		Rate:     float64(binary.LittleEndian.Uint32(buf[4:])),
+	}
+	bits := binary.LittleEndian.Uint16(buf[14:])
+	switch {
+	case tag == wavPCM && bits == 16:
+		f.Encoding = native.Int16
+	case tag == wavPCM && bits == 24:
+		f.Encoding = native.Int24
+	case tag == wavPCM && bits == 32:
+		f.Encoding = native.Int32
+	case tag == wavFloat && bits == 32:
+		f.Encoding = native.Float32
+	case tag == wavFloat && bits == 64:
+		f.Encoding = native.Float64
+	default:
+		return native.Format{}, chunk{}, fmt.Errorf("unsupported sample format %#x with %d bits", tag, bits)
+	}
+	return f, data, nil
+}
@@ -0,0 +1,247 @@
+package native
+
+import (
+	"math"
+	"sort"
+)
+
+// Constants from ITU-R BS.1770-4.
+const (
+	loudnessOffset = -0.691 // dB, block loudness term
+	absoluteGate   = -70.0  // LUFS
+	relativeGate   = -10.0  // LU below absolute-gated loudness
+)
+
+// Constants from EBU Tech 3342, loudness range.
+const (
+	rangeGate = -20.0 // LU below absolute-gated short-term loudness
+	rangeLow  = 0.10  // percentiles of the gated distribution
+	rangeHigh = 0.95
+)
+
+// blocks are 400 ms long and start every 100 ms, short-term
+// windows are 3 s long
+const (
+	hopsPerBlock     = 4
+	hopsPerShortterm = 30
+)
+
+// Meter measures the loudness of a signal fed to it in
+// chunks of any size, keeping the loudness of every gating
+// block. The blocks are also exposed, so that custom
+// statistics and alternative gating schemes can be computed
+// from them.
+type Meter struct {
+	weights []float64
+	filters []Filter
+	peaks   []PeakDetector
+	scratch [][]float64
+
+	rate      float64
+	samples   uint64    // per channel, so far
+	hop       int       // samples per 100 ms
+	pos       int       // samples into the current hop
+	sum       float64   // weighted sum of squares of the current hop
+	hops      []float64 // mean square of the last hops, oldest first
+	blocks    []float64 // loudness of every block, LUFS
+	shortterm []float64 // loudness of every short-term window, LUFS
+	peak      float64   // largest true peak, linear
+}
+
+// NewMeter creates a Meter for a signal with the given
+// sample rate and number of channels, using dsp for the
+// filtering (InProcess if nil). Channels are expected in WAV
+// order; for 5.1 (L, R, C, LFE, Ls, Rs) the LFE is ignored
+// and the surrounds are weighted by 1.41, as BS.1770 has it.
+func NewMeter(rate float64, channels int, dsp DSP) *Meter {
+	if dsp == nil {
+		dsp = InProcess{}
+	}
+	m := &Meter{
+		weights: channelWeights(channels),
+		filters: make([]Filter, channels),
+		peaks:   make([]PeakDetector, channels),
+		scratch: make([][]float64, channels),
+		rate:    rate,
+		hop:     int(math.Round(rate / 10)),
+	}
+	for c := range m.filters {
+		m.filters[c] = dsp.NewKWeighting(rate)
+		m.peaks[c] = dsp.NewPeakDetector(rate)
+	}
+	return m
+}
+
+func channelWeights(channels int) []float64 {
+	weights := make([]float64, channels)
+	for c := range weights {
+		weights[c] = 1
+	}
+	switch channels {
+	case 5: // L, R, C, Ls, Rs
+		weights[3], weights[4] = 1.41, 1.41
+	case 6: // L, R, C, LFE, Ls, Rs
+		weights[3], weights[4], weights[5] = 0, 1.41, 1.41
+	}
+	return weights
+}
+
+// Write feeds the next chunk of the signal, one slice per
+// channel, all of the same length. The samples are not
+// modified.
+func (m *Meter) Write(channels [][]float64) {
+	if len(channels) == 0 {
+		return
+	}
+	n := len(channels[0])
+	for c, samples := range channels {
+		if cap(m.scratch[c]) < n {
+			m.scratch[c] = make([]float64, n)
+		}
+		m.scratch[c] = m.scratch[c][:n]
+		copy(m.scratch[c], samples)
+		m.filters[c].Process(m.scratch[c])
+		m.peak = math.Max(m.peak, m.peaks[c].Peak(samples))
+	}
+	m.samples += uint64(n)
+
+	for i := 0; i < n; i++ {
+		for c, w := range m.weights {
+			x := m.scratch[c][i]
+			m.sum += w * x * x
+		}
+		m.pos++
+		if m.pos < m.hop {
+			continue
+		}
+
+		m.hops = append(m.hops, m.sum/float64(m.hop))
+		m.pos, m.sum = 0, 0
+		if len(m.hops) > hopsPerShortterm {
+			m.hops = m.hops[1:]
+		}
+		if len(m.hops) >= hopsPerBlock {
+			m.blocks = append(m.blocks, loudness(meanOf(m.hops[len(m.hops)-hopsPerBlock:])))
+		}
+		if len(m.hops) == hopsPerShortterm {
+			m.shortterm = append(m.shortterm, loudness(meanOf(m.hops)))
+		}
+	}
+}
+
+func meanOf(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Blocks returns the loudness, in LUFS, of every 400 ms
+// block measured so far, in order; consecutive blocks overlap
+// by 300 ms. These are the momentary loudness values.
+func (m *Meter) Blocks() []float64 {
+	return append([]float64(nil), m.blocks...)
+}
+
+// Gated returns the blocks that pass both the absolute and
+// the relative gate, in order. The integrated loudness is
+// their energy mean.
+func (m *Meter) Gated() []float64 {
+	threshold := energyMean(gate(m.blocks, absoluteGate)) + relativeGate
+	return gate(m.blocks, math.Max(threshold, absoluteGate))
+}
+
+// Integrated returns the gated loudness of the signal so
+// far, or -Inf if no block passes the gates.
+func (m *Meter) Integrated() float64 {
+	return energyMean(m.Gated())
+}
+
+// Shortterm returns the loudness, in LUFS, of every 3 s
+// window measured so far, in order, one every 100 ms.
+func (m *Meter) Shortterm() []float64 {
+	return append([]float64(nil), m.shortterm...)
+}
+
+// Range returns the loudness range, in LU, of the signal so
+// far, as EBU Tech 3342 defines it: the spread between the
+// 10th and 95th percentiles of the gated short-term loudness.
+// It is 0 for signals shorter than 3 s.
+func (m *Meter) Range() float64 {
+	gated := gate(m.shortterm, absoluteGate)
+	threshold := energyMean(gated) + rangeGate
+	gated = gate(gated, threshold)
+	if len(gated) == 0 {
+		return 0
+	}
+	sort.Float64s(gated)
+	return percentile(gated, rangeHigh) - percentile(gated, rangeLow)
+}
+
+// TruePeak returns the largest true peak of the signal so far,
+// over all channels, in dBTP.
+func (m *Meter) TruePeak() float64 {
+	return 20 * math.Log10(m.peak)
+}
+
+// Result returns the measurements of the signal so far.
+func (m *Meter) Result() Result {
+	return Result{
+		Integrated: m.Integrated(),
+		Range:      m.Range(),
+		TruePeak:   m.TruePeak(),
+		Momentary:  maxOf(m.blocks),
+		Shortterm:  maxOf(m.shortterm),
+		Samples:    m.samples,
+		Rate:       m.rate,
+		Blocks:     m.Blocks(),
+	}
+}
+
+// percentile picks the p-quantile of sorted values, nearest
+// rank.
+func percentile(sorted []float64, p float64) float64 {
+	return sorted[int(math.Round(p*float64(len(sorted)-1)))]
+}
+
+// maxOf returns the largest value, or -Inf for none.
+func maxOf(values []float64) float64 {
+	m := math.Inf(-1)
+	for _, v := range values {
+		m = math.Max(m, v)
+	}
+	return m
+}
+
+func loudness(energy float64) float64 {
+	return loudnessOffset + 10*math.Log10(energy)
+}
+
+func energy(lufs float64) float64 {
+	return math.Pow(10, (lufs-loudnessOffset)/10)
+}
+
+// energyMean averages block loudness values in the energy
+// domain, returning -Inf for no blocks.
+func energyMean(blocks []float64) float64 {
+	if len(blocks) == 0 {
+		return math.Inf(-1)
+	}
+	sum := 0.0
+	for _, l := range blocks {
+		sum += energy(l)
+	}
+	return loudness(sum / float64(len(blocks)))
+}
+
+// gate returns the blocks at or above threshold.
+func gate(blocks []float64, threshold float64) []float64 {
+	var passed []float64
+	for _, l := range blocks {
+		if l >= threshold {
+			passed = append(passed, l)
+		}
+	}
+	return passed
+}
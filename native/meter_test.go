@@ -0,0 +1,225 @@
+package native
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// tone is a stretch of a sine on each channel; level is the
+// peak in dBFS of each, or -Inf for silence.
+type tone struct {
+	seconds float64
+	levels  []float64
+}
+
+// stereo returns tones of a stereo sine at the same level on
+// both channels, seconds and levels alternating.
+func stereo(stretches ...float64) []tone {
+	var tones []tone
+	for i := 0; i+1 < len(stretches); i += 2 {
+		tones = append(tones, tone{stretches[i], []float64{stretches[i+1], stretches[i+1]}})
+	}
+	return tones
+}
+
+// render returns the tones as interleaved samples of a sine
+// of freq Hz, starting at phase, in radians.
+func render(rate, freq, phase float64, tones []tone) []float64 {
+	var samples []float64
+	n := 0
+	for _, t := range tones {
+		amps := make([]float64, len(t.levels))
+		for c, l := range t.levels {
+			amps[c] = math.Pow(10, l/20)
+		}
+		for end := n + int(math.Round(t.seconds*rate)); n < end; n++ {
+			x := math.Sin(2*math.Pi*freq*float64(n)/rate + phase)
+			for _, a := range amps {
+				samples = append(samples, a*x)
+			}
+		}
+	}
+	return samples
+}
+
+func TestTech3341Loudness(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		tones []tone
+		want  float64 // integrated, LUFS
+	}{
+		{"case 1", stereo(20, -23), -23},
+		{"case 2", stereo(20, -33), -33},
+		{"case 3", stereo(10, -36, 60, -23, 10, -36), -23},
+		{"case 4", stereo(10, -72, 10, -36, 60, -23, 10, -36, 10, -72), -23},
+		{"case 5", stereo(20, -26, 20.1, -20, 20, -26), -23},
+		{"case 6", []tone{{20, []float64{-28, -28, -24, -30, -30}}}, -23},
+	} {
+		for _, rate := range []float64{44100, 48000} {
+			r := AnalyzeSamples(render(rate, 1000, 0, c.tones), rate, len(c.tones[0].levels), nil)
+			if math.Abs(r.Integrated-c.want) > 0.1 {
+				t.Errorf("%s at %.0f Hz: integrated %.2f LUFS, want %.1f +/- 0.1", c.name, rate, r.Integrated, c.want)
+			}
+		}
+	}
+}
+
+func TestTech3341Shortterm(t *testing.T) {
+	// case 9: every 3 s window holds a cycle of 1.34 s at -20
+	// dBFS and 1.66 s at -30 dBFS
+	var tones []tone
+	for i := 0; i < 20; i++ {
+		tones = append(tones, stereo(1.34, -20, 1.66, -30)...)
+	}
+	m := NewMeter(48000, 2, nil)
+	m.Write(planar(render(48000, 1000, 0, tones), 2))
+	st := m.Shortterm()
+	if len(st) == 0 {
+		t.Fatal("no short-term loudness measured")
+	}
+	for i, l := range st {
+		if math.Abs(l+23) > 0.1 {
+			t.Fatalf("short-term window %d is %.2f LUFS, want -23.0 +/- 0.1", i, l)
+		}
+	}
+}
+
+func TestTech3342Range(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		tones []tone
+		want  float64 // LU
+	}{
+		{"case 1", stereo(20, -20, 20, -30), 10},
+		{"case 2", stereo(20, -20, 20, -15), 5},
+		{"case 3", stereo(20, -40, 20, -20), 20},
+		{"case 4", stereo(20, -50, 20, -35, 20, -20, 20, -35, 20, -50), 15},
+	} {
+		r := AnalyzeSamples(render(48000, 1000, 0, c.tones), 48000, 2, nil)
+		if math.Abs(r.Range-c.want) > 1 {
+			t.Errorf("%s: range %.2f LU, want %.0f +/- 1", c.name, r.Range, c.want)
+		}
+	}
+}
+
+func TestTech3341TruePeak(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		freq, phase float64 // fraction of the rate, degrees
+		level, want float64 // dBFS of the sine, dBTP
+	}{
+		{"case 15", 1. / 4, 0, -6, -6},
+		{"case 16", 1. / 4, 45, -6, -6},
+		{"case 17", 1. / 6, 60, -6, -6},
+		{"case 18", 1. / 8, 67.5, -6, -6},
+		{"case 19", 1. / 4, 45, 3, 3},
+	} {
+		samples := render(48000, c.freq*48000, c.phase*math.Pi/180, stereo(1, c.level))
+		fadeIn(samples, 2, 480)
+		r := AnalyzeSamples(samples, 48000, 2, nil)
+		if d := r.TruePeak - c.want; d > 0.2 || d < -0.4 {
+			t.Errorf("%s: true peak %.2f dBTP, want %.1f +0.2/-0.4", c.name, r.TruePeak, c.want)
+		}
+	}
+}
+
+func TestEdgeCases(t *testing.T) {
+	silence := make([]float64, 2*48000*5)
+	r := AnalyzeSamples(silence, 48000, 2, nil)
+	if !math.IsInf(r.Integrated, -1) || !math.IsInf(r.TruePeak, -1) || !math.IsInf(r.Momentary, -1) || r.Range != 0 {
+		t.Errorf("silence measures %+v, want -Inf levels and no range", r)
+	}
+	if r.Length().Seconds() != 5 || len(r.Blocks) != 47 {
+		t.Errorf("silence of 5 s is %v long with %d blocks, want 5 s and 47", r.Length(), len(r.Blocks))
+	}
+
+	// under a block: no loudness, but a peak
+	short := AnalyzeSamples(render(48000, 1000, 0, stereo(0.3, -23)), 48000, 2, nil)
+	if !math.IsInf(short.Integrated, -1) || !math.IsInf(short.Momentary, -1) || len(short.Blocks) != 0 {
+		t.Errorf("300 ms measure %+v, want no loudness", short)
+	}
+	if math.Abs(short.TruePeak+23) > 0.2 {
+		t.Errorf("300 ms have a true peak of %.2f dBTP, want -23", short.TruePeak)
+	}
+	// a single block, which the meter must not require a
+	// short-term window for
+	block := AnalyzeSamples(render(48000, 1000, 0, stereo(0.4, -23)), 48000, 2, nil)
+	if len(block.Blocks) != 1 || math.Abs(block.Integrated+23) > 0.1 || math.Abs(block.Momentary+23) > 0.1 || block.Range != 0 {
+		t.Errorf("400 ms measure %+v, want one block at -23 LUFS", block)
+	}
+
+	// mono is a single channel: 3 dB below the same sine on
+	// both channels
+	mono := AnalyzeSamples(render(48000, 1000, 0, []tone{{10, []float64{-23}}}), 48000, 1, nil)
+	if math.Abs(mono.Integrated+26.01) > 0.1 {
+		t.Errorf("mono sine at -23 dBFS measures %.2f LUFS, want -26.0", mono.Integrated)
+	}
+	// the LFE of 5.1 does not count
+	lfe := AnalyzeSamples(render(48000, 1000, 0, []tone{{10, []float64{-23, -23, math.Inf(-1), 0, math.Inf(-1), math.Inf(-1)}}}), 48000, 6, nil)
+	if math.Abs(lfe.Integrated+23) > 0.1 {
+		t.Errorf("5.1 with a loud LFE measures %.2f LUFS, want -23", lfe.Integrated)
+	}
+}
+
+func TestAnalyzeEncodings(t *testing.T) {
+	samples := render(48000, 1000, 0, stereo(3, -23))
+	want := AnalyzeSamples(samples, 48000, 2, nil)
+	for _, c := range []struct {
+		enc    Encoding
+		encode func(buf []byte, x float64) []byte
+	}{
+		{Int16, func(buf []byte, x float64) []byte {
+			return binary.LittleEndian.AppendUint16(buf, uint16(int16(math.Round(x*(1<<15-1)))))
+		}},
+		{Int24, func(buf []byte, x float64) []byte {
+			v := int32(math.Round(x * (1<<23 - 1)))
+			return append(buf, byte(v), byte(v>>8), byte(v>>16))
+		}},
+		{Int32, func(buf []byte, x float64) []byte {
+			return binary.LittleEndian.AppendUint32(buf, uint32(int32(math.Round(x*(1<<31-1)))))
+		}},
+		{Float32, func(buf []byte, x float64) []byte {
+			return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(x)))
+		}},
+		{Float64, func(buf []byte, x float64) []byte {
+			return binary.LittleEndian.AppendUint64(buf, math.Float64bits(x))
+		}},
+	} {
+		var pcm []byte
+		for _, x := range samples {
+			pcm = c.encode(pcm, x)
+		}
+		r, err := Analyze(bytes.NewReader(pcm), Format{Rate: 48000, Channels: 2, Encoding: c.enc}, nil)
+		if err != nil {
+			t.Errorf("encoding %d: %v", c.enc, err)
+			continue
+		}
+		if math.Abs(r.Integrated-want.Integrated) > 0.01 || r.Samples != want.Samples {
+			t.Errorf("encoding %d: %.3f LUFS over %d samples, want %.3f over %d", c.enc, r.Integrated, r.Samples, want.Integrated, want.Samples)
+		}
+
+		if _, err := Analyze(bytes.NewReader(pcm[:len(pcm)-1]), Format{Rate: 48000, Channels: 2, Encoding: c.enc}, nil); err == nil {
+			t.Errorf("encoding %d: a truncated frame is not an error", c.enc)
+		}
+	}
+}
+
+// fadeIn ramps the first frames of interleaved samples up:
+// a sine that starts with a step overshoots between samples
+// as it does, which is a true peak of its own.
+func fadeIn(samples []float64, channels, frames int) {
+	for i := 0; i < frames*channels && i < len(samples); i++ {
+		samples[i] *= float64(i/channels) / float64(frames)
+	}
+}
+
+// planar splits interleaved samples by channel.
+func planar(samples []float64, channels int) [][]float64 {
+	p := make([][]float64, channels)
+	for i, x := range samples {
+		p[i%channels] = append(p[i%channels], x)
+	}
+	return p
+}
@@ -0,0 +1,150 @@
+package native
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Result holds the measurements of a signal.
+type Result struct {
+	Integrated float64 // LUFS
+	Range      float64 // LU
+	TruePeak   float64 // dBTP
+	Momentary  float64 // maximum, LUFS
+	Shortterm  float64 // maximum, LUFS
+	Samples    uint64  // per channel
+	Rate       float64
+
+	// Blocks is the loudness of every 400 ms gating block, as
+	// Meter.Blocks returns it.
+	Blocks []float64
+}
+
+// Length returns the duration of the signal.
+func (r Result) Length() time.Duration {
+	if r.Rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(r.Samples) / r.Rate * float64(time.Second))
+}
+
+// Encoding is a sample encoding of interleaved, little-endian
+// PCM.
+type Encoding int
+
+// Supported encodings. Integers are signed, floats span
+// [-1, 1] at full scale.
+const (
+	Int16 Encoding = iota
+	Int24
+	Int32
+	Float32
+	Float64
+)
+
+// size returns the bytes per sample.
+func (e Encoding) size() int {
+	switch e {
+	case Int16:
+		return 2
+	case Int24:
+		return 3
+	case Int32, Float32:
+		return 4
+	case Float64:
+		return 8
+	}
+	return 0
+}
+
+// decode returns the sample at the start of b, scaled to
+// [-1, 1].
+func (e Encoding) decode(b []byte) float64 {
+	switch e {
+	case Int16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case Int24:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+		return float64(v) / (1 << 23)
+	case Int32:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	case Float32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case Float64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// Format describes a PCM stream.
+type Format struct {
+	Rate     float64
+	Channels int
+	Encoding Encoding
+}
+
+// frames read at a time by Analyze
+const readFrames = 4096
+
+// Analyze measures the PCM stream read from r until EOF,
+// using dsp (InProcess if nil). A partial frame at the end is
+// an error.
+func Analyze(r io.Reader, f Format, dsp DSP) (Result, error) {
+	size := f.Encoding.size()
+	if size == 0 || f.Channels <= 0 || f.Rate <= 0 {
+		return Result{}, fmt.Errorf("Invalid PCM format %+v", f)
+	}
+
+	m := NewMeter(f.Rate, f.Channels, dsp)
+	frame := size * f.Channels
+	buf := make([]byte, readFrames*frame)
+	planar := make([][]float64, f.Channels)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n%frame != 0 {
+			return Result{}, fmt.Errorf("Truncated PCM stream: %d bytes of a frame left over", n%frame)
+		}
+		frames := n / frame
+		for c := range planar {
+			if planar[c] == nil {
+				planar[c] = make([]float64, readFrames)
+			}
+			planar[c] = planar[c][:frames]
+			for i := range planar[c] {
+				planar[c][i] = f.Encoding.decode(buf[i*frame+c*size:])
+			}
+		}
+		if frames > 0 {
+			m.Write(planar)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return m.Result(), nil
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("Cannot read PCM stream: %v", err)
+		}
+	}
+}
+
+// AnalyzeSamples measures interleaved samples in [-1, 1],
+// using dsp (InProcess if nil).
+func AnalyzeSamples(samples []float64, rate float64, channels int, dsp DSP) Result {
+	if channels <= 0 {
+		return Result{Integrated: math.Inf(-1), TruePeak: math.Inf(-1), Momentary: math.Inf(-1), Shortterm: math.Inf(-1)}
+	}
+	m := NewMeter(rate, channels, dsp)
+	frames := len(samples) / channels
+	planar := make([][]float64, channels)
+	for c := range planar {
+		planar[c] = make([]float64, frames)
+		for i := range planar[c] {
+			planar[c][i] = samples[i*channels+c]
+		}
+	}
+	m.Write(planar)
+	return m.Result()
+}
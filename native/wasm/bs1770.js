@@ -0,0 +1,49 @@
+// bs1770.js loads bs1770.wasm, built from this directory, and
+// wraps its functions so they throw on errors. It needs the
+// Go class of wasm_exec.js, from $(go env GOROOT)/lib/wasm,
+// loaded beforehand.
+//
+//   const bs1770 = await load("bs1770.wasm");
+//
+//   // a whole signal of interleaved little-endian PCM, in a
+//   // Uint8Array; encoding is int16, int24, int32, float32 or
+//   // float64
+//   const r = bs1770.analyze(pcm, {rate: 48000, channels: 2, encoding: "int16"});
+//
+//   // or one Float32Array per channel at a time
+//   const m = bs1770.newMeter(48000, 2);
+//   m.write([left, right]);
+//   const r = m.result();
+//
+// Results are {integrated, range, truePeak, momentary,
+// shortterm, samples, rate}, levels in LUFS and dBTP, null for
+// silence; momentary and shortterm are maxima.
+
+function check(v) {
+  if (v && v.error !== undefined) {
+    throw new Error(v.error);
+  }
+  return v;
+}
+
+// load instantiates the module, given its URL or its bytes,
+// and resolves to the API once it has started.
+export async function load(source = "bs1770.wasm") {
+  const go = new Go();
+  const result = typeof source === "string" || source instanceof URL
+    ? await WebAssembly.instantiateStreaming(fetch(source), go.importObject)
+    : await WebAssembly.instantiate(source, go.importObject);
+  go.run(result.instance);
+
+  const api = globalThis.bs1770;
+  return {
+    analyze: (pcm, format) => check(api.analyze(pcm, format)),
+    newMeter: (rate, channels) => {
+      const m = check(api.newMeter(rate, channels));
+      return {
+        write: (channels) => { check(m.write(channels)); },
+        result: () => m.result(),
+      };
+    },
+  };
+}
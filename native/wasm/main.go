@@ -0,0 +1,162 @@
+//go:build js && wasm
+
+// Command wasm exposes the native engine to JavaScript, for
+// browser and Electron tools that want the very measurements
+// the package makes. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o bs1770.wasm ./native/wasm
+//
+// and load it with bs1770.js, next to it, which also documents
+// the API. The command registers a global bs1770 object and
+// then waits forever, as Go programs in the browser do.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"syscall/js"
+
+	"github.com/burillo-se/bs1770wrap/native"
+)
+
+var encodings = map[string]native.Encoding{
+	"int16":   native.Int16,
+	"int24":   native.Int24,
+	"int32":   native.Int32,
+	"float32": native.Float32,
+	"float64": native.Float64,
+}
+
+func main() {
+	js.Global().Set("bs1770", js.ValueOf(map[string]interface{}{
+		"analyze":  safely(analyze),
+		"newMeter": safely(newMeter),
+	}))
+	select {}
+}
+
+// failure is what the functions return instead of throwing,
+// which Go cannot do; bs1770.js throws it.
+func failure(err error) interface{} {
+	return map[string]interface{}{"error": err.Error()}
+}
+
+// safely wraps f so that a panic, such as a js.Value of the
+// wrong type being used, is returned as a failure instead of
+// bringing down the program.
+func safely(f func(js.Value, []js.Value) interface{}) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) (v interface{}) {
+		defer func() {
+			if p := recover(); p != nil {
+				v = failure(fmt.Errorf("Invalid arguments: %v", p))
+			}
+		}()
+		return f(this, args)
+	})
+}
+
+// result converts a result, using null for -Inf, which
+// silence measures.
+func result(r native.Result) interface{} {
+	level := func(v float64) interface{} {
+		if math.IsInf(v, -1) {
+			return nil
+		}
+		return v
+	}
+	return map[string]interface{}{
+		"integrated": level(r.Integrated),
+		"range":      r.Range,
+		"truePeak":   level(r.TruePeak),
+		"momentary":  level(r.Momentary),
+		"shortterm":  level(r.Shortterm),
+		"samples":    float64(r.Samples),
+		"rate":       r.Rate,
+	}
+}
+
+// bytesOf copies the contents of a typed array.
+func bytesOf(a js.Value) ([]byte, error) {
+	if !js.Global().Get("ArrayBuffer").Call("isView", a).Bool() {
+		return nil, fmt.Errorf("Expected a typed array, got %s", a.Type())
+	}
+	view := js.Global().Get("Uint8Array").New(a.Get("buffer"), a.Get("byteOffset"), a.Get("byteLength"))
+	buf := make([]byte, view.Length())
+	js.CopyBytesToGo(buf, view)
+	return buf, nil
+}
+
+// analyze(pcm, {rate, channels, encoding}) measures a
+// Uint8Array of interleaved little-endian PCM.
+func analyze(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return failure(fmt.Errorf("analyze takes the PCM data and its format"))
+	}
+	f := native.Format{
+		Rate:     args[1].Get("rate").Float(),
+		Channels: args[1].Get("channels").Int(),
+	}
+	enc, ok := encodings[args[1].Get("encoding").String()]
+	if !ok {
+		return failure(fmt.Errorf("Unknown encoding %q", args[1].Get("encoding").String()))
+	}
+	f.Encoding = enc
+
+	pcm, err := bytesOf(args[0])
+	if err != nil {
+		return failure(err)
+	}
+	r, err := native.Analyze(bytes.NewReader(pcm), f, nil)
+	if err != nil {
+		return failure(err)
+	}
+	return result(r)
+}
+
+// newMeter(rate, channels) returns a meter that is fed one
+// Float32Array per channel at a time, as Web Audio hands them
+// out, with write(channels), and measured with result().
+func newMeter(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return failure(fmt.Errorf("newMeter takes the sample rate and channel count"))
+	}
+	rate, channels := args[0].Float(), args[1].Int()
+	if rate <= 0 || channels <= 0 {
+		return failure(fmt.Errorf("Invalid format: %v Hz, %d channels", rate, channels))
+	}
+	m := native.NewMeter(rate, channels, nil)
+
+	planar := make([][]float64, channels)
+	write := safely(func(this js.Value, args []js.Value) interface{} {
+		if len(args) != 1 || args[0].Length() != channels {
+			return failure(fmt.Errorf("write takes an array of %d channels", channels))
+		}
+		frames := -1
+		for c := range planar {
+			a := args[0].Index(c)
+			if !a.InstanceOf(js.Global().Get("Float32Array")) {
+				return failure(fmt.Errorf("Expected a Float32Array for channel %d", c))
+			}
+			buf, err := bytesOf(a)
+			if err != nil {
+				return failure(err)
+			}
+			if frames >= 0 && len(buf)/4 != frames {
+				return failure(fmt.Errorf("Channels differ in length"))
+			}
+			frames = len(buf) / 4
+			planar[c] = planar[c][:0]
+			for i := 0; i < frames; i++ {
+				planar[c] = append(planar[c], float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))))
+			}
+		}
+		m.Write(planar)
+		return nil
+	})
+	return js.ValueOf(map[string]interface{}{
+		"write":  write,
+		"result": js.FuncOf(func(js.Value, []js.Value) interface{} { return result(m.Result()) }),
+	})
+}
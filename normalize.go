@@ -0,0 +1,238 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// NormalizeMode selects what Normalize adjusts the gain by.
+type NormalizeMode int
+
+const (
+	// NormalizeLoudness brings the integrated loudness to the
+	// target, in LUFS.
+	NormalizeLoudness NormalizeMode = iota
+
+	// NormalizePeak brings the true peak to the target, in
+	// dBTP. This suits sound effects and jingles, which are
+	// too short or too sparse for loudness to mean much.
+	NormalizePeak
+)
+
+// NormalizeOptions tunes Normalize. Options are used for the
+// analysis and the tools spawned.
+type NormalizeOptions struct {
+	Mode   NormalizeMode
+	Target float64
+
+	// PeakLimit, if set, is the true peak ceiling, in dBTP, a
+	// limiter holds the output under when the gain would push
+	// it higher, as raising quiet material often does.
+	PeakLimit *float64
+
+	// Codec is the encoding of the output, which ffmpeg then
+	// writes; nil means sox writes it, in the format of the
+	// extension of the output file.
+	Codec *Codec
+
+	Options Options
+}
+
+// NormalizeResult describes a normalization.
+type NormalizeResult struct {
+	Before  LoudnessData // measurement of the input
+	Output  string       // file written, which a hook may have changed
+	Gain    float64      // dB applied
+	Limited bool         // a limiter held the peaks under NormalizeOptions.PeakLimit
+	Info    AnalysisInfo
+}
+
+// Gain returns the gain, in dB, that normalizes ld according
+// to the options.
+func (n NormalizeOptions) Gain(ld LoudnessData) float64 {
+	if n.Mode == NormalizePeak {
+		return n.Target - float64(ld.Peak)
+	}
+	return n.Target - float64(ld.Integrated)
+}
+
+// Normalize measures file and writes a copy of it with the
+// normalizing gain applied to outFile, which must differ from
+// file. The gain is applied with sox's gain effect; ffmpeg is
+// only needed to encode with nopts.Codec, as sox knows none of
+// its encoders. Silent files, which no gain normalizes, are
+// refused. It fails with ErrReadOnly in read-only mode. Targets such as ReferenceEBUR128 or
+// ReferenceReplayGain2 are common choices. Hooks of
+// nopts.Options run around it as StageNormalize.
+func Normalize(file, outFile string, nopts NormalizeOptions) (NormalizeResult, error) {
+	opts := nopts.Options
+	if opts.ReadOnly {
+		return NormalizeResult{}, ErrReadOnly
+	}
+	var r NormalizeResult
+	err := withHooks(opts, &HookEvent{Stage: StageNormalize, File: file, Output: outFile}, func(e *HookEvent) error {
+		var err error
+		r, err = normalize(file, e.Output, nopts)
+		r.Output = e.Output
+		e.Info = &r.Info
+		e.Gain = r.Gain
+		if err == nil {
+			e.Loudness = &r.Before
+		}
+		return err
+	})
+	if err != nil {
+		return NormalizeResult{}, err
+	}
+	return r, nil
+}
+
+func normalize(file, outFile string, nopts NormalizeOptions) (NormalizeResult, error) {
+	opts := nopts.Options
+	if samePath(file, outFile) {
+		return NormalizeResult{}, fmt.Errorf("Cannot normalize %s onto itself", file)
+	}
+
+	ld, info, err := CalculateLoudnessWithOptions(file, opts)
+	if err != nil {
+		return NormalizeResult{}, err
+	}
+	r := NormalizeResult{Before: ld, Gain: nopts.Gain(ld), Info: info}
+	if math.IsInf(r.Gain, 0) || math.IsNaN(r.Gain) {
+		return NormalizeResult{}, fmt.Errorf("Cannot normalize %s: it is silent", file)
+	}
+	limit := nopts.PeakLimit
+	r.Limited = limit != nil && float64(ld.Peak)+r.Gain > *limit
+
+	codec := Codec{}
+	if nopts.Codec != nil {
+		codec = *nopts.Codec
+		filter := gainFilter(r.Gain)
+		if r.Limited {
+			rate, _, err := streamFormat(file, opts, &r.Info)
+			if err != nil {
+				return NormalizeResult{}, err
+			}
+			filter += "," + truePeakLimiter(*limit, rate)
+		}
+		err = renderFilter(file, outFile, filter, codec, opts, &r.Info)
+	} else {
+		effects := []string{"gain", strconv.FormatFloat(r.Gain, 'f', 2, 64)}
+		if r.Limited {
+			rate, err := soxRate(file, opts, &r.Info)
+			if err != nil {
+				return NormalizeResult{}, err
+			}
+			effects = soxTruePeakLimiter(r.Gain, *limit, rate)
+		}
+		err = renderGain(file, outFile, effects, opts, &r.Info)
+	}
+	if err != nil {
+		return NormalizeResult{}, err
+	}
+	return r, auditRender(file, outFile, r.Gain, codec, opts)
+}
+
+// auditRender records the writing of out from file with gain
+// applied, as a re-encoding if codec names an encoder.
+func auditRender(file, out string, gain float64, codec Codec, opts Options) error {
+	after := map[string]string{"gain": strconv.FormatFloat(gain, 'f', 2, 64)}
+	op := AuditApplyGain
+	if codec.Encoder != "" {
+		op = AuditReencode
+		after["encoder"] = codec.Encoder
+		if codec.Bitrate != "" {
+			after["bitrate"] = codec.Bitrate
+		}
+	}
+	return audit(opts, AuditEntry{
+		Operation: op,
+		File:      out,
+		Before:    map[string]string{"source": file},
+		After:     after,
+	})
+}
+
+// gainFilter returns the ffmpeg filter applying gain dB.
+func gainFilter(gain float64) string {
+	return "volume=" + strconv.FormatFloat(gain, 'f', 2, 64) + "dB"
+}
+
+// truePeakOversampling is how much the signal is upsampled
+// for limiting, so that peaks between samples are caught
+const truePeakOversampling = 4
+
+// truePeakLimiter returns the ffmpeg filters limiting a signal
+// at rate to the true peak ceiling limit, in dBTP. alimiter
+// only sees samples, so it runs on the oversampled signal.
+func truePeakLimiter(limit float64, rate int) string {
+	return fmt.Sprintf("aresample=%d,alimiter=limit=%.4f:level=0,aresample=%d",
+		rate*truePeakOversampling, DBToLinear(limit), rate)
+}
+
+// soxTruePeakLimiter returns the sox effects applying gain
+// dB to a signal at rate with its true peaks held under the
+// ceiling limit, in dBTP. The limiter of the gain effect holds
+// samples under 0 dBFS, so it runs on the oversampled signal
+// raised by the headroom the ceiling leaves, which is taken
+// off again after.
+func soxTruePeakLimiter(gain, limit float64, rate int) []string {
+	db := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	return []string{
+		"rate", "-v", strconv.Itoa(rate * truePeakOversampling),
+		"gain", "-l", db(gain - limit),
+		"gain", db(limit),
+		"rate", "-v", strconv.Itoa(rate),
+	}
+}
+
+// soxRate returns the sample rate of file, as the analysis
+// found it or else as sox reads it.
+func soxRate(file string, opts Options, info *AnalysisInfo) (int, error) {
+	if info.Media == nil {
+		if _, err := soxLength(file, opts, info); err != nil {
+			return 0, fmt.Errorf("Cannot probe audio format: %w", err)
+		}
+	}
+	if info.Media == nil || info.Media.SampleRate <= 0 {
+		return 0, fmt.Errorf("Cannot probe audio format: no sample rate")
+	}
+	return info.Media.SampleRate, nil
+}
+
+// renderGain writes file through the sox effects to out.
+func renderGain(file, out string, effects []string, opts Options, info *AnalysisInfo) error {
+	return createFile(out, opts, func(dst string) error {
+		var stderr bytes.Buffer
+
+		cmd := exec.Command("sox", append([]string{toolPath(file), toolPath(dst)}, effects...)...)
+		cmd.Stderr = &stderr
+
+		err := run("sox", cmd, opts, info)
+		if err != nil {
+			return fmt.Errorf("Cannot apply gain: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	})
+}
+
+// renderFilter writes file through the ffmpeg audio filter
+// to out.
+func renderFilter(file, out, filter string, codec Codec, opts Options, info *AnalysisInfo) error {
+	return createFile(out, opts, func(dst string) error {
+		var stderr bytes.Buffer
+
+		cmd := exec.Command("ffmpeg", encodeArgs(file, dst, codec, filter)...)
+		cmd.Stderr = &stderr
+
+		err := run("ffmpeg", cmd, opts, info)
+		if err != nil {
+			return fmt.Errorf("Cannot apply gain: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,151 @@
+package bs1770wrap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAudited(t *testing.T) {
+	dir := t.TempDir()
+	file, out := filepath.Join(dir, "in.wav"), filepath.Join(dir, "out.wav")
+	sineWAV(t, file, 0.5, 1)
+	log := &FileAuditLog{Path: filepath.Join(dir, "audit.log")}
+
+	var rendered []string
+	opts := Options{
+		Backends: []LoudnessAnalyzer{Native{}},
+		Audit:    log,
+		Operator: "tester",
+		Runner: RunnerFunc(func(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+			rendered = append(rendered, cmd)
+			return nil, nil, os.WriteFile(renderedFile(cmd, args), nil, 0644)
+		}),
+	}
+	for _, codec := range []*Codec{nil, &CodecOpus64} {
+		_, err := Normalize(file, out, NormalizeOptions{Target: ReferenceEBUR128, Codec: codec, Options: opts})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rendered) != 2 || rendered[0] != "sox" || rendered[1] != "ffmpeg" {
+		t.Fatalf("rendered with %q, want sox, then ffmpeg for the codec", rendered)
+	}
+
+	entries, err := log.Query(AuditFilter{File: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries for %s, want 2", len(entries), out)
+	}
+	want := []string{AuditApplyGain, AuditReencode}
+	for i, e := range entries {
+		if e.Operation != want[i] || e.Operator != "tester" || e.Before["source"] != file || e.After["gain"] == "" {
+			t.Errorf("entry %d is %+v, want a %s of %s", i, e, want[i], file)
+		}
+	}
+	if entries[1].After["encoder"] != CodecOpus64.Encoder {
+		t.Errorf("re-encoding recorded with encoder %q, want %q", entries[1].After["encoder"], CodecOpus64.Encoder)
+	}
+}
+
+// renderedFile returns the output file of a rendering cmd.
+func renderedFile(cmd string, args []string) string {
+	if cmd == "sox" {
+		return args[1]
+	}
+	return strings.TrimPrefix(args[len(args)-1], "file:")
+}
+
+func TestNormalizeRender(t *testing.T) {
+	dir := t.TempDir()
+	file, out := filepath.Join(dir, "in.wav"), filepath.Join(dir, "out.wav")
+	sineWAV(t, file, 0.5, 1)
+	before, _, err := CalculateLoudnessWithOptions(file, Options{Backends: []LoudnessAnalyzer{Native{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ceiling := float64(before.Peak) + 1
+
+	for _, c := range []struct {
+		name    string
+		target  float64
+		limit   *float64
+		effects string
+	}{
+		{"gain", float64(before.Integrated) - 3, nil, "gain -3.00"},
+		{"under the ceiling", float64(before.Integrated) + 0.5, &ceiling, "gain 0.50"},
+		{"limited", float64(before.Integrated) + 3, &ceiling, fmt.Sprintf("rate -v 192000 gain -l %.2f gain %.2f rate -v 48000", 3-ceiling, ceiling)},
+	} {
+		var effects string
+		opts := Options{
+			Backends: []LoudnessAnalyzer{Native{}},
+			Runner: RunnerFunc(func(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+				if cmd != "sox" {
+					t.Fatalf("%s: ran %s, want sox", c.name, cmd)
+				}
+				dst := renderedFile(cmd, args)
+				if dst == out || filepath.Dir(dst) != dir {
+					t.Errorf("%s: sox writes %s, want a scratch file next to %s", c.name, dst, out)
+				}
+				effects = strings.Join(args[2:], " ")
+				return nil, nil, os.WriteFile(dst, []byte(c.name), 0644)
+			}),
+		}
+		r, err := Normalize(file, out, NormalizeOptions{Target: c.target, PeakLimit: c.limit, Options: opts})
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if effects != c.effects {
+			t.Errorf("%s: sox effects %q, want %q", c.name, effects, c.effects)
+		}
+		if r.Limited != (c.limit != nil && c.target > float64(before.Integrated)+1) {
+			t.Errorf("%s: Limited is %v", c.name, r.Limited)
+		}
+		if buf, _ := os.ReadFile(out); string(buf) != c.name {
+			t.Errorf("%s: %s holds %q, not what sox rendered", c.name, out, buf)
+		}
+	}
+
+	// a failed rendering leaves the output as it was
+	failing := Options{
+		Backends: []LoudnessAnalyzer{Native{}},
+		Runner: RunnerFunc(func(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+			os.WriteFile(renderedFile(cmd, args), []byte("partial"), 0644)
+			return nil, nil, errors.New("failed")
+		}),
+	}
+	if _, err := Normalize(file, out, NormalizeOptions{Target: -23, Options: failing}); err == nil {
+		t.Error("Normalize succeeded with sox failing")
+	}
+	if buf, _ := os.ReadFile(out); string(buf) != "limited" {
+		t.Errorf("a failed rendering left %q in %s", buf, out)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Errorf("a failed rendering left %d files behind", len(files)-2)
+	}
+}
+
+func TestNormalizeRefusesSilence(t *testing.T) {
+	dir := t.TempDir()
+	file, out := filepath.Join(dir, "in.wav"), filepath.Join(dir, "out.wav")
+	sineWAV(t, file, 0, 1)
+	opts := Options{
+		Backends: []LoudnessAnalyzer{Native{}},
+		Runner: RunnerFunc(func(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+			t.Errorf("rendered silence with %s %q", cmd, args)
+			return nil, nil, nil
+		}),
+	}
+	for _, mode := range []NormalizeMode{NormalizeLoudness, NormalizePeak} {
+		if _, err := Normalize(file, out, NormalizeOptions{Mode: mode, Target: -23, Options: opts}); err == nil {
+			t.Errorf("mode %d: normalized a silent file", mode)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package bs1770wrap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Internet radio dumps and some streaming tools produce
+// chained Ogg files: several complete Ogg streams, each with
+// its own headers, one after the other. Decoders play them
+// through, but the links may differ in everything from
+// channel count to loudness, so a single measurement of the
+// whole file says little. CalculateChainedLoudness measures
+// each link separately.
+//
+// An Ogg page starts with a 27 byte header: "OggS", version,
+// flags (0x02 marks the first page of a stream), granule
+// position, serial, sequence number, CRC, and the number of
+// segments, whose sizes follow. A link starts with the first
+// pages of all streams multiplexed into it.
+
+// Ogg page flags
+const (
+	oggContinued = 0x01
+	oggBOS       = 0x02
+)
+
+// oggPage is a page of an Ogg stream.
+type oggPage struct {
+	flags    byte
+	granule  uint64
+	serial   uint32
+	seq      uint32
+	segments []byte // lacing values
+	body     []byte
+}
+
+// oggCRC is the CRC-32 of Ogg pages: polynomial 0x04c11db7,
+// not reflected, no initial or final XOR.
+var oggCRC = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// readOggPage reads the page at the current position of r,
+// returning its size; io.EOF means there are no more pages.
+func readOggPage(r io.Reader) (oggPage, int64, error) {
+	hdr := make([]byte, 27)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return oggPage{}, 0, err
+	}
+	if string(hdr[:4]) != "OggS" {
+		return oggPage{}, 0, fmt.Errorf("no Ogg page")
+	}
+	p := oggPage{
+		flags:    hdr[5],
+		granule:  binary.LittleEndian.Uint64(hdr[6:]),
+		serial:   binary.LittleEndian.Uint32(hdr[14:]),
+		seq:      binary.LittleEndian.Uint32(hdr[18:]),
+		segments: make([]byte, hdr[26]),
+	}
+	if _, err := io.ReadFull(r, p.segments); err != nil {
+		return oggPage{}, 0, io.ErrUnexpectedEOF
+	}
+	size := 0
+	for _, s := range p.segments {
+		size += int(s)
+	}
+	p.body = make([]byte, size)
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return oggPage{}, 0, io.ErrUnexpectedEOF
+	}
+	return p, int64(27 + len(p.segments) + size), nil
+}
+
+// encode returns the page with its CRC.
+func (p oggPage) encode() []byte {
+	buf := append([]byte("OggS"), 0, p.flags)
+	buf = binary.LittleEndian.AppendUint64(buf, p.granule)
+	buf = binary.LittleEndian.AppendUint32(buf, p.serial)
+	buf = binary.LittleEndian.AppendUint32(buf, p.seq)
+	buf = append(buf, 0, 0, 0, 0, byte(len(p.segments)))
+	buf = append(buf, p.segments...)
+	buf = append(buf, p.body...)
+
+	crc := uint32(0)
+	for _, b := range buf {
+		crc = crc<<8 ^ oggCRC[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(buf[22:], crc)
+	return buf
+}
+
+// oggPaginate lays header packets out on pages numbered from
+// seq, the last packet finishing the last page. Pages on
+// which no packet finishes have no granule position.
+func oggPaginate(packets [][]byte, serial, seq uint32) []oggPage {
+	var pages []oggPage
+	page := oggPage{serial: serial, seq: seq, granule: ^uint64(0)}
+	for _, packet := range packets {
+		for started := false; ; started = true {
+			if len(page.segments) == 255 {
+				pages = append(pages, page)
+				page = oggPage{serial: serial, seq: page.seq + 1, granule: ^uint64(0)}
+				if started {
+					page.flags = oggContinued
+				}
+			}
+			n := len(packet)
+			if n > 255 {
+				n = 255
+			}
+			page.segments = append(page.segments, byte(n))
+			page.body = append(page.body, packet[:n]...)
+			packet = packet[n:]
+			if n < 255 {
+				page.granule = 0
+				break
+			}
+		}
+	}
+	return append(pages, page)
+}
+
+// ChainLink is a link of a chained Ogg file and its
+// measurement.
+type ChainLink struct {
+	Start, End int64 // byte range in the file
+	Loudness   LoudnessData
+	Info       AnalysisInfo
+	Err        error // analysis failure, Loudness is then zero
+}
+
+// oggLinks returns the byte ranges of the links of an Ogg
+// file, or nil if it is not one.
+func oggLinks(file string) ([][2]int64, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read file: %v", err)
+	}
+
+	// dumps often end in a partial page, which belongs to the
+	// last link
+	var links [][2]int64
+	hdr := make([]byte, 27)
+	segments := make([]byte, 255)
+	pos := int64(0)
+	prevBOS := false
+	for pos < end {
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("Cannot read file: %v", err)
+		}
+		_, err := io.ReadFull(f, hdr)
+		if err != nil && pos > 0 {
+			break
+		}
+		if err != nil || string(hdr[:4]) != "OggS" {
+			if pos == 0 {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("Cannot parse Ogg page at byte %d", pos)
+		}
+
+		n := int(hdr[26])
+		if _, err := io.ReadFull(f, segments[:n]); err != nil {
+			break
+		}
+		size := int64(27 + n)
+		for _, s := range segments[:n] {
+			size += int64(s)
+		}
+
+		bos := hdr[5]&oggBOS != 0
+		if bos && !prevBOS {
+			if len(links) > 0 {
+				links[len(links)-1][1] = pos
+			}
+			links = append(links, [2]int64{pos, 0})
+		}
+		prevBOS = bos
+		pos += size
+	}
+	if len(links) > 0 {
+		links[len(links)-1][1] = end
+	}
+	return links, nil
+}
+
+// CalculateChainedLoudness measures each link of a chained
+// Ogg file on its own, in order. A file with a single link,
+// or that is not an Ogg file, is measured as a whole. A link
+// that fails is reported in its ChainLink; an error is only
+// returned if the file cannot be split.
+func CalculateChainedLoudness(file string, opts Options) ([]ChainLink, error) {
+	links, err := oggLinks(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) <= 1 {
+		ld, info, err := calculateSafely(file, opts)
+		l := ChainLink{Loudness: ld, Info: info, Err: err}
+		if len(links) == 1 {
+			l.Start, l.End = links[0][0], links[0][1]
+		}
+		return []ChainLink{l}, nil
+	}
+
+	dir, err := os.MkdirTemp(opts.TempDir, "bs1770wrap")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	result := make([]ChainLink, len(links))
+	for i, r := range links {
+		l := ChainLink{Start: r[0], End: r[1]}
+		// keep the extension, tools pick the format by it
+		part := filepath.Join(dir, fmt.Sprintf("link%d%s", i, filepath.Ext(file)))
+		l.Err = copyRange(file, part, r[0], r[1])
+		if l.Err == nil {
+			l.Loudness, l.Info, l.Err = calculateSafely(part, opts)
+		}
+		result[i] = l
+		os.Remove(part)
+	}
+	return result, nil
+}
+
+// copyRange copies the bytes [start, end) of src into dst.
+func copyRange(src, dst string, start, end int64) error {
+	openFiles.acquire(nil)
+	defer openFiles.release()
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %v", err)
+	}
+	_, err = in.Seek(start, io.SeekStart)
+	if err == nil {
+		_, err = io.CopyN(out, in, end-start)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %v", err)
+	}
+	return nil
+}
+
+// replaceRange writes src to dst with the bytes [start, end)
+// replaced by data.
+func replaceRange(src, dst string, start, end int64, data []byte) error {
+	openFiles.acquire(nil)
+	defer openFiles.release()
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot create file: %v", err)
+	}
+	_, err = io.CopyN(out, in, start)
+	if err == nil {
+		_, err = out.Write(data)
+	}
+	if err == nil {
+		_, err = in.Seek(end, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(out, in)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package bs1770wrap
+
+import (
+	"context"
+	"time"
+)
+
+// Options tunes a single analysis. The zero value analyzes
+// the file exactly like CalculateLoudness does.
+type Options struct {
+	// Backends is the ordered list of analyzers to try; if one
+	// fails (including because its tools are missing), the
+	// next one is tried. Empty means DefaultBackends.
+	Backends []LoudnessAnalyzer
+
+	// VerifyWith, if set, is run on the file as well and its
+	// results are compared with those of the backend that
+	// produced the result. Differences larger than
+	// VerifyTolerance LU (DefaultVerifyTolerance if zero) are
+	// reported as warnings, or fail the analysis if
+	// VerifyStrict is set.
+	VerifyWith      LoudnessAnalyzer
+	VerifyTolerance float64
+	VerifyStrict    bool
+
+	// Calibration maps backend names to an offset, in LU,
+	// added to the loudness levels (integrated, short-term and
+	// momentary) that backend measures. It reconciles small
+	// systematic differences between analyzers; range and
+	// true peak are left alone.
+	Calibration map[string]float32
+
+	// Highpass, if non-zero, is the cutoff in Hz of a sox
+	// highpass filter applied before measuring, so bass-heavy
+	// material doesn't skew the numbers (see DefaultHighpass).
+	//
+	// Effects is an arbitrary sox effect chain, given as it
+	// would be on the sox command line (e.g. "equalizer",
+	// "1000", "1q", "-3"), applied after the highpass.
+	// FilterGraph is an ffmpeg audio filtergraph to use instead
+	// of sox effects. See ValidateEffects.
+	//
+	// RoundTrip, if set, has the file encoded with the given
+	// codec and decoded again before measuring, approximating
+	// what a platform's transcode does to loudness and,
+	// mostly, true peak. It happens before the other
+	// preprocessing and requires ffmpeg.
+	//
+	// DialogueGate, if set, restricts the measurement to the
+	// parts of the file the detector finds speech in (see
+	// EnergyVAD), after all other preprocessing; the reported
+	// length is then that of the speech. It requires sox and
+	// ffmpeg.
+	//
+	// Preprocessing requires the tool to be able to decode the
+	// file, and does not apply to directories. Each stage
+	// writes a scratch file under TempDir for the next, rather
+	// than piping into it: backends measure files, and
+	// containers such as MP4 cannot be written to a pipe. With
+	// MeasureUnfiltered the original signal is measured too,
+	// and returned in AnalysisInfo.Unfiltered.
+	Highpass          float64
+	Effects           []string
+	FilterGraph       string
+	RoundTrip         *Codec
+	DialogueGate      VoiceActivityDetector
+	MeasureUnfiltered bool
+
+	// Inspect has the file checked for structural problems
+	// (see Inspect) before it is measured, reporting them in
+	// AnalysisInfo.Issues. RepairInput additionally works
+	// around what can be in a scratch copy, which is then
+	// measured instead. Both require ffmpeg.
+	Inspect     bool
+	RepairInput bool
+
+	// RemuxOnError has a failed analysis retried on a copy of
+	// the file remuxed by ffmpeg, if ffprobe finds fault with
+	// its container. AnalysisInfo.Remuxed is then set.
+	RemuxOnError bool
+
+	// KeepPadding leaves the encoder delay and padding of
+	// lossy files (see Gapless) in the measurement and length,
+	// instead of excluding them.
+	KeepPadding bool
+
+	// TempDir is where scratch files go; empty means the
+	// system default.
+	TempDir string
+
+	// ReadOnly makes every operation that would modify files
+	// fail with ErrReadOnly. See also the ReadOnly type.
+	ReadOnly bool
+
+	// LockTimeout is how long to wait for another worker to
+	// finish modifying a file before giving up with ErrLocked,
+	// and LockStale the age after which a lock is considered
+	// abandoned; zero means DefaultLockTimeout and
+	// DefaultLockStale.
+	LockTimeout time.Duration
+	LockStale   time.Duration
+
+	// Audit, if set, receives an entry for every modification
+	// made to a file, attributed to Operator (the current OS
+	// user if empty).
+	Audit    AuditLog
+	Operator string
+
+	// Logger, if set, receives log messages up to LogLevel.
+	// At LogTrace, if TraceDir is set, the command line and
+	// output of every tool an analysis spawns are saved in a
+	// directory of their own under it, see
+	// AnalysisInfo.TraceDir.
+	Logger   Logger
+	LogLevel LogLevel
+	TraceDir string
+
+	// MemoryLimit caps the resident memory, in bytes, of each
+	// spawned tool; zero means no limit. On Linux tools are
+	// killed as soon as they exceed it, elsewhere the peak is
+	// checked once they exit.
+	MemoryLimit uint64
+
+	// Timeout caps how long each spawned tool may run; zero
+	// means no limit. Tools running longer are killed, along
+	// with the processes they started on Unix and Windows, and
+	// the analysis fails with ErrTimeout (or, with several
+	// backends, moves on to the next one).
+	Timeout time.Duration
+
+	// Context, if set, aborts the analysis once it is done:
+	// running tools are killed, and no more are started. The
+	// error matches the cause of the cancellation, which
+	// callers can give with context.WithCancelCause, such as
+	// ErrShutdown or ErrCancelled; see AbortReasonOf.
+	Context context.Context
+
+	// TrackNumber and TrackFile pick a single track out of an
+	// album analysis, when a directory is being analyzed.
+	// TrackNumber is the 1-based number bs1770gain assigns,
+	// TrackFile the file name of the track. If neither is set,
+	// the first track is used.
+	TrackNumber int
+	TrackFile   string
+
+	// KeepRawOutput retains the analyzer's raw report in
+	// AnalysisInfo.RawOutput instead of discarding it once
+	// parsed, for debugging and archiving.
+	KeepRawOutput bool
+
+	// Metrics are computed once the file has been analyzed,
+	// see Metric. They require ffmpeg.
+	Metrics []Metric
+
+	// Tools, if set, says where the tools are, instead of
+	// SetTools.
+	Tools *Tools
+
+	// Runner, if set, runs the tools instead of the package,
+	// which then neither looks them up nor enforces Timeout
+	// and MemoryLimit; see Runner.
+	Runner Runner
+
+	// Hooks are run around the stages of the pipeline, see
+	// Hook.
+	Hooks []Hook
+
+	// OnProgress, if set, is called with the percentage of
+	// the file analyzed so far. Backends that can tell (ffmpeg
+	// and native) report as they go, others only at the start
+	// and the end. A Scanner calls it from its workers at once.
+	OnProgress func(file string, percent float64)
+}
+
+// AnalysisInfo describes how an analysis was carried out,
+// as opposed to what it measured.
+type AnalysisInfo struct {
+	Backend  string           // backend that produced the result
+	Attempts []BackendAttempt // every backend tried, in order
+
+	// Aborted is why the analysis was aborted, if its error
+	// did; see AbortReasonOf.
+	Aborted AbortReason
+
+	// ToolVersion is the version of the tool behind Backend,
+	// if known; only bs1770gain is asked for it.
+	ToolVersion string
+
+	// Calibration is the offset from Options.Calibration that
+	// was applied to the result, in LU.
+	Calibration float32
+
+	// Relative is the result relative to ReferenceEBUR128,
+	// with the gains and peak factor bs1770gain reported, or
+	// nil for other backends. The album values are set for
+	// the tracks of an album analyzed at once.
+	Relative *RelativeLoudness
+
+	// Unfiltered is the loudness of the signal without any
+	// preprocessing, if Options.MeasureUnfiltered was set.
+	Unfiltered *LoudnessData
+
+	// Dialogue lists the speech found by Options.DialogueGate.
+	Dialogue []Segment
+
+	// Gapless is the encoder delay and padding of lossy input
+	// files that record it. MediaOffset is the delay as a
+	// duration: positions in the decoded signal are that much
+	// later than in the original, and loudness series are
+	// shifted back by it.
+	//
+	// GaplessTrimmed is set if delay and padding were cut
+	// before measuring; otherwise they are only taken out of
+	// the reported length (unless Options.KeepPadding is set).
+	Gapless        *Gapless
+	MediaOffset    time.Duration
+	GaplessTrimmed bool
+
+	// Media is the format of the audio measured, if the
+	// length probe reported it. With preprocessing, it is
+	// that of the processed copy.
+	Media *MediaInfo
+
+	// Issues are the structural problems of the input file
+	// found with Options.Inspect.
+	Issues []Issue
+
+	// Remuxed is set if the result was measured on a remuxed
+	// copy, see Options.RemuxOnError; the original file may
+	// be damaged.
+	Remuxed bool
+
+	// Divergences from the Options.VerifyWith backend, and
+	// other non-fatal problems noticed along the way.
+	Divergences []Divergence
+	Warnings    []string
+
+	// Blocks is the histogram of gating blocks, from backends
+	// that measure them blockwise.
+	Blocks *BlockHistogram
+
+	Timings Timings
+	Tools   []ToolStats // every tool spawned, in order
+
+	// TraceDir is where the tool IO of the analysis was
+	// saved, if Options.TraceDir was set.
+	TraceDir string
+
+	// Metrics are the values computed by Options.Metrics.
+	Metrics map[string]float64
+
+	// RawOutput is the analyzer report the results were
+	// parsed from, if Options.KeepRawOutput was set. It is
+	// filled in even when parsing fails.
+	RawOutput []byte
+}
+
+// Timings is a breakdown of where the wall clock time of an
+// analysis was spent. Stages that did not run are zero.
+type Timings struct {
+	Probe      time.Duration // length detection
+	Preprocess time.Duration // filtering ahead of measurement
+	Analyze    time.Duration // loudness measurement
+	Parse      time.Duration // decoding analyzer output
+	TagWrite   time.Duration // persisting results into the file
+}
+
+// Total returns the time spent across all stages.
+func (t Timings) Total() time.Duration {
+	return t.Probe + t.Preprocess + t.Analyze + t.Parse + t.TagWrite
+}
+
+// Add accumulates other into t, which is how per-file
+// timings are aggregated into batch totals.
+func (t *Timings) Add(other Timings) {
+	t.Probe += other.Probe
+	t.Preprocess += other.Preprocess
+	t.Analyze += other.Analyze
+	t.Parse += other.Parse
+	t.TagWrite += other.TagWrite
+}
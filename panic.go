@@ -0,0 +1,57 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned in place of a panic in a backend or
+// other caller-supplied code (key strategies, detectors),
+// so that in a batch only the affected file fails.
+type PanicError struct {
+	Value interface{} // what was passed to panic
+	Stack []byte      // stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// recoverPanic turns a panic into a *PanicError in *err. It
+// must be deferred directly.
+func recoverPanic(err *error) {
+	if v := recover(); v != nil {
+		*err = &PanicError{Value: v, Stack: debug.Stack()}
+	}
+}
+
+// analyzeSafely runs a backend, recovering from its panics.
+func analyzeSafely(b LoudnessAnalyzer, file string, opts Options, info *AnalysisInfo) (ld LoudnessData, err error) {
+	defer recoverPanic(&err)
+	return b.Analyze(file, opts, info)
+}
+
+// keySafely runs a key strategy, with opts if it takes them,
+// recovering from its panics.
+func keySafely(keys KeyStrategy, file string, opts Options) (key string, err error) {
+	defer recoverPanic(&err)
+	if k, ok := keys.(OptionsKeyStrategy); ok {
+		return k.KeyWithOptions(file, opts)
+	}
+	return keys.Key(file)
+}
+
+// detectSafely runs a voice activity detector, recovering
+// from its panics.
+func detectSafely(vad VoiceActivityDetector, samples []float32, rate int) (segments []Segment, err error) {
+	defer recoverPanic(&err)
+	return vad.Detect(samples, rate)
+}
+
+// calculateSafely is CalculateLoudnessWithOptions, recovering
+// from any panic, for batch components that must not lose
+// other files to one file's failure.
+func calculateSafely(file string, opts Options) (ld LoudnessData, info AnalysisInfo, err error) {
+	defer recoverPanic(&err)
+	return CalculateLoudnessWithOptions(file, opts)
+}
@@ -0,0 +1,45 @@
+package bs1770wrap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Paths are passed to tools as separate arguments, never
+// through a shell, so spaces and quotes need no escaping.
+// What does need care is what the tools themselves make of
+// an argument.
+
+// toolPath prepares a file path to be passed to sox or
+// bs1770gain: names starting with a dash would be taken for
+// options, and overlong paths need a special prefix on
+// Windows.
+func toolPath(path string) string {
+	if strings.HasPrefix(path, "-") {
+		path = "." + string(filepath.Separator) + path
+	}
+	return longPath(path)
+}
+
+// ffmpegPath is toolPath for ffmpeg, which in addition takes
+// anything containing a colon for a protocol URL (think
+// "mix: final.wav") unless told otherwise.
+func ffmpegPath(path string) string {
+	return "file:" + toolPath(path)
+}
+
+// samePath reports whether the paths a and b are of the
+// same file: the same file on disk if both exist, hard links
+// and case-insensitive names included, or else the same
+// absolute path.
+func samePath(a, b string) bool {
+	fa, errA := os.Stat(longPath(a))
+	fb, errB := os.Stat(longPath(b))
+	if errA == nil && errB == nil {
+		return os.SameFile(fa, fb)
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package bs1770wrap
+
+// longPath returns path unchanged: only Windows has a path
+// length limit to work around.
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,65 @@
+package bs1770wrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSamePath(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir("out", 0755)
+	os.WriteFile("track.flac", nil, 0644)
+	os.WriteFile(filepath.Join("out", "track.flac"), nil, 0644)
+	os.Link("track.flac", "link.flac")
+
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"track.flac", "track.flac", true},
+		{"track.flac", "./track.flac", true},
+		{"track.flac", filepath.Join(dir, "track.flac"), true},
+		{"track.flac", filepath.Join("out", "..", "track.flac"), true},
+		{"track.flac", "link.flac", true},
+		{"track.flac", filepath.Join("out", "track.flac"), false},
+		{"track.flac", filepath.Join("new", "track.flac"), false},
+		{"track.flac", "new.flac", false},
+		{"missing.flac", "./missing.flac", true},
+	} {
+		if got := samePath(c.a, c.b); got != c.want {
+			t.Errorf("samePath(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestToolPath(t *testing.T) {
+	sep := string(filepath.Separator)
+	for _, c := range []struct {
+		path, tool, ffmpeg string
+	}{
+		{"a.wav", "a.wav", "file:a.wav"},
+		{"-a.wav", "." + sep + "-a.wav", "file:." + sep + "-a.wav"},
+		{filepath.Join("-dir", "a.wav"), "." + sep + filepath.Join("-dir", "a.wav"), "file:." + sep + filepath.Join("-dir", "a.wav")},
+		{filepath.Join("dir", "-a.wav"), filepath.Join("dir", "-a.wav"), "file:" + filepath.Join("dir", "-a.wav")},
+		{"mix: final.wav", "mix: final.wav", "file:mix: final.wav"},
+		{"http://host/a.wav", "http://host/a.wav", "file:http://host/a.wav"},
+		{`it's a "track" (1).wav`, `it's a "track" (1).wav`, `file:it's a "track" (1).wav`},
+		{"päivä 日本.flac", "päivä 日本.flac", "file:päivä 日本.flac"},
+	} {
+		if got := toolPath(c.path); got != c.tool {
+			t.Errorf("toolPath(%q) = %q, want %q", c.path, got, c.tool)
+		}
+		if got := ffmpegPath(c.path); got != c.ffmpeg {
+			t.Errorf("ffmpegPath(%q) = %q, want %q", c.path, got, c.ffmpeg)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package bs1770wrap
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Windows limits ordinary paths to MAX_PATH characters
+const maxPath = 260
+
+// longPath turns overlong paths into extended-length ones,
+// which are exempt from the MAX_PATH limit. The limit applies
+// to the absolute path, so a short relative one under a deep
+// working directory can be overlong too.
+func longPath(path string) string {
+	if strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil || len(abs) < maxPath {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// \\server\share becomes \\?\UNC\server\share
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,46 @@
+package bs1770wrap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	// a working directory deep enough for a short relative
+	// path under it to be overlong
+	tmp := t.TempDir()
+	deep := filepath.Join(tmp, strings.Repeat("d", max(200-len(tmp), 1)))
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(deep); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		path, want string
+	}{
+		{"a.wav", "a.wav"},
+		{`C:\music\a.wav`, `C:\music\a.wav`},
+		{`C:\` + long + `\` + long + `\` + long + `.wav`, `\\?\C:\` + long + `\` + long + `\` + long + `.wav`},
+		{`C:\` + long + `\..\` + long + `\` + long + `\` + long + `.wav`, `\\?\C:\` + long + `\` + long + `\` + long + `.wav`},
+		{`C:/` + long + `/` + long + `/` + long + `.wav`, `\\?\C:\` + long + `\` + long + `\` + long + `.wav`},
+		{`\\server\share\` + long + `\` + long + `\` + long + `.wav`, `\\?\UNC\server\share\` + long + `\` + long + `\` + long + `.wav`},
+		{`\\?\C:\` + long + `\` + long + `\` + long + `.wav`, `\\?\C:\` + long + `\` + long + `\` + long + `.wav`},
+		// short, but not under the deep working directory
+		{long + `.wav`, `\\?\` + filepath.Join(deep, long+`.wav`)},
+		{`-` + long + `.wav`, `\\?\` + filepath.Join(deep, `-`+long+`.wav`)},
+	} {
+		if got := longPath(c.path); got != c.want {
+			t.Errorf("longPath(%q) = %q, want %q", c.path, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package bs1770wrap
+
+import "math"
+
+// Platform describes how a streaming service normalizes
+// playback loudness.
+type Platform struct {
+	Name   string
+	Target float32 // playback reference, LUFS
+
+	// Boosts is whether tracks quieter than Target get turned
+	// up. If they do, the boost is capped so the true peak
+	// stays at or below PeakCeiling (dBTP).
+	Boosts      bool
+	PeakCeiling float32
+}
+
+// Platforms are the normalization presets of popular
+// services, as published at the time of writing. Services
+// change these now and then, so treat the numbers as
+// approximate.
+var Platforms = []Platform{
+	{Name: "Spotify", Target: -14, Boosts: true, PeakCeiling: -1},
+	{Name: "Apple Music", Target: -16, Boosts: true, PeakCeiling: -1},
+	{Name: "YouTube", Target: -14},
+	{Name: "Tidal", Target: -14},
+	{Name: "Amazon Music", Target: -14},
+	{Name: "Deezer", Target: -15},
+	{Name: "Pandora", Target: -14},
+}
+
+// Penalty returns the gain, in dB, the platform will apply
+// to a track during playback: negative if it gets turned
+// down, positive if it gets turned up, zero if it is played
+// back as is.
+func (p Platform) Penalty(ld LoudnessData) float32 {
+	gain := p.Target - ld.Integrated
+	if gain <= 0 {
+		return gain
+	}
+	if !p.Boosts {
+		return 0
+	}
+	headroom := p.PeakCeiling - ld.Peak
+	return float32(math.Max(0, math.Min(float64(gain), float64(headroom))))
+}
+
+// PlatformPenalty is the playback gain of one platform.
+type PlatformPenalty struct {
+	Platform string
+	Gain     float32 // dB
+}
+
+// LoudnessPenalties returns the playback gain each of the
+// Platforms will apply to the track, in the same order.
+func LoudnessPenalties(ld LoudnessData) []PlatformPenalty {
+	penalties := make([]PlatformPenalty, len(Platforms))
+	for i, p := range Platforms {
+		penalties[i] = PlatformPenalty{Platform: p.Name, Gain: p.Penalty(ld)}
+	}
+	return penalties
+}
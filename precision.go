@@ -0,0 +1,53 @@
+package bs1770wrap
+
+import (
+	"math"
+	"strconv"
+)
+
+// DefaultPrecision is the number of decimals loudness values
+// are usually reported with, matching bs1770gain's output.
+const DefaultPrecision = 2
+
+// tolerance comparisons allow for this much float noise, so
+// that e.g. -23.5 is within 0.5 LU of -23 even though neither
+// is exactly representable in binary
+const toleranceEpsilon = 1e-6
+
+// Round rounds v to the given number of decimals, halves
+// away from zero. A negative precision leaves v alone.
+func Round(v float64, decimals int) float64 {
+	if decimals < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return v
+	}
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
+
+// FormatLevel formats a loudness or peak value with the
+// given number of decimals.
+func FormatLevel(v float32, decimals int) string {
+	return strconv.FormatFloat(Round(float64(v), decimals), 'f', decimals, 64)
+}
+
+// WithinTolerance reports whether measured lies within tol of
+// target once rounded to the reporting precision, so that a
+// result is judged on the number that is actually reported
+// (pass a negative precision to compare unrounded values).
+// The bounds themselves are inside the window: -23.5 passes
+// a -23 ±0.5 LU check.
+func WithinTolerance(measured, target, tol float64, decimals int) bool {
+	return math.Abs(Round(measured, decimals)-target) <= tol+toleranceEpsilon
+}
+
+// Rounded returns a copy of ld with the levels rounded to
+// the given number of decimals. Length is left alone.
+func (ld LoudnessData) Rounded(decimals int) LoudnessData {
+	r := func(v float32) float32 { return float32(Round(float64(v), decimals)) }
+	ld.Integrated = r(ld.Integrated)
+	ld.Peak = r(ld.Peak)
+	ld.Range = r(ld.Range)
+	ld.Shortterm = r(ld.Shortterm)
+	ld.Momentary = r(ld.Momentary)
+	return ld
+}
@@ -0,0 +1,246 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHighpass is a cutoff, in Hz, that keeps bass-heavy
+// music from skewing the measurements without affecting the
+// rest of the spectrum much.
+const DefaultHighpass = 100
+
+// soxEffects returns the sox effect chain requested in opts,
+// with the highpass shorthand first.
+func soxEffects(opts Options) []string {
+	var effects []string
+	if opts.Highpass > 0 {
+		effects = append(effects, "highpass", strconv.FormatFloat(opts.Highpass, 'f', -1, 64))
+	}
+	return append(effects, opts.Effects...)
+}
+
+// preprocessing reports whether opts ask for the signal to
+// be processed before it is measured.
+func preprocessing(opts Options) bool {
+	return opts.RoundTrip != nil || opts.DialogueGate != nil || filtering(opts)
+}
+
+// filtering reports whether opts ask for sox effects or an
+// ffmpeg filtergraph.
+func filtering(opts Options) bool {
+	return opts.Highpass > 0 || len(opts.Effects) > 0 || opts.FilterGraph != ""
+}
+
+// Codec describes a lossy encoding, as done by ffmpeg, whose
+// effect on loudness and peaks is to be simulated.
+type Codec struct {
+	Encoder   string // ffmpeg encoder name, e.g. "libopus"
+	Bitrate   string // ffmpeg bitrate, e.g. "64k"
+	Extension string // container to encode into, e.g. ".opus"
+}
+
+// Some encodings streaming platforms transcode into.
+var (
+	CodecOpus64  = Codec{Encoder: "libopus", Bitrate: "64k", Extension: ".opus"}
+	CodecOpus128 = Codec{Encoder: "libopus", Bitrate: "128k", Extension: ".opus"}
+	CodecAAC128  = Codec{Encoder: "aac", Bitrate: "128k", Extension: ".m4a"}
+	CodecAAC256  = Codec{Encoder: "aac", Bitrate: "256k", Extension: ".m4a"}
+	CodecMP3320  = Codec{Encoder: "libmp3lame", Bitrate: "320k", Extension: ".mp3"}
+	CodecVorbis  = Codec{Encoder: "libvorbis", Bitrate: "160k", Extension: ".ogg"}
+)
+
+// ValidateEffects checks the preprocessing requested in opts
+// by running it over a few milliseconds of generated signal,
+// so a typo in an effect chain is reported up front rather
+// than once per file.
+func ValidateEffects(opts Options) error {
+	var stderr bytes.Buffer
+	var cmd *exec.Cmd
+
+	effects := soxEffects(opts)
+	switch {
+	case len(effects) > 0 && opts.FilterGraph != "":
+		return fmt.Errorf("Cannot combine sox effects with an ffmpeg filtergraph")
+	case len(effects) > 0:
+		if strings.HasPrefix(effects[0], "-") {
+			return fmt.Errorf("Invalid effect chain: %q is not an effect", effects[0])
+		}
+		args := []string{"-n", "-n", "synth", "0.01", "sine", "1000"}
+		cmd = exec.Command("sox", append(args, effects...)...)
+	case opts.FilterGraph != "":
+		cmd = exec.Command("ffmpeg",
+			"-nostdin",
+			"-f", "lavfi", "-i", "sine=frequency=1000:duration=0.01",
+			"-af", opts.FilterGraph,
+			"-f", "null", "-",
+		)
+	default:
+		return nil
+	}
+	cmd.Stderr = &stderr
+
+	err := run(cmd.Args[0], cmd, opts, &AnalysisInfo{})
+	if err != nil {
+		return fmt.Errorf("Invalid effect chain: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// preprocess writes a processed copy of file into a
+// temporary directory, returning its path and a function
+// removing it. Each stage reads the file the previous one
+// wrote there, see Options.Highpass. Arguments are handed to
+// sox or ffmpeg directly, never through a shell.
+func preprocess(file string, opts Options, info *AnalysisInfo) (string, func(), error) {
+	effects := soxEffects(opts)
+	if len(effects) > 0 && opts.FilterGraph != "" {
+		return "", nil, fmt.Errorf("Cannot combine sox effects with an ffmpeg filtergraph")
+	}
+
+	dir, err := os.MkdirTemp(opts.TempDir, "bs1770wrap")
+	if err != nil {
+		return "", nil, fmt.Errorf("Error creating temporary directory: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	start := time.Now()
+	defer func() { info.Timings.Preprocess += time.Since(start) }()
+
+	// the codec goes first, as measurement filters such as the
+	// highpass apply to what the listener would get
+	in := file
+	if opts.RoundTrip != nil {
+		in, err = roundTrip(in, dir, *opts.RoundTrip, opts, info)
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	if filtering(opts) {
+		// sox doesn't skip encoder delay and padding by itself,
+		// unlike ffmpeg, which has decoded anything but the
+		// original file by now
+		if in == file && opts.FilterGraph == "" && info.Gapless != nil && !opts.KeepPadding {
+			effects = append(gaplessTrim(*info.Gapless), effects...)
+		}
+		in, err = filter(in, dir, effects, opts, info)
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	if opts.DialogueGate != nil {
+		in, err = gateDialogue(in, dir, opts, info)
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	info.GaplessTrimmed = info.Gapless != nil && !(in == file && opts.KeepPadding)
+	return in, cleanup, nil
+}
+
+// gaplessTrim returns the sox effect cutting encoder delay
+// and padding.
+func gaplessTrim(g Gapless) []string {
+	return []string{"trim", fmt.Sprintf("%ds", g.Delay), fmt.Sprintf("-%ds", g.Padding)}
+}
+
+// filter runs the sox effects or ffmpeg filtergraph over in,
+// writing the result into dir.
+func filter(in, dir string, effects []string, opts Options, info *AnalysisInfo) (string, error) {
+	var stderr bytes.Buffer
+	var cmd *exec.Cmd
+	var name string
+
+	out := filepath.Join(dir, "preprocessed.wav")
+	if opts.FilterGraph != "" {
+		name = "ffmpeg"
+		cmd = exec.Command("ffmpeg",
+			"-nostdin",
+			"-loglevel", "error",
+			"-i", ffmpegPath(in),
+			"-af", opts.FilterGraph,
+			"-f", "wav",
+			ffmpegPath(out),
+		)
+	} else {
+		name = "sox"
+		cmd = exec.Command("sox", append([]string{toolPath(in), toolPath(out)}, effects...)...)
+	}
+	cmd.Stderr = &stderr
+
+	err := run(name, cmd, opts, info)
+	if err != nil {
+		return "", fmt.Errorf("Error creating temporary file: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// roundTrip encodes file with the codec and decodes it again
+// into dir, returning the path of the decoded file.
+func roundTrip(file, dir string, codec Codec, opts Options, info *AnalysisInfo) (string, error) {
+	var stderr bytes.Buffer
+
+	encoded := filepath.Join(dir, "encoded"+codec.Extension)
+	decoded := filepath.Join(dir, "decoded.wav")
+
+	cmd := exec.Command("ffmpeg", encodeArgs(file, encoded, codec, "")...)
+	cmd.Stderr = &stderr
+
+	err := run("ffmpeg", cmd, opts, info)
+	if err != nil {
+		return "", fmt.Errorf("Cannot encode with %s: %w: %s", codec.Encoder, err, strings.TrimSpace(stderr.String()))
+	}
+	stderr.Reset()
+
+	cmd = exec.Command("ffmpeg",
+		"-nostdin",
+		"-loglevel", "error",
+		"-i", ffmpegPath(encoded),
+		"-c:a", "pcm_f32le", // keep inter-sample overs the codec introduced
+		ffmpegPath(decoded),
+	)
+	cmd.Stderr = &stderr
+
+	err = run("ffmpeg", cmd, opts, info)
+	if err != nil {
+		return "", fmt.Errorf("Cannot decode %s: %w: %s", codec.Encoder, err, strings.TrimSpace(stderr.String()))
+	}
+	return decoded, nil
+}
+
+// analyzePreprocessed applies the preprocessing before
+// running the backends, measuring the original signal as
+// well if asked to.
+func analyzePreprocessed(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	processed, cleanup, err := preprocess(file, opts, info)
+	if err != nil {
+		return LoudnessData{}, err
+	}
+	defer cleanup()
+
+	ld, err := analyzeChain(processed, opts, info)
+	if err != nil || !opts.MeasureUnfiltered {
+		return ld, err
+	}
+
+	// keep the processed run's backend bookkeeping, but do
+	// account for the extra work
+	extra := AnalysisInfo{Gapless: info.Gapless, MediaOffset: info.MediaOffset}
+	unfiltered, err := analyzeChain(file, opts, &extra)
+	info.Timings.Add(extra.Timings)
+	info.Tools = append(info.Tools, extra.Tools...)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot measure unfiltered loudness: %w", err)
+	}
+	info.Unfiltered = &unfiltered
+	return ld, nil
+}
@@ -0,0 +1,64 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPreviewLength is how long a normalization preview
+// is, unless asked otherwise.
+const DefaultPreviewLength = 20 * time.Second
+
+// Preview renders a clip for auditioning the normalization
+// of file before committing to it: the loudest stretch of
+// the given length (DefaultPreviewLength if zero), going by
+// momentary loudness, with gain dB applied. The clip is
+// written to out, in whatever format its extension implies,
+// and where it starts in file is returned. Gain is applied
+// as is, so a positive gain may clip just like it would
+// without a limiter. It requires ffmpeg.
+func Preview(file, out string, gain float64, length time.Duration, opts Options) (time.Duration, error) {
+	if length <= 0 {
+		length = DefaultPreviewLength
+	}
+
+	info := AnalysisInfo{}
+	if g, ok, err := ReadGapless(file); err == nil && ok {
+		info.Gapless = &g
+		info.MediaOffset = g.Offset()
+	}
+	series, err := loudnessSeries(file, opts, &info)
+	if err != nil {
+		return 0, err
+	}
+	start, _ := LoudestPassage(series, length)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg",
+		"-nostdin",
+		"-loglevel", "error",
+		"-y",
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(length.Seconds(), 'f', 3, 64),
+		"-i", ffmpegPath(file),
+		"-vn",
+		"-af", "volume="+strconv.FormatFloat(gain, 'f', 2, 64)+"dB",
+		ffmpegPath(out),
+	)
+	cmd.Stderr = &stderr
+
+	err = run("ffmpeg", cmd, opts, &info)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot render preview: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	start -= ffmpegOffset(&info)
+	if start < 0 {
+		start = 0
+	}
+	return start, nil
+}
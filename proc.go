@@ -0,0 +1,174 @@
+package bs1770wrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how often the resident set of a running tool is sampled
+// when a memory limit is in effect
+const memoryPollInterval = 100 * time.Millisecond
+
+// ToolStats records the resource usage of one spawned tool.
+type ToolStats struct {
+	Name   string
+	MaxRSS uint64 // peak resident set size, bytes (0 if unknown)
+}
+
+// run starts cmd, once SetMaxProcesses allows, waits for it
+// to finish, and records its resource usage in info. If
+// opts.MemoryLimit is set and the tool outgrows it, the tool
+// is killed (on platforms where its memory can be watched
+// while it runs) and an error is returned. If opts.Timeout
+// is set and the tool runs longer, it is killed along with
+// the processes it started and the error matches ErrTimeout;
+// likewise if opts.Context is done, see Options.Context.
+// Errors are *ToolError, with the end of the tool's stderr
+// kept. The tool is looked up as LookupTool does, unless
+// opts.Runner is set, which runs it instead.
+func run(name string, cmd *exec.Cmd, opts Options, info *AnalysisInfo) error {
+	logf(opts, LogDebug, "running %q", redactArgs(cmd.Args))
+	done := traceCmd(name, cmd, opts, info)
+	defer done()
+
+	err := runCmd(name, cmd, opts, info)
+	if err != nil {
+		logf(opts, LogDebug, "%s failed: %v", name, err)
+	}
+	return err
+}
+
+func runCmd(name string, cmd *exec.Cmd, opts Options, info *AnalysisInfo) error {
+	if opts.Runner == nil {
+		if err := resolveTool(name, cmd, opts); err != nil {
+			return err
+		}
+	}
+
+	// waiting for a slot is aborted with the analysis, but
+	// does not count against Timeout, which is for running
+	if err := processes.acquire(opts.Context); err != nil {
+		return &ToolError{Tool: name, Err: contextError(opts.Context)}
+	}
+	defer processes.release()
+
+	tail := &tailBuffer{max: stderrTail}
+	if cmd.Stderr == nil {
+		cmd.Stderr = tail
+	} else {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, tail)
+	}
+
+	if ctx := opts.Context; ctx != nil && ctx.Err() != nil {
+		return &ToolError{Tool: name, Err: contextError(ctx)}
+	}
+
+	if opts.Runner != nil {
+		err := runWith(opts.Runner, name, cmd, cmd.Stderr)
+		info.Tools = append(info.Tools, ToolStats{Name: name})
+		if err != nil {
+			return &ToolError{Tool: name, Err: err, Stderr: tail.String()}
+		}
+		return nil
+	}
+
+	// grouped, the tool can be killed along with what it
+	// spawned, shells running other tools included
+	grouped := opts.Timeout > 0 || opts.Context != nil || opts.MemoryLimit > 0
+	if grouped {
+		startGroup(cmd)
+	}
+	err := cmd.Start()
+	if err != nil {
+		return &ToolError{Tool: name, Err: err}
+	}
+	defer joinGroup(cmd)()
+
+	// once the tool is reaped, its process ID, which is that
+	// of its group too, may be another's: kills are only sent
+	// until it has exited, flagging why
+	var mu sync.Mutex
+	exited := false
+	kill := func(why *int32) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !exited {
+			atomic.StoreInt32(why, 1)
+			killGroup(cmd)
+		}
+	}
+
+	var timedOut int32
+	var timer *time.Timer
+	if opts.Timeout > 0 {
+		timer = time.AfterFunc(opts.Timeout, func() { kill(&timedOut) })
+	}
+
+	var cancelled int32
+	stopCancel := func() bool { return false }
+	if ctx := opts.Context; ctx != nil {
+		stopCancel = context.AfterFunc(ctx, func() { kill(&cancelled) })
+	}
+
+	var killed int32
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	if opts.MemoryLimit > 0 {
+		go func() {
+			defer close(done)
+			watchMemory(cmd.Process.Pid, opts.MemoryLimit, stop, func() { kill(&killed) })
+		}()
+	} else {
+		close(done)
+	}
+
+	// where the tool can be waited for without reaping it, the
+	// kills stop before Wait reaps it, and what it left in its
+	// group is killed, as job objects do on Windows; elsewhere
+	// as soon as Wait returns
+	stopKills := func() {
+		mu.Lock()
+		exited = true
+		mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		stopCancel()
+		close(stop)
+		<-done
+	}
+	if awaitExit(cmd.Process.Pid) {
+		stopKills()
+		if grouped {
+			killGroup(cmd)
+		}
+		err = cmd.Wait()
+	} else {
+		err = cmd.Wait()
+		stopKills()
+	}
+
+	stats := ToolStats{Name: name}
+	if cmd.ProcessState != nil {
+		stats.MaxRSS = maxRSS(cmd.ProcessState)
+	}
+	info.Tools = append(info.Tools, stats)
+
+	if atomic.LoadInt32(&cancelled) != 0 {
+		err = contextError(opts.Context)
+	} else if atomic.LoadInt32(&timedOut) != 0 {
+		err = withKind(ErrTimeout, fmt.Errorf("%s timed out after %v", name, opts.Timeout))
+	} else if opts.MemoryLimit > 0 &&
+		(atomic.LoadInt32(&killed) != 0 || stats.MaxRSS > opts.MemoryLimit) {
+		err = fmt.Errorf("%s exceeded memory limit of %d bytes", name, opts.MemoryLimit)
+	}
+	if err != nil {
+		return &ToolError{Tool: name, Err: err, Stderr: tail.String()}
+	}
+	return nil
+}
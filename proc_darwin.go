@@ -0,0 +1,28 @@
+package bs1770wrap
+
+import (
+	"os"
+	"syscall"
+)
+
+func maxRSS(ps *os.ProcessState) uint64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// macOS reports bytes
+	return uint64(ru.Maxrss)
+}
+
+// watchMemory does nothing: there is no cheap way to sample
+// another process' memory here, so the limit is only checked
+// against the peak once the tool exits.
+func watchMemory(pid int, limit uint64, stop <-chan struct{}, kill func()) {
+	<-stop
+}
+
+// awaitExit cannot wait for a process without reaping it
+// here, see the Linux one.
+func awaitExit(pid int) bool {
+	return false
+}
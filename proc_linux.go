@@ -0,0 +1,82 @@
+package bs1770wrap
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func maxRSS(ps *os.ProcessState) uint64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// Linux reports kilobytes
+	return uint64(ru.Maxrss) * 1024
+}
+
+// currentRSS reads the resident set size of pid from procfs.
+func currentRSS(pid int) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// looks like "VmRSS:	   12345 kB"
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "VmRSS:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("no VmRSS in process status")
+}
+
+// watchMemory samples the resident set of pid until stop is
+// closed, calling kill once if it grows beyond limit.
+func watchMemory(pid int, limit uint64, stop <-chan struct{}, kill func()) {
+	ticker := time.NewTicker(memoryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rss, err := currentRSS(pid)
+			if err != nil {
+				// process is most likely exiting
+				continue
+			}
+			if rss > limit {
+				kill()
+				return
+			}
+		}
+	}
+}
+
+// awaitExit waits for the child pid to exit, leaving it to be
+// reaped: until it is, its process ID is not reused.
+func awaitExit(pid int) bool {
+	const pPID, wExited, wNoWait = 1, 4, 0x01000000
+	var info [128]byte // siginfo_t
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid), uintptr(unsafe.Pointer(&info)), wExited|wNoWait, 0, 0)
+		if errno != syscall.EINTR {
+			return errno == 0
+		}
+	}
+}
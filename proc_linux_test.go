@@ -0,0 +1,48 @@
+package bs1770wrap
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLeftoversKilled checks that what a grouped tool leaves
+// running once it exits is killed before it is reaped, rather
+// than holding its stderr open until the timeout.
+func TestLeftoversKilled(t *testing.T) {
+	exe, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cmd := exec.Command(exe, "-test.run=^TestGroupHelper$")
+	cmd.Env = append(os.Environ(), "BS1770WRAP_GROUP_HELPER="+pidFile, "BS1770WRAP_GROUP_HELPER_EXIT=1")
+
+	start := time.Now()
+	if err := run(exe, cmd, Options{Timeout: 20 * time.Second}, &AnalysisInfo{}); err != nil {
+		t.Fatalf("the tool failed: %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatalf("the tool took %v to be done with", time.Since(start))
+	}
+
+	buf, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for alive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the child %d of the tool outlived it", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
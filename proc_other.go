@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package bs1770wrap
+
+import "os"
+
+// peak memory is not available on this platform
+func maxRSS(ps *os.ProcessState) uint64 {
+	return 0
+}
+
+// watchMemory does nothing, so memory limits are not
+// enforced on this platform.
+func watchMemory(pid int, limit uint64, stop <-chan struct{}, kill func()) {
+	<-stop
+}
+
+// awaitExit cannot wait for a process without reaping it on
+// this platform, see the Linux one.
+func awaitExit(pid int) bool {
+	return false
+}
@@ -0,0 +1,84 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"time"
+)
+
+// progressStep is the smallest change in percent reported,
+// so that callbacks are not flooded
+const progressStep = 0.5
+
+// reportProgress calls opts.OnProgress, if set.
+func reportProgress(opts Options, file string, percent float64) {
+	if opts.OnProgress != nil {
+		opts.OnProgress(file, percent)
+	}
+}
+
+// progress reports the progress through a file of known size
+// in steps of at least progressStep.
+type progress struct {
+	opts  Options
+	file  string
+	total float64
+	last  float64
+}
+
+func newProgress(file string, total float64, opts Options) *progress {
+	return &progress{opts: opts, file: file, total: total}
+}
+
+func (p *progress) update(done float64) {
+	if p.total <= 0 {
+		return
+	}
+	percent := 100 * done / p.total
+	if percent > 100 {
+		percent = 100
+	}
+	if percent-p.last >= progressStep {
+		p.last = percent
+		reportProgress(p.opts, p.file, percent)
+	}
+}
+
+// ebur128Progress is an io.Writer taking ffmpeg's log as it
+// is written and reporting the time of the ebur128 frame log
+// lines against the total duration, in microseconds.
+type ebur128Progress struct {
+	*progress
+	line []byte
+}
+
+func (w *ebur128Progress) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if m := seriesRegex.FindSubmatch(w.line[:i]); m != nil {
+			if t, err := strconv.ParseFloat(string(m[1]), 64); err == nil {
+				w.update(t * float64(time.Second/time.Microsecond))
+			}
+		}
+		w.line = w.line[i+1:]
+	}
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	io.Reader
+	*progress
+	done int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.done += int64(n)
+	r.update(float64(r.done))
+	return n, err
+}
@@ -0,0 +1,130 @@
+package bs1770wrap
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+)
+
+// ProgressProtocolVersion is the version of the JSON progress
+// protocol, bumped whenever an event changes in a way readers
+// could trip over. Fields are only ever added within a
+// version.
+const ProgressProtocolVersion = 1
+
+// The JSON progress protocol is newline-delimited JSON, one
+// ProgressEvent per line, for front ends wrapping a program
+// rather than linking the package. A file gets any number of
+// "progress" events, then either a "result" or an "error".
+// Levels that are -Inf, as for silence, are null.
+
+// ProgressEvent is a line of the JSON progress protocol.
+type ProgressEvent struct {
+	Version int      `json:"v"`
+	Event   string   `json:"event"` // "progress", "result" or "error"
+	File    string   `json:"file"`
+	Percent *float64 `json:"percent,omitempty"`
+
+	Result *ProgressResult `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Reason AbortReason     `json:"reason,omitempty"` // of "error" events aborting the analysis
+}
+
+// ProgressResult is the measurement of a "result" event.
+// Length is in microseconds.
+type ProgressResult struct {
+	Integrated *float64 `json:"integrated"`
+	Peak       *float64 `json:"peak"`
+	Range      *float64 `json:"range"`
+	Shortterm  *float64 `json:"shortterm"`
+	Momentary  *float64 `json:"momentary"`
+	Length     uint64   `json:"length"`
+	Backend    string   `json:"backend,omitempty"`
+}
+
+// ProgressWriter writes the JSON progress protocol to an
+// io.Writer. It may be used from several goroutines at once,
+// such as the workers of a Scanner.
+type ProgressWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewProgressWriter returns a ProgressWriter writing to w.
+func NewProgressWriter(w io.Writer) *ProgressWriter {
+	return &ProgressWriter{enc: json.NewEncoder(w)}
+}
+
+// Progress writes a "progress" event. Its signature is that
+// of Options.OnProgress.
+func (p *ProgressWriter) Progress(file string, percent float64) {
+	p.write(ProgressEvent{Event: "progress", File: file, Percent: &percent})
+}
+
+// Result writes a "result" event for ld, or an "error" event
+// if err is set.
+func (p *ProgressWriter) Result(file string, ld LoudnessData, info AnalysisInfo, err error) {
+	if err != nil {
+		p.write(ProgressEvent{Event: "error", File: file, Error: err.Error(), Reason: AbortReasonOf(err)})
+		return
+	}
+	p.write(ProgressEvent{Event: "result", File: file, Result: progressResult(ld, info.Backend)})
+}
+
+// progressResult returns ld as the protocol has it.
+func progressResult(ld LoudnessData, backend string) *ProgressResult {
+	level := func(v float32) *float64 {
+		if math.IsInf(float64(v), 0) || math.IsNaN(float64(v)) {
+			return nil
+		}
+		f := float64(v)
+		return &f
+	}
+	return &ProgressResult{
+		Integrated: level(ld.Integrated),
+		Peak:       level(ld.Peak),
+		Range:      level(ld.Range),
+		Shortterm:  level(ld.Shortterm),
+		Momentary:  level(ld.Momentary),
+		Length:     ld.Length,
+		Backend:    backend,
+	}
+}
+
+// loudness returns the measurement r describes, null levels
+// being -Inf.
+func (r ProgressResult) loudness() LoudnessData {
+	level := func(v *float64) float32 {
+		if v == nil {
+			return float32(math.Inf(-1))
+		}
+		return float32(*v)
+	}
+	return LoudnessData{
+		Integrated: level(r.Integrated),
+		Peak:       level(r.Peak),
+		Range:      level(r.Range),
+		Shortterm:  level(r.Shortterm),
+		Momentary:  level(r.Momentary),
+		Length:     r.Length,
+	}
+}
+
+// Err returns the first error writing an event, after which
+// no more are written.
+func (p *ProgressWriter) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *ProgressWriter) write(e ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		e.Version = ProgressProtocolVersion
+		p.err = p.enc.Encode(e)
+	}
+}
@@ -0,0 +1,65 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// CalculateLoudnessFromReader measures the audio read from r,
+// which ffmpeg is fed through stdin, so that streams need not
+// be written to disk first. format is the name of the ffmpeg
+// demuxer ("wav", "flac", "mp3", ...), or empty to have ffmpeg
+// guess, which works for most containers but not for raw PCM.
+func CalculateLoudnessFromReader(r io.Reader, format string) (LoudnessData, error) {
+	ld, _, err := CalculateLoudnessFromReaderWithOptions(r, format, Options{})
+	return ld, err
+}
+
+// CalculateLoudnessFromReaderWithOptions is like
+// CalculateLoudnessFromReader, but takes options and reports
+// how the analysis went. A stream cannot be probed, so the
+// length is only accurate to 100 ms, and options that need a
+// file, such as preprocessing, inspection and opts.Backends,
+// do not apply: ffmpeg always does the analysis.
+func CalculateLoudnessFromReaderWithOptions(r io.Reader, format string, opts Options) (LoudnessData, AnalysisInfo, error) {
+	info := AnalysisInfo{}
+	reportProgress(opts, "", 0)
+
+	input := []string{}
+	if format != "" {
+		input = append(input, "-f", format)
+	}
+	input = append(input, "-i", "pipe:0")
+	cmd := exec.Command("ffmpeg", ebur128Args("ebur128=framelog=info:peak=true", input...)...)
+	cmd.Stdin = r
+
+	ld, err := analyzeStream(cmd, opts, &info)
+	info.Attempts = append(info.Attempts, BackendAttempt{Backend: FFmpeg{}.Name(), Err: err})
+	if err != nil {
+		logf(opts, LogError, "cannot analyze stream: %v", err)
+		return LoudnessData{}, info, err
+	}
+	info.Backend = FFmpeg{}.Name()
+	ld, info.Calibration = calibrate(ld, info.Backend, opts)
+
+	reportProgress(opts, "", 100)
+	logf(opts, LogInfo, "analyzed stream with %s in %v: %s LUFS", info.Backend, info.Timings.Total(), FormatLevel(ld.Integrated, DefaultPrecision))
+	return ld, info, nil
+}
+
+func analyzeStream(cmd *exec.Cmd, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	out, err := runFilterLog(cmd, nil, opts, info)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot calculate loudness: %w", err)
+	}
+	if opts.KeepRawOutput {
+		info.RawOutput = []byte(out)
+	}
+
+	start := time.Now()
+	ld, err := parseEBUR128(out)
+	info.Timings.Parse += time.Since(start)
+	return ld, err
+}
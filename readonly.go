@@ -0,0 +1,44 @@
+package bs1770wrap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrReadOnly is returned by operations that would modify
+// files when Options.ReadOnly is set.
+var ErrReadOnly = errors.New("refusing to modify files in read-only mode")
+
+// ReadOnly is a handle on the package for environments with
+// strict no-modification policies. It only exposes analysis,
+// so code that is handed a ReadOnly rather than the package
+// functions cannot tag, normalize or otherwise write to
+// input files, and all scratch files it needs go under one
+// directory chosen by the caller.
+type ReadOnly struct {
+	opts Options
+}
+
+// NewReadOnly creates a ReadOnly handle analyzing with opts,
+// keeping temporary files under scratch, which must be an
+// existing directory.
+func NewReadOnly(scratch string, opts Options) (*ReadOnly, error) {
+	fi, err := os.Stat(scratch)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot use scratch directory: %v", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("Cannot use scratch directory: %s is not a directory", scratch)
+	}
+
+	opts.ReadOnly = true
+	opts.TempDir = scratch
+	return &ReadOnly{opts: opts}, nil
+}
+
+// CalculateLoudness analyzes file, see
+// CalculateLoudnessWithOptions.
+func (r *ReadOnly) CalculateLoudness(file string) (LoudnessData, AnalysisInfo, error) {
+	return CalculateLoudnessWithOptions(file, r.opts)
+}
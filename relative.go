@@ -0,0 +1,108 @@
+package bs1770wrap
+
+// RelativeLoudness is a measurement along with the levels
+// relative to a reference that bs1770gain reports next to the
+// absolute ones: each LU field is the gain, in LU, that brings
+// the level to the reference (the "lu" attributes), and
+// PeakFactor the true peak as a linear factor (the "factor"
+// attribute). The album fields are set by WithAlbum.
+//
+// Results of bs1770gain carry the values it reported in
+// AnalysisInfo.Relative; they are only computed, as Relative
+// does, where it did not report them.
+type RelativeLoudness struct {
+	LoudnessData
+	Reference float64 // LUFS
+
+	IntegratedLU float32
+	MomentaryLU  float32
+	ShorttermLU  float32
+	PeakFactor   float32
+
+	HasAlbum        bool
+	AlbumLU         float32
+	AlbumPeakFactor float32
+}
+
+// Relative returns ld relative to reference, in LUFS, or to
+// ReferenceEBUR128, which bs1770gain uses by default, if zero.
+func (ld LoudnessData) Relative(reference float64) RelativeLoudness {
+	if reference == 0 {
+		reference = ReferenceEBUR128
+	}
+	lu := func(lufs float32) float32 {
+		return float32(reference) - lufs
+	}
+	return RelativeLoudness{
+		LoudnessData: ld,
+		Reference:    reference,
+		IntegratedLU: lu(ld.Integrated),
+		MomentaryLU:  lu(ld.Momentary),
+		ShorttermLU:  lu(ld.Shortterm),
+		PeakFactor:   float32(DBToLinear(float64(ld.Peak))),
+	}
+}
+
+// WithAlbum returns r with the album gain and peak factor of
+// album set, against the same reference.
+func (r RelativeLoudness) WithAlbum(album LoudnessData) RelativeLoudness {
+	r.HasAlbum = true
+	r.AlbumLU = float32(r.Reference) - album.Integrated
+	r.AlbumPeakFactor = float32(DBToLinear(float64(album.Peak)))
+	return r
+}
+
+// Relative returns the tracks of a relative to reference, as
+// LoudnessData.Relative does, with the album values set. They
+// are in the order of a.Tracks; tracks that failed analysis
+// are left zero. Tracks measured by bs1770gain against the
+// same reference have the values it reported.
+func (a AlbumLoudness) Relative(reference float64) []RelativeLoudness {
+	if reference == 0 {
+		reference = ReferenceEBUR128
+	}
+	tracks := make([]RelativeLoudness, len(a.Tracks))
+	for i, t := range a.Tracks {
+		switch r := t.Info.Relative; {
+		case t.Err != nil:
+		case r != nil && r.Reference == reference && r.HasAlbum:
+			tracks[i] = *r
+		case r != nil && r.Reference == reference:
+			tracks[i] = r.WithAlbum(a.Album)
+		default:
+			tracks[i] = t.Loudness.Relative(reference).WithAlbum(a.Album)
+		}
+	}
+	return tracks
+}
+
+// relative returns ld, the loudness of m calibrated by
+// offset, relative to ReferenceEBUR128, which the "lu" and
+// "factor" attributes are against. The gains reported are
+// less the offset; those not reported are computed.
+func (m measurements) relative(ld LoudnessData, offset float32) RelativeLoudness {
+	r := ld.Relative(ReferenceEBUR128)
+	if lu := m.Integrated.LU; lu != nil {
+		r.IntegratedLU = *lu - offset
+	}
+	if l := firstReported(m.MomentaryMaximum, m.Momentary); l != nil && l.LU != nil {
+		r.MomentaryLU = *l.LU - offset
+	}
+	if l := firstReported(m.ShorttermMaximum, m.Shortterm); l != nil && l.LU != nil {
+		r.ShorttermLU = *l.LU - offset
+	}
+	if f := m.TruePeak.Factor; f != nil {
+		r.PeakFactor = *f
+	}
+	return r
+}
+
+// withSummary returns r with the album values of the album
+// summary s set, as relative does for a track.
+func (r RelativeLoudness) withSummary(s measurements, album LoudnessData, offset float32) RelativeLoudness {
+	a := s.relative(album, offset)
+	r.HasAlbum = true
+	r.AlbumLU = a.IntegratedLU
+	r.AlbumPeakFactor = a.PeakFactor
+	return r
+}
@@ -0,0 +1,100 @@
+package bs1770wrap
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// xmlAlbum is an --xml report of bs1770gain 0.4 whose gains
+// and factors are not quite the reference less the levels, as
+// bs1770gain works them out before rounding; the second track
+// reports none.
+const xmlAlbum = `<bs1770gain norm="-23.00">
+  <album>
+    <track total="2" number="1" file="a.flac">
+      <integrated lufs="-14.14" lu="-8.87" />
+      <momentary lufs="-9.55" lu="-13.44" />
+      <shortterm lufs="-11.32" lu="-11.69" />
+      <range lufs="4.52" />
+      <true-peak tpfs="0.05" factor="1.005500" />
+    </track>
+    <track total="2" number="2" file="b.flac">
+      <integrated lufs="-15.20" />
+      <momentary-maximum lufs="-10.01" />
+      <shortterm-maximum lufs="-12.40" />
+      <range lufs="6.10" />
+      <true-peak tpfs="-0.30" />
+    </track>
+    <summary total="2">
+      <integrated lufs="-14.53" lu="-8.48" />
+      <momentary lufs="-9.55" lu="-13.44" />
+      <shortterm lufs="-11.32" lu="-11.69" />
+      <range lufs="5.34" />
+      <true-peak tpfs="0.05" factor="1.005500" />
+    </summary>
+  </album>
+</bs1770gain>`
+
+func TestRelativeReported(t *testing.T) {
+	gd := bs1770gainData{}
+	if err := xml.Unmarshal([]byte(xmlAlbum), &gd); err != nil {
+		t.Fatal(err)
+	}
+	summary := gd.Album.Summary.measurements
+	for _, c := range []struct {
+		offset float32
+		want   [2]RelativeLoudness
+	}{
+		{0, [2]RelativeLoudness{
+			{IntegratedLU: -8.87, MomentaryLU: -13.44, ShorttermLU: -11.69, PeakFactor: 1.0055, AlbumLU: -8.48, AlbumPeakFactor: 1.0055},
+			{IntegratedLU: -7.8, MomentaryLU: -12.99, ShorttermLU: -10.6, PeakFactor: 0.966051, AlbumLU: -8.48, AlbumPeakFactor: 1.0055},
+		}},
+		// calibrated levels are louder by the offset, and need
+		// that much less gain
+		{1, [2]RelativeLoudness{
+			{IntegratedLU: -9.87, MomentaryLU: -14.44, ShorttermLU: -12.69, PeakFactor: 1.0055, AlbumLU: -9.48, AlbumPeakFactor: 1.0055},
+			{IntegratedLU: -8.8, MomentaryLU: -13.99, ShorttermLU: -11.6, PeakFactor: 0.966051, AlbumLU: -9.48, AlbumPeakFactor: 1.0055},
+		}},
+	} {
+		opts := Options{Calibration: map[string]float32{"bs1770gain": c.offset}}
+		album, _ := calibrate(summary.loudness(0), "bs1770gain", opts)
+		for i, track := range gd.Album.Tracks {
+			ld, offset := calibrate(track.loudness(0), "bs1770gain", opts)
+			got := track.relative(ld, offset).withSummary(summary, album, offset)
+			want := c.want[i]
+			if got.LoudnessData != ld || got.Reference != ReferenceEBUR128 || !got.HasAlbum {
+				t.Errorf("offset %v, track %d: %+v, want the loudness %+v against %v with the album", c.offset, i, got, ld, ReferenceEBUR128)
+			}
+			if !nearRelative(got, want) {
+				t.Errorf("offset %v, track %d: %+v, want %+v", c.offset, i, got, want)
+			}
+		}
+	}
+
+	// bs1770gain's values are kept for its reference only
+	a := AlbumLoudness{Album: LoudnessData{Integrated: -14.53}}
+	r := gd.Album.Tracks[0].relative(gd.Album.Tracks[0].loudness(0), 0)
+	a.Tracks = []AlbumTrack{{Loudness: r.LoudnessData, Info: AnalysisInfo{Relative: &r}}}
+	if got := a.Relative(0)[0]; !nearLU(got.IntegratedLU, -8.87) || !nearLU(got.AlbumLU, -8.47) {
+		t.Errorf("against the default reference: %+v, want the reported gain", got)
+	}
+	if got := a.Relative(ReferenceStreaming)[0]; !nearLU(got.IntegratedLU, ReferenceStreaming+14.14) {
+		t.Errorf("against %v LUFS: %+v, want the gain computed", ReferenceStreaming, got)
+	}
+
+	if err := xml.Unmarshal([]byte(`<bs1770gain><album><track><integrated lufs="-14" lu="x" /></track></album></bs1770gain>`), &bs1770gainData{}); err == nil {
+		t.Error("a bad lu attribute parses")
+	}
+}
+
+// nearRelative reports whether the gains and peak factors of
+// got are near those of want.
+func nearRelative(got, want RelativeLoudness) bool {
+	return nearLU(got.IntegratedLU, want.IntegratedLU) && nearLU(got.MomentaryLU, want.MomentaryLU) &&
+		nearLU(got.ShorttermLU, want.ShorttermLU) && nearLU(got.PeakFactor, want.PeakFactor) &&
+		nearLU(got.AlbumLU, want.AlbumLU) && nearLU(got.AlbumPeakFactor, want.AlbumPeakFactor)
+}
+
+func nearLU(got, want float32) bool {
+	return near(float64(got), float64(want))
+}
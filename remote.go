@@ -0,0 +1,325 @@
+package bs1770wrap
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Remote says how to fetch files from HTTP(S) servers, such
+// as object storage, which mostly is not public. The zero
+// value fetches with http.DefaultClient and no credentials.
+type Remote struct {
+	// Client makes the requests; http.DefaultClient if nil.
+	Client *http.Client
+
+	// Header is added to every request, e.g. an Authorization
+	// bearer token or the headers of a signed request.
+	Header http.Header
+
+	// Username and Password, if set, are sent with basic
+	// authentication.
+	Username string
+	Password string
+
+	// Refresh, if set, is called for a new URL to fetch when
+	// the server refuses the one it has, as happens once a
+	// pre-signed URL expires, and the request is tried again
+	// with it once. Later requests for url use the new URL.
+	// It is called ahead of fetching URLs other than HTTP(S)
+	// ones as well, such as s3://bucket/key, which it is to
+	// pre-sign, with the SDK of the storage.
+	Refresh func(ctx context.Context, url string) (string, error)
+
+	mu        sync.Mutex
+	refreshed map[string]string
+}
+
+// Open fetches url, returning the body of the response.
+func (r *Remote) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	resp, err := r.get(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get requests url with the credentials of r and header on
+// top, refreshing the URL if refused, and fails unless the
+// response is a success.
+func (r *Remote) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	current := r.current(url)
+	if !fetchable(current) && r.Refresh == nil {
+		return nil, fmt.Errorf("Cannot fetch %s: only HTTP(S) URLs can be fetched without a Remote.Refresh to pre-sign others", redactURL(url))
+	}
+	if !fetchable(current) {
+		fresh, err := r.refresh(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		current = fresh
+	}
+	resp, err := r.do(ctx, current, header)
+	if err != nil {
+		return nil, err
+	}
+	refused := resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+	if refused && r.Refresh != nil {
+		resp.Body.Close()
+		fresh, err := r.refresh(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = r.do(ctx, fresh, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Cannot fetch %s: %s", redactURL(url), resp.Status)
+	}
+	return resp, nil
+}
+
+// refresh has Refresh give a new URL for url, which later
+// requests use.
+func (r *Remote) refresh(ctx context.Context, url string) (string, error) {
+	fresh, err := r.Refresh(ctx, url)
+	if err != nil {
+		return "", fmt.Errorf("Cannot refresh URL of %s: %v", redactURL(url), err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.refreshed == nil {
+		r.refreshed = make(map[string]string)
+	}
+	r.refreshed[url] = fresh
+	return fresh, nil
+}
+
+// fetchable reports whether url is one for HTTP.
+func fetchable(url string) bool {
+	u, err := neturl.Parse(url)
+	return err == nil && (strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https"))
+}
+
+func (r *Remote) current(url string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fresh, ok := r.refreshed[url]; ok {
+		return fresh
+	}
+	return url
+}
+
+func (r *Remote) do(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot fetch %s: %v", redactURL(url), err)
+	}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if ue, ok := err.(*neturl.Error); ok {
+		err = ue.Err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot fetch %s: %v", redactURL(url), err)
+	}
+	return resp, nil
+}
+
+// redactURL returns url without the query and credentials,
+// which of a pre-signed URL are a secret, for messages.
+func redactURL(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "remote file"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// DefaultMaxDownload is how large a remote file may be when
+// URLOptions.MaxSize is zero.
+const DefaultMaxDownload = 2 << 30
+
+// ErrTooLarge is a remote file larger than
+// URLOptions.MaxSize.
+var ErrTooLarge = errors.New("remote file too large")
+
+// URLOptions tunes CalculateLoudnessURLWithOptions. Options
+// are used for the analysis; the file is downloaded to their
+// TempDir.
+type URLOptions struct {
+	// Remote has the credentials to fetch with; nil fetches
+	// without.
+	Remote *Remote
+
+	// MaxSize caps the size of the file, in bytes;
+	// DefaultMaxDownload if zero.
+	MaxSize int64
+
+	Options Options
+}
+
+// CalculateLoudnessURL measures the audio at url, on an
+// HTTP(S) server, as CalculateLoudness does a file.
+func CalculateLoudnessURL(ctx context.Context, url string) (LoudnessData, error) {
+	ld, _, err := CalculateLoudnessURLWithOptions(ctx, url, URLOptions{})
+	return ld, err
+}
+
+// CalculateLoudnessURLWithOptions is like
+// CalculateLoudnessURL, but takes options and reports how the
+// analysis went. The file is downloaded to a scratch file,
+// which keeps the extension of the URL path for the tools to
+// go by, and analyzed by opts.Options; ctx aborts both,
+// unless the options have a Context of their own. Only
+// HTTP(S) URLs are fetched as they are: object storage URLs
+// such as s3:// fail unless the Remote has a Refresh to turn
+// them into pre-signed HTTP(S) ones.
+func CalculateLoudnessURLWithOptions(ctx context.Context, url string, opts URLOptions) (LoudnessData, AnalysisInfo, error) {
+	remote := opts.Remote
+	if remote == nil {
+		remote = &Remote{}
+	}
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDownload
+	}
+	if opts.Options.Context == nil {
+		opts.Options.Context = ctx
+	}
+	ctx = opts.Options.Context
+
+	file, err := remote.download(ctx, url, opts.Options.TempDir, maxSize)
+	if err != nil {
+		logf(opts.Options, LogError, "cannot download %s: %v", redactURL(url), err)
+		return LoudnessData{}, AnalysisInfo{}, err
+	}
+	defer os.Remove(file)
+	return CalculateLoudnessWithOptions(file, opts.Options)
+}
+
+// download saves url in a scratch file in dir, failing with
+// ErrTooLarge if it is larger than maxSize.
+func (r *Remote) download(ctx context.Context, url, dir string, maxSize int64) (string, error) {
+	resp, err := r.get(ctx, url, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength > maxSize {
+		return "", fmt.Errorf("%w: %s is %d bytes", ErrTooLarge, redactURL(url), resp.ContentLength)
+	}
+
+	ext := ""
+	if u, err := neturl.Parse(url); err == nil {
+		ext = path.Ext(u.Path)
+	}
+	if strings.ContainsAny(ext, `*/\`) {
+		ext = ""
+	}
+	f, err := os.CreateTemp(dir, "bs1770wrap-remote-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("Cannot create scratch file: %v", err)
+	}
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxSize+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case err != nil:
+		err = fmt.Errorf("Cannot download %s: %v", redactURL(url), err)
+	case n > maxSize:
+		err = fmt.Errorf("%w: %s is over %d bytes", ErrTooLarge, redactURL(url), maxSize)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// proxy serves url on the loopback interface, under a random
+// path, fetching with the credentials of r, so that tools can
+// read it with range requests without the credentials or a
+// pre-signed URL on their command line. stop shuts it down.
+func (r *Remote) proxy(ctx context.Context, url string) (local string, stop func(), err error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", nil, fmt.Errorf("Cannot proxy %s: %v", redactURL(url), err)
+	}
+	ext := ""
+	if u, err := neturl.Parse(url); err == nil {
+		ext = path.Ext(u.Path)
+	}
+	p := fmt.Sprintf("/%x%s", token, neturl.PathEscape(ext))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("Cannot proxy %s: %v", redactURL(url), err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != p {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		header := http.Header{}
+		if v := req.Header.Get("Range"); v != "" {
+			header.Set("Range", v)
+		}
+		resp, err := r.get(req.Context(), url, header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for _, k := range []string{"Accept-Ranges", "Content-Length", "Content-Range", "Content-Type", "ETag", "Last-Modified"} {
+			if v := resp.Header.Get(k); v != "" {
+				w.Header().Set(k, v)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		if req.Method == http.MethodGet {
+			io.Copy(w, resp.Body)
+		}
+	})}
+	if ctx != nil {
+		srv.BaseContext = func(net.Listener) context.Context { return ctx }
+	}
+	go srv.Serve(l)
+	return "http://" + l.Addr().String() + p, func() { srv.Close() }, nil
+}
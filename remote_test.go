@@ -0,0 +1,35 @@
+package bs1770wrap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRemoteSchemes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("audio"))
+	}))
+	defer srv.Close()
+
+	_, _, err := CalculateLoudnessURLWithOptions(context.Background(), "s3://bucket/a.wav", URLOptions{})
+	if err == nil || !strings.Contains(err.Error(), "Remote.Refresh") {
+		t.Errorf("s3:// without Refresh failed with %v, want an error naming Remote.Refresh", err)
+	}
+
+	var asked string
+	remote := &Remote{Refresh: func(ctx context.Context, url string) (string, error) {
+		asked = url
+		return srv.URL + "/a.wav?X-Amz-Signature=sig", nil
+	}}
+	body, err := remote.Open(context.Background(), "s3://bucket/a.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if asked != "s3://bucket/a.wav" {
+		t.Errorf("Refresh was asked for %q, want the s3:// URL", asked)
+	}
+}
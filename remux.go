@@ -0,0 +1,79 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// containerDamaged reports whether ffprobe finds fault with
+// the container of file, as opposed to the analysis failing
+// for other reasons.
+func containerDamaged(file string, opts Options, info *AnalysisInfo) bool {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format=format_name",
+		"-of", "csv",
+		ffmpegPath(file),
+	)
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := run("ffprobe", cmd, opts, info)
+	info.Timings.Probe += time.Since(start)
+	return err != nil || strings.TrimSpace(stderr.String()) != ""
+}
+
+// retryRemuxed retries an analysis that failed with err on a
+// copy of file remuxed into a fresh container, if the
+// container is what seems to be at fault. The copy has the
+// same streams, packet for packet, so only the container
+// structure is rebuilt.
+func retryRemuxed(file string, err error, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	if fi, serr := os.Stat(file); serr != nil || fi.IsDir() {
+		return LoudnessData{}, err
+	}
+	if !containerDamaged(file, opts, info) {
+		return LoudnessData{}, err
+	}
+	logf(opts, LogWarn, "container of %s is damaged, retrying on a remuxed copy", file)
+
+	dir, derr := os.MkdirTemp(opts.TempDir, "bs1770wrap")
+	if derr != nil {
+		return LoudnessData{}, fmt.Errorf("Error creating temporary directory: %v", derr)
+	}
+	defer os.RemoveAll(dir)
+
+	var stderr bytes.Buffer
+	remuxed := filepath.Join(dir, "remuxed"+filepath.Ext(file))
+	cmd := exec.Command("ffmpeg",
+		"-nostdin",
+		"-loglevel", "error",
+		"-i", ffmpegPath(file),
+		"-map", "0:a",
+		"-c", "copy",
+		ffmpegPath(remuxed),
+	)
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	rerr := run("ffmpeg", cmd, opts, info)
+	info.Timings.Preprocess += time.Since(start)
+	if rerr != nil {
+		return LoudnessData{}, fmt.Errorf("%w; cannot remux: %v: %s", err, rerr, strings.TrimSpace(stderr.String()))
+	}
+
+	ld, rerr := analyze(remuxed, opts, info)
+	if rerr != nil {
+		return LoudnessData{}, fmt.Errorf("%w; remuxed copy failed too: %v", err, rerr)
+	}
+	info.Remuxed = true
+	info.Warnings = append(info.Warnings, "analyzed a remuxed copy after container errors, the original may be damaged")
+	return ld, nil
+}
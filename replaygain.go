@@ -0,0 +1,133 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ReplayGain 2.0 tags, as read by players. Gains are in dB
+// and bring the loudness to the reference; peaks are linear.
+const (
+	TagReferenceLoudness = "REPLAYGAIN_REFERENCE_LOUDNESS"
+	TagTrackGain         = "REPLAYGAIN_TRACK_GAIN"
+	TagTrackPeak         = "REPLAYGAIN_TRACK_PEAK"
+	TagAlbumGain         = "REPLAYGAIN_ALBUM_GAIN"
+	TagAlbumPeak         = "REPLAYGAIN_ALBUM_PEAK"
+)
+
+// Opus files carry R128 gains instead (RFC 7845): Q7.8 fixed
+// point dB bringing the loudness to ReferenceEBUR128, on top
+// of the output gain of the header, with no peaks.
+const (
+	TagR128TrackGain = "R128_TRACK_GAIN"
+	TagR128AlbumGain = "R128_ALBUM_GAIN"
+)
+
+// ReplayGain are the ReplayGain values of a track and, if
+// HasAlbum is set, of the album it is on.
+type ReplayGain struct {
+	Reference float64 // LUFS
+
+	TrackGain float64 // dB
+	TrackPeak float64 // linear
+
+	HasAlbum  bool
+	AlbumGain float64
+	AlbumPeak float64
+}
+
+// NewReplayGain computes the ReplayGain values of a track
+// towards target, ReferenceReplayGain2 if zero. The peak is
+// the true peak.
+func NewReplayGain(ld LoudnessData, target float64) ReplayGain {
+	if target == 0 {
+		target = ReferenceReplayGain2
+	}
+	return ReplayGain{
+		Reference: target,
+		TrackGain: target - float64(ld.Integrated),
+		TrackPeak: DBToLinear(float64(ld.Peak)),
+	}
+}
+
+// WithAlbum returns rg with the album values of album set.
+func (rg ReplayGain) WithAlbum(album LoudnessData) ReplayGain {
+	rg.HasAlbum = true
+	rg.AlbumGain = rg.Reference - float64(album.Integrated)
+	rg.AlbumPeak = DBToLinear(float64(album.Peak))
+	return rg
+}
+
+// Tags returns the ReplayGain 2.0 tags of rg. Album tags are
+// left out unless HasAlbum is set.
+func (rg ReplayGain) Tags() map[string]string {
+	tags := map[string]string{
+		TagReferenceLoudness: fmt.Sprintf("%.2f LUFS", rg.Reference),
+		TagTrackGain:         fmt.Sprintf("%.2f dB", rg.TrackGain),
+		TagTrackPeak:         fmt.Sprintf("%.6f", rg.TrackPeak),
+	}
+	if rg.HasAlbum {
+		tags[TagAlbumGain] = fmt.Sprintf("%.2f dB", rg.AlbumGain)
+		tags[TagAlbumPeak] = fmt.Sprintf("%.6f", rg.AlbumPeak)
+	}
+	return tags
+}
+
+// OpusTags returns the R128 tags of rg, which are relative to
+// ReferenceEBUR128 whatever the Reference. Album tags are left
+// out unless HasAlbum is set.
+func (rg ReplayGain) OpusTags() map[string]string {
+	q78 := func(gain float64) string {
+		// loudness relative to the reference, as gain towards
+		// -23 LUFS
+		v := math.Round(256 * (gain - rg.Reference + ReferenceEBUR128))
+		return strconv.Itoa(int(math.Max(math.MinInt16, math.Min(math.MaxInt16, v))))
+	}
+	tags := map[string]string{TagR128TrackGain: q78(rg.TrackGain)}
+	if rg.HasAlbum {
+		tags[TagR128AlbumGain] = q78(rg.AlbumGain)
+	}
+	return tags
+}
+
+// WriteReplayGain tags file with the ReplayGain values of ld
+// towards target, ReferenceReplayGain2 if zero. See
+// WriteReplayGainTags.
+func WriteReplayGain(file string, ld LoudnessData, target float64) error {
+	return WriteReplayGainTags(file, NewReplayGain(ld, target), Options{})
+}
+
+// WriteReplayGainTags writes rg into file with WriteTags, as
+// ID3v2 TXXX frames, Vorbis comments or iTunes freeform items
+// depending on the format; Opus files get R128 tags instead.
+// Other tags are kept.
+func WriteReplayGainTags(file string, rg ReplayGain, opts Options) error {
+	codec, err := tagCodecFor(file)
+	if err != nil {
+		return err
+	}
+	tags := rg.Tags()
+	if codec.name == "opus" {
+		tags = rg.OpusTags()
+	}
+	return WriteTags(file, tags, opts)
+}
+
+// WriteAlbumReplayGain tags each analyzed track of album with
+// its own and the album's ReplayGain values towards target,
+// ReferenceReplayGain2 if zero, stopping at the first error.
+// Tracks that failed analysis are skipped.
+func WriteAlbumReplayGain(album AlbumLoudness, target float64, opts Options) error {
+	for _, t := range album.Tracks {
+		if t.Err != nil {
+			continue
+		}
+		rg := NewReplayGain(t.Loudness, target).WithAlbum(album.Album)
+		err := WriteReplayGainTags(t.File, rg, opts)
+		if err != nil {
+			return fmt.Errorf("Cannot tag %s: %v", t.File, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package bs1770wrap
+
+import (
+	"io"
+	"text/template"
+	"time"
+)
+
+// Report is everything known about the analysis of one file,
+// in a form meant for rendering.
+type Report struct {
+	File       string
+	Loudness   LoudnessData
+	Info       AnalysisInfo
+	Err        error // analysis failure, other fields are then zero
+	Profile    *Profile
+	Violations []Violation
+	Advice     []Advisory
+	Penalties  []PlatformPenalty
+}
+
+// NewReport assembles the report for an analysis. If profile
+// is given, the result is checked against it and advice is
+// given relative to its target; otherwise advice assumes
+// normalization to ReferenceStreaming.
+func NewReport(file string, ld LoudnessData, info AnalysisInfo, err error, profile *Profile) Report {
+	r := Report{File: file, Info: info, Err: err, Profile: profile}
+	if err != nil {
+		return r
+	}
+
+	r.Loudness = ld
+	target := float32(ReferenceStreaming)
+	if profile != nil {
+		r.Violations = profile.Check(ld)
+		target = float32(profile.Target)
+	}
+	r.Advice = Advise(ld, target)
+	r.Penalties = LoudnessPenalties(ld)
+	return r
+}
+
+// Compliant reports whether the file was analyzed and met
+// the report's profile (if any).
+func (r Report) Compliant() bool {
+	return r.Err == nil && len(r.Violations) == 0
+}
+
+// BatchReport collects the reports of several files.
+type BatchReport struct {
+	Reports []Report
+	Failed  int     // reports with an analysis error
+	Timings Timings // summed over all reports
+	Stats   LibraryStats
+}
+
+// NewBatchReport aggregates per-file reports.
+func NewBatchReport(reports []Report) BatchReport {
+	b := BatchReport{Reports: reports}
+
+	var results []StoredResult
+	var profiles []Profile
+	seen := make(map[string]bool)
+	for _, r := range reports {
+		b.Timings.Add(r.Info.Timings)
+		if r.Err != nil {
+			b.Failed++
+			continue
+		}
+		results = append(results, StoredResult{File: r.File, Loudness: r.Loudness})
+		if r.Profile != nil && !seen[r.Profile.ID] {
+			seen[r.Profile.ID] = true
+			profiles = append(profiles, *r.Profile)
+		}
+	}
+	b.Stats = ComputeLibraryStats(results, profiles)
+	return b
+}
+
+// ReportFuncs are the functions available to report
+// templates, besides the text/template builtins:
+//
+//	level      formats a level with DefaultPrecision decimals
+//	levelp     formats a level with the given decimals
+//	length     turns LoudnessData.Length into a time.Duration
+var ReportFuncs = template.FuncMap{
+	"level": func(v float32) string {
+		return FormatLevel(v, DefaultPrecision)
+	},
+	"levelp": func(decimals int, v float32) string {
+		return FormatLevel(v, decimals)
+	},
+	"length": func(us uint64) time.Duration {
+		return time.Duration(us) * time.Microsecond
+	},
+}
+
+// ParseReportTemplate parses a report template with
+// ReportFuncs available. Per-file templates are executed with
+// a Report, batch templates with a BatchReport.
+func ParseReportTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(ReportFuncs).Parse(text)
+}
+
+// Render executes tmpl with the report.
+func (r Report) Render(w io.Writer, tmpl *template.Template) error {
+	return tmpl.Execute(w, r)
+}
+
+// Render executes tmpl with the batch report.
+func (b BatchReport) Render(w io.Writer, tmpl *template.Template) error {
+	return tmpl.Execute(w, b)
+}
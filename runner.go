@@ -0,0 +1,65 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+)
+
+// Runner runs the external tools of an analysis in place of
+// the package, for tests and CI to stand in for sox, ffmpeg
+// or bs1770gain without them installed, or for callers to see
+// exactly what is run. cmd is the tool as named ("sox",
+// "ffprobe"...) and args its arguments; stdin, if not nil, is
+// what the tool reads. A tool that ran but failed should be
+// reported with an *exec.ExitError, or an error wrapping
+// ErrDecodeFailed, so that the package tells it apart from
+// one that could not be run.
+type Runner interface {
+	Run(cmd string, args []string, stdin io.Reader) (stdout, stderr []byte, err error)
+}
+
+// RunnerFunc adapts a function to a Runner.
+type RunnerFunc func(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error)
+
+// Run implements Runner.
+func (f RunnerFunc) Run(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+	return f(cmd, args, stdin)
+}
+
+// ExecRunner is the Runner that runs the tools for real,
+// found as LookupTool finds them with Options, for Runners
+// that only look at the commands to wrap. Timeouts and memory
+// limits of Options do not apply to it.
+type ExecRunner struct {
+	Options Options
+}
+
+// Run implements Runner.
+func (r ExecRunner) Run(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+	path, err := LookupTool(cmd, r.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(path, args...)
+	c.Stdin = stdin
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err = c.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// runWith runs cmd with runner instead of starting it,
+// feeding it the stdin and taking the output cmd was set up
+// with.
+func runWith(runner Runner, name string, cmd *exec.Cmd, stderr io.Writer) error {
+	stdout, errOut, err := runner.Run(name, cmd.Args[1:], cmd.Stdin)
+	if cmd.Stdout != nil {
+		if _, werr := cmd.Stdout.Write(stdout); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	stderr.Write(errOut)
+	return err
+}
@@ -0,0 +1,145 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ScanOptions tunes ScanDirectory.
+type ScanOptions struct {
+	// Scanner analyzes the files; with NoSkip, files that are
+	// no audio are analyzed too, unless filtered out below.
+	Scanner Scanner
+
+	// Workers is the number of files analyzed at once,
+	// runtime.NumCPU() if not positive.
+	Workers int
+
+	// Extensions, if set, restricts the scan to files with one
+	// of them, such as ".flac", regardless of case.
+	Extensions []string
+
+	// Include, if set, restricts the scan to files matching
+	// one of its patterns, and Exclude leaves out the files
+	// and directories matching any of its. Patterns are those
+	// of path.Match, matched against the base name and
+	// the path relative to the root, with slashes.
+	Include []string
+	Exclude []string
+}
+
+// FileResult is the outcome of analyzing one file of
+// ScanDirectory.
+type FileResult struct {
+	File string
+	ScanResult
+}
+
+// ScanDirectory walks the tree under root and analyzes the
+// regular files opts let through, as Scanner.Scan does,
+// sending the results over the channel returned as they come
+// in. The channel is closed once the scan is over; it must be
+// drained. Entries that cannot be listed are sent with Err
+// set, and the walk goes on. Once the Context of the options
+// is done, no more files are queued.
+func ScanDirectory(root string, opts ScanOptions) (<-chan FileResult, error) {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+		}
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot scan %s: %v", root, err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("Cannot scan %s: not a directory", root)
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	results := make(chan FileResult, workers)
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				results <- FileResult{File: file, ScanResult: opts.Scanner.scan(file)}
+			}
+		}()
+	}
+
+	go func() {
+		ctx := opts.Scanner.Options.Context
+		filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if ctx != nil && ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				results <- FileResult{File: p, ScanResult: ScanResult{Err: fmt.Errorf("Cannot list %s: %v", p, err)}}
+				return nil
+			}
+			if p == root {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, p)
+			rel = filepath.ToSlash(rel)
+			if matchAny(opts.Exclude, rel) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !fi.Mode().IsRegular() || !opts.wants(rel) {
+				return nil
+			}
+			queue <- p
+			return nil
+		})
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+	return results, nil
+}
+
+// wants reports whether the file at rel passes the extension
+// and include filters.
+func (o ScanOptions) wants(rel string) bool {
+	if len(o.Extensions) > 0 {
+		ext := filepath.Ext(rel)
+		found := false
+		for _, e := range o.Extensions {
+			found = found || strings.EqualFold(ext, "."+strings.TrimPrefix(e, "."))
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(o.Include) == 0 || matchAny(o.Include, rel)
+}
+
+// matchAny reports whether rel, a slash separated path, or
+// its base name match one of patterns.
+func matchAny(patterns []string, rel string) bool {
+	base := rel[strings.LastIndex(rel, "/")+1:]
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// ScanResult is the outcome of analyzing one file of a scan.
+type ScanResult struct {
+	Loudness LoudnessData
+	Info     AnalysisInfo // empty for results served by the cache
+	Err      error
+
+	// Skipped is why the file was not analyzed, if it was
+	// not: it is no audio file, going by ClassifySkip.
+	Skipped SkipReason
+
+	// Aborted is why the analysis was aborted, if Err is set
+	// and it was, rather than failing on the file.
+	Aborted AbortReason
+}
+
+// Scanner analyzes many files at once, for libraries too
+// large to go through one by one.
+type Scanner struct {
+	Options Options
+
+	// Cache, if set, is consulted and filled instead of
+	// analyzing every file; its own Options apply then.
+	Cache *Cache
+
+	// NoSkip analyzes every file, audio or not, rather than
+	// skipping those ClassifySkip tells apart.
+	NoSkip bool
+}
+
+// Scan analyzes the files on a pool of workers goroutines,
+// runtime.NumCPU() if workers is not positive, returning the
+// results by path. Each file is analyzed once, however often
+// it is listed. The tools spawned count against
+// SetMaxProcesses like any others. Files that are no audio,
+// such as cover art, cue sheets, logs or partial downloads,
+// are reported as Skipped rather than failed, unless NoSkip
+// is set.
+func (s *Scanner) Scan(files []string, workers int) map[string]ScanResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	results := make(map[string]ScanResult, len(files))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				r := s.scan(file)
+				mu.Lock()
+				results[file] = r
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if !seen[file] {
+			seen[file] = true
+			queue <- file
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// ScanDir scans every file under dir, as Scan does.
+func (s *Scanner) ScanDir(dir string, workers int) (map[string]ScanResult, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list %s: %v", dir, err)
+	}
+	return s.Scan(files, workers), nil
+}
+
+func (s *Scanner) scan(file string) ScanResult {
+	if !s.NoSkip {
+		if reason, ok := ClassifySkip(file); ok {
+			logf(s.Options, LogDebug, "skipping %s: %s", file, reason)
+			return ScanResult{Skipped: reason}
+		}
+	}
+	if s.Cache != nil {
+		ld, err := s.Cache.CalculateLoudness(file)
+		return ScanResult{Loudness: ld, Err: err, Aborted: AbortReasonOf(err)}
+	}
+	ld, info, err := calculateSafely(file, s.Options)
+	return ScanResult{Loudness: ld, Info: info, Err: err, Aborted: AbortReasonOf(err)}
+}
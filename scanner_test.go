@@ -0,0 +1,162 @@
+package bs1770wrap
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackend measures every file as -20 LUFS, panicking on
+// those named panic.wav and, if block is set, waiting for the
+// analysis to be aborted.
+type fakeBackend struct {
+	block bool
+}
+
+func (fakeBackend) Name() string {
+	return "fake"
+}
+
+func (b fakeBackend) Analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	if filepath.Base(file) == "panic.wav" {
+		panic("backend bug")
+	}
+	if b.block && opts.Context != nil {
+		<-opts.Context.Done()
+		return LoudnessData{}, contextError(opts.Context)
+	}
+	return LoudnessData{Integrated: -20}, nil
+}
+
+// panicStore is a Store that panics on lookups.
+type panicStore struct {
+	MemoryStore
+}
+
+func (*panicStore) Get(key string) (LoudnessData, bool, error) {
+	panic("store bug")
+}
+
+func scanFiles(t *testing.T, names ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var files []string
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte("RIFF"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// checkGoroutines fails t unless the goroutines started since
+// there were before have all ended, allowing them a moment.
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left running, %d before:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScanRecoversPanics(t *testing.T) {
+	files := scanFiles(t, "a.wav", "panic.wav", "b.wav")
+	s := &Scanner{Options: Options{Backends: []LoudnessAnalyzer{fakeBackend{}}}}
+
+	results := s.Scan(files, 2)
+	for _, file := range files {
+		r := results[file]
+		var pe *PanicError
+		if strings.HasSuffix(file, "panic.wav") {
+			if !errors.As(r.Err, &pe) {
+				t.Errorf("%s failed with %v, want a *PanicError", file, r.Err)
+			}
+		} else if r.Err != nil || r.Loudness.Integrated != -20 {
+			t.Errorf("%s: %+v, want -20 LUFS", file, r)
+		}
+	}
+}
+
+func TestScanRecoversStorePanics(t *testing.T) {
+	files := scanFiles(t, "a.wav", "b.wav")
+	// the same key for both, so that one waits on the other's
+	// flight
+	keys := KeyFunc(func(string) (string, error) { return "key", nil })
+	s := &Scanner{Cache: &Cache{Store: &panicStore{}, Keys: keys, Options: Options{Backends: []LoudnessAnalyzer{fakeBackend{}}}}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file, r := range s.Scan(files, 2) {
+				var pe *PanicError
+				if !errors.As(r.Err, &pe) {
+					t.Errorf("%s failed with %v, want a *PanicError", file, r.Err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestScanLeavesNoGoroutines(t *testing.T) {
+	files := scanFiles(t, "a.wav", "b.wav", "panic.wav", "c.wav", "d.wav")
+	before := runtime.NumGoroutine()
+
+	s := &Scanner{Options: Options{Backends: []LoudnessAnalyzer{fakeBackend{}}}}
+	if results := s.Scan(files, 3); len(results) != len(files) {
+		t.Fatalf("scanned %d files, want %d", len(results), len(files))
+	}
+	checkGoroutines(t, before)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s = &Scanner{Options: Options{Backends: []LoudnessAnalyzer{fakeBackend{block: true}}, Context: ctx}}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	for file, r := range s.Scan(files, 3) {
+		if r.Err == nil && !strings.HasSuffix(file, "panic.wav") {
+			t.Errorf("%s was analyzed despite the cancellation", file)
+		}
+	}
+	checkGoroutines(t, before)
+}
+
+func TestScanDirectoryLeavesNoGoroutines(t *testing.T) {
+	files := scanFiles(t, "a.wav", "b.wav", "panic.wav", "c.wav", "d.wav")
+	root := filepath.Dir(files[0])
+	before := runtime.NumGoroutine()
+
+	for _, block := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := ScanOptions{Workers: 2}
+		opts.Scanner.Options = Options{Backends: []LoudnessAnalyzer{fakeBackend{block: block}}, Context: ctx}
+		results, err := ScanDirectory(root, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block {
+			time.AfterFunc(50*time.Millisecond, cancel)
+		}
+		n := 0
+		for range results {
+			n++
+		}
+		cancel()
+		if !block && n != len(files) {
+			t.Errorf("ScanDirectory sent %d results, want %d", n, len(files))
+		}
+		checkGoroutines(t, before)
+	}
+}
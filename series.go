@@ -0,0 +1,189 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffmpeg's ebur128 filter logs a line per 100 ms of input:
+//
+//	[Parsed_ebur128_0 @ 0x...] t: 1.2  TARGET:-23 LUFS  M: -20.4 S: -21.9  I: -21.0 LUFS  LRA: 1.3 LU
+//
+// t is the end of the 400 ms (momentary) and 3 s
+// (short-term) windows the values were measured over.
+var seriesRegex = regexp.MustCompile(`t:\s*([0-9.]+)\s+TARGET:.*?M:\s*(\S+)\s+S:\s*(\S+)`)
+
+// loudnessSeries measures the momentary and short-term
+// loudness of file every 100 ms with ffmpeg. Times are on
+// ffmpeg's timeline, see ffmpegOffset.
+func loudnessSeries(file string, opts Options, info *AnalysisInfo) ([]LoudnessSample, error) {
+	out, err := runEBUR128(file, "ebur128=framelog=info", nil, opts, info)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot measure loudness series: %w", err)
+	}
+
+	start := time.Now()
+	defer func() { info.Timings.Parse += time.Since(start) }()
+	return parseSeries(out)
+}
+
+// CalculateLoudnessSeries measures the momentary and
+// short-term loudness of file every 100 ms, in media time,
+// for drawing loudness graphs and finding passages such as
+// with LoudestPassage. Each sample measures the 400 ms
+// (momentary) and 3 s (short-term) up to its time. No
+// preprocessing is applied. It requires ffmpeg.
+func CalculateLoudnessSeries(file string, opts Options) ([]LoudnessSample, error) {
+	return mediaSeries(file, opts)
+}
+
+// LoudestPassage returns where the passage of the given
+// length with the highest mean momentary energy starts, and
+// its loudness, LUFS. A series no longer than length is a
+// single passage starting at zero; an empty one is silent.
+func LoudestPassage(series []LoudnessSample, length time.Duration) (time.Duration, float64) {
+	n := int(length / seriesHop)
+	if len(series) == 0 {
+		return 0, math.Inf(-1)
+	}
+	if n < 1 || len(series) <= n {
+		sum := 0.0
+		for _, s := range series {
+			sum += LoudnessToEnergy(float64(s.Momentary))
+		}
+		return 0, EnergyToLoudness(sum / float64(len(series)))
+	}
+
+	// sliding sum of energy over n samples
+	sum := 0.0
+	for _, s := range series[:n] {
+		sum += LoudnessToEnergy(float64(s.Momentary))
+	}
+	best, bestSum := 0, sum
+	for i := n; i < len(series); i++ {
+		sum += LoudnessToEnergy(float64(series[i].Momentary)) - LoudnessToEnergy(float64(series[i-n].Momentary))
+		if sum > bestSum {
+			best, bestSum = i-n+1, sum
+		}
+	}
+
+	start := series[best].At - 400*time.Millisecond
+	if start < 0 {
+		start = 0
+	}
+	return start, EnergyToLoudness(bestSum / float64(n))
+}
+
+// mediaSeries is loudnessSeries in media time.
+func mediaSeries(file string, opts Options) ([]LoudnessSample, error) {
+	info := AnalysisInfo{}
+	if g, ok, err := ReadGapless(file); err == nil && ok {
+		info.Gapless = &g
+		info.MediaOffset = g.Offset()
+	}
+	series, err := loudnessSeries(file, opts, &info)
+	if err != nil {
+		return nil, err
+	}
+	return alignSeries(series, ffmpegOffset(&info)), nil
+}
+
+// runEBUR128 runs ffmpeg's ebur128 filter, as configured by
+// filter, over file and returns ffmpeg's log, which is also
+// written to progress as it comes if set.
+func runEBUR128(file, filter string, progress io.Writer, opts Options, info *AnalysisInfo) (string, error) {
+	cmd := exec.Command("ffmpeg", ebur128Args(filter, "-nostdin", "-i", ffmpegPath(file))...)
+	return runFilterLog(cmd, progress, opts, info)
+}
+
+// ebur128Args are the ffmpeg arguments running filter over
+// the given input.
+func ebur128Args(filter string, input ...string) []string {
+	args := []string{"-nostats", "-loglevel", "info"}
+	args = append(args, input...)
+	return append(args, "-vn", "-af", filter, "-f", "null", "-")
+}
+
+// runFilterLog runs an ffmpeg command and returns its log.
+func runFilterLog(cmd *exec.Cmd, progress io.Writer, opts Options, info *AnalysisInfo) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if progress != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, progress)
+	}
+
+	start := time.Now()
+	err := run("ffmpeg", cmd, opts, info)
+	info.Timings.Analyze += time.Since(start)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+	return stderr.String(), nil
+}
+
+// parseSeries parses the frame log of the ebur128 filter.
+func parseSeries(out string) ([]LoudnessSample, error) {
+	var series []LoudnessSample
+	for _, line := range strings.Split(out, "\n") {
+		m := seriesRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		t, err1 := strconv.ParseFloat(m[1], 64)
+		mom, err2 := parseLevel(m[2])
+		st, err3 := parseLevel(m[3])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, withKind(ErrParse, fmt.Errorf("Cannot parse loudness series: %q", strings.TrimSpace(line)))
+		}
+		series = append(series, LoudnessSample{
+			At:        time.Duration(t * float64(time.Second)),
+			Momentary: mom,
+			Shortterm: st,
+		})
+	}
+	if len(series) == 0 {
+		return nil, withKind(ErrNoLoudnessData, fmt.Errorf("Cannot parse loudness series: no measurements in output"))
+	}
+	return series, nil
+}
+
+// parseLevel parses a level as ffmpeg prints it, which may
+// be "-inf" or "nan" for silence.
+func parseLevel(s string) (float32, error) {
+	v, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) {
+		v = math.Inf(-1)
+	}
+	return float32(v), nil
+}
+
+// lastLine returns the last non-empty line of tool output,
+// which for ffmpeg is where the error is.
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// ffmpegOffset returns how much later than in media time
+// positions are on ffmpeg's timeline. ffmpeg skips the
+// delay a LAME header records, but not that of an iTunSMPB
+// tag.
+func ffmpegOffset(info *AnalysisInfo) time.Duration {
+	if info.Gapless != nil && info.Gapless.Source == "iTunSMPB" {
+		return info.MediaOffset
+	}
+	return 0
+}
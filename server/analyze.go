@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/burillo-se/bs1770wrap"
+)
+
+// Default limits of Analyzer.
+const (
+	DefaultAnalyzeTimeout = 10 * time.Minute
+	DefaultMaxUpload      = 2 << 30
+)
+
+// Analyzer serves loudness analyses, for running the package
+// as a sidecar of a media pipeline:
+//
+//	POST /analyze with a multipart form, the audio in its "file" field
+//	POST /analyze?path=album/01.flac
+//
+// The first analyzes the upload, the second a file under
+// Root on the server's side, if Root is set. The response is
+// the Loudness measured, as JSON. Failed
+// analyses are answered with 422, and aborted ones with 503,
+// or 504 if they timed out.
+//
+// It is the Analyzer service of analyzerpb too, over HTTP/2,
+// by way of grpc.Server's ServeHTTP; without TLS, the
+// http.Server must speak unencrypted HTTP/2, by its Protocols
+// from Go 1.24 or golang.org/x/net/http2/h2c before. The
+// handler does not route, so mount it at both paths:
+//
+//	mux.Handle("POST /analyze", analyzer)
+//	mux.Handle("POST /bs1770wrap.Analyzer/Analyze", analyzer)
+//
+// or, to serve gRPC on a listener of its own, as grpc-go
+// serves best, register it on a grpc.Server with RegisterGRPC.
+type Analyzer struct {
+	Options bs1770wrap.Options
+
+	// Root is the directory paths are relative to; if empty,
+	// only uploads are analyzed. Symbolic links under it are
+	// followed.
+	Root string
+
+	// MaxConcurrent is how many analyses run at once, others
+	// waiting their turn; runtime.NumCPU() if zero.
+	MaxConcurrent int
+
+	// Timeout caps each request, the upload and the wait for
+	// a turn included; MaxUpload caps its body, in bytes.
+	// Zero means DefaultAnalyzeTimeout and DefaultMaxUpload.
+	Timeout   time.Duration
+	MaxUpload int64
+
+	once  sync.Once
+	slots chan struct{}
+
+	grpcOnce sync.Once
+	grpc     *grpc.Server
+}
+
+// Loudness is the JSON form of a measurement, with the field
+// names of Result. Levels that are -Inf, as for silence, are
+// null.
+type Loudness struct {
+	Integrated bs1770wrap.JSONLevel `json:"integrated"`
+	Peak       bs1770wrap.JSONLevel `json:"peak"`
+	Range      bs1770wrap.JSONLevel `json:"range"`
+	Shortterm  bs1770wrap.JSONLevel `json:"shortterm"`
+	Momentary  bs1770wrap.JSONLevel `json:"momentary"`
+	Length     uint64               `json:"length"`
+	Backend    string               `json:"backend,omitempty"`
+}
+
+// NewLoudness returns the JSON form of ld, measured by the
+// backend info names.
+func NewLoudness(ld bs1770wrap.LoudnessData, info bs1770wrap.AnalysisInfo) Loudness {
+	return Loudness{
+		Integrated: bs1770wrap.JSONLevel(ld.Integrated),
+		Peak:       bs1770wrap.JSONLevel(ld.Peak),
+		Range:      bs1770wrap.JSONLevel(ld.Range),
+		Shortterm:  bs1770wrap.JSONLevel(ld.Shortterm),
+		Momentary:  bs1770wrap.JSONLevel(ld.Momentary),
+		Length:     ld.Length,
+		Backend:    info.Backend,
+	}
+}
+
+func (a *Analyzer) acquire(ctx context.Context) error {
+	a.once.Do(func() {
+		n := a.MaxConcurrent
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		a.slots = make(chan struct{}, n)
+	})
+	select {
+	case a.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *Analyzer) release() {
+	<-a.slots
+}
+
+// bound has reading the body of r fail once ctx is done, so
+// that a client slow to upload cannot hold a handler past the
+// timeout: the deadline of ctx is set on the connection, and
+// the body is closed when ctx is cancelled. The returned
+// function is to be called once done with the body.
+func bound(ctx context.Context, w http.ResponseWriter, r *http.Request) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		// not every ResponseWriter supports it, closing the body
+		// does for those
+		http.NewResponseController(w).SetReadDeadline(deadline)
+	}
+	body := r.Body
+	stop := context.AfterFunc(ctx, func() { body.Close() })
+	return func() { stop() }
+}
+
+// ServeHTTP implements http.Handler.
+func (a *Analyzer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if isGRPC(r) {
+		a.serveGRPC(w, r)
+		return
+	}
+
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = DefaultAnalyzeTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	defer bound(ctx, w, r)()
+	maxUpload := a.MaxUpload
+	if maxUpload <= 0 {
+		maxUpload = DefaultMaxUpload
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+
+	// the turn is taken before the upload, so that the limit
+	// bounds the scratch space uploads take too
+	var file string
+	var status int
+	var err error
+	p := r.URL.Query().Get("path")
+	if p != "" {
+		file, status, err = a.local(p)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	err = a.acquire(ctx)
+	if err != nil {
+		http.Error(w, "too busy", http.StatusServiceUnavailable)
+		return
+	}
+	defer a.release()
+
+	if p == "" {
+		file, status, err = a.upload(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				status = http.StatusRequestTimeout // see bound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		defer os.Remove(file)
+	}
+
+	opts := a.Options
+	opts.Context = ctx
+	ld, info, err := bs1770wrap.CalculateLoudnessWithOptions(file, opts)
+	if err != nil {
+		switch bs1770wrap.AbortReasonOf(err) {
+		case "":
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case bs1770wrap.AbortTimeout:
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		default:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	buf, err := json.Marshal(NewLoudness(ld, info))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot encode result: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(buf, '\n'))
+}
+
+// local resolves p under Root.
+func (a *Analyzer) local(p string) (string, int, error) {
+	if a.Root == "" {
+		return "", http.StatusForbidden, fmt.Errorf("paths are not served")
+	}
+	rel := filepath.FromSlash(strings.TrimPrefix(p, "/"))
+	if !filepath.IsLocal(rel) {
+		return "", http.StatusBadRequest, fmt.Errorf("bad path %q", p)
+	}
+	file := filepath.Join(a.Root, rel)
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", http.StatusNotFound, fmt.Errorf("no such file %q", p)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", http.StatusBadRequest, fmt.Errorf("%q is not a file", p)
+	}
+	return file, 0, nil
+}
+
+// upload saves the file of the multipart form of r in a
+// scratch file, which keeps the extension of the upload for
+// the tools to go by and must be removed once done with.
+func (a *Analyzer) upload(r *http.Request) (string, int, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return "", http.StatusBadRequest, fmt.Errorf("expected a path or a multipart upload")
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("bad upload: %v", err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return "", http.StatusBadRequest, fmt.Errorf("no file uploaded")
+		}
+		if err != nil {
+			return "", uploadStatus(err), fmt.Errorf("bad upload: %v", err)
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		ext := filepath.Ext(filepath.Base(filepath.FromSlash(part.FileName())))
+		if strings.Contains(ext, "*") {
+			ext = ""
+		}
+		f, err := os.CreateTemp(a.Options.TempDir, "bs1770wrap-upload-*"+ext)
+		if err != nil {
+			return "", http.StatusInternalServerError, fmt.Errorf("Cannot save upload: %v", err)
+		}
+		_, err = io.Copy(f, part)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return "", uploadStatus(err), fmt.Errorf("Cannot save upload: %v", err)
+		}
+		return f.Name(), 0, nil
+	}
+}
+
+func uploadStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return http.StatusRequestTimeout
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burillo-se/bs1770wrap"
+)
+
+// silentWAV writes a second of 16-bit mono silence.
+func silentWAV(t *testing.T, file string) {
+	t.Helper()
+	pcm := make([]byte, 2*48000)
+	buf := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	buf = binary.LittleEndian.AppendUint32(buf, 16)
+	buf = binary.LittleEndian.AppendUint16(buf, 1) // PCM
+	buf = binary.LittleEndian.AppendUint16(buf, 1)
+	buf = binary.LittleEndian.AppendUint32(buf, 48000)
+	buf = binary.LittleEndian.AppendUint32(buf, 2*48000)
+	buf = binary.LittleEndian.AppendUint16(buf, 2)
+	buf = binary.LittleEndian.AppendUint16(buf, 16)
+	buf = append(buf, "data"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(pcm)))
+	buf = append(buf, pcm...)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	if err := os.WriteFile(file, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalyzeSilence(t *testing.T) {
+	dir := t.TempDir()
+	silentWAV(t, filepath.Join(dir, "silence.wav"))
+	srv := httptest.NewServer(&Analyzer{Root: dir, Options: bs1770wrap.Options{Backends: []bs1770wrap.LoudnessAnalyzer{bs1770wrap.Native{}}}})
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/analyze?path=silence.wav", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("silence answered with %s: %s", resp.Status, body)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("bad response %q: %v", body, err)
+	}
+	for _, key := range []string{"integrated", "peak"} {
+		if v, ok := got[key]; !ok || v != nil {
+			t.Errorf("%s of silence is %v, want null", key, v)
+		}
+	}
+	if got["length"] != float64(1000000) || got["backend"] != "native" {
+		t.Errorf("response %s, want the length and backend of the analysis", body)
+	}
+
+	var l Loudness
+	if err := json.Unmarshal(body, &l); err != nil || !math.IsInf(float64(l.Integrated), -1) {
+		t.Errorf("response reads back as %+v, %v; want -Inf", l, err)
+	}
+}
+
+func TestUploadWaitsForTurn(t *testing.T) {
+	scratch := t.TempDir()
+	a := &Analyzer{MaxConcurrent: 1, Timeout: 500 * time.Millisecond, Options: bs1770wrap.Options{TempDir: scratch}}
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+	a.acquire(context.Background()) // a running analysis
+	defer a.release()
+
+	body := "--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.wav\"\r\n\r\nRIFF\r\n--b--\r\n"
+	done := make(chan int)
+	go func() {
+		resp, err := http.Post(srv.URL+"/analyze", "multipart/form-data; boundary=b", strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	if files, _ := os.ReadDir(scratch); len(files) != 0 {
+		t.Errorf("upload saved to %s before its turn", files[0].Name())
+	}
+	if status := <-done; status != http.StatusServiceUnavailable {
+		t.Errorf("upload with no turn free answered with %d, want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestUploadTimesOut(t *testing.T) {
+	srv := httptest.NewServer(&Analyzer{Timeout: 200 * time.Millisecond})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the start of an upload, and nothing after
+	body := "--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.wav\"\r\n\r\nRIFF"
+	fmt.Fprintf(conn, "POST /analyze HTTP/1.1\r\nHost: x\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: 1000000\r\n\r\n%s", body)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("no response to a stalled upload: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("stalled upload answered with %s, want %d", resp.Status, http.StatusRequestTimeout)
+	}
+}
@@ -0,0 +1,289 @@
+// The gRPC service of server.Analyzer, for clients in other
+// languages to stream audio to the analyzer. Generate stubs
+// for them with the usual protoc plugins; the Go ones are in
+// this directory, see generate.go.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: analyzer.proto
+
+package analyzerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AudioChunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// The ffmpeg demuxer of the audio ("wav", "flac", "mp3",
+	// ...), empty to have ffmpeg guess; only read from the
+	// first chunk.
+	Format string `protobuf:"bytes,2,opt,name=format,proto3" json:"format,omitempty"`
+}
+
+func (x *AudioChunk) Reset() {
+	*x = AudioChunk{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_analyzer_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AudioChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AudioChunk) ProtoMessage() {}
+
+func (x *AudioChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_analyzer_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AudioChunk.ProtoReflect.Descriptor instead.
+func (*AudioChunk) Descriptor() ([]byte, []int) {
+	return file_analyzer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AudioChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *AudioChunk) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type LoudnessResult struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Integrated float32 `protobuf:"fixed32,1,opt,name=integrated,proto3" json:"integrated,omitempty"` // LUFS
+	Peak       float32 `protobuf:"fixed32,2,opt,name=peak,proto3" json:"peak,omitempty"`             // dBTP
+	Range      float32 `protobuf:"fixed32,3,opt,name=range,proto3" json:"range,omitempty"`           // LU
+	Shortterm  float32 `protobuf:"fixed32,4,opt,name=shortterm,proto3" json:"shortterm,omitempty"`   // LUFS
+	Momentary  float32 `protobuf:"fixed32,5,opt,name=momentary,proto3" json:"momentary,omitempty"`   // LUFS
+	Length     uint64  `protobuf:"varint,6,opt,name=length,proto3" json:"length,omitempty"`          // microseconds
+	Backend    string  `protobuf:"bytes,7,opt,name=backend,proto3" json:"backend,omitempty"`
+}
+
+func (x *LoudnessResult) Reset() {
+	*x = LoudnessResult{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_analyzer_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LoudnessResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoudnessResult) ProtoMessage() {}
+
+func (x *LoudnessResult) ProtoReflect() protoreflect.Message {
+	mi := &file_analyzer_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoudnessResult.ProtoReflect.Descriptor instead.
+func (*LoudnessResult) Descriptor() ([]byte, []int) {
+	return file_analyzer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LoudnessResult) GetIntegrated() float32 {
+	if x != nil {
+		return x.Integrated
+	}
+	return 0
+}
+
+func (x *LoudnessResult) GetPeak() float32 {
+	if x != nil {
+		return x.Peak
+	}
+	return 0
+}
+
+func (x *LoudnessResult) GetRange() float32 {
+	if x != nil {
+		return x.Range
+	}
+	return 0
+}
+
+func (x *LoudnessResult) GetShortterm() float32 {
+	if x != nil {
+		return x.Shortterm
+	}
+	return 0
+}
+
+func (x *LoudnessResult) GetMomentary() float32 {
+	if x != nil {
+		return x.Momentary
+	}
+	return 0
+}
+
+func (x *LoudnessResult) GetLength() uint64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *LoudnessResult) GetBackend() string {
+	if x != nil {
+		return x.Backend
+	}
+	return ""
+}
+
+var File_analyzer_proto protoreflect.FileDescriptor
+
+var file_analyzer_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x61, 0x6e, 0x61, 0x6c, 0x79, 0x7a, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x0a, 0x62, 0x73, 0x31, 0x37, 0x37, 0x30, 0x77, 0x72, 0x61, 0x70, 0x22, 0x38, 0x0a, 0x0a,
+	0x41, 0x75, 0x64, 0x69, 0x6f, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x16,
+	0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x22, 0xc8, 0x01, 0x0a, 0x0e, 0x4c, 0x6f, 0x75, 0x64, 0x6e,
+	0x65, 0x73, 0x73, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x69, 0x6e, 0x74,
+	0x65, 0x67, 0x72, 0x61, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x02, 0x52, 0x0a, 0x69,
+	0x6e, 0x74, 0x65, 0x67, 0x72, 0x61, 0x74, 0x65, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x65, 0x61,
+	0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x02, 0x52, 0x04, 0x70, 0x65, 0x61, 0x6b, 0x12, 0x14, 0x0a,
+	0x05, 0x72, 0x61, 0x6e, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x02, 0x52, 0x05, 0x72, 0x61,
+	0x6e, 0x67, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x74, 0x65, 0x72, 0x6d,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x02, 0x52, 0x09, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x74, 0x65, 0x72,
+	0x6d, 0x12, 0x1c, 0x0a, 0x09, 0x6d, 0x6f, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x72, 0x79, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x02, 0x52, 0x09, 0x6d, 0x6f, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x72, 0x79, 0x12,
+	0x16, 0x0a, 0x06, 0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x06, 0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x12, 0x18, 0x0a, 0x07, 0x62, 0x61, 0x63, 0x6b, 0x65,
+	0x6e, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x62, 0x61, 0x63, 0x6b, 0x65, 0x6e,
+	0x64, 0x32, 0x4b, 0x0a, 0x08, 0x41, 0x6e, 0x61, 0x6c, 0x79, 0x7a, 0x65, 0x72, 0x12, 0x3f, 0x0a,
+	0x07, 0x41, 0x6e, 0x61, 0x6c, 0x79, 0x7a, 0x65, 0x12, 0x16, 0x2e, 0x62, 0x73, 0x31, 0x37, 0x37,
+	0x30, 0x77, 0x72, 0x61, 0x70, 0x2e, 0x41, 0x75, 0x64, 0x69, 0x6f, 0x43, 0x68, 0x75, 0x6e, 0x6b,
+	0x1a, 0x1a, 0x2e, 0x62, 0x73, 0x31, 0x37, 0x37, 0x30, 0x77, 0x72, 0x61, 0x70, 0x2e, 0x4c, 0x6f,
+	0x75, 0x64, 0x6e, 0x65, 0x73, 0x73, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x28, 0x01, 0x42, 0x34,
+	0x5a, 0x32, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x75, 0x72,
+	0x69, 0x6c, 0x6c, 0x6f, 0x2d, 0x73, 0x65, 0x2f, 0x62, 0x73, 0x31, 0x37, 0x37, 0x30, 0x77, 0x72,
+	0x61, 0x70, 0x2f, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2f, 0x61, 0x6e, 0x61, 0x6c, 0x79, 0x7a,
+	0x65, 0x72, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_analyzer_proto_rawDescOnce sync.Once
+	file_analyzer_proto_rawDescData = file_analyzer_proto_rawDesc
+)
+
+func file_analyzer_proto_rawDescGZIP() []byte {
+	file_analyzer_proto_rawDescOnce.Do(func() {
+		file_analyzer_proto_rawDescData = protoimpl.X.CompressGZIP(file_analyzer_proto_rawDescData)
+	})
+	return file_analyzer_proto_rawDescData
+}
+
+var file_analyzer_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_analyzer_proto_goTypes = []any{
+	(*AudioChunk)(nil),     // 0: bs1770wrap.AudioChunk
+	(*LoudnessResult)(nil), // 1: bs1770wrap.LoudnessResult
+}
+var file_analyzer_proto_depIdxs = []int32{
+	0, // 0: bs1770wrap.Analyzer.Analyze:input_type -> bs1770wrap.AudioChunk
+	1, // 1: bs1770wrap.Analyzer.Analyze:output_type -> bs1770wrap.LoudnessResult
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_analyzer_proto_init() }
+func file_analyzer_proto_init() {
+	if File_analyzer_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_analyzer_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*AudioChunk); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_analyzer_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*LoudnessResult); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_analyzer_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_analyzer_proto_goTypes,
+		DependencyIndexes: file_analyzer_proto_depIdxs,
+		MessageInfos:      file_analyzer_proto_msgTypes,
+	}.Build()
+	File_analyzer_proto = out.File
+	file_analyzer_proto_rawDesc = nil
+	file_analyzer_proto_goTypes = nil
+	file_analyzer_proto_depIdxs = nil
+}
@@ -0,0 +1,123 @@
+// The gRPC service of server.Analyzer, for clients in other
+// languages to stream audio to the analyzer. Generate stubs
+// for them with the usual protoc plugins; the Go ones are in
+// this directory, see generate.go.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: analyzer.proto
+
+package analyzerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Analyzer_Analyze_FullMethodName = "/bs1770wrap.Analyzer/Analyze"
+)
+
+// AnalyzerClient is the client API for Analyzer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AnalyzerClient interface {
+	// Analyze measures the audio streamed to it, which ffmpeg
+	// decodes as it comes in.
+	Analyze(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AudioChunk, LoudnessResult], error)
+}
+
+type analyzerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnalyzerClient(cc grpc.ClientConnInterface) AnalyzerClient {
+	return &analyzerClient{cc}
+}
+
+func (c *analyzerClient) Analyze(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AudioChunk, LoudnessResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Analyzer_ServiceDesc.Streams[0], Analyzer_Analyze_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AudioChunk, LoudnessResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Analyzer_AnalyzeClient = grpc.ClientStreamingClient[AudioChunk, LoudnessResult]
+
+// AnalyzerServer is the server API for Analyzer service.
+// All implementations must embed UnimplementedAnalyzerServer
+// for forward compatibility.
+type AnalyzerServer interface {
+	// Analyze measures the audio streamed to it, which ffmpeg
+	// decodes as it comes in.
+	Analyze(grpc.ClientStreamingServer[AudioChunk, LoudnessResult]) error
+	mustEmbedUnimplementedAnalyzerServer()
+}
+
+// UnimplementedAnalyzerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAnalyzerServer struct{}
+
+func (UnimplementedAnalyzerServer) Analyze(grpc.ClientStreamingServer[AudioChunk, LoudnessResult]) error {
+	return status.Errorf(codes.Unimplemented, "method Analyze not implemented")
+}
+func (UnimplementedAnalyzerServer) mustEmbedUnimplementedAnalyzerServer() {}
+func (UnimplementedAnalyzerServer) testEmbeddedByValue()                  {}
+
+// UnsafeAnalyzerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnalyzerServer will
+// result in compilation errors.
+type UnsafeAnalyzerServer interface {
+	mustEmbedUnimplementedAnalyzerServer()
+}
+
+func RegisterAnalyzerServer(s grpc.ServiceRegistrar, srv AnalyzerServer) {
+	// If the following call pancis, it indicates UnimplementedAnalyzerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Analyzer_ServiceDesc, srv)
+}
+
+func _Analyzer_Analyze_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AnalyzerServer).Analyze(&grpc.GenericServerStream[AudioChunk, LoudnessResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Analyzer_AnalyzeServer = grpc.ClientStreamingServer[AudioChunk, LoudnessResult]
+
+// Analyzer_ServiceDesc is the grpc.ServiceDesc for Analyzer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Analyzer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bs1770wrap.Analyzer",
+	HandlerType: (*AnalyzerServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Analyze",
+			Handler:       _Analyzer_Analyze_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "analyzer.proto",
+}
@@ -0,0 +1,8 @@
+// Package analyzerpb is the gRPC service of server.Analyzer,
+// generated from analyzer.proto with protoc-gen-go and
+// protoc-gen-go-grpc:
+//
+//	go generate ./server/analyzerpb
+package analyzerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative analyzer.proto
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/burillo-se/bs1770wrap"
+)
+
+// MaxStatusErrors is how many failures of a rescan a Daemon
+// keeps for its status.
+const MaxStatusErrors = 100
+
+// Policy is what a Daemon does with each file it analyzed,
+// for the library to stay normalized.
+type Policy interface {
+	Apply(file string, ld bs1770wrap.LoudnessData) error
+}
+
+// ReplayGainPolicy tags files with their ReplayGain towards
+// Target, bs1770wrap.ReferenceReplayGain2 if zero, leaving
+// alone those whose track gain tag is already within
+// Tolerance dB (0.01 if zero) of it, so that rescans do not
+// rewrite the whole library. With a cache keyed by path and
+// mtime, retagged files are remeasured on the next rescan;
+// bs1770wrap.AudioHashKey avoids that.
+type ReplayGainPolicy struct {
+	Target    float64
+	Tolerance float64
+	Options   bs1770wrap.Options
+}
+
+// Apply implements Policy.
+func (p ReplayGainPolicy) Apply(file string, ld bs1770wrap.LoudnessData) error {
+	rg := bs1770wrap.NewReplayGain(ld, p.Target)
+	tolerance := p.Tolerance
+	if tolerance <= 0 {
+		tolerance = 0.01
+	}
+	if tags, err := bs1770wrap.ReadTags(file); err == nil {
+		for k, v := range tags {
+			if !strings.EqualFold(k, bs1770wrap.TagTrackGain) {
+				continue
+			}
+			gain, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "dB")), 64)
+			if err == nil && math.Abs(gain-rg.TrackGain) <= tolerance {
+				return nil
+			}
+		}
+	}
+	return bs1770wrap.WriteReplayGainTags(file, rg, p.Options)
+}
+
+// FileError is a file a rescan failed on, and why.
+type FileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// DaemonStatus is the state of a Daemon, as its status API
+// serves it.
+type DaemonStatus struct {
+	Scanning bool       `json:"scanning"`
+	Runs     int        `json:"runs"`
+	Started  *time.Time `json:"started,omitempty"`  // of the current or last rescan
+	Finished *time.Time `json:"finished,omitempty"` // of the last rescan
+	Next     *time.Time `json:"next,omitempty"`
+
+	// counts of the last rescan
+	Files    int            `json:"files"`
+	Analyzed int            `json:"analyzed"`
+	Skipped  map[string]int `json:"skipped,omitempty"` // by bs1770wrap.SkipReason
+	Failed   int            `json:"failed"`
+	Aborted  map[string]int `json:"aborted,omitempty"` // by bs1770wrap.AbortReason
+	Applied  int            `json:"applied"`
+
+	Errors []FileError `json:"errors,omitempty"` // up to MaxStatusErrors
+	Error  string      `json:"error,omitempty"`  // the rescan itself failed
+}
+
+// Daemon keeps a library normalized: it rescans Dirs with
+// Scanner on Schedule and applies Policy, if set, to every
+// file analyzed. Giving Scanner a Cache keeps rescans to the
+// files that changed, and makes the results available to
+// Results and Metrics through its store.
+//
+// Daemon serves its status as JSON; POST asks for a rescan
+// right away.
+type Daemon struct {
+	Dirs     []string
+	Schedule Schedule
+	Scanner  *bs1770wrap.Scanner
+	Policy   Policy
+	Workers  int
+
+	// RunAtStart rescans as soon as Run is called, rather
+	// than waiting for the schedule.
+	RunAtStart bool
+
+	scan    sync.Mutex // held during rescans
+	mu      sync.Mutex
+	status  DaemonStatus
+	trigger chan struct{}
+	once    sync.Once
+}
+
+func (d *Daemon) triggers() chan struct{} {
+	d.once.Do(func() { d.trigger = make(chan struct{}, 1) })
+	return d.trigger
+}
+
+// Trigger asks Run for a rescan right away, unless one is
+// already pending.
+func (d *Daemon) Trigger() {
+	select {
+	case d.triggers() <- struct{}{}:
+	default:
+	}
+}
+
+// Run rescans on the schedule until ctx is done, which
+// aborts a rescan in progress too, unless Scanner.Options has
+// a Context of its own. The files left are then counted as
+// aborted, with the cause ctx was cancelled with (see
+// bs1770wrap.AbortReasonOf). A Scanner Cache analyzes with its
+// own Options, which ctx is not added to.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.Scanner == nil || d.Schedule == nil {
+		return fmt.Errorf("Cannot run daemon: no scanner or schedule")
+	}
+	if d.RunAtStart {
+		d.Trigger()
+	}
+	for {
+		next := d.Schedule.Next(time.Now())
+		var wait <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			wait = timer.C
+		}
+		d.mu.Lock()
+		d.status.Next = nil
+		if !next.IsZero() {
+			d.status.Next = &next
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return ctx.Err()
+		case <-wait:
+		case <-d.triggers():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		d.rescan(ctx)
+	}
+}
+
+// Rescan scans the library once and applies the policy,
+// waiting for any rescan in progress first.
+func (d *Daemon) Rescan() {
+	d.rescan(context.Background())
+}
+
+func (d *Daemon) rescan(ctx context.Context) {
+	scanner := *d.Scanner
+	if scanner.Options.Context == nil && ctx.Done() != nil {
+		scanner.Options.Context = ctx
+	}
+
+	d.scan.Lock()
+	defer d.scan.Unlock()
+
+	start := time.Now()
+	d.mu.Lock()
+	d.status.Scanning = true
+	d.status.Started = &start
+	d.mu.Unlock()
+
+	st := DaemonStatus{Skipped: make(map[string]int), Aborted: make(map[string]int)}
+	fail := func(file string, err error) {
+		st.Failed++
+		if len(st.Errors) < MaxStatusErrors {
+			st.Errors = append(st.Errors, FileError{File: file, Error: err.Error()})
+		}
+	}
+	for _, dir := range d.Dirs {
+		results, err := scanner.ScanDir(dir, d.Workers)
+		if err != nil {
+			st.Error = err.Error()
+			break
+		}
+		for file, r := range results {
+			st.Files++
+			switch {
+			case r.Skipped != "":
+				st.Skipped[string(r.Skipped)]++
+			case r.Aborted != "":
+				st.Aborted[string(r.Aborted)]++
+			case r.Err != nil:
+				fail(file, r.Err)
+			default:
+				st.Analyzed++
+				if d.Policy == nil {
+					continue
+				}
+				if err := d.Policy.Apply(file, r.Loudness); err != nil {
+					fail(file, fmt.Errorf("Cannot apply policy: %v", err))
+				} else {
+					st.Applied++
+				}
+			}
+		}
+	}
+
+	end := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st.Runs = d.status.Runs + 1
+	st.Started = &start
+	st.Finished = &end
+	st.Next = d.status.Next
+	d.status = st
+}
+
+// Status returns the state of the daemon.
+func (d *Daemon) Status() DaemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// ServeHTTP implements http.Handler.
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d.Trigger()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.Status())
+}
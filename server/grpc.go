@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // for clients compressing their chunks
+	"google.golang.org/grpc/status"
+
+	"github.com/burillo-se/bs1770wrap"
+	"github.com/burillo-se/bs1770wrap/server/analyzerpb"
+)
+
+// RegisterGRPC registers the Analyzer service of analyzerpb
+// on s, answering calls as a does, for serving it from a
+// grpc.Server of the caller's:
+//
+//	s := grpc.NewServer()
+//	analyzer.RegisterGRPC(s)
+//	s.Serve(lis)
+func (a *Analyzer) RegisterGRPC(s grpc.ServiceRegistrar) {
+	analyzerpb.RegisterAnalyzerServer(s, grpcAnalyzer{a: a})
+}
+
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// serveGRPC hands a gRPC request to a grpc.Server of a's.
+func (a *Analyzer) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	a.grpcOnce.Do(func() {
+		a.grpc = grpc.NewServer()
+		a.RegisterGRPC(a.grpc)
+	})
+	a.grpc.ServeHTTP(w, r)
+}
+
+// grpcAnalyzer is the analyzerpb.AnalyzerServer of an
+// Analyzer.
+type grpcAnalyzer struct {
+	analyzerpb.UnimplementedAnalyzerServer
+	a *Analyzer
+}
+
+// Analyze implements analyzerpb.AnalyzerServer. The audio of
+// the chunks is piped to ffmpeg as it comes in, as with
+// bs1770wrap.CalculateLoudnessFromReader, so nothing is
+// written to disk. The deadline of the call applies if it is
+// sooner than Timeout.
+func (g grpcAnalyzer) Analyze(stream analyzerpb.Analyzer_AnalyzeServer) error {
+	a := g.a
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = DefaultAnalyzeTimeout
+	}
+	ctx, cancel := context.WithTimeout(stream.Context(), timeout)
+	defer cancel()
+	maxUpload := a.MaxUpload
+	if maxUpload <= 0 {
+		maxUpload = DefaultMaxUpload
+	}
+
+	err := a.acquire(ctx)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "too busy")
+	}
+	defer a.release()
+
+	next := receive(ctx, stream)
+	first, err := next()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "no audio")
+	}
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	type result struct {
+		ld   bs1770wrap.LoudnessData
+		info bs1770wrap.AnalysisInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	opts := a.Options
+	opts.Context = ctx
+	go func() {
+		ld, info, err := bs1770wrap.CalculateLoudnessFromReaderWithOptions(pr, first.GetFormat(), opts)
+		pr.Close()
+		done <- result{ld, info, err}
+	}()
+
+	// the audio of the chunks, until the stream ends or
+	// fails, which aborts the analysis
+	data := first.GetData()
+	total := int64(len(data))
+	var readErr error
+	for readErr == nil {
+		if _, err := pw.Write(data); err != nil {
+			break // ffmpeg gave up
+		}
+		var chunk *analyzerpb.AudioChunk
+		chunk, readErr = next()
+		data = chunk.GetData()
+		total += int64(len(data))
+		if readErr == nil && total > maxUpload {
+			readErr = status.Error(codes.ResourceExhausted, "upload too large")
+		}
+	}
+	if readErr != nil && readErr != io.EOF {
+		cancel()
+	}
+	pw.Close()
+	res := <-done
+
+	switch {
+	case readErr != nil && readErr != io.EOF:
+		return readErr
+	case res.err != nil:
+		code := codes.InvalidArgument
+		switch bs1770wrap.AbortReasonOf(res.err) {
+		case bs1770wrap.AbortTimeout:
+			code = codes.DeadlineExceeded
+		case bs1770wrap.AbortCancelled:
+			code = codes.Canceled
+		case bs1770wrap.AbortShutdown, bs1770wrap.AbortMissingTool:
+			code = codes.Unavailable
+		}
+		return status.Error(code, res.err.Error())
+	}
+	ld := res.ld
+	return stream.SendAndClose(&analyzerpb.LoudnessResult{
+		Integrated: ld.Integrated,
+		Peak:       ld.Peak,
+		Range:      ld.Range,
+		Shortterm:  ld.Shortterm,
+		Momentary:  ld.Momentary,
+		Length:     ld.Length,
+		Backend:    res.info.Backend,
+	})
+}
+
+// receive returns a function returning the next chunk of
+// stream, or io.EOF once it is over. It fails once ctx is
+// done, rather than wait on a client gone quiet: the chunks
+// are received by a goroutine, which ends with the call.
+func receive(ctx context.Context, stream analyzerpb.Analyzer_AnalyzeServer) func() (*analyzerpb.AudioChunk, error) {
+	chunks := make(chan *analyzerpb.AudioChunk)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			chunk, err := stream.Recv()
+			if err != nil {
+				failed <- err
+				return
+			}
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() (*analyzerpb.AudioChunk, error) {
+		select {
+		case chunk := <-chunks:
+			return chunk, nil
+		case err := <-failed:
+			return nil, err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, status.Error(codes.DeadlineExceeded, "upload timed out")
+			}
+			return nil, status.Error(codes.Canceled, "upload cancelled")
+		}
+	}
+}
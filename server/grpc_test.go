@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+
+	"github.com/burillo-se/bs1770wrap"
+	"github.com/burillo-se/bs1770wrap/server/analyzerpb"
+)
+
+// ebur128Log is the log of ffmpeg's ebur128 filter on a
+// second of audio.
+const ebur128Log = `[Parsed_ebur128_0 @ 0x1] t: 1         TARGET:-23 LUFS    M: -20.5 S: -21.0     I: -20.0 LUFS       LRA:   0.0 LU  FTPK: -3.0 dBFS  TPK: -3.0 dBFS
+[Parsed_ebur128_0 @ 0x1] Summary:
+
+  Integrated loudness:
+    I:         -20.0 LUFS
+    Threshold: -30.0 LUFS
+
+  Loudness range:
+    LRA:         2.5 LU
+    Threshold: -40.0 LUFS
+    LRA low:   -21.0 LUFS
+    LRA high:  -18.5 LUFS
+
+  True peak:
+    Peak:       -3.0 dBFS
+`
+
+// fakeFFmpeg runs as ffmpeg, keeping the audio it is fed and
+// its arguments.
+type fakeFFmpeg struct {
+	audio chan []byte
+	args  chan []string
+}
+
+func newFakeFFmpeg() *fakeFFmpeg {
+	return &fakeFFmpeg{audio: make(chan []byte, 1), args: make(chan []string, 1)}
+}
+
+func (f *fakeFFmpeg) Run(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+	audio, err := io.ReadAll(stdin)
+	f.audio <- audio
+	f.args <- args
+	return nil, []byte(ebur128Log), err
+}
+
+// transports are the ways an Analyzer serves gRPC, each
+// returning a client connection to a.
+var transports = []struct {
+	name string
+	dial func(t *testing.T, a *Analyzer) *grpc.ClientConn
+}{
+	{"grpc.Server", func(t *testing.T, a *Analyzer) *grpc.ClientConn {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := grpc.NewServer()
+		a.RegisterGRPC(s)
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+		return dial(t, lis.Addr().String())
+	}},
+	{"ServeHTTP", func(t *testing.T, a *Analyzer) *grpc.ClientConn {
+		mux := http.NewServeMux()
+		mux.Handle("POST /bs1770wrap.Analyzer/Analyze", a)
+		srv := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+		t.Cleanup(srv.Close)
+		return dial(t, srv.Listener.Addr().String())
+	}},
+}
+
+func dial(t *testing.T, addr string) *grpc.ClientConn {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCAnalyze(t *testing.T) {
+	audio := bytes.Repeat([]byte("0123456789"), 100000)
+	for _, tr := range transports {
+		for _, compressed := range []bool{false, true} {
+			ffmpeg := newFakeFFmpeg()
+			a := &Analyzer{Options: bs1770wrap.Options{Runner: ffmpeg}}
+			client := analyzerpb.NewAnalyzerClient(tr.dial(t, a))
+
+			var callOpts []grpc.CallOption
+			if compressed {
+				callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+			}
+			stream, err := client.Analyze(context.Background(), callOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < len(audio); i += 300000 {
+				chunk := &analyzerpb.AudioChunk{Data: audio[i:min(i+300000, len(audio))]}
+				if i == 0 {
+					chunk.Format = "wav"
+				}
+				if err := stream.Send(chunk); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res, err := stream.CloseAndRecv()
+			if err != nil {
+				t.Fatalf("%s, compressed %v: %v", tr.name, compressed, err)
+			}
+
+			if got := <-ffmpeg.audio; !bytes.Equal(got, audio) {
+				t.Errorf("%s, compressed %v: ffmpeg was fed %d bytes, not the %d streamed", tr.name, compressed, len(got), len(audio))
+			}
+			if args := strings.Join(<-ffmpeg.args, " "); !strings.Contains(args, "-f wav -i pipe:0") {
+				t.Errorf("%s: ffmpeg run with %q, not the format of the first chunk", tr.name, args)
+			}
+			if res.Integrated != -20 || res.Peak != -3 || res.Range != 2.5 || res.Momentary != -20.5 || res.Backend != "ffmpeg" {
+				t.Errorf("%s, compressed %v: result %v", tr.name, compressed, res)
+			}
+		}
+	}
+}
+
+// wait waits for the status of an Analyze call, without
+// closing its stream.
+func wait(t *testing.T, stream grpc.ClientStreamingClient[analyzerpb.AudioChunk, analyzerpb.LoudnessResult]) *status.Status {
+	t.Helper()
+	var res analyzerpb.LoudnessResult
+	err := stream.RecvMsg(&res)
+	if err == nil {
+		t.Fatal("a stalled stream was analyzed")
+	}
+	return status.Convert(err)
+}
+
+func TestGRPCDeadlines(t *testing.T) {
+	for _, tr := range transports {
+		// the client's deadline
+		conn := tr.dial(t, &Analyzer{Options: bs1770wrap.Options{Runner: newFakeFFmpeg()}})
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		stream, err := analyzerpb.NewAnalyzerClient(conn).Analyze(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := wait(t, stream); s.Code() != codes.DeadlineExceeded {
+			t.Errorf("%s: stalled call past its deadline ended with %v", tr.name, s)
+		}
+		cancel()
+
+		// the server's Timeout
+		conn = tr.dial(t, &Analyzer{Timeout: 200 * time.Millisecond, Options: bs1770wrap.Options{Runner: newFakeFFmpeg()}})
+		stream, err = analyzerpb.NewAnalyzerClient(conn).Analyze(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := wait(t, stream); s.Code() != codes.DeadlineExceeded || s.Message() != "upload timed out" {
+			t.Errorf("%s: stalled call past the server's timeout ended with %v", tr.name, s)
+		}
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	for _, tr := range transports {
+		conn := tr.dial(t, &Analyzer{Options: bs1770wrap.Options{Runner: newFakeFFmpeg()}})
+		stream, err := analyzerpb.NewAnalyzerClient(conn).Analyze(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument || status.Convert(err).Message() != "no audio" {
+			t.Errorf("%s: empty stream ended with %v", tr.name, err)
+		}
+
+		conn = tr.dial(t, &Analyzer{MaxUpload: 10, Options: bs1770wrap.Options{Runner: newFakeFFmpeg()}})
+		stream, err = analyzerpb.NewAnalyzerClient(conn).Analyze(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			stream.Send(&analyzerpb.AudioChunk{Data: []byte("0123456789")})
+		}
+		if _, err := stream.CloseAndRecv(); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("%s: upload over MaxUpload ended with %v", tr.name, err)
+		}
+
+		failing := bs1770wrap.RunnerFunc(func(cmd string, args []string, stdin io.Reader) ([]byte, []byte, error) {
+			io.Copy(io.Discard, stdin)
+			return nil, []byte("Invalid data found when processing input"), nil
+		})
+		conn = tr.dial(t, &Analyzer{Options: bs1770wrap.Options{Runner: failing}})
+		stream, err = analyzerpb.NewAnalyzerClient(conn).Analyze(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Send(&analyzerpb.AudioChunk{Data: []byte("not audio")})
+		if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: failed analysis ended with %v", tr.name, err)
+		}
+	}
+}
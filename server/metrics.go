@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/burillo-se/bs1770wrap"
+)
+
+// DefaultMetricsInterval is how long Metrics reuses the
+// statistics it computed when Interval is zero.
+const DefaultMetricsInterval = time.Minute
+
+// Metrics serves library statistics computed from a results
+// store, in the Prometheus text format, or as JSON when
+// requested with ?format=json. Statistics are recomputed at
+// most once per Interval, so scraping a large library stays
+// cheap. If Profiles is empty, all of bs1770wrap.Profiles are
+// checked.
+type Metrics struct {
+	Store    bs1770wrap.Lister
+	Profiles []bs1770wrap.Profile
+	Interval time.Duration
+
+	mu       sync.Mutex
+	stats    bs1770wrap.LibraryStats
+	computed time.Time
+}
+
+func (m *Metrics) profiles() []bs1770wrap.Profile {
+	if len(m.Profiles) > 0 {
+		return m.Profiles
+	}
+	var profiles []bs1770wrap.Profile
+	for _, p := range bs1770wrap.Profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].ID < profiles[j].ID })
+	return profiles
+}
+
+// Stats returns the current statistics, recomputing them if
+// they are older than the interval.
+func (m *Metrics) Stats() (bs1770wrap.LibraryStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultMetricsInterval
+	}
+	if !m.computed.IsZero() && time.Since(m.computed) < interval {
+		return m.stats, nil
+	}
+
+	results, err := m.Store.List()
+	if err != nil {
+		return bs1770wrap.LibraryStats{}, err
+	}
+	m.stats = bs1770wrap.ComputeLibraryStats(results, m.profiles())
+	m.computed = time.Now()
+	return m.stats, nil
+}
+
+type jsonStats struct {
+	Count     int                `json:"count"`
+	Compliant map[string]float64 `json:"compliant_ratio"`
+	Quantiles map[string]float64 `json:"integrated_quantiles"`
+	Mean      *float64           `json:"integrated_mean,omitempty"`
+	Methods   int                `json:"methods"`
+}
+
+// ServeHTTP implements http.Handler.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats, err := m.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	profiles := m.profiles()
+
+	if r.URL.Query().Get("format") == "json" {
+		js := jsonStats{
+			Count:     stats.Count,
+			Compliant: make(map[string]float64),
+			Quantiles: make(map[string]float64),
+			Methods:   len(stats.Methods),
+		}
+		for _, p := range profiles {
+			js.Compliant[p.ID] = stats.CompliantRatio(p.ID)
+		}
+		for q, v := range stats.Quantiles {
+			js.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = v
+		}
+		if !math.IsInf(stats.Mean, 0) {
+			js.Mean = &stats.Mean
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(js)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP bs1770wrap_results Number of analyzed files.")
+	fmt.Fprintln(w, "# TYPE bs1770wrap_results gauge")
+	fmt.Fprintf(w, "bs1770wrap_results %d\n", stats.Count)
+
+	fmt.Fprintln(w, "# HELP bs1770wrap_methods Number of distinct measurement methods among the results; above 1, statistics mix methodologies.")
+	fmt.Fprintln(w, "# TYPE bs1770wrap_methods gauge")
+	fmt.Fprintf(w, "bs1770wrap_methods %d\n", len(stats.Methods))
+
+	fmt.Fprintln(w, "# HELP bs1770wrap_compliant_ratio Fraction of analyzed files compliant with a profile.")
+	fmt.Fprintln(w, "# TYPE bs1770wrap_compliant_ratio gauge")
+	for _, p := range profiles {
+		fmt.Fprintf(w, "bs1770wrap_compliant_ratio{profile=%q} %g\n", p.ID, stats.CompliantRatio(p.ID))
+	}
+
+	if stats.Count == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP bs1770wrap_integrated_lufs Distribution of integrated loudness.")
+	fmt.Fprintln(w, "# TYPE bs1770wrap_integrated_lufs gauge")
+	for _, q := range bs1770wrap.StatsQuantiles {
+		fmt.Fprintf(w, "bs1770wrap_integrated_lufs{quantile=\"%g\"} %g\n", q, stats.Quantiles[q])
+	}
+}
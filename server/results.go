@@ -0,0 +1,204 @@
+// Package server exposes bs1770wrap over HTTP, for dashboards
+// and services that would rather not link the package in.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/burillo-se/bs1770wrap"
+)
+
+// Default and maximum page sizes of the results API.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Result is the JSON form of a stored result. Levels that
+// are -Inf, as for silence, are null.
+type Result struct {
+	Key         string                  `json:"key"`
+	File        string                  `json:"file,omitempty"`
+	Updated     time.Time               `json:"updated"`
+	Integrated  bs1770wrap.JSONLevel    `json:"integrated"`
+	Peak        bs1770wrap.JSONLevel    `json:"peak"`
+	Range       bs1770wrap.JSONLevel    `json:"range"`
+	Shortterm   bs1770wrap.JSONLevel    `json:"shortterm"`
+	Momentary   bs1770wrap.JSONLevel    `json:"momentary"`
+	Length      uint64                  `json:"length"`
+	Compliant   *bool                   `json:"compliant,omitempty"`
+	Annotations []bs1770wrap.Annotation `json:"annotations,omitempty"`
+}
+
+// ResultPage is the JSON response of the results API.
+type ResultPage struct {
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	Results []Result `json:"results"`
+}
+
+// Results serves the contents of a results store:
+//
+//	GET /?prefix=/music/&min=-30&max=-10&profile=ebu-r128&compliant=false&offset=0&limit=100
+//
+// prefix filters on the file path, min and max on integrated
+// loudness (LUFS), and compliant on whether results satisfy
+// the named bs1770wrap.Profiles entry; with a profile but no
+// compliant filter, each result is annotated with its status
+// instead. Results are ordered by path, then key.
+type Results struct {
+	Store bs1770wrap.Lister
+}
+
+type resultQuery struct {
+	prefix        string
+	min, max      *float64
+	profile       *bs1770wrap.Profile
+	compliant     *bool
+	offset, limit int
+}
+
+func parseFloatParam(v string) (*float64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseResultQuery(r *http.Request) (resultQuery, error) {
+	var err error
+	v := r.URL.Query()
+	q := resultQuery{prefix: v.Get("prefix"), limit: DefaultLimit}
+
+	if q.min, err = parseFloatParam(v.Get("min")); err != nil {
+		return q, fmt.Errorf("bad min: %v", err)
+	}
+	if q.max, err = parseFloatParam(v.Get("max")); err != nil {
+		return q, fmt.Errorf("bad max: %v", err)
+	}
+	if id := v.Get("profile"); id != "" {
+		p, ok := bs1770wrap.Profiles[id]
+		if !ok {
+			return q, fmt.Errorf("unknown profile %q", id)
+		}
+		q.profile = &p
+	}
+	if c := v.Get("compliant"); c != "" {
+		b, err := strconv.ParseBool(c)
+		if err != nil {
+			return q, fmt.Errorf("bad compliant: %v", err)
+		}
+		if q.profile == nil {
+			return q, fmt.Errorf("compliant needs a profile")
+		}
+		q.compliant = &b
+	}
+	if o := v.Get("offset"); o != "" {
+		if q.offset, err = strconv.Atoi(o); err != nil || q.offset < 0 {
+			return q, fmt.Errorf("bad offset %q", o)
+		}
+	}
+	if l := v.Get("limit"); l != "" {
+		if q.limit, err = strconv.Atoi(l); err != nil || q.limit <= 0 {
+			return q, fmt.Errorf("bad limit %q", l)
+		}
+		if q.limit > MaxLimit {
+			q.limit = MaxLimit
+		}
+	}
+	return q, nil
+}
+
+// match reports whether r passes the filters, and its
+// compliance status if a profile was given.
+func (q resultQuery) match(r bs1770wrap.StoredResult) (bool, *bool) {
+	integrated := float64(r.Loudness.Integrated)
+	if !strings.HasPrefix(r.File, q.prefix) ||
+		(q.min != nil && integrated < *q.min) ||
+		(q.max != nil && integrated > *q.max) {
+		return false, nil
+	}
+	if q.profile == nil {
+		return true, nil
+	}
+	compliant := q.profile.Compliant(r.Loudness)
+	if q.compliant != nil && compliant != *q.compliant {
+		return false, nil
+	}
+	return true, &compliant
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Results) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseResultQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.Store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		if stored[i].File != stored[j].File {
+			return stored[i].File < stored[j].File
+		}
+		return stored[i].Key < stored[j].Key
+	})
+
+	annotator, _ := h.Store.(bs1770wrap.Annotator)
+	page := ResultPage{Offset: q.offset, Limit: q.limit, Results: []Result{}}
+	for _, s := range stored {
+		ok, compliant := q.match(s)
+		if !ok {
+			continue
+		}
+		page.Total++
+		if page.Total <= q.offset || len(page.Results) >= q.limit {
+			continue
+		}
+
+		res := Result{
+			Key:        s.Key,
+			File:       s.File,
+			Updated:    s.Updated,
+			Integrated: bs1770wrap.JSONLevel(s.Loudness.Integrated),
+			Peak:       bs1770wrap.JSONLevel(s.Loudness.Peak),
+			Range:      bs1770wrap.JSONLevel(s.Loudness.Range),
+			Shortterm:  bs1770wrap.JSONLevel(s.Loudness.Shortterm),
+			Momentary:  bs1770wrap.JSONLevel(s.Loudness.Momentary),
+			Length:     s.Loudness.Length,
+			Compliant:  compliant,
+		}
+		if annotator != nil {
+			res.Annotations, err = annotator.Annotations(s.Key)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		page.Results = append(page.Results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
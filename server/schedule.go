@@ -0,0 +1,175 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a Daemon rescans.
+type Schedule interface {
+	// Next returns the first time after t the schedule
+	// fires, or the zero time if it never does.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a cron-like schedule: five fields of
+// minute, hour, day of month, month and day of week (0 or 7
+// is Sunday), each "*", a number, a range "a-b", a step "*/n"
+// or "a-b/n", or a comma-separated list of those, in local
+// time. As with cron, a day matches if either of the day
+// fields does when both are restricted. Unlike Vixie cron,
+// which takes any day field starting with "*" for
+// unrestricted, only "*" alone is: "0 0 */2 * 1" fires on odd
+// days and on Mondays, not on odd Mondays only. "@hourly",
+// "@daily", "@weekly" and "@monthly" are shorthands, and
+// "@every 6h" fires at a fixed interval.
+func ParseSchedule(spec string) (Schedule, error) {
+	s, err := parseSchedule(strings.TrimSpace(spec))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse schedule %q: %v", spec, err)
+	}
+	return s, nil
+}
+
+func parseSchedule(spec string) (Schedule, error) {
+	if d := strings.TrimPrefix(spec, "@every"); d != spec {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, err
+		}
+		if every < time.Second {
+			return nil, fmt.Errorf("interval %v is under a second", every)
+		}
+		return everySchedule(every), nil
+	}
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("want 5 fields, got %d", len(fields))
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	// "*/n" restricts, see ParseSchedule
+	s.anyDom = fields[2] == "*"
+	s.anyDow = fields[4] == "*"
+	return s, nil
+}
+
+// parseField parses one cron field into a bit set of the
+// values it matches.
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max // "a/n" runs from a on
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+func (s cronSchedule) day(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next implements Schedule, skipping whole months, days and
+// hours that do not match.
+func (s cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0) // such as Feb 30, which never comes
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.day(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type everySchedule time.Duration
+
+// Next implements Schedule.
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, c := range []struct {
+		spec, from, want string // want empty for never
+	}{
+		{"*/15 * * * *", "2026-10-14 10:07:30", "2026-10-14 10:15:00"},
+		{"*/15 * * * *", "2026-10-14 10:45:00", "2026-10-14 11:00:00"},
+		{"@hourly", "2026-10-14 23:00:00", "2026-10-15 00:00:00"},
+		{"30 2 * * *", "2026-10-14 02:30:00", "2026-10-15 02:30:00"},
+
+		// month rollover, short months and years
+		{"@monthly", "2026-01-31 12:00:00", "2026-02-01 00:00:00"},
+		{"@monthly", "2026-12-15 00:00:00", "2027-01-01 00:00:00"},
+		{"0 12 31 * *", "2026-04-01 00:00:00", "2026-05-31 12:00:00"},
+		{"0 0 29 2 *", "2025-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"0 0 30 2 *", "2026-01-01 00:00:00", ""},
+		{"0 0 1 1,7 *", "2026-07-01 00:00:00", "2027-01-01 00:00:00"},
+
+		// day of week rollover, 7 being Sunday too
+		{"0 9 * * 1-5", "2026-10-16 10:00:00", "2026-10-19 09:00:00"},
+		{"0 0 * * 7", "2026-10-17 13:00:00", "2026-10-18 00:00:00"},
+		{"@weekly", "2026-10-18 00:00:00", "2026-10-25 00:00:00"},
+		{"0 0 * 2 1", "2026-03-01 00:00:00", "2027-02-01 00:00:00"},
+		{"0 0 * * 6", "2026-12-27 00:00:00", "2027-01-02 00:00:00"},
+
+		// both day fields restricted: either matches
+		{"0 0 13 * 5", "2026-10-14 00:00:00", "2026-10-16 00:00:00"},
+		{"0 0 13 * 5", "2027-01-09 00:00:00", "2027-01-13 00:00:00"},
+		// "*/2" restricts: Monday the 12th matches
+		{"0 0 */2 * 1", "2026-10-11 00:30:00", "2026-10-12 00:00:00"},
+		{"0 0 */2 * *", "2026-10-11 00:30:00", "2026-10-13 00:00:00"},
+		{"0 0 * * */2", "2026-10-14 00:00:00", "2026-10-15 00:00:00"},
+	} {
+		s, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Errorf("%s: %v", c.spec, err)
+			continue
+		}
+		got := s.Next(at(c.from))
+		if c.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%s after %s: %v, want never", c.spec, c.from, got)
+			}
+		} else if want := at(c.want); !got.Equal(want) {
+			t.Errorf("%s after %s: %v, want %v", c.spec, c.from, got, want)
+		}
+	}
+
+	s, err := ParseSchedule("@every 6h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(at("2026-10-14 10:07:30")); !got.Equal(at("2026-10-14 16:07:30")) {
+		t.Errorf("@every 6h: %v", got)
+	}
+}
+
+func TestScheduleNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	s, err := ParseSchedule("@daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Next(time.Date(2026, 10, 14, 23, 30, 0, 0, time.UTC)) // 01:30 on the 15th there
+	if want := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next midnight %v, want %v", got, want)
+	}
+	got = s.Next(time.Date(2026, 10, 14, 23, 30, 0, 0, time.UTC).In(loc))
+	if want := time.Date(2026, 10, 16, 0, 0, 0, 0, loc); !got.Equal(want) || got.Location() != loc {
+		t.Errorf("next midnight at UTC+2 %v, want %v", got, want)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *",
+		"@every", "@every 10ms", "@yearly",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q parses", spec)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// SkipReason is why a scan left a file alone rather than
+// analyzing it.
+type SkipReason string
+
+// Files scans skip, none of which hold audio.
+const (
+	SkipImage    SkipReason = "image"     // cover art, scans
+	SkipCueSheet SkipReason = "cue"       // cue sheets
+	SkipText     SkipReason = "text"      // logs, playlists, notes
+	SkipPartial  SkipReason = "partial"   // downloads or copies in progress
+	SkipEmpty    SkipReason = "empty"     // files of no size
+	SkipOther    SkipReason = "non-audio" // documents, archives, system files
+)
+
+var skipExtensions = map[string]SkipReason{
+	".jpg": SkipImage, ".jpeg": SkipImage, ".png": SkipImage,
+	".gif": SkipImage, ".bmp": SkipImage, ".webp": SkipImage,
+	".tif": SkipImage, ".tiff": SkipImage,
+
+	".cue": SkipCueSheet,
+
+	".log": SkipText, ".txt": SkipText, ".nfo": SkipText,
+	".m3u": SkipText, ".m3u8": SkipText, ".pls": SkipText,
+	".md5": SkipText, ".sfv": SkipText, ".ffp": SkipText,
+	".accurip": SkipText, ".json": SkipText, ".xml": SkipText,
+
+	".part": SkipPartial, ".crdownload": SkipPartial,
+	".partial": SkipPartial, ".download": SkipPartial,
+	".tmp": SkipPartial, ".!qb": SkipPartial,
+
+	".pdf": SkipOther, ".zip": SkipOther, ".rar": SkipOther,
+	".7z": SkipOther, ".torrent": SkipOther, ".db": SkipOther,
+	".ini": SkipOther, ".ds_store": SkipOther,
+}
+
+// audioExtensions are taken as audio without looking inside.
+var audioExtensions = map[string]bool{
+	".flac": true, ".wav": true, ".mp3": true, ".m4a": true,
+	".mp4": true, ".m4b": true, ".aac": true, ".ogg": true,
+	".opus": true, ".wv": true, ".ape": true, ".aif": true,
+	".aiff": true, ".wma": true, ".mka": true, ".mkv": true,
+	".dsf": true, ".dff": true, ".tta": true, ".ac3": true,
+}
+
+// image and document signatures, for files whose extension
+// says nothing
+var skipMagic = []struct {
+	magic  string
+	reason SkipReason
+}{
+	{"\x89PNG", SkipImage},
+	{"\xff\xd8\xff", SkipImage},
+	{"GIF8", SkipImage},
+	{"%PDF", SkipOther},
+	{"PK\x03\x04", SkipOther},
+	{"Rar!", SkipOther},
+}
+
+// ClassifySkip reports whether file is something a scan should
+// skip rather than analyze, and why. It goes by the name, and
+// for names it does not know by the first bytes of the file,
+// so it is cheap enough to run on a whole library. Files it
+// cannot read are left to the analyzer to fail.
+func ClassifySkip(file string) (SkipReason, bool) {
+	base := filepath.Base(file)
+	if strings.HasPrefix(base, "._") || strings.EqualFold(base, "thumbs.db") {
+		return SkipOther, true // AppleDouble and Windows thumbnail files
+	}
+	if strings.HasPrefix(base, ".~") || strings.HasPrefix(base, "~$") {
+		return SkipPartial, true
+	}
+	ext := strings.ToLower(filepath.Ext(base))
+	if r, ok := skipExtensions[ext]; ok {
+		return r, true
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	if fi.Size() == 0 {
+		return SkipEmpty, true
+	}
+	if audioExtensions[ext] {
+		return "", false
+	}
+
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	for _, m := range skipMagic {
+		if bytes.HasPrefix(head, []byte(m.magic)) {
+			return m.reason, true
+		}
+	}
+	if looksLikeText(head) {
+		if bytes.Contains(bytes.ToUpper(head), []byte("FILE \"")) {
+			return SkipCueSheet, true
+		}
+		return SkipText, true
+	}
+	return "", false
+}
+
+// looksLikeText reports whether head is UTF-8 text, which no
+// audio file starts with.
+func looksLikeText(head []byte) bool {
+	if len(head) == 0 {
+		return false
+	}
+	for i := 0; i < len(head); {
+		r, size := utf8.DecodeRune(head[i:])
+		if r == utf8.RuneError && size <= 1 {
+			// a sequence cut short at the end is fine
+			return !utf8.FullRune(head[i:])
+		}
+		if r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+		i += size
+	}
+	return true
+}
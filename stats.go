@@ -0,0 +1,86 @@
+package bs1770wrap
+
+import (
+	"math"
+	"sort"
+)
+
+// StatsQuantiles are the quantiles of integrated loudness
+// computed by ComputeLibraryStats.
+var StatsQuantiles = []float64{0.05, 0.25, 0.5, 0.75, 0.95}
+
+// LibraryStats summarizes a collection of results.
+type LibraryStats struct {
+	Count     int
+	Compliant map[string]int      // profile ID to number of compliant results
+	Quantiles map[float64]float64 // StatsQuantiles of integrated loudness, LUFS (silence excluded)
+	Mean      float64             // energy mean of integrated loudness, LUFS
+
+	// Methods counts results by Method.ID, "" standing for
+	// unknown methods. With more than one, the statistics mix
+	// methodologies; see Mixed.
+	Methods map[string]int
+}
+
+// Mixed reports whether the results were measured with more
+// than one method, which makes the statistics questionable.
+func (s LibraryStats) Mixed() bool {
+	return len(s.Methods) > 1
+}
+
+// CompliantRatio returns the fraction of results compliant
+// with the given profile, or 0 for an empty library.
+func (s LibraryStats) CompliantRatio(profile string) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Compliant[profile]) / float64(s.Count)
+}
+
+// ComputeLibraryStats summarizes results, checking each
+// against the given profiles.
+func ComputeLibraryStats(results []StoredResult, profiles []Profile) LibraryStats {
+	stats := LibraryStats{
+		Count:     len(results),
+		Compliant: make(map[string]int),
+		Quantiles: make(map[float64]float64),
+		Mean:      math.Inf(-1),
+		Methods:   make(map[string]int),
+	}
+	if len(results) == 0 {
+		return stats
+	}
+
+	// silent files measure -inf, which would drown out the
+	// distribution
+	var levels []float64
+	for _, r := range results {
+		stats.Methods[methodID(r)]++
+		if l := float64(r.Loudness.Integrated); !math.IsInf(l, 0) && !math.IsNaN(l) {
+			levels = append(levels, l)
+		}
+		for _, p := range profiles {
+			if p.Compliant(r.Loudness) {
+				stats.Compliant[p.ID]++
+			}
+		}
+	}
+	if len(levels) == 0 {
+		return stats
+	}
+	sort.Float64s(levels)
+
+	for _, q := range StatsQuantiles {
+		stats.Quantiles[q] = quantile(levels, q)
+	}
+	stats.Mean = EnergyMean(levels...)
+	return stats
+}
+
+// quantile interpolates the q-quantile of sorted values.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
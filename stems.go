@@ -0,0 +1,108 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// StemLoudness is the measurement of one stem of a mix.
+type StemLoudness struct {
+	File     string
+	Loudness LoudnessData
+	Info     AnalysisInfo
+
+	// Relative is the integrated loudness of the stem against
+	// that of the sum, LU, and Share the fraction of the
+	// energy of the sum it stands for. Gating and correlation
+	// between stems mean that the shares need not add up to
+	// one.
+	Relative float64
+	Share    float64
+}
+
+// StemSum is the result of CalculateStemLoudness.
+type StemSum struct {
+	Sum   LoudnessData
+	Info  AnalysisInfo // of the sum
+	Stems []StemLoudness
+}
+
+// CalculateStemLoudness measures the sum of stems, such as the
+// dialogue, music and effects stems of a mix, and each stem on
+// its own. ffmpeg sums the stems sample by sample at unity
+// gain and measures the sum as it goes, so no mix is written
+// to disk; stems of differing length are summed to the end of
+// the longest. The stems themselves are measured with the
+// backends.
+func CalculateStemLoudness(stems []string, opts Options) (StemSum, error) {
+	if len(stems) == 0 {
+		return StemSum{}, fmt.Errorf("Cannot measure stems: no stems given")
+	}
+
+	s := StemSum{}
+	var err error
+	s.Sum, err = sumLoudness(stems, opts, &s.Info)
+	if err != nil {
+		return StemSum{}, err
+	}
+	for _, file := range stems {
+		st := StemLoudness{File: file}
+		st.Loudness, st.Info, err = calculateSafely(file, opts)
+		if err != nil {
+			return StemSum{}, fmt.Errorf("Cannot analyze stem %s: %w", file, err)
+		}
+		st.Relative = float64(st.Loudness.Integrated - s.Sum.Integrated)
+		st.Share = LoudnessToEnergy(float64(st.Loudness.Integrated)) / LoudnessToEnergy(float64(s.Sum.Integrated))
+		s.Stems = append(s.Stems, st)
+	}
+	return s, nil
+}
+
+// sumLoudness measures the sum of the stems with a single
+// ffmpeg filter graph.
+func sumLoudness(stems []string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	args := []string{"-nostdin", "-nostats", "-loglevel", "info"}
+	var inputs strings.Builder
+	for i, file := range stems {
+		args = append(args, "-i", ffmpegPath(file))
+		fmt.Fprintf(&inputs, "[%d:a:0]", i)
+	}
+	graph := "ebur128=framelog=info:peak=true"
+	if len(stems) > 1 {
+		graph = fmt.Sprintf("%samix=inputs=%d:duration=longest:normalize=0,%s", inputs.String(), len(stems), graph)
+	} else {
+		graph = inputs.String() + graph
+	}
+	args = append(args, "-filter_complex", graph, "-f", "null", "-")
+
+	out, err := runFilterLog(exec.Command("ffmpeg", args...), nil, opts, info)
+	if err != nil {
+		return LoudnessData{}, fmt.Errorf("Cannot calculate loudness of stems: %w", err)
+	}
+	if opts.KeepRawOutput {
+		info.RawOutput = []byte(out)
+	}
+	start := time.Now()
+	ld, err := parseEBUR128(out)
+	info.Timings.Parse += time.Since(start)
+	if err != nil {
+		return LoudnessData{}, err
+	}
+
+	// the sum lasts as long as the longest stem
+	ld.Length = 0
+	for _, file := range stems {
+		length, err := probeDuration(file, opts, info)
+		if err != nil {
+			return LoudnessData{}, err
+		}
+		if length > ld.Length {
+			ld.Length = length
+		}
+	}
+	info.Backend = FFmpeg{}.Name()
+	ld, info.Calibration = calibrate(ld, info.Backend, opts)
+	return ld, nil
+}
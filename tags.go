@@ -0,0 +1,312 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Tags are free-form text metadata, by key. Keys are matched
+// case-insensitively, as ReplayGain readers do.
+//
+// In MP3 files they are the user-defined text frames (TXXX)
+// of the ID3v2 tag at the start of the file, in AIFF files
+// those of an ID3v2 tag in an "ID3 " chunk, which is how
+// pro-audio tools tag AIFF, and in WAV files those of one in
+// an "id3 " chunk, as foobar2000 and Mp3tag write and ffmpeg
+// reads; in CAF files they are the entries
+// of the info chunk; in FLAC, Ogg Vorbis and Opus files they
+// are Vorbis comments; in MP4 files they are the iTunes
+// freeform ("----") items.
+
+// tagCodec reads and rewrites the tags of one kind of file.
+type tagCodec struct {
+	name  string
+	read  func(file string) (map[string]string, error)
+	write func(src, dst string, tags map[string]string) error // tags as for WriteTags
+}
+
+// tagCodecFor picks the tagCodec for file by its first bytes.
+func tagCodecFor(file string) (tagCodec, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return tagCodec{}, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	hdr := make([]byte, 64)
+	n, err := io.ReadFull(f, hdr)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return tagCodec{}, fmt.Errorf("Cannot read file: %v", err)
+	}
+	hdr = hdr[:n]
+
+	switch {
+	case bytes.HasPrefix(hdr, []byte("RIFF")), bytes.HasPrefix(hdr, []byte("FORM")), bytes.HasPrefix(hdr, []byte("caff")):
+		return tagCodec{name: "iff", read: readChunkTags, write: writeChunkTags}, nil
+	case bytes.HasPrefix(hdr, []byte("ID3")), len(hdr) >= 2 && hdr[0] == 0xff && hdr[1]&0xe0 == 0xe0:
+		return tagCodec{name: "id3", read: readID3Tags, write: writeID3Tags}, nil
+	case bytes.HasPrefix(hdr, []byte("fLaC")):
+		return tagCodec{name: "flac", read: readFLACTags, write: writeFLACTags}, nil
+	case bytes.HasPrefix(hdr, []byte("OggS")):
+		name := "ogg"
+		if bytes.Contains(hdr, []byte("OpusHead")) {
+			name = "opus"
+		}
+		return tagCodec{name: name, read: readOggTags, write: writeOggTags}, nil
+	case len(hdr) >= 8 && string(hdr[4:8]) == "ftyp":
+		return tagCodec{name: "mp4", read: readMP4Tags, write: writeMP4Tags}, nil
+	}
+	return tagCodec{}, fmt.Errorf("Cannot tag %s: unknown format", file)
+}
+
+// LoudnessTags returns tags describing ld the way the loudness
+// fields of a broadcast WAV bext chunk (EBU Tech 3285 v2) do,
+// for formats that have no bext chunk.
+func LoudnessTags(ld LoudnessData) map[string]string {
+	return map[string]string{
+		"LoudnessValue":        FormatLevel(ld.Integrated, DefaultPrecision),
+		"LoudnessRange":        FormatLevel(ld.Range, DefaultPrecision),
+		"MaxTruePeakLevel":     FormatLevel(ld.Peak, DefaultPrecision),
+		"MaxMomentaryLoudness": FormatLevel(ld.Momentary, DefaultPrecision),
+		"MaxShortTermLoudness": FormatLevel(ld.Shortterm, DefaultPrecision),
+	}
+}
+
+// tagChunk returns the type of the chunk holding the tags of
+// a chunked file, and the chunk it is added before if missing
+// (empty for the end).
+func tagChunk(cf chunkFile) (string, string, error) {
+	switch cf.magic {
+	case "RIFF":
+		return "id3 ", "", nil
+	case "FORM":
+		return "ID3 ", "", nil
+	case "caff":
+		return "info", "data", nil
+	}
+	return "", "", fmt.Errorf("Cannot tag %s files", cf.formType)
+}
+
+// readTagChunk reads the layout and tag chunk of file; the
+// payload is nil if there is no tag chunk.
+func readTagChunk(file string) (chunkFile, []byte, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return chunkFile{}, nil, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	cf, err := readChunks(f)
+	if err != nil {
+		return chunkFile{}, nil, err
+	}
+	id, _, err := tagChunk(cf)
+	if err != nil {
+		return chunkFile{}, nil, err
+	}
+	c, ok := cf.find(id)
+	if !ok {
+		return cf, nil, nil
+	}
+	buf, err := readChunk(f, c)
+	return cf, buf, err
+}
+
+// ReadTags reads the tags of an MP3, FLAC, Ogg, MP4, WAV,
+// AIFF or CAF file.
+func ReadTags(file string) (map[string]string, error) {
+	codec, err := tagCodecFor(file)
+	if err != nil {
+		return nil, err
+	}
+	return codec.read(file)
+}
+
+// WriteTags sets the tags of an MP3, FLAC, Ogg, MP4, WAV,
+// AIFF or CAF file, keeping those not in tags. An empty value
+// removes the tag. The audio and other metadata are copied
+// unchanged. Hooks of opts run around it as StageTag.
+func WriteTags(file string, tags map[string]string, opts Options) error {
+	if len(opts.Hooks) == 0 {
+		return writeTags(file, tags, opts)
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return withHooks(opts, &HookEvent{Stage: StageTag, File: file, Tags: copied}, func(e *HookEvent) error {
+		return writeTags(file, e.Tags, opts)
+	})
+}
+
+func writeTags(file string, tags map[string]string, opts Options) error {
+	codec, err := tagCodecFor(file)
+	if err != nil {
+		return err
+	}
+	old, err := codec.read(file)
+	if err != nil {
+		return err
+	}
+
+	write := func(dst string) error {
+		return codec.write(file, dst, tags)
+	}
+	verify := func(path string) error {
+		got, err := codec.read(path)
+		if err != nil {
+			return err
+		}
+		for k, v := range tags {
+			if lookupTag(got, k) != v {
+				return fmt.Errorf("tag %s does not read back as written", k)
+			}
+		}
+		return nil
+	}
+	err = modifyFile(file, opts, write, verify)
+	if err != nil {
+		return err
+	}
+
+	changed := make(map[string]string)
+	for k := range tags {
+		if v := lookupTag(old, k); v != "" {
+			changed[k] = v
+		}
+	}
+	return audit(opts, AuditEntry{
+		Operation: AuditTagWrite,
+		File:      file,
+		Before:    changed,
+		After:     tags,
+	})
+}
+
+// readChunkTags reads the tags of a WAV, AIFF or CAF file.
+func readChunkTags(file string) (map[string]string, error) {
+	cf, buf, err := readTagChunk(file)
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		return map[string]string{}, nil
+	}
+	if cf.magic == "caff" {
+		return parseCAFInfo(buf)
+	}
+	t, err := parseID3(buf)
+	if err != nil {
+		return nil, err
+	}
+	return t.userText(), nil
+}
+
+// writeChunkTags writes src with its tag chunk updated to dst.
+func writeChunkTags(src, dst string, tags map[string]string) error {
+	cf, buf, err := readTagChunk(src)
+	if err != nil {
+		return err
+	}
+	id, before, _ := tagChunk(cf)
+
+	var payload []byte
+	if cf.magic == "caff" {
+		old := map[string]string{}
+		if buf != nil {
+			old, err = parseCAFInfo(buf)
+			if err != nil {
+				return err
+			}
+		}
+		payload = encodeCAFInfo(mergeTags(old, tags))
+	} else {
+		t := newID3Tag()
+		if buf != nil {
+			t, err = parseID3(buf)
+			if err != nil {
+				return err
+			}
+		}
+		for k, v := range tags {
+			t.setUserText(k, v)
+		}
+		payload = t.encode()
+	}
+	return rewriteChunk(src, dst, newChunk(id, payload), before)
+}
+
+// lookupTag returns the value of key in tags, ignoring case.
+func lookupTag(tags map[string]string, key string) string {
+	for k, v := range tags {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// mergeTags returns old with tags set or, if empty, removed.
+func mergeTags(old, tags map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range old {
+		if !hasTag(tags, k) {
+			merged[k] = v
+		}
+	}
+	for k, v := range tags {
+		if v != "" {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func hasTag(tags map[string]string, key string) bool {
+	for k := range tags {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCAFInfo parses a CAF info chunk: an entry count, then
+// as many NUL-terminated UTF-8 keys and values.
+func parseCAFInfo(buf []byte) (map[string]string, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Cannot parse CAF info chunk: truncated")
+	}
+	n := binary.BigEndian.Uint32(buf)
+	fields := bytes.Split(buf[4:], []byte{0})
+	if uint64(len(fields)) < 2*uint64(n) {
+		return nil, fmt.Errorf("Cannot parse CAF info chunk: %d entries expected, %d strings found", n, len(fields)/2)
+	}
+	tags := make(map[string]string, n)
+	for i := uint32(0); i < n; i++ {
+		tags[string(fields[2*i])] = string(fields[2*i+1])
+	}
+	return tags, nil
+}
+
+// encodeCAFInfo encodes a CAF info chunk, with the keys in
+// order.
+func encodeCAFInfo(tags map[string]string) []byte {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(keys)))
+	for _, k := range keys {
+		buf = append(append(buf, k...), 0)
+		buf = append(append(buf, tags[k]...), 0)
+	}
+	return buf
+}
@@ -0,0 +1,348 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// audioBytes stands in for the audio of the files made below:
+// frame syncs and bytes of every value, odd in length.
+var audioBytes = func() []byte {
+	buf := []byte{0xff, 0xfb, 0x90, 0x64}
+	for i := 0; i < 1001; i++ {
+		buf = append(buf, byte(i*7))
+	}
+	return buf
+}()
+
+func id3Frames(version byte, frames ...id3Frame) []byte {
+	t := id3Tag{version: version, frames: frames}
+	return t.encode()[10:]
+}
+
+// mp3File is audio after an ID3 tag of the version and header
+// flags with an artist frame, or none if version is zero.
+func mp3File(version, flags byte, padding int) []byte {
+	if version == 0 {
+		return audioBytes
+	}
+	artist := id3Frame{id: "TPE1", data: []byte("\x03Artist\x00")}
+	frames := id3Frames(version, artist)
+	frames = frames[:len(frames)-id3Padding]
+	size := len(frames) + padding
+	hdr := append([]byte{version, 0, flags}, syncsafe(size)...)
+	buf := append([]byte("ID3"), hdr...)
+	buf = append(append(buf, frames...), make([]byte, padding)...)
+	if flags&id3Footer != 0 {
+		buf = append(append(buf, "3DI"...), hdr...)
+	}
+	return append(buf, audioBytes...)
+}
+
+// chunkedFile lays out a RIFF, FORM or caff file of chunks.
+func chunkedFile(magic, formType string, chunks ...chunk) []byte {
+	cf := chunkFile{magic: magic, formType: formType, chunks: chunks}
+	var buf bytes.Buffer
+	if err := cf.write(&buf, nil); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func flacFile() []byte {
+	buf := append([]byte("fLaC"), flacLast|flacStreamInfo, 0, 0, 34)
+	buf = append(buf, make([]byte, 34)...)
+	return append(buf, audioBytes...)
+}
+
+// oggFile is a Vorbis or Opus stream whose audio is on pages
+// of its own after the headers.
+func oggFile(opus bool) []byte {
+	id, headers := []byte("\x01vorbis"), [][]byte{[]byte("\x03vorbis\x00\x00\x00\x00\x00\x00\x00\x00\x01"), []byte("\x05vorbis")}
+	if opus {
+		id, headers = []byte("OpusHead"), [][]byte{[]byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00")}
+	}
+	first := oggPaginate([][]byte{id}, 7, 0)[0]
+	first.flags = oggBOS
+	pages := append([]oggPage{first}, oggPaginate(headers, 7, 1)...)
+	seq := pages[len(pages)-1].seq
+	for _, audio := range [][]byte{audioBytes[:500], audioBytes[500:]} {
+		seq++
+		pages = append(pages, oggPaginate([][]byte{audio}, 7, seq)...)
+	}
+	var buf []byte
+	for _, p := range pages {
+		buf = append(buf, p.encode()...)
+	}
+	return buf
+}
+
+// oggAudio returns the bodies of the pages after the headers
+// of an Ogg file, checking they are numbered on from them.
+func oggAudio(t *testing.T, file string) []byte {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h, err := readOggHeaders(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audio []byte
+	for seq := uint32(h.pages); ; seq++ {
+		p, _, err := readOggPage(f)
+		if err != nil {
+			break
+		}
+		if p.seq != seq {
+			t.Errorf("audio page %d numbered %d", seq, p.seq)
+		}
+		audio = append(audio, p.body...)
+	}
+	return audio
+}
+
+// chunkAudio returns the payload of the chunk of the type.
+func chunkAudio(id string) func(t *testing.T, file string) []byte {
+	return func(t *testing.T, file string) []byte {
+		buf, _, ok, err := findChunk(file, id)
+		if err != nil || !ok {
+			t.Fatalf("no %q chunk: %v", id, err)
+		}
+		return buf
+	}
+}
+
+// suffixAudio returns the end of the file, as long as the
+// audio.
+func suffixAudio(t *testing.T, file string) []byte {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) < len(audioBytes) {
+		return buf
+	}
+	return buf[len(buf)-len(audioBytes):]
+}
+
+func TestTagsRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		file  []byte
+		codec string
+		audio func(t *testing.T, file string) []byte
+	}{
+		{"untagged.mp3", mp3File(0, 0, 0), "id3", suffixAudio},
+		{"v23.mp3", mp3File(3, 0, 0), "id3", suffixAudio},
+		{"v24.mp3", mp3File(4, 0, 100), "id3", suffixAudio},
+		{"footer.mp3", mp3File(4, id3Footer, 0), "id3", suffixAudio},
+		{"a.wav", chunkedFile("RIFF", "WAVE", newChunk("fmt ", make([]byte, 16)), newChunk("data", audioBytes)), "iff", chunkAudio("data")},
+		{"a.aiff", chunkedFile("FORM", "AIFF", newChunk("COMM", make([]byte, 18)), newChunk("SSND", audioBytes)), "iff", chunkAudio("SSND")},
+		{"a.caf", chunkedFile("caff", "", newChunk("desc", make([]byte, 32)), newChunk("data", audioBytes)), "iff", chunkAudio("data")},
+		{"a.flac", flacFile(), "flac", suffixAudio},
+		{"a.ogg", oggFile(false), "ogg", oggAudio},
+		{"a.opus", oggFile(true), "opus", oggAudio},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), c.name)
+			if err := os.WriteFile(file, c.file, 0644); err != nil {
+				t.Fatal(err)
+			}
+			codec, err := tagCodecFor(file)
+			if err != nil || codec.name != c.codec {
+				t.Fatalf("tagged as %q (%v), want %q", codec.name, err, c.codec)
+			}
+			audio := c.audio(t, file)
+
+			err = WriteTags(file, map[string]string{TagTrackGain: "-3.20 dB", "Title": "Grün – ü"}, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = WriteTags(file, map[string]string{TagTrackPeak: "0.988553", "title": ""}, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags, err := ReadTags(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tags) != 2 || lookupTag(tags, TagTrackGain) != "-3.20 dB" || lookupTag(tags, TagTrackPeak) != "0.988553" {
+				t.Errorf("read back %q, want the gain and peak only", tags)
+			}
+			if got := c.audio(t, file); !bytes.Equal(got, audio) {
+				t.Errorf("audio changed: %d bytes, was %d", len(got), len(audio))
+			}
+		})
+	}
+}
+
+func TestID3Layout(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		file    []byte
+		padding int // after the frames
+		footer  bool
+	}{
+		{"new", mp3File(0, 0, 0), id3Padding, false},
+		{"padding kept", mp3File(3, 0, 2000), -1, false},
+		{"padding grown", mp3File(4, 0, 10), id3Padding, false},
+		{"footer", mp3File(4, id3Footer, 0), 0, true},
+	} {
+		file := filepath.Join(t.TempDir(), "a.mp3")
+		if err := os.WriteFile(file, c.file, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, before, err := readID3File(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteTags(file, map[string]string{TagTrackGain: "-3.20 dB"}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag, size, err := readID3File(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[size:], audioBytes) {
+			t.Errorf("%s: %d bytes of tag, not followed by the audio", c.name, size)
+		}
+		if got := buf[5] & id3Footer; (got != 0) != c.footer || c.footer && string(buf[size-10:size-7]) != "3DI" {
+			t.Errorf("%s: flags %#x, footer %q", c.name, buf[5], buf[size-10:size-7])
+		}
+		if len(tag.frames) != len(mp3Frames(c.file))+1 {
+			t.Errorf("%s: %d frames, want the artist kept", c.name, len(tag.frames))
+		}
+
+		frames := len(id3Frames(tag.version, tag.frames...)) - id3Padding
+		padding := unsyncsafe(buf[6:10]) - frames
+		if c.padding < 0 {
+			if size != before {
+				t.Errorf("%s: tag of %d bytes, was %d and had room", c.name, size, before)
+			}
+		} else if padding != c.padding {
+			t.Errorf("%s: %d bytes of padding, want %d", c.name, padding, c.padding)
+		}
+	}
+}
+
+// mp3Frames returns the frames of the tag of an mp3File.
+func mp3Frames(file []byte) []id3Frame {
+	t, err := parseID3(file)
+	if err != nil {
+		return nil
+	}
+	return t.frames
+}
+
+// mp4BoxOf returns a box of the type holding the payloads.
+func mp4BoxOf(typ string, payload ...[]byte) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(8+len(bytes.Join(payload, nil))))
+	buf = append(buf, typ...)
+	return append(buf, bytes.Join(payload, nil)...)
+}
+
+// mp4Track is a trak box whose sample table has a chunk
+// offset box of the type, with the offsets.
+func mp4Track(typ string, offsets ...uint64) []byte {
+	table := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(offsets)))
+	for _, off := range offsets {
+		if typ == "stco" {
+			table = binary.BigEndian.AppendUint32(table, uint32(off))
+		} else {
+			table = binary.BigEndian.AppendUint64(table, off)
+		}
+	}
+	return mp4BoxOf("trak", mp4BoxOf("mdia", mp4BoxOf("minf", mp4BoxOf("stbl", mp4BoxOf(typ, table)))))
+}
+
+// mp4Offsets returns the chunk offsets of the tracks of an MP4
+// file.
+func mp4Offsets(t *testing.T, file string) [][]uint64 {
+	moov, _, err := readMP4Moov(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets [][]uint64
+	for _, trak := range moov.children {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl := trak.child("mdia").child("minf").child("stbl")
+		for _, c := range stbl.children {
+			var table []uint64
+			n := int(binary.BigEndian.Uint32(c.data[4:]))
+			for i := 0; i < n; i++ {
+				if c.typ == "stco" {
+					table = append(table, uint64(binary.BigEndian.Uint32(c.data[8+4*i:])))
+				} else {
+					table = append(table, binary.BigEndian.Uint64(c.data[8+8*i:]))
+				}
+			}
+			offsets = append(offsets, table)
+		}
+	}
+	return offsets
+}
+
+func TestMP4ChunkOffsets(t *testing.T) {
+	ftyp := mp4BoxOf("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42"))
+	free := mp4BoxOf("free", make([]byte, 8)) // before moov, not moved
+	layout := func(moov []byte) []byte {
+		return append(append(append(ftyp, free...), moov...), mp4BoxOf("mdat", audioBytes)...)
+	}
+	// offsets into free, which stays where it is, and into
+	// mdat, which moves: the moov box is as long either way
+	moov := func(mdat uint64) []byte {
+		return mp4BoxOf("moov", mp4Track("stco", uint64(len(ftyp)), mdat+8, mdat+300), mp4Track("co64", mdat+8+1000))
+	}
+	mdat := uint64(len(ftyp) + len(free) + len(moov(0)))
+	buf := layout(moov(mdat))
+
+	file := filepath.Join(t.TempDir(), "a.m4a")
+	if err := os.WriteFile(file, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	at := func(buf []byte, offsets [][]uint64) []byte {
+		var data []byte
+		for _, table := range offsets {
+			for _, off := range table {
+				data = append(data, buf[off:off+4]...)
+			}
+		}
+		return data
+	}
+	want := at(buf, mp4Offsets(t, file))
+
+	for i, tags := range []map[string]string{
+		{TagTrackGain: "-3.20 dB", TagTrackPeak: "0.988553"},
+		{TagTrackGain: "-3.21 dB"},
+		{TagTrackPeak: ""},
+	} {
+		if err := WriteTags(file, tags, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		tagged, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := at(tagged, mp4Offsets(t, file)); !bytes.Equal(got, want) {
+			t.Errorf("write %d: chunk offsets point at % x, want % x", i, got, want)
+		}
+		if !bytes.HasSuffix(tagged, audioBytes) {
+			t.Errorf("write %d: audio changed", i)
+		}
+	}
+	tags, err := ReadTags(file)
+	if err != nil || len(tags) != 1 || tags[TagTrackGain] != "-3.21 dB" {
+		t.Errorf("read back %q (%v), want the second gain only", tags, err)
+	}
+}
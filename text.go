@@ -0,0 +1,111 @@
+package bs1770wrap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* Plain text format, as printed by builds without --xml:
+
+`
+analyzing ...
+  [1/2] "01 - Powerful Blues Rock.wav":
+      integrated:  -14.14 LUFS / -8.86 LU
+      momentary maximum:  -9.55 LUFS / -13.45 LU
+      shortterm maximum:  -11.32 LUFS / -11.68 LU
+      range:  4.52 LUFS
+      true peak:  0.05 TPFS / 1.005459
+  [2/2] ...
+  [ALBUM]:
+      integrated:  -14.53 LUFS / -8.47 LU
+      ...
+done.
+`
+
+The values following [ALBUM] are the album summary.
+*/
+
+var (
+	textTrackRegex = regexp.MustCompile(`^\s*\[(\d+)/\d+\]\s+"(.*)":\s*$`)
+	textAlbumRegex = regexp.MustCompile(`^\s*\[ALBUM\]:\s*$`)
+	textValueRegex = regexp.MustCompile(`^\s*([a-z][a-z -]*):\s+(-?(?:inf|\d+(?:\.\d+)?))`)
+)
+
+// flagUnsupported reports whether bs1770gain rejected the
+// report format flag, such as --xml, judging by its error
+// output.
+func flagUnsupported(stderr []byte, format string) bool {
+	msg := strings.ToLower(string(stderr))
+	if !strings.Contains(msg, format) {
+		return false
+	}
+	for _, s := range []string{"unrecognized", "unknown", "invalid", "not supported"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func looksLikeXML(out []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(out), []byte("<"))
+}
+
+// parseText parses the plain text report into the same
+// structure the XML report is unmarshalled into.
+func parseText(out []byte) (bs1770gainData, error) {
+	gd := bs1770gainData{}
+	var cur *measurements
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+
+		if m := textTrackRegex.FindStringSubmatch(line); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil {
+				return bs1770gainData{}, fmt.Errorf("bad track number %q", m[1])
+			}
+			gd.Album.Tracks = append(gd.Album.Tracks, trackData{Number: n, File: m[2]})
+			cur = &gd.Album.Tracks[len(gd.Album.Tracks)-1].measurements
+			continue
+		}
+		if textAlbumRegex.MatchString(line) {
+			gd.Album.Summary = &summaryData{}
+			cur = &gd.Album.Summary.measurements
+			continue
+		}
+
+		m := textValueRegex.FindStringSubmatch(line)
+		if m == nil || cur == nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(m[2], 32)
+		if err != nil {
+			return bs1770gainData{}, fmt.Errorf("bad %s value %q", m[1], m[2])
+		}
+		switch m[1] {
+		case "integrated":
+			cur.Integrated.Value = float32(v)
+		case "momentary", "momentary maximum":
+			cur.MomentaryMaximum = &levelData{Value: float32(v)}
+		case "shortterm", "shortterm maximum", "short-term maximum":
+			cur.ShorttermMaximum = &levelData{Value: float32(v)}
+		case "range":
+			cur.Range.Value = float32(v)
+		case "true peak":
+			cur.TruePeak.Value = float32(v)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return bs1770gainData{}, err
+	}
+	if len(gd.Album.Tracks) == 0 {
+		return bs1770gainData{}, withKind(ErrNoLoudnessData, fmt.Errorf("no tracks in output"))
+	}
+	return gd, nil
+}
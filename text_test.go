@@ -0,0 +1,108 @@
+package bs1770wrap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// textAlbum is a plain text report of bs1770gain 0.4, as it
+// prints it without --xml, of an album of odd file names:
+// they are printed between quotes as they are, quotes and
+// backslashes included.
+const textAlbum = `analyzing ...
+  [1/3] "01 - Powerful Blues Rock.wav":
+      integrated:  -14.14 LUFS / -8.86 LU
+      momentary maximum:  -9.55 LUFS / -13.45 LU
+      shortterm maximum:  -11.32 LUFS / -11.68 LU
+      range:  4.52 LUFS
+      true peak:  0.05 TPFS / 1.005459
+  [2/3] "02 - Björk – "Jóga" (夜).flac":
+      integrated:  -15.20 LUFS / -7.80 LU
+      momentary maximum:  -10.01 LUFS / -12.99 LU
+      shortterm maximum:  -12.40 LUFS / -10.60 LU
+      range:  6.10 LUFS
+      true peak:  -0.30 TPFS / 0.966051
+  [3/3] "03 - C:\ "silence": x.mp3":
+      integrated:  -inf LUFS / -inf LU
+      momentary maximum:  -inf LUFS / -inf LU
+      shortterm maximum:  -inf LUFS / -inf LU
+      range:  0.00 LUFS
+      true peak:  -inf TPFS / 0.000000
+  [ALBUM]:
+      integrated:  -14.53 LUFS / -8.47 LU
+      momentary maximum:  -9.55 LUFS / -13.45 LU
+      shortterm maximum:  -11.32 LUFS / -11.68 LU
+      range:  5.34 LUFS
+      true peak:  0.05 TPFS / 1.005459
+done.
+`
+
+// textTrack is a track of a report as parsed.
+type textTrack struct {
+	number int
+	file   string
+	ld     LoudnessData
+}
+
+// textAlbumTracks are the tracks of textAlbum.
+var textAlbumTracks = []textTrack{
+	{1, "01 - Powerful Blues Rock.wav", LoudnessData{Integrated: -14.14, Momentary: -9.55, Shortterm: -11.32, Range: 4.52, Peak: 0.05}},
+	{2, `02 - Björk – "Jóga" (夜).flac`, LoudnessData{Integrated: -15.20, Momentary: -10.01, Shortterm: -12.40, Range: 6.10, Peak: -0.30}},
+	{3, `03 - C:\ "silence": x.mp3`, LoudnessData{Integrated: -float32(inf), Momentary: -float32(inf), Shortterm: -float32(inf), Peak: -float32(inf)}},
+}
+
+func TestParseText(t *testing.T) {
+	album := &LoudnessData{Integrated: -14.53, Momentary: -9.55, Shortterm: -11.32, Range: 5.34, Peak: 0.05}
+	for _, c := range []struct {
+		name   string
+		out    string
+		tracks []textTrack
+		album  *LoudnessData
+	}{
+		{"album", textAlbum, textAlbumTracks, album},
+		{"CRLF", strings.ReplaceAll(textAlbum, "\n", "\r\n"), textAlbumTracks, album},
+		// 0.5 names the maxima alone, and adds a sample peak
+		{"0.5", `analyzing ...
+  [1/1] "a.flac":
+      integrated:  -23.00 LUFS / 0.00 LU
+      momentary:  -20.50 LUFS / 2.50 LU
+      short-term maximum:  -21.00 LUFS / 2.00 LU
+      range:  2.50 LUFS
+      sample peak:  -3.50 SPFS / 0.668344
+      true peak:  -3.00 TPFS / 0.707946
+done.
+`, []textTrack{{1, "a.flac", LoudnessData{Integrated: -23, Momentary: -20.5, Shortterm: -21, Range: 2.5, Peak: -3}}}, nil},
+		// what was not asked for is left zero
+		{"partial", "analyzing ...\n  [1/1] \"a.flac\":\n      momentary:  -20.50 LUFS / 2.50 LU\ndone.\n",
+			[]textTrack{{1, "a.flac", LoudnessData{Momentary: -20.5}}}, nil},
+	} {
+		gd, err := parseText([]byte(c.out))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(gd.Album.Tracks) != len(c.tracks) {
+			t.Errorf("%s: %d tracks, want %d", c.name, len(gd.Album.Tracks), len(c.tracks))
+			continue
+		}
+		for i, want := range c.tracks {
+			got := gd.Album.Tracks[i]
+			if got.Number != want.number || got.File != want.file || got.loudness(0) != want.ld {
+				t.Errorf("%s: track %d is %d %q measuring %+v, want %d %q measuring %+v", c.name, i, got.Number, got.File, got.loudness(0), want.number, want.file, want.ld)
+			}
+		}
+		switch {
+		case (gd.Album.Summary != nil) != (c.album != nil):
+			t.Errorf("%s: album summary %v, want %v", c.name, gd.Album.Summary, c.album)
+		case c.album != nil && gd.Album.Summary.loudness(0) != *c.album:
+			t.Errorf("%s: album measures %+v, want %+v", c.name, gd.Album.Summary.loudness(0), *c.album)
+		}
+	}
+
+	for _, out := range []string{"", "analyzing ...\ndone.\n", "bs1770gain: unrecognized option '--xml'\n"} {
+		if _, err := parseText([]byte(out)); !errors.Is(err, ErrNoLoudnessData) {
+			t.Errorf("parseText(%q) = %v, want ErrNoLoudnessData", out, err)
+		}
+	}
+}
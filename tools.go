@@ -0,0 +1,100 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Tools says where the external programs the package runs
+// are. A path given for a tool is used as is, or looked up in
+// PATH if it has no directory in it; tools without one are
+// looked for in Dir, if set, and then in PATH.
+type Tools struct {
+	Dir string
+
+	Sox        string
+	BS1770Gain string
+	FFmpeg     string
+	FFprobe    string
+}
+
+var defaultTools struct {
+	mu sync.RWMutex
+	t  Tools
+}
+
+// SetTools sets where tools are looked for, process-wide,
+// for analyses whose Options.Tools is nil. The zero value,
+// the default, means PATH.
+func SetTools(t Tools) {
+	defaultTools.mu.Lock()
+	defer defaultTools.mu.Unlock()
+	defaultTools.t = t
+}
+
+// path returns the path configured for the tool name.
+func (t Tools) path(name string) string {
+	switch name {
+	case "sox":
+		return t.Sox
+	case "bs1770gain":
+		return t.BS1770Gain
+	case "ffmpeg":
+		return t.FFmpeg
+	case "ffprobe":
+		return t.FFprobe
+	}
+	return ""
+}
+
+// LookupTool returns the path of the tool name ("sox",
+// "bs1770gain", "ffmpeg" or "ffprobe") as an analysis with
+// opts would run it, or an error matching ErrBinaryNotFound
+// saying where it was looked for. It lets callers check for
+// the tools they need up front.
+func LookupTool(name string, opts Options) (string, error) {
+	t := Tools{}
+	if opts.Tools != nil {
+		t = *opts.Tools
+	} else {
+		defaultTools.mu.RLock()
+		t = defaultTools.t
+		defaultTools.mu.RUnlock()
+	}
+
+	if p := t.path(name); p != "" {
+		path, err := exec.LookPath(p)
+		if err != nil {
+			return "", &ToolError{Tool: name, Err: fmt.Errorf("Cannot find %s at %s: %w", name, p, err)}
+		}
+		return path, nil
+	}
+	if t.Dir != "" {
+		if path, err := exec.LookPath(filepath.Join(t.Dir, name)); err == nil {
+			return path, nil
+		}
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		where := "PATH"
+		if t.Dir != "" {
+			where = t.Dir + " or PATH"
+		}
+		return "", &ToolError{Tool: name, Err: fmt.Errorf("Cannot find %s in %s: %w", name, where, err)}
+	}
+	return path, nil
+}
+
+// resolveTool points cmd, made with exec.Command(name, ...),
+// at the tool opts say to run.
+func resolveTool(name string, cmd *exec.Cmd, opts Options) error {
+	path, err := LookupTool(name, opts)
+	if err != nil {
+		return err
+	}
+	cmd.Path = path
+	cmd.Err = nil
+	return nil
+}
@@ -0,0 +1,96 @@
+package bs1770wrap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The file attribute of a track is entity-escaped by
+// bs1770gain (every non-alphanumeric character becomes a
+// &#x..; reference), which encoding/xml undoes for us. What
+// is left is matching that name against paths on disk or
+// given by the caller, which may use a different Unicode
+// normalization than bs1770gain does: macOS file systems
+// hand out decomposed (NFD) names, while most other sources
+// use composed (NFC) ones.
+
+// fileNameKey reduces a file name to a form in which names
+// referring to the same file compare equal.
+func fileNameKey(name string) string {
+	return norm.NFC.String(filepath.ToSlash(filepath.Clean(name)))
+}
+
+// sameFileName reports whether a track's file attribute
+// refers to the given path. Only as many trailing path
+// elements as the attribute has are compared, since
+// bs1770gain reports names relative to what it was given.
+func sameFileName(attr, path string) bool {
+	want := fileNameKey(attr)
+	have := fileNameKey(path)
+	if want == have {
+		return true
+	}
+	n := len(have) - len(want)
+	return n > 0 && have[n-1] == '/' && have[n:] == want
+}
+
+// selectTrack picks the track requested in opts out of the
+// album, defaulting to the first one.
+func selectTrack(album albumData, opts Options) (trackData, error) {
+	if len(album.Tracks) == 0 {
+		return trackData{}, withKind(ErrNoLoudnessData, fmt.Errorf("Cannot parse loudness information: no tracks in output"))
+	}
+
+	switch {
+	case opts.TrackNumber != 0:
+		for _, t := range album.Tracks {
+			if t.Number == opts.TrackNumber {
+				return t, nil
+			}
+		}
+		return trackData{}, withKind(ErrNoLoudnessData, fmt.Errorf("Cannot find track number %d in analysis", opts.TrackNumber))
+	case opts.TrackFile != "":
+		for _, t := range album.Tracks {
+			if sameFileName(t.File, opts.TrackFile) {
+				return t, nil
+			}
+		}
+		return trackData{}, withKind(ErrNoLoudnessData, fmt.Errorf("Cannot find track %q in analysis", opts.TrackFile))
+	}
+	return album.Tracks[0], nil
+}
+
+var errFound = errors.New("found")
+
+// trackPath returns the path of the file a track was
+// measured from, given the path bs1770gain was run on.
+func trackPath(input string, track trackData) string {
+	fi, err := os.Stat(input)
+	if err != nil || !fi.IsDir() {
+		return input
+	}
+
+	path := filepath.Join(input, track.File)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	// the name on disk is normalized differently, look for it
+	found := path
+	filepath.Walk(input, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(input, p)
+		if err == nil && sameFileName(track.File, rel) {
+			found = p
+			return errFound
+		}
+		return nil
+	})
+	return found
+}
@@ -0,0 +1,193 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VADRate is the sample rate audio is decoded at for voice
+// activity detection; speech needs no more than this.
+const VADRate = 8000
+
+// Segment is a span of time within a file.
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// VoiceActivityDetector finds the spans of a signal that
+// contain speech, for dialogue-gated measurement. It gets
+// the whole file as mono float samples in [-1, 1] at the
+// given rate. Detectors wrapping WebRTC VAD or an ML model
+// can be plugged in through Options.DialogueGate.
+type VoiceActivityDetector interface {
+	Detect(samples []float32, rate int) ([]Segment, error)
+}
+
+// EnergyVAD is a simple detector taking any frame noticeably
+// louder than the file's noise floor for speech. It works
+// well for dialogue over quiet beds, less so under music.
+// Zero fields take the defaults noted.
+type EnergyVAD struct {
+	Frame     time.Duration // analysis frame length, 20ms
+	Threshold float64       // dB above the noise floor, 15
+	Floor     float64       // absolute minimum level of speech, -60 dBFS
+	Hangover  time.Duration // speech is extended by this much, 200ms
+	MinSpeech time.Duration // shorter bursts are dropped, 100ms
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+func floatOr(f, def float64) float64 {
+	if f == 0 {
+		return def
+	}
+	return f
+}
+
+// Detect implements VoiceActivityDetector.
+func (v EnergyVAD) Detect(samples []float32, rate int) ([]Segment, error) {
+	frameLen := int(durationOr(v.Frame, 20*time.Millisecond).Seconds() * float64(rate))
+	if frameLen <= 0 {
+		return nil, fmt.Errorf("frame too short for %d Hz", rate)
+	}
+	nframes := len(samples) / frameLen
+	if nframes == 0 {
+		return nil, nil
+	}
+
+	levels := make([]float64, nframes)
+	for i := range levels {
+		sum := 0.0
+		for _, s := range samples[i*frameLen : (i+1)*frameLen] {
+			sum += float64(s) * float64(s)
+		}
+		levels[i] = 10 * math.Log10(sum/float64(frameLen))
+	}
+
+	// the noise floor is taken to be the 10th percentile of
+	// the frames that aren't digital silence
+	var audible []float64
+	for _, l := range levels {
+		if !math.IsInf(l, -1) {
+			audible = append(audible, l)
+		}
+	}
+	if len(audible) == 0 {
+		return nil, nil
+	}
+	sort.Float64s(audible)
+	threshold := math.Max(
+		audible[len(audible)/10]+floatOr(v.Threshold, 15),
+		floatOr(v.Floor, -60))
+
+	frame := time.Duration(frameLen) * time.Second / time.Duration(rate)
+	hangover := durationOr(v.Hangover, 200*time.Millisecond)
+	minSpeech := durationOr(v.MinSpeech, 100*time.Millisecond)
+	end := time.Duration(nframes) * frame
+
+	var segs []Segment
+	for i, l := range levels {
+		if l < threshold {
+			continue
+		}
+		start := time.Duration(i) * frame
+		stop := start + frame + hangover
+		if stop > end {
+			stop = end
+		}
+		if n := len(segs); n > 0 && start <= segs[n-1].End {
+			segs[n-1].End = stop
+			continue
+		}
+		segs = append(segs, Segment{Start: start, End: stop})
+	}
+
+	kept := segs[:0]
+	for _, s := range segs {
+		if s.End-s.Start-hangover >= minSpeech {
+			kept = append(kept, s)
+		}
+	}
+	return kept, nil
+}
+
+// decodeMono decodes file into mono float samples with sox.
+func decodeMono(file string, rate int, opts Options, info *AnalysisInfo) ([]float32, error) {
+	var out, stderr bytes.Buffer
+
+	cmd := exec.Command("sox",
+		toolPath(file),
+		"-t", "raw", "-e", "floating-point", "-b", "32", "-L", // headerless little endian float
+		"-c", "1",
+		"-r", fmt.Sprint(rate),
+		"-",
+	)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err := run("sox", cmd, opts, info)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode audio: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	samples := make([]float32, out.Len()/4)
+	err = binary.Read(&out, binary.LittleEndian, samples)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode audio: %v", err)
+	}
+	return samples, nil
+}
+
+// gateDialogue keeps only the parts of in the detector deems
+// speech, writing them, joined, into dir.
+func gateDialogue(in, dir string, opts Options, info *AnalysisInfo) (string, error) {
+	var stderr bytes.Buffer
+
+	samples, err := decodeMono(in, VADRate, opts, info)
+	if err != nil {
+		return "", err
+	}
+	segs, err := detectSafely(opts.DialogueGate, samples, VADRate)
+	if err != nil {
+		return "", fmt.Errorf("Cannot detect dialogue: %v", err)
+	}
+	if len(segs) == 0 {
+		return "", fmt.Errorf("Cannot detect dialogue: no speech found")
+	}
+	info.Dialogue = segs
+
+	between := make([]string, len(segs))
+	for i, s := range segs {
+		between[i] = fmt.Sprintf("between(t,%f,%f)", s.Start.Seconds(), s.End.Seconds())
+	}
+
+	out := filepath.Join(dir, "dialogue.wav")
+	cmd := exec.Command("ffmpeg",
+		"-nostdin",
+		"-loglevel", "error",
+		"-i", ffmpegPath(in),
+		"-af", "aselect='"+strings.Join(between, "+")+"',asetpts=N/SR/TB",
+		"-f", "wav",
+		ffmpegPath(out),
+	)
+	cmd.Stderr = &stderr
+
+	err = run("ffmpeg", cmd, opts, info)
+	if err != nil {
+		return "", fmt.Errorf("Error creating temporary file: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package bs1770wrap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultVerifyTolerance is the divergence, in LU, allowed
+// between backends when Options.VerifyTolerance is zero.
+const DefaultVerifyTolerance = 0.2
+
+// Divergence is a measurement on which two backends
+// disagreed by more than the tolerance.
+type Divergence struct {
+	Field     string
+	Primary   float32 // value from the backend that produced the result
+	Reference float32 // value from Options.VerifyWith
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s %s vs %s", d.Field,
+		FormatLevel(d.Primary, DefaultPrecision), FormatLevel(d.Reference, DefaultPrecision))
+}
+
+// compareLoudness lists the measurements in which a and b
+// differ by more than tol. Levels that are equal agree,
+// silence (-Inf) on both sides included.
+func compareLoudness(a, b LoudnessData, tol float64) []Divergence {
+	fields := []struct {
+		name string
+		a, b float32
+	}{
+		{"integrated", a.Integrated, b.Integrated},
+		{"range", a.Range, b.Range},
+		{"peak", a.Peak, b.Peak},
+		{"shortterm", a.Shortterm, b.Shortterm},
+		{"momentary", a.Momentary, b.Momentary},
+	}
+
+	var divs []Divergence
+	for _, f := range fields {
+		if f.a != f.b && !WithinTolerance(float64(f.a), float64(f.b), tol, -1) {
+			divs = append(divs, Divergence{Field: f.name, Primary: f.a, Reference: f.b})
+		}
+	}
+	return divs
+}
+
+// verifyResult re-analyzes file with opts.VerifyWith and
+// compares it against ld. Divergences are recorded in info;
+// they only fail the analysis if opts.VerifyStrict is set.
+// The cross-check has an AnalysisInfo of its own, so that it
+// does not overwrite what info says of the result.
+func verifyResult(file string, ld LoudnessData, opts Options, info *AnalysisInfo) error {
+	ref, err := analyzeSafely(opts.VerifyWith, file, opts, &AnalysisInfo{})
+	if err != nil {
+		return fmt.Errorf("Cannot verify loudness with %s: %w", opts.VerifyWith.Name(), err)
+	}
+	ref, _ = calibrate(ref, opts.VerifyWith.Name(), opts)
+
+	tol := opts.VerifyTolerance
+	if tol <= 0 {
+		tol = DefaultVerifyTolerance
+	}
+
+	info.Divergences = compareLoudness(ld, ref, tol)
+	if len(info.Divergences) == 0 {
+		return nil
+	}
+
+	diffs := make([]string, len(info.Divergences))
+	for i, d := range info.Divergences {
+		diffs[i] = d.String()
+	}
+	msg := fmt.Sprintf("%s and %s disagree: %s",
+		info.Backend, opts.VerifyWith.Name(), strings.Join(diffs, ", "))
+	if opts.VerifyStrict {
+		return fmt.Errorf("Cannot verify loudness: %s", msg)
+	}
+	info.Warnings = append(info.Warnings, msg)
+	return nil
+}
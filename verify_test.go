@@ -0,0 +1,58 @@
+package bs1770wrap
+
+import (
+	"strings"
+	"testing"
+)
+
+// verifyBackend reports ld, and writes over the info it is
+// given.
+type verifyBackend struct {
+	ld LoudnessData
+}
+
+func (verifyBackend) Name() string {
+	return "verify"
+}
+
+func (b verifyBackend) Analyze(file string, opts Options, info *AnalysisInfo) (LoudnessData, error) {
+	info.ToolVersion = "9.9"
+	info.Media = &MediaInfo{}
+	return b.ld, nil
+}
+
+func TestVerifyResult(t *testing.T) {
+	silence := LoudnessData{Integrated: -float32(inf), Momentary: -float32(inf), Shortterm: -float32(inf), Peak: -float32(inf)}
+	for _, c := range []struct {
+		name      string
+		ld, ref   LoudnessData
+		divergent string
+	}{
+		{"same", LoudnessData{Integrated: -23, Peak: -1}, LoudnessData{Integrated: -23.1, Peak: -1}, ""},
+		{"silence", silence, silence, ""},
+		{"apart", LoudnessData{Integrated: -23, Peak: -1}, LoudnessData{Integrated: -22, Peak: -1}, "integrated"},
+		{"silence on one side", silence, LoudnessData{Integrated: -70, Momentary: -float32(inf), Shortterm: -float32(inf), Peak: -float32(inf)}, "integrated"},
+	} {
+		info := AnalysisInfo{Backend: "fake", ToolVersion: "1.0"}
+		opts := Options{VerifyWith: verifyBackend{c.ref}}
+		if err := verifyResult("a.wav", c.ld, opts, &info); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		var fields []string
+		for _, d := range info.Divergences {
+			fields = append(fields, d.Field)
+		}
+		if got := strings.Join(fields, ","); got != c.divergent {
+			t.Errorf("%s: divergent %q, want %q", c.name, got, c.divergent)
+		}
+		if info.ToolVersion != "1.0" || info.Media != nil {
+			t.Errorf("%s: the cross-check wrote over the info of the result: %+v", c.name, info)
+		}
+	}
+
+	opts := Options{VerifyWith: verifyBackend{LoudnessData{Integrated: float32(nan)}}, VerifyStrict: true}
+	if err := verifyResult("a.wav", LoudnessData{Integrated: -23}, opts, &AnalysisInfo{}); err == nil {
+		t.Error("a strict check of diverging results passes")
+	}
+}
@@ -0,0 +1,83 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// BS1770GainVersion is a release of bs1770gain.
+type BS1770GainVersion struct {
+	Major, Minor, Patch int
+}
+
+func (v BS1770GainVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is major.minor or later.
+func (v BS1770GainVersion) AtLeast(major, minor int) bool {
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+// looks like "bs1770gain 0.5.2, Copyright (C) ..." or, with
+// some builds, just the number
+var bs1770gainVersionRegex = regexp.MustCompile(`(?i)(?:bs1770gain\D*)?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// bs1770gainVersions caches the version of each bs1770gain
+// binary, by path and modification time.
+var bs1770gainVersions sync.Map
+
+// BS1770GainVersionOf returns the version of the bs1770gain an
+// analysis with opts runs, as its --version reports it. The
+// answer is cached until the binary changes. Builds too old to
+// know --version are reported as 0.0.0.
+func BS1770GainVersionOf(opts Options) (BS1770GainVersion, error) {
+	return bs1770gainVersion(opts, &AnalysisInfo{})
+}
+
+func bs1770gainVersion(opts Options, info *AnalysisInfo) (BS1770GainVersion, error) {
+	// what a Runner answers is not cached, there being no
+	// binary to tell its versions apart
+	key := ""
+	if opts.Runner == nil {
+		path, err := LookupTool("bs1770gain", opts)
+		if err != nil {
+			return BS1770GainVersion{}, err
+		}
+		key = path
+		if fi, err := os.Stat(path); err == nil {
+			key += "@" + strconv.FormatInt(fi.ModTime().UnixNano(), 10)
+		}
+		if v, ok := bs1770gainVersions.Load(key); ok {
+			return v.(BS1770GainVersion), nil
+		}
+	}
+
+	// the version goes to stdout or stderr, depending on the
+	// build, and may come with a non-zero exit status
+	var out bytes.Buffer
+	cmd := exec.Command("bs1770gain", "--version")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := run("bs1770gain", cmd, opts, info)
+
+	v := BS1770GainVersion{}
+	if m := bs1770gainVersionRegex.FindSubmatch(out.Bytes()); m != nil {
+		v.Major, _ = strconv.Atoi(string(m[1]))
+		v.Minor, _ = strconv.Atoi(string(m[2]))
+		v.Patch, _ = strconv.Atoi(string(m[3]))
+	} else if err != nil && !errors.Is(err, ErrDecodeFailed) {
+		// it did not even run
+		return BS1770GainVersion{}, err
+	}
+	if key != "" {
+		bs1770gainVersions.Store(key, v)
+	}
+	return v, nil
+}
@@ -0,0 +1,254 @@
+package bs1770wrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A Vorbis comment, the tag of FLAC, Ogg Vorbis and Opus, is
+// a vendor string and a list of KEY=value comments, each
+// string preceded by its little-endian 32-bit length and the
+// list by its count. Keys are ASCII and case-insensitive; a
+// key may be given more than once.
+
+type vorbisComment struct {
+	vendor   string
+	comments []string
+}
+
+// parseVorbisComment parses a Vorbis comment and returns
+// whatever follows it.
+func parseVorbisComment(buf []byte) (vorbisComment, []byte, error) {
+	var vc vorbisComment
+	next := func() (string, bool) {
+		if len(buf) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(buf)
+		if uint64(n) > uint64(len(buf)-4) {
+			return "", false
+		}
+		s := string(buf[4 : 4+n])
+		buf = buf[4+n:]
+		return s, true
+	}
+
+	vendor, ok := next()
+	if !ok || len(buf) < 4 {
+		return vorbisComment{}, nil, fmt.Errorf("Cannot parse Vorbis comment: truncated")
+	}
+	vc.vendor = vendor
+	count := binary.LittleEndian.Uint32(buf)
+	buf = buf[4:]
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return vorbisComment{}, nil, fmt.Errorf("Cannot parse Vorbis comment: truncated")
+		}
+		vc.comments = append(vc.comments, c)
+	}
+	return vc, buf, nil
+}
+
+func (vc vorbisComment) encode() []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(vc.vendor)))
+	buf = append(buf, vc.vendor...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(vc.comments)))
+	for _, c := range vc.comments {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c)))
+		buf = append(buf, c...)
+	}
+	return buf
+}
+
+// tags returns the first value of each key.
+func (vc vorbisComment) tags() map[string]string {
+	m := make(map[string]string)
+	for _, c := range vc.comments {
+		k, v, ok := strings.Cut(c, "=")
+		if ok && !hasTag(m, k) {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// set replaces the comments with key by one with the value.
+// An empty value removes them.
+func (vc *vorbisComment) set(key, value string) {
+	var comments []string
+	for _, c := range vc.comments {
+		if k, _, _ := strings.Cut(c, "="); !strings.EqualFold(k, key) {
+			comments = append(comments, c)
+		}
+	}
+	if value != "" {
+		comments = append(comments, key+"="+value)
+	}
+	vc.comments = comments
+}
+
+// Ogg Vorbis has three header packets: identification,
+// comment ("\x03vorbis", the comment, a framing bit) and
+// setup. Opus has two: identification and comment
+// ("OpusTags", the comment, possibly binary data). The
+// identification header is alone on the first page, and audio
+// starts on a fresh page after the last header.
+
+// oggHeaders are the header packets of an Ogg Vorbis or Opus
+// stream and the pages they take.
+type oggHeaders struct {
+	first   oggPage // holding the identification header
+	packets [][]byte
+	pages   int   // pages holding headers, the first included
+	end     int64 // where the first audio page starts
+}
+
+// readOggHeaders reads the header packets of the Ogg file f.
+// Multiplexed and chained streams are not supported.
+func readOggHeaders(f io.ReadSeeker) (oggHeaders, error) {
+	var h oggHeaders
+	var packet []byte
+	count := 0
+	for pos := int64(0); count == 0 || len(h.packets) < count; h.pages++ {
+		page, size, err := readOggPage(f)
+		if err != nil {
+			return oggHeaders{}, fmt.Errorf("Cannot parse Ogg headers: %v", err)
+		}
+		pos += size
+		if h.pages == 0 {
+			if page.flags&oggBOS == 0 {
+				return oggHeaders{}, fmt.Errorf("Cannot parse Ogg headers: no stream start")
+			}
+			h.first = page
+		} else if page.serial != h.first.serial || page.flags&oggBOS != 0 {
+			return oggHeaders{}, fmt.Errorf("Cannot tag multiplexed Ogg streams")
+		}
+
+		body := page.body
+		for i, lace := range page.segments {
+			if count > 0 && len(h.packets) == count {
+				return oggHeaders{}, fmt.Errorf("Cannot parse Ogg headers: audio shares a page with them")
+			}
+			packet = append(packet, body[:lace]...)
+			body = body[lace:]
+			if lace == 255 {
+				continue
+			}
+			h.packets = append(h.packets, packet)
+			packet = nil
+			if count == 0 {
+				switch {
+				case bytes.HasPrefix(h.packets[0], []byte("\x01vorbis")):
+					count = 3
+				case bytes.HasPrefix(h.packets[0], []byte("OpusHead")):
+					count = 2
+				default:
+					return oggHeaders{}, fmt.Errorf("Cannot tag Ogg streams other than Vorbis and Opus")
+				}
+				if i != len(page.segments)-1 {
+					return oggHeaders{}, fmt.Errorf("Cannot parse Ogg headers: identification header not alone on its page")
+				}
+			}
+		}
+		h.end = pos
+	}
+	return h, nil
+}
+
+// comment splits the comment header into the prefix, the
+// comment and the suffix.
+func (h oggHeaders) comment() ([]byte, vorbisComment, []byte, error) {
+	prefix := []byte("OpusTags")
+	if len(h.packets) == 3 {
+		prefix = []byte("\x03vorbis")
+	}
+	if !bytes.HasPrefix(h.packets[1], prefix) {
+		return nil, vorbisComment{}, nil, fmt.Errorf("Cannot parse Ogg headers: no comment header")
+	}
+	vc, rest, err := parseVorbisComment(h.packets[1][len(prefix):])
+	return prefix, vc, rest, err
+}
+
+// readOggTags reads the tags of an Ogg Vorbis or Opus file.
+func readOggTags(file string) (map[string]string, error) {
+	f, err := openFile(file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer f.Close()
+
+	h, err := readOggHeaders(f)
+	if err != nil {
+		return nil, err
+	}
+	_, vc, _, err := h.comment()
+	if err != nil {
+		return nil, err
+	}
+	return vc.tags(), nil
+}
+
+// writeOggTags writes src with its comment header updated to
+// dst. The headers are paged anew and the pages after them
+// renumbered.
+func writeOggTags(src, dst string, tags map[string]string) error {
+	openFiles.acquire(nil)
+	defer openFiles.release()
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Cannot open file: %v", err)
+	}
+	defer in.Close()
+
+	h, err := readOggHeaders(in)
+	if err != nil {
+		return err
+	}
+	prefix, vc, rest, err := h.comment()
+	if err != nil {
+		return err
+	}
+	for k, v := range tags {
+		vc.set(k, v)
+	}
+	h.packets[1] = append(append(append([]byte{}, prefix...), vc.encode()...), rest...)
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot create file: %v", err)
+	}
+	pages := append([]oggPage{h.first}, oggPaginate(h.packets[1:], h.first.serial, 1)...)
+	delta := uint32(len(pages) - h.pages)
+	for _, p := range pages {
+		if err == nil {
+			_, err = out.Write(p.encode())
+		}
+	}
+	if err == nil {
+		_, err = in.Seek(h.end, io.SeekStart)
+	}
+	for err == nil {
+		var p oggPage
+		p, _, err = readOggPage(in)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err == nil {
+			p.seq += delta
+			_, err = out.Write(p.encode())
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package bs1770wrap
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writing tags or gain into files on NFS or SMB shares that
+// several workers process at once needs care: advisory locks
+// are not reliably honoured across hosts, and writes may be
+// cached or interleaved. Every in-place modification therefore
+// goes through modifyFile, which takes a lock file created
+// exclusively next to the file (which is atomic on NFSv3 and
+// later, and on SMB), writes the new version to a temporary
+// file in the same directory, checks it, renames it over the
+// original and reads the result back.
+
+// Default locking settings, used when the Options fields are
+// zero.
+const (
+	DefaultLockTimeout = time.Minute
+	DefaultLockStale   = 10 * time.Minute
+)
+
+// ErrLocked is returned when a file stays locked by another
+// worker for longer than Options.LockTimeout.
+var ErrLocked = errors.New("file is locked by another worker")
+
+// lockPollInterval is how often a held lock is retried
+const lockPollInterval = 100 * time.Millisecond
+
+// lockFile takes the lock on file, returning a function
+// releasing it. A lock file older than opts.LockStale is
+// considered left behind by a crashed worker and taken over;
+// the holder touches it while it holds it so that it never
+// looks stale.
+//
+// Each lock holds a token of its owner. Taking over, a worker
+// renames the stale lock aside, which only one can, and
+// checks it moved the lock it found stale rather than one
+// just taken; then it competes for the lock anew, creating it
+// exclusively. The lock is only removed by the owner of its
+// token.
+func lockFile(file string, opts Options) (func(), error) {
+	timeout := opts.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	stale := opts.LockStale
+	if stale <= 0 {
+		stale = DefaultLockStale
+	}
+
+	lock := longPath(file + ".lock")
+	token, err := lockToken()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot lock %s: %v", file, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := openFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(token)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lock)
+				return nil, fmt.Errorf("Cannot lock %s: %v", file, err)
+			}
+			return holdLock(lock, token, stale), nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("Cannot lock %s: %v", file, err)
+		}
+
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > stale {
+			takeOver(lock, token, stale)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Cannot lock %s: %w", file, ErrLocked)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lockToken returns a token unique to a lock taken, naming
+// its owner for whoever looks.
+func lockToken() (string, error) {
+	host, _ := os.Hostname()
+	var id [8]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %x\n", host, os.Getpid(), id), nil
+}
+
+func readLock(lock string) string {
+	buf, _ := os.ReadFile(lock)
+	return string(buf)
+}
+
+// takeOver removes the stale lock, moving it to a name of
+// token's first. If what it moved is not stale, another
+// worker took the lock in the meantime, and it is put back.
+func takeOver(lock, token string, stale time.Duration) {
+	aside := lock + ".stale-" + strings.Fields(token)[2]
+	if os.Rename(lock, aside) != nil {
+		return // taken over by another worker
+	}
+	if fi, err := os.Stat(aside); err == nil && time.Since(fi.ModTime()) <= stale {
+		putBack(aside, lock)
+	}
+	os.Remove(aside)
+}
+
+// putBack restores a lock moved aside, unless yet another
+// worker took it since. It is linked back where hard links
+// work; SMB shares and some network filesystems have none,
+// and there it is created anew, exclusively, with the token.
+func putBack(aside, lock string) {
+	err := os.Link(aside, lock)
+	if err == nil || os.IsExist(err) {
+		return
+	}
+	token, err := os.ReadFile(aside)
+	if err != nil {
+		return
+	}
+	f, err := openFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(token)
+	if cerr := f.Close(); err != nil || cerr != nil {
+		os.Remove(lock)
+	}
+}
+
+// holdLock keeps lock fresh while it is held, returning the
+// function releasing it. A lock found missing may only be
+// moved aside for a moment, by a worker taking over that
+// puts it back on finding it fresh: it is checked for again
+// every lockPollInterval until it is back. Only a lock of
+// another token means it was taken over after all.
+func holdLock(lock, token string, stale time.Duration) func() {
+	interval := stale / 4
+	if interval < lockPollInterval {
+		interval = lockPollInterval
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				switch readLock(lock) {
+				case token:
+					now := time.Now()
+					os.Chtimes(lock, now, now)
+					t.Reset(interval)
+				case "":
+					t.Reset(lockPollInterval) // moved aside, or being put back
+				default:
+					return // taken over, after all
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			if readLock(lock) == token {
+				os.Remove(lock)
+			}
+		})
+	}
+}
+
+// modifyFile replaces file with a new version, which write
+// creates at the path it is given. verify checks a version,
+// and is run on the new one before it replaces the original
+// and again, reading it back, afterwards.
+func modifyFile(file string, opts Options, write func(dst string) error, verify func(path string) error) error {
+	if opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	unlock, err := lockFile(file, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	fi, err := os.Stat(longPath(file))
+	if err != nil {
+		return fmt.Errorf("Cannot stat file: %v", err)
+	}
+
+	tmp := tempName(file)
+	defer os.Remove(longPath(tmp))
+
+	err = write(tmp)
+	if err != nil {
+		return err
+	}
+	err = syncFile(tmp, fi)
+	if err != nil {
+		return err
+	}
+	err = verify(tmp)
+	if err != nil {
+		return fmt.Errorf("Cannot verify new version of %s: %v", file, err)
+	}
+
+	err = os.Rename(longPath(tmp), longPath(file))
+	if err != nil {
+		return fmt.Errorf("Cannot replace %s: %v", file, err)
+	}
+	err = verify(file)
+	if err != nil {
+		return fmt.Errorf("Cannot verify %s after writing, it may be damaged: %v", file, err)
+	}
+	return nil
+}
+
+// createFile writes file, which need not exist, as modifyFile
+// does: write creates it under a temporary name next to it,
+// which then replaces file, the lock on file held meanwhile.
+// A failed write leaves whatever file was untouched.
+func createFile(file string, opts Options, write func(dst string) error) error {
+	if opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	unlock, err := lockFile(file, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tmp := tempName(file)
+	defer os.Remove(longPath(tmp))
+
+	err = write(tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(longPath(tmp), longPath(file))
+	if err != nil {
+		return fmt.Errorf("Cannot write %s: %v", file, err)
+	}
+	return nil
+}
+
+// tempName returns a name for a new version of file next to
+// it. It keeps the extension, tools pick the format by it.
+func tempName(file string) string {
+	dir, base := filepath.Split(file)
+	return filepath.Join(dir, ".bs1770wrap-"+time.Now().Format("150405.000000000")+"-"+base)
+}
+
+// syncFile gives a new file the mode and ownership of the
+// original, orig, and flushes it to storage.
+func syncFile(path string, orig os.FileInfo) error {
+	f, err := openFile(longPath(path), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("Cannot open new version: %v", err)
+	}
+	defer f.Close()
+
+	err = copyOwner(f.File, orig)
+	if err != nil {
+		return fmt.Errorf("Cannot set owner of new version: %v", err)
+	}
+	err = f.Chmod(orig.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot flush new version: %v", err)
+	}
+	return nil
+}
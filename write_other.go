@@ -0,0 +1,12 @@
+//go:build !unix
+
+package bs1770wrap
+
+import "os"
+
+// copyOwner does nothing: files of other systems have no
+// owner to copy; on Windows, a new file inherits the
+// permissions of its directory.
+func copyOwner(f *os.File, orig os.FileInfo) error {
+	return nil
+}
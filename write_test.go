@@ -0,0 +1,115 @@
+package bs1770wrap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockFileStaleTakeover(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.flac")
+	lock := file + ".lock"
+	if err := os.WriteFile(lock, []byte("crashed 1 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{LockTimeout: 20 * time.Second, LockStale: time.Second}
+	var holders, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockFile(file, opts)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&holders, 1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Fatalf("%d workers held the lock at once", most)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Fatalf("lock left behind: %v", err)
+	}
+}
+
+func TestLockFileHeldLockStaysFresh(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.flac")
+	opts := Options{LockTimeout: 50 * time.Millisecond, LockStale: 400 * time.Millisecond}
+	unlock, err := lockFile(file, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	// a holder writing for longer than LockStale
+	time.Sleep(time.Second)
+	opts.LockTimeout = 300 * time.Millisecond
+	_, err = lockFile(file, opts)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("took a lock held by a live worker: %v", err)
+	}
+}
+
+func TestLockFileReleaseKeepsOthersLock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.flac")
+	unlock, err := lockFile(file, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// taken over behind the holder's back
+	if err := os.WriteFile(file+".lock", []byte("other 2 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if buf, _ := os.ReadFile(file + ".lock"); string(buf) != "other 2 1\n" {
+		t.Fatalf("release removed a lock of another worker, left %q", buf)
+	}
+}
+
+func TestLockFileRefreshOutlastsTakeoverRace(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.flac")
+	lock := file + ".lock"
+	opts := Options{LockTimeout: 50 * time.Millisecond, LockStale: 400 * time.Millisecond}
+	unlock, err := lockFile(file, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	// moved aside by a worker that then finds it fresh, for
+	// longer than a refresh
+	if err := os.Rename(lock, lock+".aside"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	putBack(lock+".aside", lock)
+	os.Remove(lock + ".aside")
+
+	time.Sleep(time.Second)
+	opts.LockTimeout = 300 * time.Millisecond
+	if _, err = lockFile(file, opts); !errors.Is(err, ErrLocked) {
+		t.Fatalf("the lock went stale once put back: %v", err)
+	}
+}
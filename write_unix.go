@@ -0,0 +1,28 @@
+//go:build unix
+
+package bs1770wrap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// copyOwner gives f the owner and group of orig. Only root
+// may give a file away: when the original is another user's,
+// the new version stays the writer's, with the group kept if
+// the writer is a member.
+func copyOwner(f *os.File, orig os.FileInfo) error {
+	st, ok := orig.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := f.Chown(int(st.Uid), int(st.Gid))
+	if errors.Is(err, os.ErrPermission) {
+		err = f.Chown(-1, int(st.Gid))
+	}
+	if errors.Is(err, os.ErrPermission) {
+		err = nil
+	}
+	return err
+}
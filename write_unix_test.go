@@ -0,0 +1,41 @@
+//go:build unix
+
+package bs1770wrap
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestModifyFileKeepsModeAndOwner(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.flac")
+	if err := os.WriteFile(file, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0640|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = 1234, 5678 // only root may give files away
+		if err := os.Chown(file, uid, gid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write := func(dst string) error { return os.WriteFile(dst, []byte("new"), 0600) }
+	verify := func(string) error { return nil }
+	if err := modifyFile(file, Options{}, write, verify); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if fi.Mode() != 0640|os.ModeSetgid || int(st.Uid) != uid || int(st.Gid) != gid {
+		t.Errorf("new version has mode %v, owner %d:%d, want %v, %d:%d", fi.Mode(), st.Uid, st.Gid, 0640|os.ModeSetgid, uid, gid)
+	}
+}